@@ -21,15 +21,20 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
 	"github.com/openebs/jiva-csi/pkg/config"
 	"github.com/openebs/jiva-csi/pkg/driver"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
 	"github.com/openebs/jiva-csi/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog"
+	utilexec "k8s.io/utils/exec"
 	k8scfg "sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -51,8 +56,10 @@ func (w *log2LogrusWriter) Write(b []byte) (int, error) {
 }
 
 var (
-	enableISCSIDebug   bool
-	metricsBindAddress string
+	logFormat         string
+	enableISCSIDebug  bool
+	initiatorNameFile string
+	minVolumeSize     string
 )
 
 /*
@@ -76,6 +83,40 @@ func main() {
 		},
 	}
 
+	// health-check is only run on demand i.e via kubectl exec,
+	// it is never part of the default startup of the driver
+	cmd.AddCommand(&cobra.Command{
+		Use:   "health-check",
+		Short: "checks the connectivity of the driver and exits",
+		Long: `checks that the kube-apiserver is reachable, JivaVolumes can be
+listed and on a node (--plugin=node) that iscsiadm is present,
+exits with non zero status if any of the checks fail`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !driver.RunHealthCheck(os.Stdout, config.PluginType, newHealthCheckClient, utilexec.New()) {
+				os.Exit(1)
+			}
+		},
+	})
+
+	// preflight is run by the init container of the node plugin
+	// so that a node missing the host dependencies fails the
+	// rollout instead of the first volume mount
+	var preflightJSON bool
+	preflightCmd := &cobra.Command{
+		Use:   "preflight",
+		Short: "checks the host dependencies of the node plugin and exits",
+		Long: `checks that iscsid is reachable, the iscsi_tcp kernel module is
+loaded and the iSCSI, mount, mkfs and resize utilities are present,
+exits with non zero status if any of the checks fail`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !driver.WritePreflightReport(os.Stdout, driver.RunPreflight(utilexec.New()), preflightJSON) {
+				os.Exit(1)
+			}
+		},
+	}
+	preflightCmd.Flags().BoolVar(&preflightJSON, "json", false, "Write the results of the checks as JSON")
+	cmd.AddCommand(preflightCmd)
+
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
 	_ = flag.CommandLine.Parse([]string{})
 
@@ -91,6 +132,30 @@ func main() {
 		&config.Endpoint, "endpoint", "unix:///plugin/csi.sock", "CSI endpoint",
 	)
 
+	cmd.PersistentFlags().Uint32Var(
+		&config.GRPCMaxConcurrentStreams, "grpc-max-concurrent-streams", 0, "Max number of concurrent streams per connection of the grpc server, grpc default is used if not set",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.GRPCKeepaliveTime, "grpc-keepalive-time", 0, "Interval after which an idle grpc connection is pinged, grpc default (2h) is used if not set",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.GRPCKeepaliveTimeout, "grpc-keepalive-timeout", 0, "Time to wait for the ack of a keepalive ping before closing the grpc connection, grpc default (20s) is used if not set",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ClusterDomain, "cluster-domain", "cluster.local", "DNS domain of the cluster, used in the portal of the volumes with jiva.openebs.io/use-dns-portal set",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time given to the in-flight requests to complete on SIGTERM before they are cancelled",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&utils.VolumeIDPrefix, "volume-id-prefix", "", "Prefix prepended to the volume IDs of the volumes provisioned by the driver, it must be the same for the controller and node plugins",
+	)
+
 	cmd.PersistentFlags().StringVar(
 		&config.DriverName, "name", "jiva.csi.openebs.io", "Name of this driver",
 	)
@@ -107,8 +172,176 @@ func main() {
 		&driver.MaxRetryCount, "retrycount", 5, "Max retry count to check if volume is ready",
 	)
 
+	cmd.PersistentFlags().DurationVar(
+		&config.ISCSILoginTimeout, "iscsi-login-timeout", 0, "Timeout for iSCSI login to the jiva target, iscsid default is used if not set",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.ISCSILoginRetries, "iscsi-login-retries", 0, "Number of times iSCSI login is retried with backoff before staging the volume fails",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ISCSIInitiatorName, "iscsi-initiator-name", "", "iSCSI initiator name used for the sessions of the volumes, default initiator name of the node is used if not set",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&initiatorNameFile, "iscsi-initiator-name-file", "", "Path of the file with the iSCSI initiator name i.e InitiatorName=<iqn>, it can't be set along with --iscsi-initiator-name",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.ISCSIInterface, "iscsi-interface", "", "Network interface the iSCSI sessions of the volumes are bound to i.e eth1, default iface of the node is used if not set",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.MountMaxAttempts, "mount-max-attempts", 3, "Max number of times the mount in NodePublishVolume is attempted with backoff if it fails with a transient error i.e EIO or ENODEV",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.EventWebhookURL, "event-webhook-url", "", "URL the lifecycle events of the volumes i.e provisioned, attached, resized, failed and deleted are posted to as JSON, events are not posted if not set",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.MountMonitorInterval, "mount-monitor-interval", driver.DefaultMountMonitorInterval, "Interval at which the node plugin lists the volumes staged on the node to remount the ones which lost their mounts if REMOUNT is enabled, i.e 1m. Mounts are not polled if set to 0",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.UnstageLogoutDelay, "unstage-logout-delay", 0, "Time for which NodeUnstageVolume defers the iSCSI logout, the session is reused if the volume is staged again on the node within it. Logout is immediate if set to 0",
+	)
+
+	cmd.PersistentFlags().StringSliceVar(
+		&config.AllowedMountOptions, "allowed-mount-options", nil, "Comma separated list of the mount options which can be set in the StorageClass along with the default ones i.e noatime, nosuid and nodev",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.EnableRWX, "enable-rwx", false, "Enable ReadWriteMany volumes which are exported over NFS by an NFS server pod mounting the jiva volume, it must be set on both the controller and node plugins",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.NFSServerImage, "nfs-server-image", driver.DefaultNFSServerImage, "Image of the NFS server which exports the ReadWriteMany volumes",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.DeviceScanTimeout, "device-scan-timeout", 10*time.Second, "Max time to wait for the block device to appear after iSCSI login to the jiva target",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&minVolumeSize, "min-volume-size", "1Gi", "Smallest size which can be requested for a volume i.e 1Gi, CreateVolume fails with OutOfRange for smaller sizes",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.ProvisioningTimeout, "provisioning-timeout", 2*time.Minute, "Max time CreateVolume waits for the target of the volume to be ready, the provisioner retries CreateVolume after it. CreateVolume doesn't wait if set to 0",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.CreateVolumeTimeout, "create-volume-timeout", 0, "Max time CreateVolume takes to provision a volume, the JivaVolume and the target of a volume which is not provisioned within it are rolled back and CreateVolume fails with DeadlineExceeded. Volumes are not rolled back if set to 0",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.ForceDetach, "force-detach", false, "Publish a volume to a new node while it is still published to a node which is deleted or tainted node.kubernetes.io/out-of-service",
+	)
+
+	cmd.PersistentFlags().Float64Var(
+		&config.UsageAlertThreshold, "usage-alert-threshold", 0, "Fraction of the bytes or inodes used in a volume, i.e 0.9, above which the node plugin reports an abnormal volume condition and records a warning event on the PVC. Usage is not alerted if set to 0",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.UsageAlertInterval, "usage-alert-interval", driver.DefaultUsageAlertInterval, "Min time between the usage alert events recorded for a volume",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.MaxConcurrentNodeOperations, "max-concurrent-node-operations", 0, "Max number of NodeStageVolume, NodeUnstageVolume and NodeExpandVolume requests served concurrently, excess requests wait for a free slot so that iscsid is not overwhelmed. Requests are not limited if set to 0",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.RegistrationReadinessTimeout, "registration-readiness-timeout", 0, "Max time the node plugin waits for iscsid and the mount utilities before it serves the registration with the kubelet, it exits if they are not ready within it. Registration is not delayed if set to 0",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.OnlineExpansionOnly, "online-expansion-only", false, "Only resize filesystems online while they are mounted, NodeStageVolume resizes an ext filesystem expanded offline after mounting it instead of running e2fsck and resize2fs before it, and NodeExpandVolume fails with FailedPrecondition on a volume path which is not mounted. It has no effect on xfs which is always resized online",
+	)
+
+	cmd.PersistentFlags().Int64Var(
+		&config.MaxVolumesPerNode, "max-volumes-per-node", driver.DefaultMaxVolumesPerNode, "Max number of volumes which can be attached to the node, reported in NodeGetInfo, 0 means no limit",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.TopologyKey, "topology-key", "topology.jiva.openebs.io/node", "Topology key advertised by the node plugin with the node ID as its value",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DebugBindAddress, "debug-bind-address", "", "TCP address at which the node plugin serves the staged volumes, mounts and iSCSI sessions as JSON, disabled if not set. It binds to localhost if only the port is set i.e :9506",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.ISCSISessionMetricsInterval, "iscsi-session-metrics-interval", 30*time.Second, "Interval at which the node plugin collects the iSCSI session state of the staged volumes for metrics, disabled if set to 0",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.DefaultFSType, "default-fstype", driver.FSTypeExt4, "Filesystem used to format the volume if fsType is not set in the StorageClass, i.e ext4 or xfs",
+	)
+
 	cmd.PersistentFlags().StringVar(
-		&metricsBindAddress, "metricsBindAddress", "0", "TCP address that the controller should bind to for serving prometheus metrics.",
+		&config.StagingPathLayout, "staging-path-layout", driver.StagingPathLayoutDefault, "Layout of the path where the volumes are staged, default mounts at the staging path of kubelet and driver-volume mounts at <staging path>/<driver name>/<volume ID>",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.SkipFormat, "skip-format", false, "Don't format the volumes, staging fails if the volume has no filesystem. It can be overridden by the skipFormat StorageClass parameter",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.EnableVolumeMountGroup, "enable-volume-mount-group", false, "Advertise VOLUME_MOUNT_GROUP capability and set the fsGroup of the pod on the volume while staging it",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.EnableVolumeGroupSnapshots, "enable-volume-group-snapshots", false, "Serve and advertise the group controller service, so that the snapshots of a group of volumes are taken together",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.CleanupOrphanedSessions, "cleanup-orphaned-sessions", false, "Logout of the iSCSI sessions of the volumes which are not staged on the node anymore, on startup",
+	)
+
+	cmd.PersistentFlags().IntVar(
+		&config.JivaAPIMaxAttempts, "jiva-api-max-attempts", 5, "Max number of attempts of a request to the jiva target REST API on transient errors",
+	)
+
+	cmd.PersistentFlags().DurationVar(
+		&config.JivaAPIRetryDelay, "jiva-api-retry-delay", 2*time.Second, "Initial delay between the attempts of a request to the jiva target REST API, doubled after each retry",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.JivaAPICAFile, "jiva-api-ca-file", "", "PEM bundle of the CAs which sign the certificate of the jiva target REST API, the API is reached over HTTPS if set",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.JivaAPICertFile, "jiva-api-cert-file", "", "PEM client certificate presented to the jiva target REST API, requires jiva-api-key-file",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.JivaAPIKeyFile, "jiva-api-key-file", "", "PEM key of the client certificate presented to the jiva target REST API",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.JivaAPIInsecureSkipVerify, "jiva-api-insecure-skip-verify", false, "Reach the jiva target REST API over HTTPS without verifying its certificate, only meant for test clusters",
+	)
+
+	cmd.PersistentFlags().BoolVar(
+		&config.EnableLeaderElection, "enable-leader-election", false, "Enable leader election of the controller plugin, only the leader serves the requests which modify the volumes",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.LeaderElectionLeaseName, "leader-election-lease-name", "jiva-csi-controller", "Name of the lease used for the leader election of the controller plugin",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.LeaderElectionNamespace, "leader-election-namespace", "openebs", "Namespace of the lease used for the leader election of the controller plugin",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&logFormat, "log-format", driver.LogFormatText, "Format of the logs i.e text or json",
+	)
+
+	cmd.PersistentFlags().StringVar(
+		&config.MetricsBindAddress, "metricsBindAddress", "0", "TCP address at which prometheus metrics of the CSI operations are served, i.e :9505, disabled if set to 0",
 	)
 
 	err := cmd.Execute()
@@ -118,11 +351,34 @@ func main() {
 	}
 }
 
+// newHealthCheckClient returns the client used by
+// the health-check subcommand, metrics are not served
+func newHealthCheckClient() (*client.Client, error) {
+	cfg, err := k8scfg.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cli, err := client.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cli.RegisterAPI(manager.Options{MetricsBindAddress: "0"}); err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
 func run(config *config.Config) {
 	if config.Version == "" {
 		config.Version = version.Version
 	}
 
+	if err := driver.SetLogFormat(logFormat, config.PluginType, config.NodeID); err != nil {
+		logrus.Fatalf("error setting log format: %v", err)
+	}
+
 	logrus.Infof("%s - %s", version.Version, version.Commit)
 	logrus.Infof(
 		"DriverName: %s Plugin: %s EndPoint: %s NodeID: %s, MaxRetryCount: %v",
@@ -133,6 +389,93 @@ func run(config *config.Config) {
 		driver.MaxRetryCount,
 	)
 
+	// volume IDs are lowercased before stripping the prefix
+	if utils.VolumeIDPrefix != strings.ToLower(utils.VolumeIDPrefix) {
+		logrus.Fatalf("invalid volume ID prefix: {%s}, it must be lowercase", utils.VolumeIDPrefix)
+	}
+	if utils.VolumeIDPrefix != "" {
+		logrus.Infof("VolumeIDPrefix: %s", utils.VolumeIDPrefix)
+	}
+
+	if err := driver.ValidateEndpoint(config.Endpoint); err != nil {
+		logrus.Fatalf("invalid endpoint: {%s}, err: %v", config.Endpoint, err)
+	}
+
+	if config.PluginType == "node" && !driver.IsValidDefaultFSType(config.DefaultFSType) {
+		logrus.Fatalf("invalid default fstype: {%s}, supported fstypes are: %v", config.DefaultFSType, driver.ValidDefaultFSTypes)
+	}
+
+	if config.PluginType == "controller" {
+		size, err := resource.ParseQuantity(minVolumeSize)
+		if err != nil || size.Value() < client.MinVolumeSizeBytes {
+			logrus.Fatalf("invalid min volume size: {%s}, it must be a quantity of at least %v bytes", minVolumeSize, client.MinVolumeSizeBytes)
+		}
+		config.MinVolumeSize = size.Value()
+	}
+
+	if config.EventWebhookURL != "" {
+		if err := driver.ValidateWebhookURL(config.EventWebhookURL); err != nil {
+			logrus.Fatalf("invalid event webhook url: %v", err)
+		}
+	}
+
+	if (config.JivaAPICertFile == "") != (config.JivaAPIKeyFile == "") {
+		logrus.Fatalf("invalid jiva api client certificate: both jiva-api-cert-file and jiva-api-key-file must be set")
+	}
+
+	if config.PluginType == "node" && config.MountMonitorInterval < 0 {
+		logrus.Fatalf("invalid mount monitor interval: {%v}, it must not be negative", config.MountMonitorInterval)
+	}
+	if config.PluginType == "node" {
+		logrus.Infof("MountMonitorInterval: %v", config.MountMonitorInterval)
+	}
+
+	if config.PluginType == "node" && config.UnstageLogoutDelay < 0 {
+		logrus.Fatalf("invalid unstage logout delay: {%v}, it must not be negative", config.UnstageLogoutDelay)
+	}
+
+	if config.PluginType == "node" && config.MountMaxAttempts < 1 {
+		logrus.Fatalf("invalid mount max attempts: {%d}, it must be at least 1", config.MountMaxAttempts)
+	}
+
+	if config.PluginType == "node" && (config.UsageAlertThreshold < 0 || config.UsageAlertThreshold > 1) {
+		logrus.Fatalf("invalid usage alert threshold: {%v}, it must be between 0 and 1", config.UsageAlertThreshold)
+	}
+
+	if config.PluginType == "node" && config.MaxConcurrentNodeOperations < 0 {
+		logrus.Fatalf("invalid max concurrent node operations: {%d}, it must not be negative", config.MaxConcurrentNodeOperations)
+	}
+
+	if config.PluginType == "node" && config.RegistrationReadinessTimeout < 0 {
+		logrus.Fatalf("invalid registration readiness timeout: {%v}, it must not be negative", config.RegistrationReadinessTimeout)
+	}
+
+	if config.PluginType == "node" && config.MaxVolumesPerNode < 0 {
+		logrus.Fatalf("invalid max volumes per node: {%d}, it must not be negative", config.MaxVolumesPerNode)
+	}
+
+	if config.PluginType == "node" && !driver.IsValidStagingPathLayout(config.StagingPathLayout) {
+		logrus.Fatalf("invalid staging path layout: {%s}, supported layouts are: %v", config.StagingPathLayout, driver.ValidStagingPathLayouts)
+	}
+
+	if config.PluginType == "node" {
+		name, err := driver.ResolveInitiatorName(config.ISCSIInitiatorName, initiatorNameFile)
+		if err != nil {
+			logrus.Fatalf("invalid iscsi initiator name: %v", err)
+		}
+		config.ISCSIInitiatorName = name
+		if name != "" {
+			logrus.Infof("ISCSIInitiatorName: %s", name)
+		}
+	}
+
+	if config.PluginType == "node" && config.ISCSIInterface != "" {
+		if err := driver.ValidateNetworkInterface(config.ISCSIInterface); err != nil {
+			logrus.Fatalf("invalid iscsi interface: %v", err)
+		}
+		logrus.Infof("ISCSIInterface: %s", config.ISCSIInterface)
+	}
+
 	if config.PluginType == "node" && enableISCSIDebug {
 		logrus.SetLevel(logrus.DebugLevel)
 		iscsi.EnableDebugLogging(&log2LogrusWriter{
@@ -152,8 +495,10 @@ func run(config *config.Config) {
 		logrus.Fatalf("error creating client from config: %v", err)
 	}
 
+	// manager is only used to register the scheme and is never
+	// started, metrics are served by the driver instead
 	if err := cli.RegisterAPI(manager.Options{
-		MetricsBindAddress: metricsBindAddress,
+		MetricsBindAddress: "0",
 	}); err != nil {
 		logrus.Fatalf("error registering API: %v", err)
 	}
@@ -16,6 +16,8 @@ limitations under the License.
 
 package config
 
+import "time"
+
 // Config struct fills the parameters of request or user input
 type Config struct {
 	// DriverName to be registered at CSI
@@ -37,11 +39,227 @@ type Config struct {
 	//  - This will be a unix based socket
 	Endpoint string
 
+	// GRPCMaxConcurrentStreams is the max number of concurrent
+	// streams per connection of the grpc server, 0 means the
+	// grpc default is used
+	GRPCMaxConcurrentStreams uint32
+
+	// GRPCKeepaliveTime and GRPCKeepaliveTimeout are the interval
+	// after which an idle connection is pinged and the time to
+	// wait for the ping ack, 0 means the grpc default is used
+	GRPCKeepaliveTime    time.Duration
+	GRPCKeepaliveTimeout time.Duration
+
+	// ShutdownTimeout is the time given to the in-flight
+	// requests to complete on SIGTERM, requests still
+	// in-flight after it are cancelled
+	ShutdownTimeout time.Duration
+
+	// EnableLeaderElection enables the leader election of the
+	// controller plugin using the lease LeaderElectionLeaseName
+	// in LeaderElectionNamespace, only the leader serves the
+	// requests which modify the volumes
+	EnableLeaderElection    bool
+	LeaderElectionLeaseName string
+	LeaderElectionNamespace string
+
+	// MinVolumeSize is the smallest size in bytes which can be
+	// requested in CreateVolume, smaller requests fail with
+	// OutOfRange instead of provisioning an unusable volume
+	MinVolumeSize int64
+
+	// ProvisioningTimeout is the max time CreateVolume waits for
+	// the target of the volume to be ready, CreateVolume doesn't
+	// wait if it is 0
+	ProvisioningTimeout time.Duration
+
+	// CreateVolumeTimeout is the max time CreateVolume takes to
+	// provision a volume, the partially provisioned volume is
+	// rolled back after it. Volumes are not rolled back if 0
+	CreateVolumeTimeout time.Duration
+
 	// NodeID helps in differentiating the nodes on
 	// which node drivers are running. This is useful
 	// in case of topologies and publishing or
 	// unpublishing volumes on nodes
 	NodeID string
+
+	// MaxVolumesPerNode is the max number of volumes which can be
+	// attached to the node, reported to kubernetes in NodeGetInfo
+	// so that the scheduler honors it, 0 means there is no limit
+	MaxVolumesPerNode int64
+
+	// ISCSILoginTimeout is the time to wait for the
+	// iSCSI login to the jiva target to complete, if
+	// it is not set the default of iscsid is used
+	ISCSILoginTimeout time.Duration
+
+	// ISCSILoginRetries is the number of times iSCSI
+	// login is retried before NodeStageVolume fails
+	ISCSILoginRetries int
+
+	// ISCSIInitiatorName is the initiator name used for the iSCSI
+	// sessions of the volumes, the default initiator name of the
+	// node is used if it is not set
+	ISCSIInitiatorName string
+
+	// ISCSIInterface is the network interface the iSCSI sessions
+	// of the volumes are bound to, i.e the dedicated storage NIC.
+	// Default iface of the node is used if it is not set.
+	ISCSIInterface string
+
+	// MountMaxAttempts is the max number of times the mount
+	// in NodePublishVolume is attempted if it fails with a
+	// transient error i.e EIO during failover of the target
+	MountMaxAttempts int
+
+	// EventWebhookURL is the URL the lifecycle events of the
+	// volumes are posted to as JSON, events are only recorded
+	// as kubernetes events if it is not set
+	EventWebhookURL string
+
+	// MountMonitorInterval is the interval at which the node plugin
+	// lists the JivaVolumes staged on the node to remount the ones
+	// which lost their mounts, if REMOUNT is enabled. Mounts are not
+	// polled if it is 0.
+	MountMonitorInterval time.Duration
+
+	// UnstageLogoutDelay is the time for which NodeUnstageVolume
+	// defers the iSCSI logout, the session is reused if the volume
+	// is staged again on the node within it. Logout is immediate
+	// if it is not set.
+	UnstageLogoutDelay time.Duration
+
+	// AllowedMountOptions is the list of the mount options which
+	// can be set in the StorageClass along with the default ones
+	AllowedMountOptions []string
+
+	// EnableRWX enables the ReadWriteMany volumes, which are
+	// exported over NFS by an NFS server pod mounting the jiva
+	// volume, only ReadWriteOnce volumes are supported otherwise
+	EnableRWX bool
+
+	// NFSServerImage is the image of the NFS server
+	// which exports the ReadWriteMany volumes
+	NFSServerImage string
+
+	// ForceDetach lets ControllerPublishVolume publish a volume to
+	// a new node while it is still published to a node which is
+	// deleted or tainted out-of-service, i.e the pod moved after
+	// its node was shut down
+	ForceDetach bool
+
+	// UsageAlertThreshold is the fraction of the bytes or inodes
+	// used in a volume above which NodeGetVolumeStats reports an
+	// abnormal condition and records an event on the PVC, usage
+	// is not alerted if it is 0
+	UsageAlertThreshold float64
+
+	// UsageAlertInterval is the min time between the
+	// usage alert events recorded for a volume
+	UsageAlertInterval time.Duration
+
+	// MaxConcurrentNodeOperations is the max number of the stage,
+	// unstage and expand requests served concurrently by the node
+	// plugin, excess requests wait for a free slot. Requests are
+	// not limited if it is 0.
+	MaxConcurrentNodeOperations int
+
+	// RegistrationReadinessTimeout is the max time the node
+	// plugin waits for iscsid and the mount utilities before
+	// it starts the grpc server, the registration with the
+	// kubelet isn't delayed if it is 0
+	RegistrationReadinessTimeout time.Duration
+
+	// OnlineExpansionOnly disables the resize of the filesystems
+	// which are not mounted i.e e2fsck followed by resize2fs, the
+	// ext filesystem of a volume expanded offline is resized after
+	// it is staged and the expansion of a volume path which is not
+	// mounted fails with FailedPrecondition
+	OnlineExpansionOnly bool
+
+	// DeviceScanTimeout is the max time to wait for the
+	// block device to appear under /dev after the iSCSI
+	// login to the jiva target succeeds
+	DeviceScanTimeout time.Duration
+
+	// TopologyKey is the key of the topology segment
+	// advertised by the node plugin with the NodeID
+	// as its value
+	TopologyKey string
+
+	// MetricsBindAddress is the TCP address at which
+	// prometheus metrics of the CSI operations are
+	// served, metrics are not served if it is empty or 0
+	MetricsBindAddress string
+
+	// ISCSISessionMetricsInterval is the interval at which the
+	// node plugin collects the state of the iSCSI sessions of
+	// the staged volumes, collection is disabled if it is 0
+	ISCSISessionMetricsInterval time.Duration
+
+	// DebugBindAddress is the TCP address at which the node plugin
+	// serves its state for debugging, it is not served if empty and
+	// binds to localhost if only the port is set
+	DebugBindAddress string
+
+	// ClusterDomain is the DNS domain of the cluster used
+	// in the DNS portal of the jiva targets
+	ClusterDomain string
+
+	// DefaultFSType is the filesystem used by the node
+	// plugin to format the volume if fsType is not set
+	// in the StorageClass
+	DefaultFSType string
+
+	// StagingPathLayout is the layout of the path where the node
+	// plugin stages the volumes under the staging target path
+	// passed by kubelet, i.e default or driver-volume
+	StagingPathLayout string
+
+	// SkipFormat disables formatting of the volumes by the node
+	// plugin, staging fails if the device has no filesystem
+	SkipFormat bool
+
+	// EnableVolumeMountGroup advertises the VOLUME_MOUNT_GROUP
+	// node capability, the node plugin then sets the fsGroup
+	// passed by kubelet on the volume while staging it
+	EnableVolumeMountGroup bool
+
+	// EnableVolumeGroupSnapshots serves the group controller
+	// service of the controller plugin and advertises it, so
+	// that the snapshots of a group of volumes are taken together
+	EnableVolumeGroupSnapshots bool
+
+	// CleanupOrphanedSessions enables logging out of the
+	// iSCSI sessions of the volumes which are not staged
+	// on the node anymore, on node plugin startup
+	CleanupOrphanedSessions bool
+
+	// JivaAPIMaxAttempts is the max number of times a
+	// request to the jiva target REST API is sent if it
+	// fails with a transient error
+	JivaAPIMaxAttempts int
+
+	// JivaAPIRetryDelay is the initial wait between the
+	// attempts of a request to the jiva target REST API,
+	// it is doubled after each retry
+	JivaAPIRetryDelay time.Duration
+
+	// JivaAPICAFile is the PEM bundle of the CAs which sign
+	// the serving certificate of the jiva target REST API,
+	// the API is reached over HTTPS if any of the TLS
+	// options are set
+	JivaAPICAFile string
+
+	// JivaAPICertFile and JivaAPIKeyFile are the PEM client
+	// certificate and key presented to the jiva target
+	JivaAPICertFile string
+	JivaAPIKeyFile  string
+
+	// JivaAPIInsecureSkipVerify skips the verification of
+	// the certificate served by the jiva target
+	JivaAPIInsecureSkipVerify bool
 }
 
 // Default returns a new instance of config
@@ -0,0 +1,210 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// jivaIQNPrefix is the prefix of the iqn of
+	// the targets exposed by jiva
+	jivaIQNPrefix = "iqn.2016-09.com.openebs.jiva:"
+)
+
+// sessionRegex parses the output of iscsiadm -m session, i.e
+// tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1234 (non-flash)
+var sessionRegex = regexp.MustCompile(`^\S+: \[\d+\] (\S+),\d+ (\S+)`)
+
+// iscsiSession is an active session to an iSCSI target
+type iscsiSession struct {
+	portal string
+	iqn    string
+}
+
+// listJivaSessions returns the active iSCSI sessions to jiva targets
+func listJivaSessions(exec utilexec.Interface) ([]iscsiSession, error) {
+	out, err := exec.Command("iscsiadm", "-m", "session").CombinedOutput()
+	if err != nil {
+		if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.ExitStatus() == iscsiErrNoObjsFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list iscsi sessions, err: {%v}, output: {%s}", err, string(out))
+	}
+
+	sessions := []iscsiSession{}
+	for _, line := range strings.Split(string(out), "\n") {
+		match := sessionRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || !strings.HasPrefix(match[2], jivaIQNPrefix) {
+			continue
+		}
+		sessions = append(sessions, iscsiSession{portal: match[1], iqn: match[2]})
+	}
+	return sessions, nil
+}
+
+// cleanupOrphanedSessions logs out of the jiva targets whose volumes
+// are no longer staged on this node as per the JivaVolume CRs, mounts
+// of the devices of such sessions are unmounted and the LUKS mapping
+// of the encrypted ones is closed before the logout.
+// It is run once on the node plugin startup, i.e after an ungraceful
+// reboot of the node.
+func (ns *node) cleanupOrphanedSessions() error {
+	sessions, err := listJivaSessions(ns.mounter.Exec)
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	if err := ns.client.Set(); err != nil {
+		return err
+	}
+
+	volumes, err := ns.client.ListJivaVolumeWithOpts(map[string]string{
+		"nodeID": ns.driver.config.NodeID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list JivaVolumes staged on node {%v}, err: {%v}", ns.driver.config.NodeID, err)
+	}
+
+	staged := map[string]bool{}
+	for _, vol := range volumes.Items {
+		staged[vol.Spec.ISCSISpec.Iqn] = true
+	}
+
+	for _, session := range sessions {
+		if staged[session.iqn] {
+			continue
+		}
+
+		logrus.Infof("Cleanup: found orphaned iscsi session to target: {%s} portal: {%s}", session.iqn, session.portal)
+		device := iscsiDevicePath(session.portal, session.iqn, defaultISCSILUN)
+		if err := ns.closeOrphanedLUKS(session.iqn); err != nil {
+			logrus.Errorf("Cleanup: failed to close LUKS mapping of target: {%s}, err: {%v}", session.iqn, err)
+			continue
+		}
+
+		if err := ns.unmountDevice(device); err != nil {
+			logrus.Errorf("Cleanup: failed to unmount device {%s}, err: {%v}", device, err)
+			continue
+		}
+
+		if err := iscsiLogout(ns.mounter.Exec, session.iqn, []string{session.portal}, device); err != nil {
+			logrus.Errorf("Cleanup: failed to logout of target: {%s}, err: {%v}", session.iqn, err)
+			continue
+		}
+		logrus.Infof("Cleanup: logged out of orphaned iscsi session to target: {%s}", session.iqn)
+	}
+	return nil
+}
+
+// closeOrphanedLUKS unmounts and closes the LUKS mapping on the device
+// of the given target, if any. JivaVolume of an orphaned session may be
+// deleted, so the mapping is found from the name of the volume in the
+// iqn instead of the encryption annotation.
+func (ns *node) closeOrphanedLUKS(iqn string) error {
+	instance := &jv.JivaVolume{}
+	instance.Name = strings.TrimPrefix(iqn, jivaIQNPrefix)
+	mapperPath := luksMapperPath(instance)
+	if _, err := os.Stat(mapperPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := ns.unmountDevice(mapperPath); err != nil {
+		return fmt.Errorf("failed to unmount LUKS mapping {%s}, err: {%v}", mapperPath, err)
+	}
+	return ns.closeLUKS(instance)
+}
+
+// unmountDevice unmounts all the mount points of the given device
+func (ns *node) unmountDevice(devicePath string) error {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		// device is already removed
+		return nil
+	}
+
+	mounts, err := ns.mounter.List()
+	if err != nil {
+		return err
+	}
+
+	for _, mnt := range mounts {
+		if mnt.Device != device && mnt.Device != devicePath {
+			continue
+		}
+		logrus.Infof("Cleanup: unmounting {%s} of device {%s}", mnt.Path, device)
+		if err := ns.mounter.Unmount(mnt.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logoutStalePortals logs out of the sessions to the target of the
+// volume at portals other than its current one, i.e the jiva target
+// has been rescheduled and got a new portal. Mounts of the device of
+// the stale session are unmounted first, so that NodeStageVolume can
+// login to the new portal and mount the refreshed device. It is a
+// no-op if there is no session or it is to the current portal.
+func (ns *node) logoutStalePortals(instance *jv.JivaVolume) error {
+	iqn := instance.Spec.ISCSISpec.Iqn
+	portal := fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)
+
+	sessions, err := listJivaSessions(ns.mounter.Exec)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.iqn != iqn || session.portal == portal {
+			continue
+		}
+
+		logrus.Warningf("NodeStageVolume: portal of target: {%s} changed from {%s} to {%s}, logging out of the stale session",
+			iqn, session.portal, portal)
+		device := instance.Spec.MountInfo.DevicePath
+		if device == "" {
+			device = iscsiDevicePath(session.portal, iqn, defaultISCSILUN)
+		}
+		if err := ns.unmountDevice(device); err != nil {
+			return fmt.Errorf("failed to unmount device {%s} of stale session to portal {%s}, err: {%v}", device, session.portal, err)
+		}
+
+		if isEncrypted(instance) {
+			if err := ns.closeLUKS(instance); err != nil {
+				return err
+			}
+		}
+
+		if err := iscsiLogout(ns.mounter.Exec, iqn, []string{session.portal}, device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
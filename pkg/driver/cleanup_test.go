@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/utils/mount"
+)
+
+// newTestLUKSMapping creates the LUKS mapping of the
+// given volume under a temp mapper dir
+func newTestLUKSMapping(t *testing.T, volume string) string {
+	dir, err := ioutil.TempDir("", "mapper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	mapperDir := luksMapperDir
+	luksMapperDir = dir
+	t.Cleanup(func() { luksMapperDir = mapperDir })
+
+	mapperPath := filepath.Join(dir, volume+"-luks")
+	if err := ioutil.WriteFile(mapperPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return mapperPath
+}
+
+func TestCleanupOrphanedSessions(t *testing.T) {
+	defer func(interval time.Duration) { iscsiLogoutRetryInterval = interval }(iscsiLogoutRetryInterval)
+	iscsiLogoutRetryInterval = 0
+
+	staged := newStagedJivaVolume("pvc-1234", "10.0.0.1")
+	sessions := "tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1234 (non-flash)\n" +
+		"tcp: [2] 10.0.0.2:3260,1 iqn.2016-09.com.openebs.jiva:pvc-5678 (non-flash)\n"
+	orphanedIQN := "iqn.2016-09.com.openebs.jiva:pvc-5678"
+
+	tests := map[string]struct {
+		encrypted bool
+		expected  [][]string
+	}{
+		"unencrypted volume": {
+			expected: [][]string{
+				{"iscsiadm", "-m", "session"},
+				{"iscsiadm", "-m", "node", "-T", orphanedIQN, "-p", "10.0.0.2:3260", "-u"},
+				{"iscsiadm", "-m", "node", "-T", orphanedIQN, "-p", "10.0.0.2:3260", "-o", "delete"},
+			},
+		},
+		"encrypted volume": {
+			encrypted: true,
+			expected: [][]string{
+				{"iscsiadm", "-m", "session"},
+				{"cryptsetup", "luksClose", "pvc-5678-luks"},
+				{"iscsiadm", "-m", "node", "-T", orphanedIQN, "-p", "10.0.0.2:3260", "-u"},
+				{"iscsiadm", "-m", "node", "-T", orphanedIQN, "-p", "10.0.0.2:3260", "-o", "delete"},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			fakeExec := newFakeExec(&cmds, sessionsOutput(sessions), success, success, success)
+			fakeExec.LookPathFunc = func(cmd string) (string, error) { return "/usr/sbin/" + cmd, nil }
+			ns, fakeMounter, _ := newFakeNode(t, fakeExec, staged)
+
+			// mapping of the orphaned volume is still mounted
+			// at its staging path, the volume CR is deleted
+			if test.encrypted {
+				mapperPath := newTestLUKSMapping(t, "pvc-5678")
+				fakeMounter.MountPoints = []mount.MountPoint{{Device: mapperPath, Path: "/staging/pvc-5678"}}
+			}
+
+			if err := ns.cleanupOrphanedSessions(); err != nil {
+				t.Fatalf("expected cleanup to succeed, got err: %v", err)
+			}
+			if !reflect.DeepEqual(cmds, test.expected) {
+				t.Fatalf("expected commands: %v, got: %v", test.expected, cmds)
+			}
+			if len(fakeMounter.MountPoints) != 0 {
+				t.Fatalf("expected LUKS mapping to be unmounted, got: %v", fakeMounter.MountPoints)
+			}
+		})
+	}
+}
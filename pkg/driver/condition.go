@@ -0,0 +1,188 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// replicaModeRW and replicaModeWO are the modes of a healthy
+	// replica and of a replica which is being rebuilt
+	replicaModeRW = "RW"
+	replicaModeWO = "WO"
+)
+
+var (
+	// replicaStatusTTL is the time for which the replicas reported
+	// by a jiva target are cached, so that the frequent polling of
+	// the volume health monitor doesn't hammer the target
+	replicaStatusTTL = 10 * time.Second
+
+	// replicaStatusErrorTTL is the time for which the failure to
+	// reach a jiva target is cached, so that a target which is down
+	// isn't queried for each volume condition request
+	replicaStatusErrorTTL = 2 * time.Second
+
+	// replicaStatusTimeout is the timeout of the request
+	// sent to the jiva target for the replica status
+	replicaStatusTimeout = 5 * time.Second
+)
+
+type replicaStatusEntry struct {
+	replicas []jiva.Replica
+	err      error
+	fetched  time.Time
+}
+
+// expired returns true if the entry is older than its TTL
+func (e replicaStatusEntry) expired(now time.Time) bool {
+	ttl := replicaStatusTTL
+	if e.err != nil {
+		ttl = replicaStatusErrorTTL
+	}
+	return now.Sub(e.fetched) >= ttl
+}
+
+// replicaStatusCache caches the replicas reported by
+// the jiva targets, keyed by the IP of the target
+type replicaStatusCache struct {
+	mu      sync.Mutex
+	entries map[string]replicaStatusEntry
+	// pruned is the last time the expired entries are removed,
+	// entries of the deleted volumes are never fetched again
+	pruned time.Time
+	// tlsConfig is set if the targets are reached over HTTPS
+	tlsConfig *tls.Config
+}
+
+func newReplicaStatusCache(tlsConfig *tls.Config) *replicaStatusCache {
+	return &replicaStatusCache{entries: map[string]replicaStatusEntry{}, tlsConfig: tlsConfig}
+}
+
+// get returns the replicas of the jiva target at targetIP, they are
+// fetched from the target if the cached ones are older than the TTL.
+// Target is queried without holding the lock, so that a slow target
+// doesn't block the condition of the other volumes.
+func (c *replicaStatusCache) get(ctx context.Context, targetIP string) ([]jiva.Replica, error) {
+	now := time.Now()
+	c.mu.Lock()
+	c.prune(now)
+	entry, ok := c.entries[targetIP]
+	c.mu.Unlock()
+	if ok && !entry.expired(now) {
+		return entry.replicas, entry.err
+	}
+
+	// status is only reported, so the request is not retried
+	cli := jiva.NewControllerClient(targetIP+":"+jivaTargetPort, jiva.RetryPolicy{MaxAttempts: 1})
+	cli.SetTimeout(replicaStatusTimeout)
+	cli.SetTLSConfig(c.tlsConfig)
+	replicas, err := cli.ListReplicas(ctx)
+	// request cancelled by the caller says nothing about the target
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[targetIP] = replicaStatusEntry{replicas: replicas, err: err, fetched: time.Now()}
+	c.mu.Unlock()
+	return replicas, err
+}
+
+// prune removes the expired entries once per TTL, so that the
+// entries of the targets which are deleted or moved to another
+// IP don't pile up. It must be called with the lock held.
+func (c *replicaStatusCache) prune(now time.Time) {
+	if now.Sub(c.pruned) < replicaStatusTTL {
+		return
+	}
+	for targetIP, entry := range c.entries {
+		if entry.expired(now) {
+			delete(c.entries, targetIP)
+		}
+	}
+	c.pruned = now
+}
+
+// volumeCondition returns the condition of the volume from the
+// replicas reported by its jiva target, the replica status in the
+// JivaVolume CR is used if the target can't be queried
+func volumeCondition(ctx context.Context, cache *replicaStatusCache, instance *jv.JivaVolume) *csi.VolumeCondition {
+	desired := instance.Spec.Policy.Target.ReplicationFactor
+	if targetIP := instance.Spec.ISCSISpec.TargetIP; cache != nil && targetIP != "" {
+		replicas, err := cache.get(ctx, targetIP)
+		if err == nil {
+			modes := make([]string, 0, len(replicas))
+			for _, rep := range replicas {
+				modes = append(modes, rep.Mode)
+			}
+			return replicaCondition(modes, desired)
+		}
+		logrus.Debugf("failed to get replica status of volume {%v} from target {%v}, using the JivaVolume status, err: {%v}",
+			instance.Name, targetIP, err)
+	}
+	return getVolumeCondition(instance)
+}
+
+// replicaCondition reports the volume as abnormal while any of the
+// replicas is being rebuilt or if there are fewer healthy replicas
+// than the desired replication factor
+func replicaCondition(modes []string, desired int) *csi.VolumeCondition {
+	healthy, rebuilding := 0, 0
+	for _, mode := range modes {
+		switch mode {
+		case replicaModeRW:
+			healthy++
+		case replicaModeWO:
+			rebuilding++
+		}
+	}
+
+	total := desired
+	if total < len(modes) {
+		total = len(modes)
+	}
+
+	if rebuilding > 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("rebuilding: %d of %d replicas healthy", healthy, total),
+		}
+	}
+
+	if healthy < desired {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume is degraded: %d of %d replicas are healthy", healthy, desired),
+		}
+	}
+
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "volume is healthy",
+	}
+}
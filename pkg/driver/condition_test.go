@@ -0,0 +1,231 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"golang.org/x/net/context"
+)
+
+func TestReplicaCondition(t *testing.T) {
+	tests := map[string]struct {
+		modes    []string
+		desired  int
+		abnormal bool
+		message  string
+	}{
+		"all healthy": {
+			modes:   []string{"RW", "RW", "RW"},
+			desired: 3,
+			message: "volume is healthy",
+		},
+		"rebuilding": {
+			modes:    []string{"RW", "WO", "RW"},
+			desired:  3,
+			abnormal: true,
+			message:  "rebuilding: 2 of 3 replicas healthy",
+		},
+		"rebuilding, replica not registered yet": {
+			modes:    []string{"RW", "WO"},
+			desired:  3,
+			abnormal: true,
+			message:  "rebuilding: 1 of 3 replicas healthy",
+		},
+		"degraded": {
+			modes:    []string{"RW", "ERR"},
+			desired:  2,
+			abnormal: true,
+			message:  "volume is degraded: 1 of 2 replicas are healthy",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cond := replicaCondition(test.modes, test.desired)
+			if cond.GetAbnormal() != test.abnormal || cond.GetMessage() != test.message {
+				t.Fatalf("expected abnormal %v with message {%v}, got: %+v", test.abnormal, test.message, cond)
+			}
+		})
+	}
+}
+
+// newFakeReplicaTarget starts a fake jiva target which reports the
+// replicas with the given modes, requests counts the replicas calls
+func newFakeReplicaTarget(t *testing.T, modes *[]string, requests *int32) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/replicas" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		reps := jiva.Replicas{}
+		for _, mode := range *modes {
+			reps.Data = append(reps.Data, jiva.Replica{Address: "tcp://10.0.0.2:9502", Mode: mode})
+		}
+		_ = json.NewEncoder(w).Encode(reps)
+	}))
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jivaTargetPort = port
+	return server, host
+}
+
+func TestControllerGetVolumeRebuilding(t *testing.T) {
+	defer func(port string, ttl time.Duration) {
+		jivaTargetPort, replicaStatusTTL = port, ttl
+	}(jivaTargetPort, replicaStatusTTL)
+	replicaStatusTTL = time.Hour
+
+	var requests int32
+	modes := []string{"RW", "WO", "RW"}
+	server, targetIP := newFakeReplicaTarget(t, &modes, &requests)
+	defer server.Close()
+
+	// CR status is stale, the replicas reported by the target are used
+	vol := newReadyJivaVolume("5Gi", targetIP)
+	vol.Spec.Policy.Target.ReplicationFactor = 3
+	cs, _ := newFakeController(t, vol)
+
+	getCondition := func() *csi.VolumeCondition {
+		resp, err := cs.ControllerGetVolume(context.TODO(), &csi.ControllerGetVolumeRequest{VolumeId: testVolumeID})
+		if err != nil {
+			t.Fatalf("expected volume to be found, got err: %v", err)
+		}
+		return resp.GetStatus().GetVolumeCondition()
+	}
+
+	for i := 0; i < 3; i++ {
+		cond := getCondition()
+		if !cond.GetAbnormal() || cond.GetMessage() != "rebuilding: 2 of 3 replicas healthy" {
+			t.Fatalf("expected volume to be rebuilding, got: %+v", cond)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected replica status to be cached, got %d requests", requests)
+	}
+
+	// condition is cleared once the rebuild completes
+	modes[1] = "RW"
+	replicaStatusTTL = 0
+	if cond := getCondition(); cond.GetAbnormal() {
+		t.Fatalf("expected volume to be healthy, got: %+v", cond)
+	}
+}
+
+func TestControllerGetVolumeTargetNotReachable(t *testing.T) {
+	defer func(port string) { jivaTargetPort = port }(jivaTargetPort)
+
+	var requests int32
+	modes := []string{}
+	server, targetIP := newFakeReplicaTarget(t, &modes, &requests)
+	server.Close()
+
+	// replica status in the CR is reported instead
+	vol := newReadyJivaVolume("5Gi", targetIP)
+	vol.Spec.Policy.Target.ReplicationFactor = 2
+	cs, _ := newFakeController(t, vol)
+
+	resp, err := cs.ControllerGetVolume(context.TODO(), &csi.ControllerGetVolumeRequest{VolumeId: testVolumeID})
+	if err != nil {
+		t.Fatalf("expected volume to be found, got err: %v", err)
+	}
+	cond := resp.GetStatus().GetVolumeCondition()
+	if !cond.GetAbnormal() || cond.GetMessage() != "volume is degraded: 1 of 2 replicas are healthy" {
+		t.Fatalf("expected volume to be degraded, got: %+v", cond)
+	}
+}
+
+func TestReplicaStatusCacheError(t *testing.T) {
+	defer func(port string, ttl time.Duration) {
+		jivaTargetPort, replicaStatusErrorTTL = port, ttl
+	}(jivaTargetPort, replicaStatusErrorTTL)
+	replicaStatusErrorTTL = time.Hour
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetIP, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jivaTargetPort = port
+
+	cache := newReplicaStatusCache(nil)
+	for i := 0; i < 3; i++ {
+		if _, err := cache.get(context.TODO(), targetIP); err == nil {
+			t.Fatal("expected error from the target")
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected error to be cached, target got %v requests", n)
+	}
+
+	replicaStatusErrorTTL = 0
+	if _, err := cache.get(context.TODO(), targetIP); err == nil {
+		t.Fatal("expected error from the target")
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected expired error to be fetched again, target got %v requests", n)
+	}
+}
+
+func TestReplicaStatusCachePrune(t *testing.T) {
+	defer func(port string) { jivaTargetPort = port }(jivaTargetPort)
+
+	var requests int32
+	modes := []string{"RW"}
+	server, targetIP := newFakeReplicaTarget(t, &modes, &requests)
+	defer server.Close()
+
+	// entry of a deleted volume which is never fetched again
+	cache := newReplicaStatusCache(nil)
+	cache.entries["10.0.0.99"] = replicaStatusEntry{fetched: time.Now().Add(-2 * replicaStatusTTL)}
+
+	if _, err := cache.get(context.TODO(), targetIP); err != nil {
+		t.Fatalf("expected replicas, got err: %v", err)
+	}
+	if _, ok := cache.entries["10.0.0.99"]; ok {
+		t.Fatal("expected expired entry to be pruned")
+	}
+	if _, ok := cache.entries[targetIP]; !ok {
+		t.Fatal("expected fetched entry to be cached")
+	}
+}
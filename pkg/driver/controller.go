@@ -18,28 +18,42 @@ package driver
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/openebs/jiva-csi/pkg/jiva"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/utils"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
-	"github.com/openebs/jiva-operator/pkg/jiva"
-	"github.com/openebs/jiva-operator/pkg/volume"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/cloud-provider/volume/helpers"
+	"k8s.io/utils/keymutex"
 )
 
 // controller is the server implementation
 // for CSI Controller
 type controller struct {
 	client       *client.Client
+	driver       *CSIDriver
 	capabilities []*csi.ControllerServiceCapability
+
+	// volumeLocks serializes the CreateVolume and
+	// ControllerModifyVolume requests for the same volume
+	volumeLocks keymutex.KeyMutex
+
+	// replicaStatus caches the replicas reported by
+	// the jiva targets for the volume condition
+	replicaStatus *replicaStatusCache
 }
 
 // SupportedVolumeCapabilityAccessModes contains the list of supported access
@@ -48,28 +62,83 @@ var SupportedVolumeCapabilityAccessModes = []*csi.VolumeCapability_AccessMode{
 	&csi.VolumeCapability_AccessMode{
 		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
 	},
+	// ReadWriteOnce is sent as SINGLE_NODE_MULTI_WRITER by the
+	// clusters which support ReadWriteOncePod i.e k8s 1.22+
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER,
+	},
+	// ReadWriteOncePod
+	&csi.VolumeCapability_AccessMode{
+		Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+	},
 }
 
+// SupportedVolumeCapabilityAccessType contains the list of supported
+// filesystem access types for the volume
 var SupportedVolumeCapabilityAccessType = []*csi.VolumeCapability_Mount{
 	&csi.VolumeCapability_Mount{
 		Mount: &csi.VolumeCapability_MountVolume{},
 	},
 }
 
+// SupportedVolumeCapabilityBlockAccessType contains the list of supported
+// raw block access types for the volume
+var SupportedVolumeCapabilityBlockAccessType = []*csi.VolumeCapability_Block{
+	&csi.VolumeCapability_Block{
+		Block: &csi.VolumeCapability_BlockVolume{},
+	},
+}
+
+const (
+	// publishedNodeAnnotation is set on the JivaVolume CR with the
+	// node to which the volume is published by ControllerPublishVolume
+	publishedNodeAnnotation = "openebs.io/published-node"
+
+	// publishContextVolumeName, publishContextIQN and publishContextPortal
+	// are the keys of the publish context returned by ControllerPublishVolume,
+	// node plugin validates them before staging and publishing the volume
+	publishContextVolumeName = "volumeName"
+	publishContextIQN        = "iqn"
+	publishContextPortal     = "portal"
+)
+
 var (
-	httpReqRetryCount    = 5
-	httpReqRetryInterval = 2 * time.Second
+	// controllerPublishTimeout is the max time ControllerPublishVolume
+	// waits for the jiva target to be ready
+	controllerPublishTimeout  = 2 * time.Minute
+	controllerPublishInterval = 5 * time.Second
+
+	// provisioningInterval is the interval at which CreateVolume
+	// checks whether the target of the volume is ready
+	provisioningInterval = 5 * time.Second
+
+	// deleteVolumeRetries is the number of times deletion of the
+	// JivaVolume is retried on transient errors, the wait between
+	// the attempts starts at deleteVolumeRetryInterval and is
+	// doubled after each retry
+	deleteVolumeRetries       = 3
+	deleteVolumeRetryInterval = time.Second
 )
 
 // NewController returns a new instance
 // of CSI controller
-func NewController(cli *client.Client) csi.ControllerServer {
+func NewController(d *CSIDriver, cli *client.Client) csi.ControllerServer {
 	return &controller{
-		client:       cli,
-		capabilities: newControllerCapabilities(),
+		client:        cli,
+		driver:        d,
+		capabilities:  newControllerCapabilities(),
+		volumeLocks:   keymutex.NewHashed(0),
+		replicaStatus: newReplicaStatusCache(d.jivaTLS),
 	}
 }
 
+func init() {
+	registerControllerCapabilities("CreateVolume",
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+	)
+}
+
 // CreateVolume provisions a volume
 func (cs *controller) CreateVolume(
 	ctx context.Context,
@@ -80,24 +149,175 @@ func (cs *controller) CreateVolume(
 		return nil, err
 	}
 
+	// provisioner may retry CreateVolume before the previous
+	// request for the same volume completes, the later request
+	// finds the JivaVolume created by the earlier one
+	cs.volumeLocks.LockKey(utils.StripName(req.GetName()))
+	defer func() {
+		_ = cs.volumeLocks.UnlockKey(utils.StripName(req.GetName()))
+	}()
+
 	// set client each time to avoid caching issue
 	if err := cs.client.Set(); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
+		return nil, status.Errorf(codes.Internal, "CreateVolume: failed to set client, err: {%v}", err)
+	}
+
+	timeout := cs.driver.config.CreateVolumeTimeout
+	if timeout == 0 {
+		return cs.createVolume(ctx, req)
+	}
+
+	// volume which is partially provisioned when the timeout
+	// expires is rolled back so that the retry starts clean,
+	// the provisioner's own deadline only aborts the request
+	createCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := cs.createVolume(createCtx, req)
+	if err == nil || ctx.Err() != nil || createCtx.Err() != context.DeadlineExceeded {
+		return resp, err
+	}
+
+	logrus.Errorf("CreateVolume: volume {%v} is not provisioned within %v, rolling back, err: {%v}", req.GetName(), timeout, err)
+	if rollbackErr := cs.rollbackVolume(req.GetName()); rollbackErr != nil {
+		return nil, status.Errorf(codes.DeadlineExceeded,
+			"CreateVolume: volume {%v} is not provisioned within %v, failed to roll back, err: {%v}", req.GetName(), timeout, rollbackErr)
+	}
+	msg := fmt.Sprintf("volume {%v} is not provisioned within %v and is rolled back", req.GetName(), timeout)
+	cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, msg)
+	return nil, status.Errorf(codes.DeadlineExceeded, "CreateVolume: %s", msg)
+}
+
+// createVolume provisions the volume, it is called by CreateVolume
+// with the volume lock held and the client set
+func (cs *controller) createVolume(
+	ctx context.Context,
+	req *csi.CreateVolumeRequest,
+) (*csi.CreateVolumeResponse, error) {
+
+	if min, requested := cs.minVolumeSize(), client.RequiredBytes(req); requested < min {
+		return nil, status.Errorf(codes.OutOfRange,
+			"CreateVolume: requested size {%v} of volume {%v} is below the minimum volume size {%v}",
+			utils.FormatCapacity(requested), req.GetName(), utils.FormatCapacity(min))
 	}
 
-	if err := cs.client.CreateJivaVolume(req); err != nil {
+	// capacity is recorded in the JivaVolume and returned
+	// after rounding it up to the allocation granularity
+	capacity := client.CapacityBytes(req)
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit != 0 && capacity > limit {
+		return nil, status.Errorf(codes.OutOfRange,
+			"CreateVolume: capacity {%v} of volume {%v} after rounding up to GiB exceeds the limit {%v}",
+			utils.FormatCapacity(capacity), req.GetName(), utils.FormatCapacity(limit))
+	}
+
+	if err := ValidateParameters(req.GetParameters(), cs.client.JivaVolumePolicyExists); err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+
+	// jiva-operator can't sync the replicas of a new volume from
+	// another volume or its snapshot, so CLONE_VOLUME isn't advertised
+	// and both are rejected instead of provisioning an empty volume
+	if src := req.GetVolumeContentSource().GetVolume(); src != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume: cloning volume {%v} from volume {%v} is not supported", req.GetName(), src.GetVolumeId())
+	}
+
+	if src := req.GetVolumeContentSource().GetSnapshot(); src != nil {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"CreateVolume: restoring volume {%v} from snapshot {%v} is not supported", req.GetName(), src.GetSnapshotId())
+	}
+
+	// pool and zones are checked with the replica count
+	// the volume is created with, not just the default
+	replicaCount, err := cs.client.ReplicaCount(req)
+	if err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+	effectiveCount, err := cs.effectiveReplicaCount(req, replicaCount)
+	if err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+
+	replicaPool, err := cs.selectReplicaPool(req, effectiveCount)
+	if err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+
+	if err := cs.checkReplicaZones(req, effectiveCount); err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+
+	if err := cs.client.CreateJivaVolume(req, replicaPool, replicaCount); err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
 		return nil, err
 	}
 
-	logrus.Infof("CreateVolume: volume: {%v} is created", req.GetName())
+	if err := cs.waitForProvisioned(ctx, req.GetName()); err != nil {
+		cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+		return nil, err
+	}
+
+	// node plugin gets the mount propagation, the reserved blocks,
+	// the mkfs options, the filesystem label, skip format and the
+	// owner from the volume context while staging and publishing
+	var volumeContext map[string]string
+	for _, key := range []string{mountPropagationKey, reservedBlocksKey, mkfsOptionsKey, fsLabelKey, skipFormatKey, ownerUIDKey, ownerGIDKey} {
+		if val, ok := req.GetParameters()[key]; ok {
+			if volumeContext == nil {
+				volumeContext = map[string]string{}
+			}
+			volumeContext[key] = val
+		}
+	}
+
+	if isRWXRequest(req.GetVolumeCapabilities()) {
+		nfsContext, err := cs.provisionNFSServer(req)
+		if err != nil {
+			cs.driver.recordProvisioningEvent(req, reasonProvisioningFailed, err.Error())
+			return nil, err
+		}
+		if volumeContext == nil {
+			volumeContext = map[string]string{}
+		}
+		for k, v := range nfsContext {
+			volumeContext[k] = v
+		}
+	}
+
+	var topology []*csi.Topology
+	if segments := client.AccessibleTopology(req); len(segments) != 0 {
+		topology = []*csi.Topology{{Segments: segments}}
+	}
+
+	logrus.Infof("CreateVolume: volume: {%v} is created with capacity {%v}", req.GetName(), utils.FormatCapacity(capacity))
+	cs.driver.notifyWebhook(webhookEventProvisioned, utils.VolumeID(req.GetName()), "", "")
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      req.GetName(),
-			CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+			VolumeId:           utils.VolumeID(req.GetName()),
+			CapacityBytes:      capacity,
+			AccessibleTopology: topology,
+			VolumeContext:      volumeContext,
 		},
 	}, nil
 }
 
+// minVolumeSize returns the smallest size which can be requested
+// in CreateVolume, jiva minimum is used if it is not configured
+func (cs *controller) minVolumeSize() int64 {
+	if min := cs.driver.config.MinVolumeSize; min > 0 {
+		return min
+	}
+	return client.MinVolumeSizeBytes
+}
+
+func init() {
+	registerControllerCapabilities("DeleteVolume", csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)
+}
+
 // DeleteVolume deletes the specified volume
 func (cs *controller) DeleteVolume(
 	ctx context.Context,
@@ -109,21 +329,57 @@ func (cs *controller) DeleteVolume(
 			"Failed to validate volume create request: missing volume name",
 		)
 	}
-	volID = strings.ToLower(volID)
+	volID = utils.StripName(volID)
 	// set client each time to avoid caching issue
 	if err := cs.client.Set(); err != nil {
 		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to set client, err: {%v}", err)
 	}
 
-	if err := cs.client.DeleteJivaVolume(volID); err != nil {
-		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to delete volume {%v}, err: {%v}", req.VolumeId, err)
+	if err := cs.deleteNFSServer(volID); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to delete nfs server of volume {%v}, err: {%v}", req.VolumeId, err)
+	}
+
+	// deletion resumes from where the previous attempt stopped, i.e
+	// the CR which is already marked for deletion only has its
+	// finalizer removed and the CR which is gone is a success
+	interval := deleteVolumeRetryInterval
+	for attempt := 0; ; attempt++ {
+		err := cs.client.DeleteJivaVolume(volID)
+		if err == nil {
+			break
+		}
+		if !isTransientError(err) || attempt == deleteVolumeRetries {
+			return nil, status.Errorf(codes.Internal, "DeleteVolume: failed to delete volume {%v}, err: {%v}", req.VolumeId, err)
+		}
+
+		logrus.Warningf("DeleteVolume: failed to delete volume {%v}, retrying in %v (attempt %d/%d), err: {%v}",
+			req.VolumeId, interval, attempt+1, deleteVolumeRetries, err)
+		if err := sleepWithContext(ctx, interval); err != nil {
+			return nil, contextStatus(ctx, "DeleteVolume")
+		}
+		interval *= 2
 	}
 
 	logrus.Infof("DeleteVolume: volume {%s} is deleted", req.VolumeId)
+	cs.driver.notifyWebhook(webhookEventDeleted, req.GetVolumeId(), "", "")
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
-// TODO Implementation will be taken up later
+// isTransientError returns true if the kube-apiserver error may
+// not occur on retry, i.e conflict on update of the CR which is
+// being updated by jiva-operator or the apiserver being overloaded
+func isTransientError(err error) bool {
+	return errors.IsConflict(err) ||
+		errors.IsServerTimeout(err) ||
+		errors.IsTimeout(err) ||
+		errors.IsTooManyRequests(err) ||
+		errors.IsServiceUnavailable(err) ||
+		errors.IsInternalError(err)
+}
+
+func init() {
+	registerControllerCapabilities("ValidateVolumeCapabilities", csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER)
+}
 
 // ValidateVolumeCapabilities validates the capabilities
 // required to create a new volume
@@ -152,12 +408,23 @@ func (cs *controller) ValidateVolumeCapabilities(
 		return nil, err
 	}
 
-	var confirmed *csi.ValidateVolumeCapabilitiesResponse_Confirmed
-	if isValidVolumeCapabilities(volCaps) {
-		confirmed = &csi.ValidateVolumeCapabilitiesResponse_Confirmed{VolumeCapabilities: volCaps}
+	// capabilities are confirmed only if all
+	// of them are supported by the driver
+	for _, volCap := range volCaps {
+		if err := validateVolumeCapability(volCap, cs.driver.config.EnableRWX); err != nil {
+			logrus.Infof("ValidateVolumeCapabilities: volume {%v} capability {%v} is rejected, %v", volumeID, volCap, err)
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Message: fmt.Sprintf("volume capability {%v} is not supported: %v", volCap, err),
+			}, nil
+		}
 	}
+
 	return &csi.ValidateVolumeCapabilitiesResponse{
-		Confirmed: confirmed,
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: volCaps,
+			Parameters:         req.GetParameters(),
+		},
 	}, nil
 }
 
@@ -176,7 +443,7 @@ func (cs *controller) ControllerGetCapabilities(
 	return resp, nil
 }
 
-func (cs *controller) isVolumeReady(volumeID string) (*jv.JivaVolume, error) {
+func (cs *controller) isVolumeReady(ctx context.Context, volumeID string) (*jv.JivaVolume, error) {
 	var interval time.Duration = 0
 	var instance *jv.JivaVolume
 	var i int
@@ -184,7 +451,9 @@ func (cs *controller) isVolumeReady(volumeID string) (*jv.JivaVolume, error) {
 		if i == MaxRetryCount {
 			return nil, status.Errorf(codes.Internal, "ExpandVolume: max retry count exceeded")
 		}
-		time.Sleep(interval * time.Second)
+		if err := sleepWithContext(ctx, interval*time.Second); err != nil {
+			return nil, contextStatus(ctx, "ExpandVolume")
+		}
 		// set client each time to avoid caching issue
 		err := cs.client.Set()
 		if err != nil {
@@ -226,6 +495,10 @@ func (cs *controller) isVolumeReady(volumeID string) (*jv.JivaVolume, error) {
 	return instance, nil
 }
 
+func init() {
+	registerControllerCapabilities("ControllerExpandVolume", csi.ControllerServiceCapability_RPC_EXPAND_VOLUME)
+}
+
 // ControllerExpandVolume resizes previously provisioned volume
 //
 // This implements csi.ControllerServer
@@ -238,61 +511,105 @@ func (cs *controller) ControllerExpandVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
 
-	volumeID = utils.StripName(volumeID)
-	jivaVolume, err := cs.isVolumeReady(volumeID)
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Capacity range not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
 	if err != nil {
 		return nil, err
 	}
 
-	updatedSize := req.GetCapacityRange().GetRequiredBytes()
-	vol := volume.Volumes{}
-	ctrlIP := jivaVolume.Spec.ISCSISpec.TargetIP
-	if len(ctrlIP) == 0 {
-		return nil, status.Errorf(codes.Internal, "Target IP is nil")
+	// filesystem of the volume is mounted by the nfs server
+	// which doesn't grow it after the target is resized
+	if instance.Annotations[client.NFSServerAnnotation] != "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "ExpandVolume: expansion of ReadWriteMany volume {%v} is not supported", volumeID)
 	}
 
-	cli := jiva.NewControllerClient(jivaVolume.Spec.ISCSISpec.TargetIP + ":9501")
-	cli.SetTimeout(30 * time.Second)
-	retryCount := 0
-	var httpErr error
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Get("/volumes", &vol)
-		if httpErr == nil {
-			break
-		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
+	currentSize, err := getCapacityBytes(instance.Spec.Capacity)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ExpandVolume: failed to parse capacity of volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	// capacity of the volume is rounded up to GiB, so the
+	// requested size is compared after rounding it up
+	requestedSize := req.GetCapacityRange().GetRequiredBytes()
+	roundedSize := client.RoundUpCapacity(requestedSize)
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit != 0 && roundedSize > limit {
+		return nil, status.Errorf(codes.OutOfRange,
+			"ExpandVolume: capacity {%v} of volume {%v} after rounding up to GiB exceeds the limit {%v}",
+			utils.FormatCapacity(roundedSize), volumeID, utils.FormatCapacity(limit))
 	}
 
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to get volume info from jiva controller, err: %v", httpErr)
+	if roundedSize < currentSize {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"ExpandVolume: requested size {%v} is smaller than the current size {%v} of volume {%v}, shrinking a volume is not supported",
+			utils.FormatCapacity(requestedSize), utils.FormatCapacity(currentSize), volumeID)
 	}
 
-	if len(vol.Data) == 0 {
-		return nil, status.Error(codes.Internal, "Failed to get volume info, no volume found")
+	if roundedSize == currentSize {
+		logrus.Infof("ExpandVolume: volume {%v} is already of the requested size {%v}", volumeID, utils.FormatCapacity(currentSize))
+		return &csi.ControllerExpandVolumeResponse{
+			CapacityBytes:         currentSize,
+			NodeExpansionRequired: false,
+		}, nil
 	}
 
-	size := resource.NewQuantity(updatedSize, resource.BinarySI)
-	volSizeGiB := helpers.RoundUpToGiB(*size)
-	capacity := fmt.Sprintf("%dGi", volSizeGiB)
+	resp, err := cs.expandVolume(ctx, req)
+	if err != nil {
+		cs.driver.recordVolumeEvent(cs.client, volumeID, reasonResizeFailed, err.Error())
+		return nil, err
+	}
+	logrus.Infof("ExpandVolume: volume {%v} is resized from {%v} to {%v}",
+		volumeID, utils.FormatCapacity(currentSize), utils.FormatCapacity(resp.GetCapacityBytes()))
+	cs.driver.notifyWebhook(webhookEventResized, volumeID, "",
+		fmt.Sprintf("volume is resized to %v", utils.FormatCapacity(resp.GetCapacityBytes())))
+	return resp, nil
+}
 
-	input := volume.ResizeInput{
-		Name: vol.Data[0].Name,
-		Size: capacity,
+// expandVolume resizes the jiva target and updates the
+// capacity in the JivaVolume CR
+func (cs *controller) expandVolume(
+	ctx context.Context,
+	req *csi.ControllerExpandVolumeRequest,
+) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := utils.StripName(req.GetVolumeId())
+	jivaVolume, err := cs.isVolumeReady(ctx, volumeID)
+	if err != nil {
+		return nil, err
 	}
 
-	retryCount = 0
-	for retryCount < httpReqRetryCount {
-		httpErr = cli.Post(vol.Data[0].Actions["resize"], input, nil)
-		if httpErr == nil {
-			break
+	updatedSize := client.RoundUpCapacity(req.GetCapacityRange().GetRequiredBytes())
+	cli, err := cs.newJivaClient(jivaVolume.Spec.ISCSISpec.TargetIP)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	vol, err := cli.GetVolume(ctx)
+	if err != nil {
+		if ctxErr := contextStatus(ctx, "ExpandVolume"); ctxErr != nil {
+			return nil, ctxErr
 		}
-		time.Sleep(httpReqRetryInterval)
-		retryCount++
+		return nil, jiva.Errorf(err, "Failed to get volume info from jiva controller, err: %v", err)
+	}
+
+	capacity := fmt.Sprintf("%dGi", updatedSize/helpers.GiB)
+
+	input := jiva.ResizeInput{
+		Name: vol.Name,
+		Size: capacity,
 	}
 
-	if httpErr != nil {
-		return nil, status.Errorf(codes.Internal, "Failed to post resize request to jiva controller, err: %v", httpErr)
+	if err := cli.PostAction(ctx, vol, jiva.ResizeAction, input); err != nil {
+		if ctxErr := contextStatus(ctx, "ExpandVolume"); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, jiva.Errorf(err, "Failed to post resize request to jiva controller, err: %v", err)
 	}
 
 	// set client each time to avoid caching issue
@@ -301,6 +618,20 @@ func (cs *controller) ControllerExpandVolume(
 	}
 
 	jivaVolume.Spec.Capacity = capacity
+	if jivaVolume.Annotations == nil {
+		jivaVolume.Annotations = map[string]string{}
+	}
+	jivaVolume.Annotations[client.CapacityBytesAnnotation] = strconv.FormatInt(updatedSize, 10)
+
+	// there is no mount of a volume which is not published to any
+	// node to be expanded, so the filesystem is resized by the next
+	// NodeStageVolume once the volume is staged again
+	expansionRequired := nodeExpansionRequired(req, jivaVolume)
+	if expansionRequired && jivaVolume.Annotations[publishedNodeAnnotation] == "" {
+		logrus.Infof("ExpandVolume: volume {%v} is offline, filesystem resize is deferred to the next NodeStageVolume", volumeID)
+		jivaVolume.Annotations[client.ResizePendingAnnotation] = strconv.FormatInt(updatedSize, 10)
+	}
+
 	err = cs.client.UpdateJivaVolume(jivaVolume)
 	if err != nil {
 		return nil, err
@@ -308,10 +639,25 @@ func (cs *controller) ControllerExpandVolume(
 
 	return &csi.ControllerExpandVolumeResponse{
 		CapacityBytes:         updatedSize,
-		NodeExpansionRequired: true,
+		NodeExpansionRequired: expansionRequired,
 	}, nil
 }
 
+// nodeExpansionRequired returns false for a raw block volume as there
+// is no filesystem to be expanded on the node. Access type is taken
+// from the volume capability of the request, if it is not set the
+// access type recorded in the JivaVolume while staging is used.
+func nodeExpansionRequired(req *csi.ControllerExpandVolumeRequest, instance *jv.JivaVolume) bool {
+	if volCap := req.GetVolumeCapability(); volCap != nil {
+		return volCap.GetBlock() == nil
+	}
+	return !isBlockVolume(instance)
+}
+
+func init() {
+	registerControllerCapabilities("CreateSnapshot", csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
+}
+
 // CreateSnapshot creates a snapshot for given volume
 //
 // This implements csi.ControllerServer
@@ -320,7 +666,91 @@ func (cs *controller) CreateSnapshot(
 	req *csi.CreateSnapshotRequest,
 ) (*csi.CreateSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	snapshotID := strings.ToLower(req.GetName())
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: snapshot name not provided")
+	}
+
+	sourceVolumeID := req.GetSourceVolumeId()
+	if len(sourceVolumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateSnapshot: source volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	snap, err := cs.client.GetJivaSnapshot(snapshotID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to get JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+	}
+
+	if snap != nil && snap.SourceVolume != sourceVolumeID {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"CreateSnapshot: snapshot {%v} already exists for a different source volume {%v}",
+			snapshotID, snap.SourceVolume)
+	}
+
+	if snap != nil && snap.ReadyToUse {
+		logrus.Infof("CreateSnapshot: snapshot {%v} of volume {%v} already exists", snapshotID, sourceVolumeID)
+		return newCreateSnapshotResponse(snap)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(sourceVolumeID))
+	if err != nil {
+		return nil, err
+	}
+
+	if snap == nil {
+		size, err := getCapacityBytes(instance.Spec.Capacity)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to parse capacity of volume {%v}, err: {%v}", sourceVolumeID, err)
+		}
+
+		// CR is created before taking the snapshot on the
+		// jiva target so that the snapshot is not leaked
+		// if the request fails midway
+		snap = &client.JivaSnapshot{
+			Name:         snapshotID,
+			Namespace:    instance.Namespace,
+			SourceVolume: sourceVolumeID,
+			SizeBytes:    size,
+			CreationTime: time.Now(),
+		}
+		if err := cs.client.CreateJivaSnapshot(snap); err != nil {
+			return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to create JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+		}
+	}
+
+	if err := cs.takeSnapshot(ctx, instance.Spec.ISCSISpec.TargetIP, snapshotID); err != nil {
+		if ctxErr := contextStatus(ctx, "CreateSnapshot"); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, jiva.Errorf(err, "CreateSnapshot: failed to take snapshot {%v} of volume {%v}, err: {%v}", snapshotID, sourceVolumeID, err)
+	}
+
+	snap.ReadyToUse = true
+	if err := cs.client.UpdateJivaSnapshot(snap); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: failed to update JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+	}
+
+	logrus.Infof("CreateSnapshot: snapshot {%v} of volume {%v} is created", snapshotID, sourceVolumeID)
+	return newCreateSnapshotResponse(snap)
+}
+
+func newCreateSnapshotResponse(snap *client.JivaSnapshot) (*csi.CreateSnapshotResponse, error) {
+	snapshot, err := newCSISnapshot(snap)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateSnapshot: invalid creation time of snapshot {%v}, err: {%v}", snap.Name, err)
+	}
+	return &csi.CreateSnapshotResponse{
+		Snapshot: snapshot,
+	}, nil
+}
+
+func init() {
+	registerControllerCapabilities("DeleteSnapshot", csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT)
 }
 
 // DeleteSnapshot deletes given snapshot
@@ -331,7 +761,53 @@ func (cs *controller) DeleteSnapshot(
 	req *csi.DeleteSnapshotRequest,
 ) (*csi.DeleteSnapshotResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	snapshotID := req.GetSnapshotId()
+	if len(snapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot: snapshot ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	snap, err := cs.client.GetJivaSnapshot(snapshotID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to get JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+	}
+
+	if snap == nil {
+		logrus.Warningf("DeleteSnapshot: JivaSnapshot {%v} not found, ignore deletion...", snapshotID)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(snap.SourceVolume))
+	if err != nil && status.Code(err) != codes.NotFound {
+		return nil, err
+	}
+
+	// snapshots are part of the replicas of the source volume,
+	// so there is nothing to clean up on the jiva target once
+	// the source volume is deleted
+	if instance != nil {
+		if err := cs.deleteSnapshot(ctx, instance.Spec.ISCSISpec.TargetIP, snapshotID); err != nil {
+			if ctxErr := contextStatus(ctx, "DeleteSnapshot"); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, jiva.Errorf(err, "DeleteSnapshot: failed to delete snapshot {%v} of volume {%v}, err: {%v}", snapshotID, snap.SourceVolume, err)
+		}
+	}
+
+	if err := cs.client.DeleteJivaSnapshot(snap); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteSnapshot: failed to delete JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+	}
+
+	logrus.Infof("DeleteSnapshot: snapshot {%v} is deleted", snapshotID)
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func init() {
+	registerControllerCapabilities("ListSnapshots", csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS)
 }
 
 // ListSnapshots lists all snapshots for the
@@ -343,7 +819,69 @@ func (cs *controller) ListSnapshots(
 	req *csi.ListSnapshotsRequest,
 ) (*csi.ListSnapshotsResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ListSnapshots: invalid max entries: {%v}", req.GetMaxEntries())
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ListSnapshots: failed to set client, err: {%v}", err)
+	}
+
+	snaps, err := cs.client.ListJivaSnapshots()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ListSnapshots: failed to list JivaSnapshots, err: {%v}", err)
+	}
+
+	snaps = filterSnapshots(snaps, req.GetSnapshotId(), req.GetSourceVolumeId())
+
+	// sort the snapshots by name so that the
+	// pagination tokens remain stable across calls
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Name < snaps[j].Name
+	})
+
+	start, end, nextToken, err := paginate(len(snaps), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*csi.ListSnapshotsResponse_Entry{}
+	for _, snap := range snaps[start:end] {
+		snapshot, err := newCSISnapshot(snap)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "ListSnapshots: invalid creation time of snapshot {%v}, err: {%v}", snap.Name, err)
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: snapshot,
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// filterSnapshots returns the snapshots matching the given
+// snapshot ID and source volume ID, empty filters match
+// all the snapshots
+func filterSnapshots(snaps []*client.JivaSnapshot, snapshotID, sourceVolumeID string) []*client.JivaSnapshot {
+	filtered := []*client.JivaSnapshot{}
+	for _, snap := range snaps {
+		if snapshotID != "" && snap.Name != snapshotID {
+			continue
+		}
+		if sourceVolumeID != "" && snap.SourceVolume != sourceVolumeID {
+			continue
+		}
+		filtered = append(filtered, snap)
+	}
+	return filtered
+}
+
+func init() {
+	registerControllerCapabilities("ControllerUnpublishVolume", csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
 }
 
 // ControllerUnpublishVolume removes a previously
@@ -355,7 +893,48 @@ func (cs *controller) ControllerUnpublishVolume(
 	req *csi.ControllerUnpublishVolumeRequest,
 ) (*csi.ControllerUnpublishVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerUnpublishVolume: volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+	if status.Code(err) == codes.NotFound {
+		logrus.Infof("ControllerUnpublishVolume: volume {%v} not found, assuming it is unpublished", volumeID)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// empty node ID means the volume needs
+	// to be unpublished from all the nodes
+	published := instance.Annotations[publishedNodeAnnotation]
+	if published == "" || (req.GetNodeId() != "" && published != req.GetNodeId()) {
+		logrus.Infof("ControllerUnpublishVolume: volume {%v} is not published to node {%v}", volumeID, req.GetNodeId())
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	// jiva target doesn't keep a list of the initiators allowed to
+	// login and has no API to deregister one, the session of the node
+	// is logged out by NodeUnstageVolume. Clearing the published node
+	// is therefore all that is needed for the volume to be published
+	// to a different node.
+	delete(instance.Annotations, publishedNodeAnnotation)
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerUnpublishVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	logrus.Infof("ControllerUnpublishVolume: volume {%v} is unpublished from node {%v}", volumeID, published)
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func init() {
+	registerControllerCapabilities("ControllerPublishVolume", csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME)
 }
 
 // ControllerPublishVolume attaches given volume
@@ -367,11 +946,214 @@ func (cs *controller) ControllerPublishVolume(
 	req *csi.ControllerPublishVolumeRequest,
 ) (*csi.ControllerPublishVolumeResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume ID not provided")
+	}
+
+	nodeID := req.GetNodeId()
+	if len(nodeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: node ID not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume capability not provided")
+	}
+
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}, cs.driver.config.EnableRWX) {
+		return nil, status.Error(codes.InvalidArgument, "ControllerPublishVolume: volume capability not supported")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+	if err != nil {
+		return nil, err
+	}
+
+	// ReadWriteMany volume is mounted from the nfs server
+	// on any number of nodes
+	if instance.Annotations[client.NFSServerAnnotation] != "" {
+		logrus.Infof("ControllerPublishVolume: ReadWriteMany volume {%v} is published to node {%v}", volumeID, nodeID)
+		return &csi.ControllerPublishVolumeResponse{
+			PublishContext: map[string]string{
+				publishContextVolumeName: instance.Name,
+			},
+		}, nil
+	}
+
+	// rest of the supported access modes are single node
+	published := instance.Annotations[publishedNodeAnnotation]
+	if published != "" && published != nodeID {
+		if err := cs.forceDetach(instance, published, nodeID); err != nil {
+			return nil, err
+		}
+		published = ""
+	}
+
+	if published == "" {
+		if instance.Annotations == nil {
+			instance.Annotations = map[string]string{}
+		}
+		instance.Annotations[publishedNodeAnnotation] = nodeID
+		if err := cs.client.UpdateJivaVolume(instance); err != nil {
+			return nil, status.Errorf(codes.Internal, "ControllerPublishVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+		}
+	}
+
+	instance, err = cs.waitForTargetReady(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("ControllerPublishVolume: volume {%v} is published to node {%v}", volumeID, nodeID)
+	return &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			publishContextVolumeName: instance.Name,
+			publishContextIQN:        instance.Spec.ISCSISpec.Iqn,
+			publishContextPortal:     targetPortal(instance, cs.driver.config.ClusterDomain),
+		},
+	}, nil
 }
 
-// GetCapacity return the capacity of the
-// given volume
+// waitForTargetReady waits till the jiva target of the volume
+// is ready to serve the iSCSI sessions, DeadlineExceeded is
+// returned if it is not ready within controllerPublishTimeout
+// and Canceled as soon as the caller cancels the request. Jiva
+// target doesn't keep a list of the allowed initiators, so there
+// is no registration of the node to wait for.
+func (cs *controller) waitForTargetReady(parent context.Context, volumeID string) (*jv.JivaVolume, error) {
+	ctx, cancel := context.WithTimeout(parent, controllerPublishTimeout)
+	defer cancel()
+
+	for {
+		instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+		if err != nil {
+			return nil, err
+		}
+
+		if isTargetReady(instance) {
+			return instance, nil
+		}
+
+		logrus.Warningf("ControllerPublishVolume: target of volume {%v} is not ready, phase: {%v}, status: {%v}",
+			volumeID, instance.Status.Phase, instance.Status.Status)
+
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return nil, contextStatus(parent, "ControllerPublishVolume")
+			}
+			return nil, status.Errorf(codes.DeadlineExceeded,
+				"ControllerPublishVolume: target of volume {%v} is not ready, err: {%v}", volumeID, ctx.Err())
+		case <-time.After(controllerPublishInterval):
+		}
+	}
+}
+
+// isTargetReady returns true if the jiva target of
+// the volume is ready to serve the iSCSI sessions
+func isTargetReady(instance *jv.JivaVolume) bool {
+	return instance.Status.Phase == jv.JivaVolumePhaseReady &&
+		instance.Status.Status == "RW" &&
+		instance.Spec.ISCSISpec.TargetIP != ""
+}
+
+// rollbackVolume deletes the NFS server and the JivaVolume CR of a
+// volume which is not provisioned yet, jiva-operator then deletes
+// its target and replicas. Bound volumes are left as is and it is a
+// no-op if the CR is already gone.
+func (cs *controller) rollbackVolume(name string) error {
+	volumeID := utils.StripName(name)
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if status.Code(err) == codes.NotFound {
+		logrus.Infof("CreateVolume: volume {%v} is already rolled back", volumeID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	phase := instance.Annotations[client.ProvisioningPhaseAnnotation]
+	if phase != client.ProvisioningPhasePending && phase != client.ProvisioningPhaseFailed {
+		logrus.Warningf("CreateVolume: volume {%v} in provisioning phase {%v} is not rolled back", volumeID, phase)
+		return nil
+	}
+
+	if err := cs.deleteNFSServer(volumeID); err != nil {
+		return err
+	}
+	if err := cs.client.DeleteJivaVolume(volumeID); err != nil {
+		return err
+	}
+	logrus.Infof("CreateVolume: volume {%v} is rolled back", volumeID)
+	return nil
+}
+
+// waitForProvisioned waits for the target of the volume to be ready
+// and moves its provisioning phase to Bound. Phase is moved to Failed
+// and Aborted is returned if the target isn't ready within the
+// provisioning timeout, the provisioner retries CreateVolume which then
+// resumes the waiting. Target is only checked once if the timeout is
+// not set.
+func (cs *controller) waitForProvisioned(parent context.Context, name string) error {
+	volumeID := utils.StripName(name)
+	timeout := cs.driver.config.ProvisioningTimeout
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	for {
+		instance, err := cs.client.GetJivaVolume(volumeID)
+		if err != nil {
+			return err
+		}
+
+		// volumes created before the phase was tracked have no phase
+		phase := instance.Annotations[client.ProvisioningPhaseAnnotation]
+		if phase == "" || phase == client.ProvisioningPhaseBound {
+			return nil
+		}
+
+		if isTargetReady(instance) {
+			logrus.Infof("CreateVolume: target of volume {%v} is ready", volumeID)
+			if err := cs.client.SetProvisioningPhase(instance, client.ProvisioningPhaseBound); err != nil {
+				return status.Errorf(codes.Internal, "CreateVolume: failed to set provisioning phase of volume {%v}, err: {%v}", volumeID, err)
+			}
+			return nil
+		}
+
+		if timeout == 0 {
+			return nil
+		}
+
+		logrus.Infof("CreateVolume: waiting for target of volume {%v} to be ready, phase: {%v}, status: {%v}",
+			volumeID, instance.Status.Phase, instance.Status.Status)
+
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				return contextStatus(parent, "CreateVolume")
+			}
+			if err := cs.client.SetProvisioningPhase(instance, client.ProvisioningPhaseFailed); err != nil {
+				logrus.Warningf("CreateVolume: failed to set provisioning phase of volume {%v}, err: {%v}", volumeID, err)
+			}
+			return status.Errorf(codes.Aborted,
+				"CreateVolume: target of volume {%v} is not ready within %v, phase: {%v}", volumeID, timeout, instance.Status.Phase)
+		case <-time.After(provisioningInterval):
+		}
+	}
+}
+
+func init() {
+	registerControllerCapabilities("GetCapacity", csi.ControllerServiceCapability_RPC_GET_CAPACITY)
+}
+
+// GetCapacity returns the capacity available for the volumes
+// of the StorageClass in the given topology segment, computed
+// from the capacity of its replica pools and their usage
 //
 // This implements csi.ControllerServer
 func (cs *controller) GetCapacity(
@@ -379,7 +1161,47 @@ func (cs *controller) GetCapacity(
 	req *csi.GetCapacityRequest,
 ) (*csi.GetCapacityResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity: failed to set client, err: {%v}", err)
+	}
+
+	// If topology is not provided, the pools
+	// of all the segments are taken into account
+	segments := req.GetAccessibleTopology().GetSegments()
+	available, maxVolumeSize, err := cs.getPoolsCapacity(req.GetParameters(), segments)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("GetCapacity: available capacity for topology {%v} is {%v}, max volume size is {%v}",
+		segments, utils.FormatCapacity(available), utils.FormatCapacity(maxVolumeSize))
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+		MaximumVolumeSize: &wrappers.Int64Value{Value: maxVolumeSize},
+	}, nil
+}
+
+// isNodeSchedulable returns true if the node is ready
+// and volume replicas can be scheduled on it
+func isNodeSchedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func init() {
+	registerControllerCapabilities("ListVolumes",
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+	)
 }
 
 // ListVolumes lists all the volumes
@@ -390,7 +1212,246 @@ func (cs *controller) ListVolumes(
 	req *csi.ListVolumesRequest,
 ) (*csi.ListVolumesResponse, error) {
 
-	return nil, status.Error(codes.Unimplemented, "")
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ListVolumes: invalid max entries: {%v}", req.GetMaxEntries())
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ListVolumes: failed to set client, err: {%v}", err)
+	}
+
+	list, err := cs.client.ListJivaVolumes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ListVolumes: failed to list JivaVolumes, err: {%v}", err)
+	}
+
+	// sort the volumes by name so that the
+	// pagination tokens remain stable across calls
+	volumes := list.Items
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+
+	start, end, nextToken, err := paginate(len(volumes), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*csi.ListVolumesResponse_Entry{}
+	for i := start; i < end; i++ {
+		vol := volumes[i]
+		capacity, err := getCapacityBytes(vol.Spec.Capacity)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "ListVolumes: failed to parse capacity of volume {%v}, err: {%v}", vol.Name, err)
+		}
+
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      utils.VolumeID(vol.Spec.PV),
+				CapacityBytes: capacity,
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: getPublishedNodeIDs(&vol),
+				VolumeCondition:  volumeCondition(ctx, cs.replicaStatus, &vol),
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+// paginate returns the range [start, end) of the entries
+// to be returned for the given starting token and max
+// entries, along with the token for the next page. Token
+// is the index of the first entry of the page.
+func paginate(total int, startingToken string, maxEntries int32) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token: {%v}", startingToken)
+		}
+	}
+
+	end := total
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+	}
+
+	nextToken := ""
+	if end < total {
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
+
+func init() {
+	registerControllerCapabilities("ControllerGetVolume",
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+	)
+}
+
+// ControllerGetVolume fetches the current status of the
+// given volume, i.e capacity, nodes where it is published
+// and its health condition
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerGetVolume(
+	ctx context.Context,
+	req *csi.ControllerGetVolumeRequest,
+) (*csi.ControllerGetVolumeResponse, error) {
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+	if err != nil {
+		return nil, err
+	}
+
+	capacity, err := getCapacityBytes(instance.Spec.Capacity)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume: failed to parse capacity of volume {%v}, err: {%v}", volumeID, err)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: capacity,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: getPublishedNodeIDs(instance),
+			VolumeCondition:  volumeCondition(ctx, cs.replicaStatus, instance),
+		},
+	}, nil
+}
+
+func init() {
+	registerControllerCapabilities("ControllerModifyVolume", csi.ControllerServiceCapability_RPC_MODIFY_VOLUME)
+}
+
+// ControllerModifyVolume updates the mutable parameters of
+// the given volume i.e replica count and io limits in the
+// JivaVolume CR, jiva-operator reconciles the replicas and
+// the new io limits are applied when the volume is staged
+//
+// This implements csi.ControllerServer
+func (cs *controller) ControllerModifyVolume(
+	ctx context.Context,
+	req *csi.ControllerModifyVolumeRequest,
+) (*csi.ControllerModifyVolumeResponse, error) {
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerModifyVolume: Volume ID not provided")
+	}
+
+	params := req.GetMutableParameters()
+	if err := ValidateMutableParameters(params); err != nil {
+		return nil, status.Errorf(status.Code(err), "ControllerModifyVolume: %s", status.Convert(err).Message())
+	}
+
+	// CreateVolume locks on the same key, the volume
+	// isn't modified while it is still being created
+	cs.volumeLocks.LockKey(utils.StripName(volumeID))
+	defer func() {
+		_ = cs.volumeLocks.UnlockKey(utils.StripName(volumeID))
+	}()
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: failed to set client, err: {%v}", err)
+	}
+
+	instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := params[client.ReplicaCountAnnotation]; ok {
+		// value is already validated above
+		rf, _ := strconv.Atoi(val)
+
+		// replicas must fit in the pool and the zones same as
+		// the ones the volume is created with
+		if err := cs.checkReplicaPoolCapacity(instance, rf); err != nil {
+			return nil, err
+		}
+		if mode := replicaZoneAntiAffinityMode(instance); mode != "" {
+			if err := cs.checkZonesForReplicas("ControllerModifyVolume", volumeID, mode, rf); err != nil {
+				return nil, err
+			}
+		}
+
+		logrus.Infof("ControllerModifyVolume: updating replica count of volume {%v} from {%v} to {%v}",
+			volumeID, instance.Spec.Policy.Target.ReplicationFactor, rf)
+		instance.Spec.Policy.Target.ReplicationFactor = rf
+	}
+
+	for _, param := range []string{client.IOPSLimitAnnotation, client.BPSLimitAnnotation} {
+		val, ok := params[param]
+		if !ok {
+			continue
+		}
+		if instance.Annotations == nil {
+			instance.Annotations = map[string]string{}
+		}
+		logrus.Infof("ControllerModifyVolume: updating {%v} of volume {%v} to {%v}", param, volumeID, val)
+		instance.Annotations[param] = val
+	}
+
+	if err := cs.client.UpdateJivaVolume(instance); err != nil {
+		return nil, status.Errorf(codes.Internal, "ControllerModifyVolume: failed to update JivaVolume {%v}, err: {%v}", volumeID, err)
+	}
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// getCapacityBytes converts the capacity set in the
+// JivaVolume spec i.e 5Gi into bytes
+func getCapacityBytes(capacity string) (int64, error) {
+	if capacity == "" {
+		return 0, nil
+	}
+	size, err := resource.ParseQuantity(capacity)
+	if err != nil {
+		return 0, err
+	}
+	return size.Value(), nil
+}
+
+// getPublishedNodeIDs returns the list of nodes to which the volume
+// is published by ControllerPublishVolume, the volume may not be
+// staged on the node yet. csi-attacher reconciles the attachments
+// with them, so the nodeID label set while staging is not used.
+func getPublishedNodeIDs(instance *jv.JivaVolume) []string {
+	if nodeID := instance.Annotations[publishedNodeAnnotation]; nodeID != "" {
+		return []string{nodeID}
+	}
+	return nil
+}
+
+// getVolumeCondition reports the condition of the volume
+// from the replica status set in the JivaVolume CR
+func getVolumeCondition(instance *jv.JivaVolume) *csi.VolumeCondition {
+	modes := make([]string, 0, len(instance.Status.ReplicaStatuses))
+	for _, rep := range instance.Status.ReplicaStatuses {
+		modes = append(modes, rep.Mode)
+	}
+	return replicaCondition(modes, instance.Spec.Policy.Target.ReplicationFactor)
 }
 
 // IsSupportedVolumeCapabilityAccessMode valides the requested access mode
@@ -406,6 +1467,20 @@ func IsSupportedVolumeCapabilityAccessMode(
 	return false
 }
 
+// controllerCapabilities are the capabilities advertised by the
+// controller plugin along with the RPCs implementing them, each RPC
+// registers the capabilities it implements next to its definition so
+// that a capability is never advertised without its RPCs.
+var controllerCapabilities = map[csi.ControllerServiceCapability_RPC_Type][]string{}
+
+// registerControllerCapabilities registers the
+// given capabilities as implemented by the rpc
+func registerControllerCapabilities(rpc string, capabilities ...csi.ControllerServiceCapability_RPC_Type) {
+	for _, c := range capabilities {
+		controllerCapabilities[c] = append(controllerCapabilities[c], rpc)
+	}
+}
+
 // newControllerCapabilities returns a list
 // of this controller's capabilities
 func newControllerCapabilities() []*csi.ControllerServiceCapability {
@@ -421,33 +1496,56 @@ func newControllerCapabilities() []*csi.ControllerServiceCapability {
 		}
 	}
 
+	// capabilities are sorted so that the
+	// advertised list is stable across restarts
+	types := make([]csi.ControllerServiceCapability_RPC_Type, 0, len(controllerCapabilities))
+	for c := range controllerCapabilities {
+		types = append(types, c)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
 	var capabilities []*csi.ControllerServiceCapability
-	for _, cap := range []csi.ControllerServiceCapability_RPC_Type{
-		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
-		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-	} {
-		capabilities = append(capabilities, fromType(cap))
+	for _, c := range types {
+		capabilities = append(capabilities, fromType(c))
 	}
 	return capabilities
 }
 
-func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability) bool {
-	hasSupport := func(cap *csi.VolumeCapability) bool {
-		for _, c := range SupportedVolumeCapabilityAccessModes {
-			if c.GetMode() == cap.AccessMode.GetMode() {
-				return true
-			}
+// validateVolumeCapability returns an error describing why the
+// access type or the access mode of the capability is not supported
+func validateVolumeCapability(volCap *csi.VolumeCapability, rwx bool) error {
+	// volume can be consumed either as a mounted
+	// filesystem or as a raw block device
+	if volCap.GetMount() == nil && volCap.GetBlock() == nil {
+		return fmt.Errorf("access type must be mount or block")
+	}
+
+	if fsType := volCap.GetMount().GetFsType(); fsType != "" && !isValidFSType(fsType) {
+		return fmt.Errorf("fsType {%s} is not supported, supported fsTypes are: %v", fsType, ValidFSTypes)
+	}
+
+	// ReadWriteMany volumes are exported over NFS,
+	// which only serves a mounted filesystem
+	if rwx && isRWXAccessMode(volCap) {
+		if volCap.GetBlock() != nil {
+			return fmt.Errorf("access mode {%v} is only supported for mount access type", volCap.GetAccessMode().GetMode())
 		}
-		return false
+		return nil
 	}
 
-	foundAll := true
+	if !IsSupportedVolumeCapabilityAccessMode(volCap.GetAccessMode().GetMode()) {
+		return fmt.Errorf("access mode {%v} is not supported", volCap.GetAccessMode().GetMode())
+	}
+	return nil
+}
+
+func isValidVolumeCapabilities(volCaps []*csi.VolumeCapability, rwx bool) bool {
 	for _, c := range volCaps {
-		if !hasSupport(c) {
-			foundAll = false
+		if validateVolumeCapability(c, rwx) != nil {
+			return false
 		}
 	}
-	return foundAll
+	return true
 }
 
 func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) error {
@@ -466,7 +1564,7 @@ func (cs *controller) validateVolumeCreateReq(req *csi.CreateVolumeRequest) erro
 		)
 	}
 
-	if !isValidVolumeCapabilities(volCapabilities) {
+	if !isValidVolumeCapabilities(volCapabilities, cs.driver.config.EnableRWX) {
 		return status.Error(
 			codes.InvalidArgument,
 			"Failed to validate volume capabilities")
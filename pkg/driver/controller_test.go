@@ -0,0 +1,1452 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/config"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/openebs/jiva-operator/pkg/apis"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cloud-provider/volume/helpers"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newFakeController returns a controller backed by
+// the fake client initialized with the given objects
+func newFakeController(t *testing.T, objs ...runtime.Object) (*controller, ctrlclient.Client) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := storagev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	// JivaSnapshot CRs are only handled as unstructured objects
+	snapshotGV := schema.GroupVersion{Group: "openebs.io", Version: "v1alpha1"}
+	scheme.AddKnownTypeWithName(snapshotGV.WithKind("JivaSnapshot"), &unstructured.Unstructured{})
+	scheme.AddKnownTypeWithName(snapshotGV.WithKind("JivaSnapshotList"), &unstructured.UnstructuredList{})
+
+	fakeClient := fake.NewFakeClientWithScheme(scheme, objs...)
+	d := &CSIDriver{config: config.Default()}
+	cs := NewController(d, client.NewWithClient(fakeClient)).(*controller)
+	return cs, fakeClient
+}
+
+func newCreateVolumeRequest(name string, sizeBytes int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name: name,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: sizeBytes,
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+}
+
+func TestCreateVolumeConcurrentRequests(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	const requests = 10
+	errs := make(chan error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest("pvc-1234", 5*helpers.GiB))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected all the requests to succeed, got err: %v", err)
+		}
+	}
+
+	list := &jv.JivaVolumeList{}
+	if err := fakeClient.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("expected exactly one JivaVolume, got: %d", len(list.Items))
+	}
+}
+
+func TestCreateVolumeRollsBackOnTimeout(t *testing.T) {
+	defer func(interval time.Duration) { provisioningInterval = interval }(provisioningInterval)
+	provisioningInterval = 10 * time.Millisecond
+
+	// target of the volume never becomes ready
+	cs, fakeClient := newFakeController(t)
+	cs.driver.config.ProvisioningTimeout = 10 * time.Second
+	cs.driver.config.CreateVolumeTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected CreateVolume to return after the create volume timeout, took: %v", elapsed)
+	}
+
+	list := &jv.JivaVolumeList{}
+	if err := fakeClient.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Fatalf("expected JivaVolume to be rolled back, got: %+v", list.Items)
+	}
+
+	// rollback of the volume which is already gone is a no-op
+	if err := cs.rollbackVolume(req.GetName()); err != nil {
+		t.Fatalf("expected rollback to be idempotent, got err: %v", err)
+	}
+
+	// provisioned volume is never rolled back
+	bound := newReadyJivaVolume("5Gi", "10.0.0.1")
+	bound.Annotations = map[string]string{client.ProvisioningPhaseAnnotation: client.ProvisioningPhaseBound}
+	cs, fakeClient = newFakeController(t, bound)
+	if err := cs.rollbackVolume(testVolumeID); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, &jv.JivaVolume{}); err != nil {
+		t.Fatalf("expected bound volume to be kept, got err: %v", err)
+	}
+}
+
+func TestCreateVolumeResumesPendingVolume(t *testing.T) {
+	defer func(interval time.Duration) { provisioningInterval = interval }(provisioningInterval)
+	provisioningInterval = 10 * time.Millisecond
+
+	// controller plugin restarts after creating the CR,
+	// before the target of the volume is ready
+	cs, fakeClient := newFakeController(t)
+	cs.driver.config.ProvisioningTimeout = 50 * time.Millisecond
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.Aborted {
+		t.Fatalf("expected Aborted, got err: %v", err)
+	}
+
+	getVolume := func(c ctrlclient.Client) *jv.JivaVolume {
+		vol := &jv.JivaVolume{}
+		if err := c.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+			t.Fatal(err)
+		}
+		return vol
+	}
+	vol := getVolume(fakeClient)
+	if phase := vol.Annotations[client.ProvisioningPhaseAnnotation]; phase != client.ProvisioningPhaseFailed {
+		t.Fatalf("expected provisioning phase Failed, got: {%v}", phase)
+	}
+
+	// half initialized CR, i.e without the finalizer
+	vol.Finalizers = nil
+	restarted, restartedClient := newFakeController(t, vol)
+	restarted.driver.config.ProvisioningTimeout = 10 * time.Second
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ready := getVolume(restartedClient)
+		ready.Spec.ISCSISpec.TargetIP = "10.0.0.1"
+		ready.Status.Phase = jv.JivaVolumePhaseReady
+		ready.Status.Status = "RW"
+		_ = restartedClient.Update(context.TODO(), ready)
+	}()
+
+	resp, err := restarted.CreateVolume(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("expected provisioning to be resumed, got err: %v", err)
+	}
+	if resp.GetVolume().GetVolumeId() != "pvc-1234" {
+		t.Fatalf("expected volume pvc-1234, got: %v", resp.GetVolume().GetVolumeId())
+	}
+
+	vol = getVolume(restartedClient)
+	if phase := vol.Annotations[client.ProvisioningPhaseAnnotation]; phase != client.ProvisioningPhaseBound {
+		t.Fatalf("expected provisioning phase Bound, got: {%v}", phase)
+	}
+	if !hasFinalizer(vol, client.VolumeProtectionFinalizer) {
+		t.Fatalf("expected finalizer to be set on resumed volume, got: %v", vol.Finalizers)
+	}
+
+	list := &jv.JivaVolumeList{}
+	if err := restartedClient.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("expected exactly one JivaVolume, got: %d", len(list.Items))
+	}
+
+	// bound volume is returned without waiting
+	restarted.driver.config.ProvisioningTimeout = 0
+	if _, err := restarted.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected bound volume to be returned, got err: %v", err)
+	}
+}
+
+func TestCreateVolumeWaitCancelled(t *testing.T) {
+	defer func(interval time.Duration) { provisioningInterval = interval }(provisioningInterval)
+	provisioningInterval = 10 * time.Millisecond
+
+	cs, fakeClient := newFakeController(t)
+	cs.driver.config.ProvisioningTimeout = 10 * time.Second
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	if _, err := cs.CreateVolume(ctx, req); status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected wait to stop once the request is cancelled, took: %v", elapsed)
+	}
+
+	// volume is still pending, the retry resumes the wait
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if phase := vol.Annotations[client.ProvisioningPhaseAnnotation]; phase != client.ProvisioningPhasePending {
+		t.Fatalf("expected provisioning phase Pending, got: {%v}", phase)
+	}
+}
+
+func TestCreateVolumeExistingWithDifferentSize(t *testing.T) {
+	cs, _ := newFakeController(t)
+
+	if _, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	_, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest("pvc-1234", 10*helpers.GiB))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got err: %v", err)
+	}
+}
+
+func TestCreateVolumeCapacityRounding(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	req := newCreateVolumeRequest("pvc-1234", 3*helpers.GiB/2)
+	req.CapacityRange.LimitBytes = 3 * helpers.GiB / 2
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("expected OutOfRange, got err: %v", err)
+	}
+
+	req.CapacityRange.LimitBytes = 0
+	resp, err := cs.CreateVolume(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+	if resp.GetVolume().GetCapacityBytes() != 2*helpers.GiB {
+		t.Fatalf("expected capacity %v, got: %v", 2*helpers.GiB, resp.GetVolume().GetCapacityBytes())
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.Capacity != "2Gi" {
+		t.Fatalf("expected capacity 2Gi in JivaVolume, got: %v", vol.Spec.Capacity)
+	}
+	if bytes := vol.Annotations[client.CapacityBytesAnnotation]; bytes != "2147483648" {
+		t.Fatalf("expected capacity of 2147483648 bytes in JivaVolume, got: %v", bytes)
+	}
+
+	// retry with the same request finds the existing volume
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected existing volume to be returned, got err: %v", err)
+	}
+}
+
+func TestCreateVolumeMinSize(t *testing.T) {
+	tests := map[string]struct {
+		minSize  int64
+		required int64
+		limit    int64
+		code     codes.Code
+		capacity int64
+	}{
+		"below jiva minimum": {
+			required: helpers.GiB / 2,
+			code:     codes.OutOfRange,
+		},
+		"at jiva minimum": {
+			required: helpers.GiB,
+			capacity: helpers.GiB,
+		},
+		"below configured minimum": {
+			minSize:  10 * helpers.GiB,
+			required: 5 * helpers.GiB,
+			code:     codes.OutOfRange,
+		},
+		"at configured minimum": {
+			minSize:  10 * helpers.GiB,
+			required: 10 * helpers.GiB,
+			capacity: 10 * helpers.GiB,
+		},
+		"required equal to limit": {
+			required: helpers.GiB,
+			limit:    helpers.GiB,
+			capacity: helpers.GiB,
+		},
+		"only limit below default size": {
+			limit:    7 * helpers.GiB / 2,
+			capacity: 3 * helpers.GiB,
+		},
+		"only limit above default size": {
+			limit:    10 * helpers.GiB,
+			capacity: 5 * helpers.GiB,
+		},
+		"only limit below minimum": {
+			limit: helpers.GiB / 2,
+			code:  codes.OutOfRange,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs, _ := newFakeController(t)
+			cs.driver.config.MinVolumeSize = test.minSize
+
+			req := newCreateVolumeRequest("pvc-1234", test.required)
+			req.CapacityRange.LimitBytes = test.limit
+			resp, err := cs.CreateVolume(context.TODO(), req)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if capacity := resp.GetVolume().GetCapacityBytes(); capacity != test.capacity {
+				t.Fatalf("expected capacity %v, got: %v", test.capacity, capacity)
+			}
+			if test.limit != 0 && resp.GetVolume().GetCapacityBytes() > test.limit {
+				t.Fatalf("expected capacity within limit %v, got: %v", test.limit, resp.GetVolume().GetCapacityBytes())
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolumeLimitExceeded(t *testing.T) {
+	cs, _ := newFakeController(t, newReadyJivaVolume("5Gi", "127.0.0.1"))
+
+	req := newControllerExpandVolumeRequest(11 * helpers.GiB / 2)
+	req.CapacityRange.LimitBytes = 11 * helpers.GiB / 2
+	if _, err := cs.ControllerExpandVolume(context.TODO(), req); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("expected OutOfRange, got err: %v", err)
+	}
+}
+
+// newReadyJivaVolume returns the test JivaVolume of the given capacity
+// whose target at targetIP has all the replicas in RW mode
+func newReadyJivaVolume(capacity, targetIP string) *jv.JivaVolume {
+	vol := newTestJivaVolume()
+	vol.Spec.Capacity = capacity
+	vol.Spec.ISCSISpec.TargetIP = targetIP
+	vol.Spec.Policy.Target.ReplicationFactor = 1
+	vol.Status.Phase = jv.JivaVolumePhaseReady
+	vol.Status.Status = "RW"
+	vol.Status.ReplicaCount = 1
+	vol.Status.ReplicaStatuses = []jv.ReplicaStatus{{Address: "tcp://10.0.0.2:9502", Mode: "RW"}}
+	return vol
+}
+
+func newControllerExpandVolumeRequest(sizeBytes int64) *csi.ControllerExpandVolumeRequest {
+	return &csi.ControllerExpandVolumeRequest{
+		VolumeId: testVolumeID,
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: sizeBytes,
+		},
+	}
+}
+
+// newFakeJivaTarget starts a fake jiva target REST server and points
+// jivaTargetPort to it, resized is set once resize action is posted
+func newFakeJivaTarget(t *testing.T, resized *bool) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Query().Get("action") == jiva.ResizeAction {
+			*resized = true
+			return
+		}
+		_ = json.NewEncoder(w).Encode(jiva.Volumes{
+			Data: []jiva.Volume{
+				{
+					Name:    testVolumeID,
+					Actions: map[string]string{jiva.ResizeAction: "http://" + r.Host + "/v1/volumes/" + testVolumeID + "?action=resize"},
+				},
+			},
+		})
+	}))
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jivaTargetPort = port
+	return server, host
+}
+
+func TestControllerExpandVolumeSmallerSize(t *testing.T) {
+	cs, _ := newFakeController(t, newReadyJivaVolume("5Gi", "127.0.0.1"))
+
+	_, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(4*helpers.GiB))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got err: %v", err)
+	}
+}
+
+func TestControllerExpandVolumeEqualSize(t *testing.T) {
+	cs, _ := newFakeController(t, newReadyJivaVolume("5Gi", "127.0.0.1"))
+
+	resp, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(5*helpers.GiB))
+	if err != nil {
+		t.Fatalf("expected success, got err: %v", err)
+	}
+
+	if resp.GetNodeExpansionRequired() {
+		t.Fatal("expected node expansion not to be required")
+	}
+	if resp.GetCapacityBytes() != 5*helpers.GiB {
+		t.Fatalf("expected capacity %v, got: %v", 5*helpers.GiB, resp.GetCapacityBytes())
+	}
+}
+
+func TestControllerExpandVolumeLargerSize(t *testing.T) {
+	defer func(retries int, port string) {
+		MaxRetryCount, jivaTargetPort = retries, port
+	}(MaxRetryCount, jivaTargetPort)
+	MaxRetryCount = 1
+
+	var resized bool
+	server, targetIP := newFakeJivaTarget(t, &resized)
+	defer server.Close()
+
+	cs, fakeClient := newFakeController(t, newReadyJivaVolume("5Gi", targetIP))
+
+	resp, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(10*helpers.GiB))
+	if err != nil {
+		t.Fatalf("expected success, got err: %v", err)
+	}
+
+	if !resp.GetNodeExpansionRequired() {
+		t.Fatal("expected node expansion to be required")
+	}
+	if !resized {
+		t.Fatal("expected resize request to be posted to jiva target")
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.Capacity != "10Gi" {
+		t.Fatalf("expected capacity 10Gi, got: %v", vol.Spec.Capacity)
+	}
+	if bytes := vol.Annotations[client.CapacityBytesAnnotation]; bytes != "10737418240" {
+		t.Fatalf("expected capacity of 10737418240 bytes, got: %v", bytes)
+	}
+}
+
+func TestControllerExpandVolumeNodeExpansionRequired(t *testing.T) {
+	defer func(retries int, port string) {
+		MaxRetryCount, jivaTargetPort = retries, port
+	}(MaxRetryCount, jivaTargetPort)
+	MaxRetryCount = 1
+
+	mount := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	block := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+
+	tests := map[string]struct {
+		volCap     *csi.VolumeCapability
+		accessType string
+		required   bool
+	}{
+		"filesystem volume":                   {volCap: mount, required: true},
+		"block volume":                        {volCap: block, required: false},
+		"staged as filesystem, no capability": {accessType: accessTypeMount, required: true},
+		"staged as block, no capability":      {accessType: accessTypeBlock, required: false},
+		"not staged, no capability":           {required: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var resized bool
+			server, targetIP := newFakeJivaTarget(t, &resized)
+			defer server.Close()
+
+			vol := newReadyJivaVolume("5Gi", targetIP)
+			if test.accessType != "" {
+				vol.Annotations = map[string]string{accessTypeAnnotation: test.accessType}
+			}
+			cs, _ := newFakeController(t, vol)
+
+			req := newControllerExpandVolumeRequest(10 * helpers.GiB)
+			req.VolumeCapability = test.volCap
+			resp, err := cs.ControllerExpandVolume(context.TODO(), req)
+			if err != nil {
+				t.Fatalf("expected success, got err: %v", err)
+			}
+			if resp.GetNodeExpansionRequired() != test.required {
+				t.Fatalf("expected node expansion required %v, got: %v", test.required, resp.GetNodeExpansionRequired())
+			}
+		})
+	}
+}
+
+func TestControllerModifyVolume(t *testing.T) {
+	cs, fakeClient := newFakeController(t, newTestJivaVolume())
+
+	_, err := cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{
+		VolumeId: testVolumeID,
+		MutableParameters: map[string]string{
+			client.ReplicaCountAnnotation: "3",
+			client.IOPSLimitAnnotation:    "500",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected success, got err: %v", err)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.Policy.Target.ReplicationFactor != 3 {
+		t.Fatalf("expected replication factor 3, got: %v", vol.Spec.Policy.Target.ReplicationFactor)
+	}
+	if vol.Annotations[client.IOPSLimitAnnotation] != "500" {
+		t.Fatalf("expected iops limit 500, got: %v", vol.Annotations[client.IOPSLimitAnnotation])
+	}
+}
+
+func TestControllerModifyVolumeImmutableParameter(t *testing.T) {
+	cs, _ := newFakeController(t, newTestJivaVolume())
+
+	_, err := cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{
+		VolumeId: testVolumeID,
+		MutableParameters: map[string]string{
+			client.ReplicaCountAnnotation: "3",
+			"csi.storage.k8s.io/fstype":   "xfs",
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got err: %v", err)
+	}
+	if !strings.Contains(err.Error(), "csi.storage.k8s.io/fstype") {
+		t.Fatalf("expected err to name the immutable parameter, got: %v", err)
+	}
+	if !strings.HasPrefix(status.Convert(err).Message(), "ControllerModifyVolume: ") {
+		t.Fatalf("expected err to be prefixed with the rpc name, got: %v", err)
+	}
+}
+
+func TestControllerModifyVolumeReplicaCountChecks(t *testing.T) {
+	zoneTerm := corev1.PodAffinityTerm{TopologyKey: client.ZoneTopologyKey}
+	strict := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{zoneTerm},
+	}}
+	preferred := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{Weight: 100, PodAffinityTerm: zoneTerm}},
+	}}
+
+	tests := map[string]struct {
+		pool     string
+		affinity *corev1.Affinity
+		replicas string
+		code     codes.Code
+	}{
+		"pool fits the replicas":            {pool: "ssd", replicas: "2"},
+		"pool can't fit the replicas":       {pool: "ssd", replicas: "3", code: codes.ResourceExhausted},
+		"strict, a zone for each replica":   {affinity: strict, replicas: "2"},
+		"strict, fewer zones than replicas": {affinity: strict, replicas: "3", code: codes.ResourceExhausted},
+		"preferred, fewer zones":            {affinity: preferred, replicas: "3"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := newTestJivaVolume()
+			vol.Spec.Policy.Target.ReplicationFactor = 1
+			vol.Spec.Policy.Replica.Affinity = test.affinity
+			objs := append([]runtime.Object{vol, newPoolStorageClass("ssd", "10Gi")}, newZonedNodes("zone-a", "zone-b")...)
+			if test.pool != "" {
+				vol.Labels[client.ReplicaPoolLabel] = test.pool
+			}
+			cs, fakeClient := newFakeController(t, objs...)
+
+			_, err := cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{
+				VolumeId:          testVolumeID,
+				MutableParameters: map[string]string{client.ReplicaCountAnnotation: test.replicas},
+			})
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+
+			got := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, got); err != nil {
+				t.Fatal(err)
+			}
+			if rf := got.Spec.Policy.Target.ReplicationFactor; (rf == 1) != (test.code != codes.OK) {
+				t.Fatalf("expected replication factor to be updated only on success, got: %v", rf)
+			}
+		})
+	}
+}
+
+func hasFinalizer(vol *jv.JivaVolume, finalizer string) bool {
+	for _, f := range vol.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDeleteVolumeFinalizer(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	if _, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest(testVolumeID, 5*helpers.GiB)); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	key := ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), key, vol); err != nil {
+		t.Fatal(err)
+	}
+	if !hasFinalizer(vol, client.VolumeProtectionFinalizer) {
+		t.Fatalf("expected finalizer %v, got: %v", client.VolumeProtectionFinalizer, vol.Finalizers)
+	}
+
+	// fake client doesn't honor finalizers, so the CR deleted
+	// directly is marked for deletion the way apiserver does
+	now := metav1.Now()
+	vol.DeletionTimestamp = &now
+	if err := fakeClient.Update(context.TODO(), vol); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fakeClient.Get(context.TODO(), key, vol); err != nil {
+		t.Fatalf("expected CR marked for deletion to exist, got err: %v", err)
+	}
+
+	// repeated call after the finalizer is removed must also succeed
+	for i := 0; i < 2; i++ {
+		if _, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+			t.Fatalf("expected DeleteVolume to succeed, got err: %v", err)
+		}
+	}
+
+	// finalizers are omitted once empty, so the CR is
+	// fetched into a new object to not keep the old ones
+	vol = &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), key, vol); err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(vol, client.VolumeProtectionFinalizer) {
+		t.Fatalf("expected finalizer to be removed, got: %v", vol.Finalizers)
+	}
+}
+
+func TestDeleteVolumeIdempotent(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	if _, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest(testVolumeID, 5*helpers.GiB)); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+			t.Fatalf("expected DeleteVolume to succeed, got err: %v", err)
+		}
+	}
+
+	list := &jv.JivaVolumeList{}
+	if err := fakeClient.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Fatalf("expected JivaVolume to be deleted, got: %d", len(list.Items))
+	}
+}
+
+// flakyClient fails the Delete and Update calls with the
+// given errors before passing them to the wrapped client
+type flakyClient struct {
+	ctrlclient.Client
+	deleteErrs []error
+	updateErrs []error
+}
+
+func (f *flakyClient) Delete(ctx context.Context, obj runtime.Object, opts ...ctrlclient.DeleteOption) error {
+	if len(f.deleteErrs) != 0 {
+		err := f.deleteErrs[0]
+		f.deleteErrs = f.deleteErrs[1:]
+		return err
+	}
+	return f.Client.Delete(ctx, obj, opts...)
+}
+
+func (f *flakyClient) Update(ctx context.Context, obj runtime.Object, opts ...ctrlclient.UpdateOption) error {
+	if len(f.updateErrs) != 0 {
+		err := f.updateErrs[0]
+		f.updateErrs = f.updateErrs[1:]
+		return err
+	}
+	return f.Client.Update(ctx, obj, opts...)
+}
+
+func TestDeleteVolumePartialFailures(t *testing.T) {
+	defer func(interval time.Duration) { deleteVolumeRetryInterval = interval }(deleteVolumeRetryInterval)
+	deleteVolumeRetryInterval = time.Millisecond
+
+	gr := schema.GroupResource{Group: "openebs.io", Resource: "jivavolumes"}
+	conflict := k8serrors.NewConflict(gr, testVolumeID, stderrors.New("object has been modified"))
+	unavailable := k8serrors.NewServiceUnavailable("etcd is not available")
+	forbidden := k8serrors.NewForbidden(gr, testVolumeID, stderrors.New("not allowed"))
+	now := metav1.Now()
+
+	tests := map[string]struct {
+		// markedForDeletion is set if the CR has been deleted
+		// but its finalizer is not removed yet
+		markedForDeletion bool
+		absent            bool
+		deleteErrs        []error
+		updateErrs        []error
+		// failFirst is set if the first DeleteVolume call
+		// is expected to fail and the retry to succeed
+		failFirst bool
+	}{
+		"CR is already absent":                      {absent: true},
+		"CR is marked for deletion, target is gone": {markedForDeletion: true},
+		"transient error on delete": {
+			deleteErrs: []error{unavailable, unavailable},
+		},
+		"conflict on finalizer removal": {
+			markedForDeletion: true,
+			updateErrs:        []error{conflict},
+		},
+		"retries exceeded": {
+			markedForDeletion: true,
+			updateErrs:        []error{conflict, conflict, conflict, conflict},
+			failFirst:         true,
+		},
+		"non transient error": {
+			deleteErrs: []error{forbidden},
+			failFirst:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var objs []runtime.Object
+			if !test.absent {
+				vol := newTestJivaVolume()
+				vol.Finalizers = []string{client.VolumeProtectionFinalizer}
+				if test.markedForDeletion {
+					vol.DeletionTimestamp = &now
+				}
+				objs = append(objs, vol)
+			}
+
+			cs, fakeClient := newFakeController(t, objs...)
+			flaky := &flakyClient{Client: fakeClient, deleteErrs: test.deleteErrs, updateErrs: test.updateErrs}
+			cs.client = client.NewWithClient(flaky)
+
+			req := &csi.DeleteVolumeRequest{VolumeId: testVolumeID}
+			_, err := cs.DeleteVolume(context.TODO(), req)
+			if test.failFirst {
+				if status.Code(err) != codes.Internal {
+					t.Fatalf("expected Internal, got err: %v", err)
+				}
+				flaky.deleteErrs, flaky.updateErrs = nil, nil
+				_, err = cs.DeleteVolume(context.TODO(), req)
+			}
+			if err != nil {
+				t.Fatalf("expected DeleteVolume to succeed, got err: %v", err)
+			}
+
+			vol := &jv.JivaVolume{}
+			err = fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol)
+			if err == nil && hasFinalizer(vol, client.VolumeProtectionFinalizer) {
+				t.Fatalf("expected finalizer to be removed, got: %v", vol.Finalizers)
+			}
+			if err != nil && !k8serrors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestControllerPublishVolumeCancelled(t *testing.T) {
+	// target of the volume never becomes ready
+	cs, _ := newFakeController(t, newTestJivaVolume())
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := cs.ControllerPublishVolume(ctx, newControllerPublishVolumeRequest("node-1"))
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= controllerPublishInterval {
+		t.Fatalf("expected publish to be aborted promptly, took: %v", elapsed)
+	}
+}
+
+func newControllerPublishVolumeRequest(nodeID string) *csi.ControllerPublishVolumeRequest {
+	return &csi.ControllerPublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		NodeId:           nodeID,
+		VolumeCapability: newCreateVolumeRequest(testVolumeID, 0).GetVolumeCapabilities()[0],
+	}
+}
+
+func TestControllerPublishVolumeDNSPortal(t *testing.T) {
+	vol := newReadyJivaVolume("5Gi", "10.0.0.1")
+	vol.Spec.ISCSISpec.TargetPort = 3260
+	vol.Annotations = map[string]string{client.UseDNSPortalAnnotation: "true"}
+	cs, _ := newFakeController(t, vol)
+	cs.driver.config.ClusterDomain = "cluster.local"
+
+	resp, err := cs.ControllerPublishVolume(context.TODO(), newControllerPublishVolumeRequest("node-1"))
+	if err != nil {
+		t.Fatalf("expected volume to be published, got err: %v", err)
+	}
+	expected := "pvc-1234-jiva-ctrl-svc.openebs.svc.cluster.local:3260"
+	if portal := resp.GetPublishContext()[publishContextPortal]; portal != expected {
+		t.Fatalf("expected portal %v, got: %v", expected, portal)
+	}
+}
+
+func TestControllerUnpublishVolumeRepublish(t *testing.T) {
+	cs, fakeClient := newFakeController(t, newReadyJivaVolume("5Gi", "10.0.0.1"))
+
+	if _, err := cs.ControllerPublishVolume(context.TODO(), newControllerPublishVolumeRequest("node-a")); err != nil {
+		t.Fatalf("expected volume to be published to node-a, got err: %v", err)
+	}
+
+	if _, err := cs.ControllerPublishVolume(context.TODO(), newControllerPublishVolumeRequest("node-b")); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition while published to node-a, got err: %v", err)
+	}
+
+	// unpublish is idempotent
+	for i := 0; i < 2; i++ {
+		_, err := cs.ControllerUnpublishVolume(context.TODO(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: testVolumeID,
+			NodeId:   "node-a",
+		})
+		if err != nil {
+			t.Fatalf("expected volume to be unpublished, got err: %v", err)
+		}
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if published, ok := vol.Annotations[publishedNodeAnnotation]; ok {
+		t.Fatalf("expected published node to be cleared, got: %v", published)
+	}
+
+	resp, err := cs.ControllerPublishVolume(context.TODO(), newControllerPublishVolumeRequest("node-b"))
+	if err != nil {
+		t.Fatalf("expected volume to be published to node-b, got err: %v", err)
+	}
+	if resp.GetPublishContext()[publishContextPortal] == "" {
+		t.Fatalf("expected portal in publish context, got: %v", resp.GetPublishContext())
+	}
+
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Annotations[publishedNodeAnnotation] != "node-b" {
+		t.Fatalf("expected volume to be published to node-b, got: %v", vol.Annotations[publishedNodeAnnotation])
+	}
+}
+
+func TestVolumeIDPrefix(t *testing.T) {
+	defer func(prefix string) { utils.VolumeIDPrefix = prefix }(utils.VolumeIDPrefix)
+	utils.VolumeIDPrefix = "cluster-a-"
+
+	cs, fakeClient := newFakeController(t)
+
+	resp, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest(testVolumeID, 5*helpers.GiB))
+	if err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	volumeID := resp.GetVolume().GetVolumeId()
+	if volumeID != "cluster-a-"+testVolumeID {
+		t.Fatalf("expected volume ID cluster-a-%v, got: %v", testVolumeID, volumeID)
+	}
+
+	// CR is named after the volume without the prefix
+	vol := &jv.JivaVolume{}
+	key := ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}
+	if err := fakeClient.Get(context.TODO(), key, vol); err != nil {
+		t.Fatalf("expected JivaVolume %v, got err: %v", testVolumeID, err)
+	}
+
+	_, err = cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{
+		VolumeId:          volumeID,
+		MutableParameters: map[string]string{client.IOPSLimitAnnotation: "500"},
+	})
+	if err != nil {
+		t.Fatalf("expected volume to be looked up by prefixed ID, got err: %v", err)
+	}
+
+	if _, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("expected volume to be deleted, got err: %v", err)
+	}
+
+	list := &jv.JivaVolumeList{}
+	if err := fakeClient.List(context.TODO(), list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Fatalf("expected JivaVolume to be deleted, got: %d", len(list.Items))
+	}
+}
+
+func TestCreateVolumeContentSourceNotSupported(t *testing.T) {
+	tests := map[string]*csi.VolumeContentSource{
+		"clone": {
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{VolumeId: testVolumeID},
+			},
+		},
+		"restore": {
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: "snap-1"},
+			},
+		},
+	}
+
+	for name, source := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs, _ := newFakeController(t, newReadyJivaVolume("5Gi", "10.0.0.1"))
+			if err := cs.client.CreateJivaSnapshot(&client.JivaSnapshot{
+				Name:         "snap-1",
+				Namespace:    "openebs",
+				SourceVolume: testVolumeID,
+				SizeBytes:    5 * helpers.GiB,
+				ReadyToUse:   true,
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := newCreateVolumeRequest("pvc-5678", 5*helpers.GiB)
+			req.VolumeContentSource = source
+			if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument, got err: %v", err)
+			}
+			if _, err := cs.client.GetJivaVolume("pvc-5678"); status.Code(err) != codes.NotFound {
+				t.Fatalf("expected JivaVolume of the new volume not to be created, got err: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeTargetNodeSelector(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	req.Parameters = map[string]string{client.TargetNodeSelectorParam: "node-group=fast,disk in (ssd)"}
+	req.AccessibilityRequirements = &csi.TopologyRequirement{
+		Preferred: []*csi.Topology{{Segments: map[string]string{"topology.kubernetes.io/zone": "zone-a"}}},
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"node-group": "fast", "disk": "ssd", "topology.kubernetes.io/zone": "zone-a"}
+	if !reflect.DeepEqual(vol.Spec.Policy.Target.NodeSelector, expected) {
+		t.Fatalf("expected target node selector %v, got: %v", expected, vol.Spec.Policy.Target.NodeSelector)
+	}
+
+	// selector is left to the operator without the parameter
+	req = newCreateVolumeRequest("pvc-5678", 5*helpers.GiB)
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+	vol = &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-5678", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if len(vol.Spec.Policy.Target.NodeSelector) != 0 {
+		t.Fatalf("expected no target node selector, got: %v", vol.Spec.Policy.Target.NodeSelector)
+	}
+
+	for _, selector := range []string{"=fast", "node-group in (fast,slow)", "!node-group"} {
+		req = newCreateVolumeRequest("pvc-9012", 5*helpers.GiB)
+		req.Parameters = map[string]string{client.TargetNodeSelectorParam: selector}
+		if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument for selector {%v}, got err: %v", selector, err)
+		}
+	}
+}
+
+func TestCreateVolumeTargetResources(t *testing.T) {
+	cs, fakeClient := newFakeController(t)
+
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	req.Parameters = map[string]string{
+		client.TargetCPURequestParam:    "500m",
+		client.TargetMemoryRequestParam: "512Mi",
+		client.TargetCPULimitParam:      "1",
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	res := vol.Spec.Policy.Target.Resources
+	if res == nil {
+		t.Fatal("expected target resources to be set")
+	}
+	cpu, memory, limit := res.Requests[corev1.ResourceCPU], res.Requests[corev1.ResourceMemory], res.Limits[corev1.ResourceCPU]
+	if cpu.String() != "500m" || memory.String() != "512Mi" || limit.String() != "1" || len(res.Limits) != 1 {
+		t.Fatalf("expected cpu 500m/1 and memory request 512Mi, got: %+v", res)
+	}
+
+	// operator defaults are used without the parameters
+	req = newCreateVolumeRequest("pvc-5678", 5*helpers.GiB)
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+	vol = &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-5678", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.Policy.Target.Resources != nil {
+		t.Fatalf("expected no target resources, got: %+v", vol.Spec.Policy.Target.Resources)
+	}
+
+	for _, params := range []map[string]string{
+		{client.TargetMemoryRequestParam: "lots"},
+		{client.TargetCPURequestParam: "2", client.TargetCPULimitParam: "1"},
+	} {
+		req = newCreateVolumeRequest("pvc-9012", 5*helpers.GiB)
+		req.Parameters = params
+		if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument for parameters %v, got err: %v", params, err)
+		}
+	}
+}
+
+func TestCreateVolumeClaimMetadata(t *testing.T) {
+	longName := "data-" + strings.Repeat("a", 70)
+	tests := map[string]struct {
+		params      map[string]string
+		annotations map[string]string
+		labels      map[string]string
+	}{
+		"extra create metadata": {
+			params: map[string]string{
+				client.PVCNameParam:      "data-mysql-0",
+				client.PVCNamespaceParam: "billing",
+				client.PVNameParam:       "pvc-1234",
+			},
+			annotations: map[string]string{
+				client.PVCNameAnnotation:      "data-mysql-0",
+				client.PVCNamespaceAnnotation: "billing",
+				client.PVNameAnnotation:       "pvc-1234",
+			},
+			labels: map[string]string{
+				client.PVCNameLabel:      "data-mysql-0",
+				client.PVCNamespaceLabel: "billing",
+			},
+		},
+		"pvc name longer than a label": {
+			params: map[string]string{
+				client.PVCNameParam:      longName,
+				client.PVCNamespaceParam: "billing",
+			},
+			annotations: map[string]string{
+				client.PVCNameAnnotation:      longName,
+				client.PVCNamespaceAnnotation: "billing",
+			},
+			labels: map[string]string{
+				client.PVCNamespaceLabel: "billing",
+			},
+		},
+		"older provisioner": {
+			params: map[string]string{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			// pvc is looked up for the replica count override
+			objs := []runtime.Object{}
+			if name, ns := test.params[client.PVCNameParam], test.params[client.PVCNamespaceParam]; name != "" {
+				objs = append(objs, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}})
+			}
+			cs, fakeClient := newFakeController(t, objs...)
+			req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+			req.Parameters = test.params
+			if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+				t.Fatalf("expected volume to be created, got err: %v", err)
+			}
+
+			vol := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{client.PVCNameAnnotation, client.PVCNamespaceAnnotation, client.PVNameAnnotation} {
+				if vol.Annotations[key] != test.annotations[key] {
+					t.Errorf("expected annotation %v {%v}, got: {%v}", key, test.annotations[key], vol.Annotations[key])
+				}
+			}
+			for _, key := range []string{client.PVCNameLabel, client.PVCNamespaceLabel} {
+				if vol.Labels[key] != test.labels[key] {
+					t.Errorf("expected label %v {%v}, got: {%v}", key, test.labels[key], vol.Labels[key])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	mount := &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}
+	block := &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+	newCap := func(accessType interface{}, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		volCap := &csi.VolumeCapability{AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode}}
+		switch t := accessType.(type) {
+		case *csi.VolumeCapability_Mount:
+			volCap.AccessType = t
+		case *csi.VolumeCapability_Block:
+			volCap.AccessType = t
+		}
+		return volCap
+	}
+
+	tests := map[string]struct {
+		volCaps   []*csi.VolumeCapability
+		confirmed bool
+		message   string
+	}{
+		"mount single node writer": {
+			volCaps:   []*csi.VolumeCapability{newCap(mount, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+			confirmed: true,
+		},
+		"block single node single writer": {
+			volCaps:   []*csi.VolumeCapability{newCap(block, csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER)},
+			confirmed: true,
+		},
+		"mount and block single node multi writer": {
+			volCaps: []*csi.VolumeCapability{
+				newCap(mount, csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER),
+				newCap(block, csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER),
+			},
+			confirmed: true,
+		},
+		"multi node multi writer": {
+			volCaps: []*csi.VolumeCapability{newCap(mount, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)},
+			message: "access mode {MULTI_NODE_MULTI_WRITER} is not supported",
+		},
+		"one of the capabilities is unsupported": {
+			volCaps: []*csi.VolumeCapability{
+				newCap(mount, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+				newCap(block, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+			},
+			message: "access mode {MULTI_NODE_READER_ONLY} is not supported",
+		},
+		"missing access type": {
+			volCaps: []*csi.VolumeCapability{newCap(nil, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)},
+			message: "access type must be mount or block",
+		},
+		"missing access mode": {
+			volCaps: []*csi.VolumeCapability{{AccessType: mount}},
+			message: "access mode {UNKNOWN} is not supported",
+		},
+		"unsupported fsType": {
+			volCaps: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "btrfs"}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+			message: "fsType {btrfs} is not supported",
+		},
+	}
+
+	cs, _ := newFakeController(t, newTestJivaVolume())
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := cs.ValidateVolumeCapabilities(context.TODO(), &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId:           testVolumeID,
+				VolumeCapabilities: test.volCaps,
+			})
+			if err != nil {
+				t.Fatalf("expected success, got err: %v", err)
+			}
+
+			if confirmed := resp.GetConfirmed() != nil; confirmed != test.confirmed {
+				t.Fatalf("expected confirmed %v, got response: %v", test.confirmed, resp)
+			}
+			if test.confirmed && len(resp.GetConfirmed().GetVolumeCapabilities()) != len(test.volCaps) {
+				t.Fatalf("expected all the capabilities to be confirmed, got: %v", resp.GetConfirmed())
+			}
+			if !strings.Contains(resp.GetMessage(), test.message) || (test.confirmed && resp.GetMessage() != "") {
+				t.Fatalf("expected message {%v}, got: {%v}", test.message, resp.GetMessage())
+			}
+		})
+	}
+}
+
+func TestListVolumesPublishedNodes(t *testing.T) {
+	// published to node-1 but not staged on it yet
+	published := newReadyJivaVolume("5Gi", "10.0.0.1")
+	published.Annotations = map[string]string{publishedNodeAnnotation: "node-1"}
+	delete(published.Labels, "nodeID")
+
+	// still staged on node-2 but already unpublished
+	unpublished := newReadyJivaVolume("5Gi", "10.0.0.2")
+	unpublished.Name, unpublished.Spec.PV = "pvc-5678", "pvc-5678"
+	unpublished.Labels["openebs.io/persistent-volume"] = "pvc-5678"
+	unpublished.Labels["nodeID"] = "node-2"
+
+	cs, _ := newFakeController(t, published, unpublished)
+	resp, err := cs.ListVolumes(context.TODO(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatalf("expected volumes to be listed, got err: %v", err)
+	}
+
+	expected := map[string][]string{"pvc-1234": {"node-1"}, "pvc-5678": nil}
+	if len(resp.GetEntries()) != len(expected) {
+		t.Fatalf("expected %d volumes, got: %v", len(expected), resp.GetEntries())
+	}
+	for _, entry := range resp.GetEntries() {
+		nodes := entry.GetStatus().GetPublishedNodeIds()
+		if !reflect.DeepEqual(nodes, expected[entry.GetVolume().GetVolumeId()]) {
+			t.Errorf("expected volume %v to be published to %v, got: %v",
+				entry.GetVolume().GetVolumeId(), expected[entry.GetVolume().GetVolumeId()], nodes)
+		}
+	}
+}
+
+func TestControllerCapabilitiesAreImplemented(t *testing.T) {
+	cs, _ := newFakeController(t)
+	var server csi.ControllerServer = cs
+	ctx := context.TODO()
+
+	// each RPC is called with an empty request, validation errors
+	// are expected but not Unimplemented
+	rpcs := map[string]func() error{
+		"CreateVolume": func() error {
+			_, err := server.CreateVolume(ctx, &csi.CreateVolumeRequest{})
+			return err
+		},
+		"DeleteVolume": func() error {
+			_, err := server.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+			return err
+		},
+		"ControllerPublishVolume": func() error {
+			_, err := server.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{})
+			return err
+		},
+		"ControllerUnpublishVolume": func() error {
+			_, err := server.ControllerUnpublishVolume(ctx, &csi.ControllerUnpublishVolumeRequest{})
+			return err
+		},
+		"ValidateVolumeCapabilities": func() error {
+			_, err := server.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{})
+			return err
+		},
+		"ControllerExpandVolume": func() error {
+			_, err := server.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{})
+			return err
+		},
+		"ControllerGetVolume": func() error {
+			_, err := server.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{})
+			return err
+		},
+		"ControllerModifyVolume": func() error {
+			_, err := server.ControllerModifyVolume(ctx, &csi.ControllerModifyVolumeRequest{})
+			return err
+		},
+		"ListVolumes": func() error {
+			_, err := server.ListVolumes(ctx, &csi.ListVolumesRequest{})
+			return err
+		},
+		"GetCapacity": func() error {
+			_, err := server.GetCapacity(ctx, &csi.GetCapacityRequest{})
+			return err
+		},
+		"CreateSnapshot": func() error {
+			_, err := server.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{})
+			return err
+		},
+		"DeleteSnapshot": func() error {
+			_, err := server.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{})
+			return err
+		},
+		"ListSnapshots": func() error {
+			_, err := server.ListSnapshots(ctx, &csi.ListSnapshotsRequest{})
+			return err
+		},
+	}
+
+	resp, err := server.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetCapabilities()) != len(controllerCapabilities) {
+		t.Fatalf("expected %d capabilities, got: %v", len(controllerCapabilities), resp.GetCapabilities())
+	}
+
+	for _, c := range resp.GetCapabilities() {
+		capability := c.GetRpc().GetType()
+		implementedBy, ok := controllerCapabilities[capability]
+		if !ok || len(implementedBy) == 0 {
+			t.Errorf("capability %v is not implemented by any RPC", capability)
+		}
+		for _, rpc := range implementedBy {
+			call, ok := rpcs[rpc]
+			if !ok {
+				t.Errorf("capability %v refers to unknown RPC {%v}", capability, rpc)
+				continue
+			}
+			if err := call(); status.Code(err) == codes.Unimplemented {
+				t.Errorf("capability %v is advertised but RPC {%v} is not implemented, err: %v", capability, rpc, err)
+			}
+		}
+	}
+}
+
+func TestControllerCapabilitiesReportStatus(t *testing.T) {
+	defer func(port string) { jivaTargetPort = port }(jivaTargetPort)
+
+	var requests int32
+	modes := []string{"RW"}
+	server, targetIP := newFakeReplicaTarget(t, &modes, &requests)
+	defer server.Close()
+
+	vol := newReadyJivaVolume("5Gi", targetIP)
+	vol.Annotations = map[string]string{publishedNodeAnnotation: "node-1"}
+	cs, _ := newFakeController(t, vol)
+
+	// the status fields of each capability must be
+	// reported by every RPC which implements it
+	checks := map[csi.ControllerServiceCapability_RPC_Type]map[string]func(t *testing.T){
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION: {
+			"ListVolumes": func(t *testing.T) {
+				resp, err := cs.ListVolumes(context.TODO(), &csi.ListVolumesRequest{})
+				if err != nil || len(resp.GetEntries()) != 1 {
+					t.Fatalf("expected 1 volume, got: %v, err: %v", resp.GetEntries(), err)
+				}
+				if cond := resp.GetEntries()[0].GetStatus().GetVolumeCondition(); cond == nil || cond.GetAbnormal() {
+					t.Fatalf("expected volume to be healthy, got: %+v", cond)
+				}
+			},
+			"ControllerGetVolume": func(t *testing.T) {
+				resp, err := cs.ControllerGetVolume(context.TODO(), &csi.ControllerGetVolumeRequest{VolumeId: testVolumeID})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cond := resp.GetStatus().GetVolumeCondition(); cond == nil || cond.GetAbnormal() {
+					t.Fatalf("expected volume to be healthy, got: %+v", cond)
+				}
+			},
+		},
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES: {
+			"ListVolumes": func(t *testing.T) {
+				resp, err := cs.ListVolumes(context.TODO(), &csi.ListVolumesRequest{})
+				if err != nil || len(resp.GetEntries()) != 1 {
+					t.Fatalf("expected 1 volume, got: %v, err: %v", resp.GetEntries(), err)
+				}
+				if nodes := resp.GetEntries()[0].GetStatus().GetPublishedNodeIds(); !reflect.DeepEqual(nodes, []string{"node-1"}) {
+					t.Fatalf("expected volume to be published to node-1, got: %v", nodes)
+				}
+			},
+		},
+	}
+
+	for capability, byRPC := range checks {
+		for _, rpc := range controllerCapabilities[capability] {
+			check, ok := byRPC[rpc]
+			if !ok {
+				t.Errorf("status of capability %v reported by RPC {%v} is not verified", capability, rpc)
+				continue
+			}
+			t.Run(capability.String()+"/"+rpc, check)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// debugDefaultHost is the host the debug endpoint binds to
+// if only the port is set in the bind address
+const debugDefaultHost = "127.0.0.1"
+
+// debugMount is a mount of the device of a staged volume
+type debugMount struct {
+	Device string `json:"device"`
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+}
+
+// debugSession is an active iSCSI session to a jiva target
+type debugSession struct {
+	Portal string `json:"portal"`
+	IQN    string `json:"iqn"`
+}
+
+// debugVolume is the state of a volume staged on the node
+type debugVolume struct {
+	VolumeID    string       `json:"volumeID"`
+	DevicePath  string       `json:"devicePath"`
+	StagingPath string       `json:"stagingPath"`
+	TargetPath  string       `json:"targetPath"`
+	Portal      string       `json:"portal"`
+	IQN         string       `json:"iqn"`
+	SessionUp   bool         `json:"sessionUp"`
+	Mounts      []debugMount `json:"mounts"`
+}
+
+// debugState is the state of the node plugin served by the debug
+// endpoint, sessions also include the ones of unstaged volumes
+type debugState struct {
+	NodeID   string         `json:"nodeID"`
+	Volumes  []debugVolume  `json:"volumes"`
+	Sessions []debugSession `json:"sessions"`
+}
+
+// debugListenAddress returns the address the debug endpoint listens
+// on, it binds to localhost unless a host is set in the address
+func debugListenAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid debug bind address {%s}: %v", addr, err)
+	}
+	if host == "" {
+		host = debugDefaultHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// debugState returns the volumes staged on the node as per the
+// JivaVolume CRs along with their mounts and iSCSI sessions
+func (ns *node) debugState() (*debugState, error) {
+	// set client each time to avoid caching issue
+	if err := ns.client.Set(); err != nil {
+		return nil, err
+	}
+
+	nodeID := ns.driver.config.NodeID
+	volumes, err := ns.client.ListJivaVolumeWithOpts(map[string]string{"nodeID": nodeID})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := listJivaSessions(ns.mounter.Exec)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := ns.mounter.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mounts, err: {%v}", err)
+	}
+
+	state := &debugState{
+		NodeID:   nodeID,
+		Volumes:  []debugVolume{},
+		Sessions: []debugSession{},
+	}
+	up := map[iscsiSession]bool{}
+	for _, s := range sessions {
+		up[s] = true
+		state.Sessions = append(state.Sessions, debugSession{Portal: s.portal, IQN: s.iqn})
+	}
+
+	for _, vol := range volumes.Items {
+		info := vol.Spec.MountInfo
+		session := iscsiSession{
+			portal: fmt.Sprintf("%v:%v", vol.Spec.ISCSISpec.TargetIP, vol.Spec.ISCSISpec.TargetPort),
+			iqn:    vol.Spec.ISCSISpec.Iqn,
+		}
+
+		v := debugVolume{
+			VolumeID:    utils.VolumeID(vol.Name),
+			DevicePath:  info.DevicePath,
+			StagingPath: info.StagingPath,
+			TargetPath:  info.TargetPath,
+			Portal:      session.portal,
+			IQN:         session.iqn,
+			SessionUp:   up[session],
+			Mounts:      []debugMount{},
+		}
+		for _, m := range mounts {
+			if (info.DevicePath != "" && m.Device == info.DevicePath) ||
+				(info.StagingPath != "" && m.Path == info.StagingPath) {
+				v.Mounts = append(v.Mounts, debugMount{Device: m.Device, Path: m.Path, Type: m.Type})
+			}
+		}
+		state.Volumes = append(state.Volumes, v)
+	}
+	return state, nil
+}
+
+// serveDebugState writes the state of the node plugin as JSON
+func (ns *node) serveDebugState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, err := ns.debugState()
+	if err != nil {
+		logrus.Errorf("Debug: failed to get node state, err: {%v}", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(state); err != nil {
+		logrus.Errorf("Debug: failed to write node state, err: {%v}", err)
+	}
+}
+
+// serveDebug serves the debug endpoint of the node plugin,
+// it only reads the state and doesn't take the volume locks
+func serveDebug(addr string, ns *node) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/state", ns.serveDebugState)
+
+	logrus.Infof("Serving debug endpoint on address: %v", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Errorf("Failed to serve debug endpoint, err: {%v}", err)
+	}
+}
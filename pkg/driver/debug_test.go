@@ -0,0 +1,102 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/utils/mount"
+)
+
+func TestDebugListenAddress(t *testing.T) {
+	tests := map[string]struct {
+		addr     string
+		expected string
+		fail     bool
+	}{
+		"only port":      {addr: ":9506", expected: "127.0.0.1:9506"},
+		"all interfaces": {addr: "0.0.0.0:9506", expected: "0.0.0.0:9506"},
+		"missing port":   {addr: "localhost", fail: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr, err := debugListenAddress(test.addr)
+			if (err != nil) != test.fail {
+				t.Fatalf("expected fail %v, got err: %v", test.fail, err)
+			}
+			if addr != test.expected {
+				t.Fatalf("expected address %v, got: %v", test.expected, addr)
+			}
+		})
+	}
+}
+
+func TestServeDebugState(t *testing.T) {
+	up := newStagedJivaVolume("pvc-1234", "10.0.0.1")
+	up.Spec.MountInfo.StagingPath = "/var/lib/kubelet/plugins/staging/pvc-1234"
+	down := newStagedJivaVolume("pvc-5678", "10.0.0.2")
+	down.Spec.MountInfo.DevicePath = "/dev/sdc"
+	sessions := "tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1234 (non-flash)\n" +
+		"tcp: [2] 10.0.0.9:3260,1 iqn.2016-09.com.openebs.jiva:pvc-9012 (non-flash)\n"
+
+	var cmds [][]string
+	ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds, sessionsOutput(sessions)), up, down)
+	fakeMounter.MountPoints = []mount.MountPoint{
+		{Device: "/dev/sdb", Path: "/var/lib/kubelet/plugins/staging/pvc-1234", Type: "ext4"},
+		{Device: "/dev/sdb", Path: "/var/lib/kubelet/pods/uid/volumes/pvc-1234/mount", Type: "ext4"},
+		{Device: "/dev/sda1", Path: "/", Type: "ext4"},
+	}
+
+	rec := httptest.NewRecorder()
+	ns.serveDebugState(rec, httptest.NewRequest(http.MethodGet, "/debug/state", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got: %v, body: %s", rec.Code, rec.Body.String())
+	}
+
+	state := debugState{}
+	if err := json.NewDecoder(rec.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.NodeID != "node-1" || len(state.Volumes) != 2 || len(state.Sessions) != 2 {
+		t.Fatalf("expected 2 volumes and 2 sessions of node-1, got: %+v", state)
+	}
+
+	for _, v := range state.Volumes {
+		switch v.VolumeID {
+		case "pvc-1234":
+			if !v.SessionUp || v.DevicePath != "/dev/sdb" || len(v.Mounts) != 2 {
+				t.Errorf("expected pvc-1234 to be up with 2 mounts of /dev/sdb, got: %+v", v)
+			}
+		case "pvc-5678":
+			if v.SessionUp || len(v.Mounts) != 0 {
+				t.Errorf("expected pvc-5678 to be down without mounts, got: %+v", v)
+			}
+		default:
+			t.Errorf("unexpected volume: %+v", v)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	ns.serveDebugState(rec, httptest.NewRequest(http.MethodPost, "/debug/state", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405, got: %v", rec.Code)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/request"
+	"github.com/sirupsen/logrus"
+)
+
+// delayedLogoutRetryInterval is the wait before the delayed logout is
+// attempted again if the volume is in transition when it fires
+var delayedLogoutRetryInterval = time.Second
+
+// delayedLogouts tracks the iSCSI logouts deferred by NodeUnstageVolume,
+// a logout which is still pending when the volume is staged again is
+// cancelled so that the existing session is reused
+type delayedLogouts struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newDelayedLogouts() *delayedLogouts {
+	return &delayedLogouts{timers: map[string]*time.Timer{}}
+}
+
+// schedule runs logout for the volume after the delay, unless it is
+// cancelled before that. Logout is run with the volume in transition
+// so that it never overlaps with the staging of the same volume.
+func (dl *delayedLogouts) schedule(volumeID string, delay time.Duration, logout func() error) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if t, ok := dl.timers[volumeID]; ok {
+		t.Stop()
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		if err := request.AddVolumeToTransitionList(volumeID, "DelayedLogout"); err != nil {
+			logrus.Debugf("NodeUnstageVolume: delayed logout of volume {%v} is postponed, %v", volumeID, err)
+			dl.mu.Lock()
+			if dl.timers[volumeID] == t {
+				t.Reset(delayedLogoutRetryInterval)
+			}
+			dl.mu.Unlock()
+			return
+		}
+		defer request.RemoveVolumeFromTransitionList(volumeID)
+
+		dl.mu.Lock()
+		if dl.timers[volumeID] != t {
+			// cancelled by NodeStageVolume
+			dl.mu.Unlock()
+			return
+		}
+		delete(dl.timers, volumeID)
+		dl.mu.Unlock()
+
+		logrus.Infof("NodeUnstageVolume: volume {%v} is not staged again within %v, logging out", volumeID, delay)
+		if err := logout(); err != nil {
+			logrus.Errorf("NodeUnstageVolume: delayed logout of volume {%v} failed, err: {%v}", volumeID, err)
+		}
+	})
+	dl.timers[volumeID] = t
+}
+
+// cancel cancels the pending logout of the volume,
+// it returns true if there was one
+func (dl *delayedLogouts) cancel(volumeID string) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	t, ok := dl.timers[volumeID]
+	if !ok {
+		return false
+	}
+	t.Stop()
+	delete(dl.timers, volumeID)
+	return true
+}
+
+// pending returns true if the logout of the volume is pending
+func (dl *delayedLogouts) pending(volumeID string) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	_, ok := dl.timers[volumeID]
+	return ok
+}
@@ -0,0 +1,130 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/request"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// waitForLogouts waits for the logout to be called count times
+func waitForLogouts(t *testing.T, logouts *int32, count int32) {
+	for i := 0; i < 100; i++ {
+		if atomic.LoadInt32(logouts) == count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d logouts, got: %d", count, atomic.LoadInt32(logouts))
+}
+
+func TestDelayedLogouts(t *testing.T) {
+	defer func(interval time.Duration) { delayedLogoutRetryInterval = interval }(delayedLogoutRetryInterval)
+	delayedLogoutRetryInterval = 10 * time.Millisecond
+
+	var logouts int32
+	logout := func() error {
+		atomic.AddInt32(&logouts, 1)
+		return nil
+	}
+
+	// logout fires if the volume isn't staged again
+	dl := newDelayedLogouts()
+	dl.schedule("pvc-1", 20*time.Millisecond, logout)
+	waitForLogouts(t, &logouts, 1)
+	if dl.pending("pvc-1") {
+		t.Fatal("expected no pending logout after it fired")
+	}
+
+	// logout cancelled by a re-stage never fires
+	dl.schedule("pvc-2", 50*time.Millisecond, logout)
+	if !dl.cancel("pvc-2") {
+		t.Fatal("expected pending logout to be cancelled")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&logouts); n != 1 {
+		t.Fatalf("expected cancelled logout to not fire, got %d logouts", n)
+	}
+	if dl.cancel("pvc-2") {
+		t.Fatal("expected no pending logout to be cancelled")
+	}
+
+	// logout waits for the volume in transition
+	if err := request.AddVolumeToTransitionList("pvc-3", "NodeStageVolume"); err != nil {
+		t.Fatal(err)
+	}
+	dl.schedule("pvc-3", 10*time.Millisecond, logout)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&logouts); n != 1 {
+		t.Fatalf("expected logout to be postponed while the volume is in transition, got %d logouts", n)
+	}
+	request.RemoveVolumeFromTransitionList("pvc-3")
+	waitForLogouts(t, &logouts, 2)
+}
+
+func TestNodeUnstageVolumeDelaysLogout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-unstage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	instance := newTestJivaVolume()
+	instance.Annotations = map[string]string{accessTypeAnnotation: accessTypeBlock}
+	instance.Spec.MountInfo.StagingPath = filepath.Join(dir, "staging")
+	instance.Spec.ISCSISpec.Iqn = testIQN
+	instance.Spec.ISCSISpec.TargetIP = "10.0.0.2"
+	instance.Spec.ISCSISpec.TargetPort = 3260
+
+	var cmds [][]string
+	ns, _, fakeClient := newFakeNode(t, newFakeExec(&cmds), instance)
+	ns.driver.config.UnstageLogoutDelay = time.Hour
+
+	req := &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: instance.Spec.MountInfo.StagingPath}
+	if _, err := ns.NodeUnstageVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be unstaged, got err: %v", err)
+	}
+	if len(cmds) != 0 {
+		t.Fatalf("expected logout to be delayed, got commands: %v", cmds)
+	}
+	if !ns.delayedLogouts.pending(testVolumeID) {
+		t.Fatal("expected logout of the volume to be pending")
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.MountInfo.StagingPath != "" || vol.Labels["nodeID"] != "" {
+		t.Fatalf("expected volume to be marked unstaged, got: %+v", vol.Spec.MountInfo)
+	}
+
+	// NodeStageVolume cancels the pending logout
+	if !ns.delayedLogouts.cancel(testVolumeID) {
+		t.Fatal("expected pending logout to be cancelled")
+	}
+}
@@ -17,12 +17,20 @@ limitations under the License.
 package driver
 
 import (
+	"crypto/tls"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	config "github.com/openebs/jiva-csi/pkg/config"
+	"github.com/openebs/jiva-csi/pkg/jiva"
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"k8s.io/client-go/tools/record"
 )
 
 // volume can only be published once as
@@ -42,26 +50,38 @@ type CSIDriver struct {
 	ns     csi.NodeServer
 	cs     csi.ControllerServer
 
+	// gcs is set if the group snapshots
+	// of the volumes are enabled
+	gcs csi.GroupControllerServer
+
 	cap []*csi.VolumeCapability_AccessMode
+
+	// recorder records kubernetes events for
+	// volume operation failures
+	recorder record.EventRecorder
+
+	client *client.Client
+
+	// elector is set if the leader election of
+	// the controller plugin is enabled
+	elector *leaderElector
+
+	// webhook is set if the volume events are
+	// exported to a webhook
+	webhook *webhookNotifier
+
+	// jivaTLS is set if the REST API of the
+	// jiva targets is reached over HTTPS
+	jivaTLS *tls.Config
 }
 
 // GetVolumeCapabilityAccessModes fetches the access
 // modes on which the volume can be exposed
 func GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
-	supported := []csi.VolumeCapability_AccessMode_Mode{
-		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+	for _, vcam := range SupportedVolumeCapabilityAccessModes {
+		logrus.Infof("enabling volume access mode: %s", vcam.GetMode().String())
 	}
-
-	var vcams []*csi.VolumeCapability_AccessMode
-	for _, vcam := range supported {
-		logrus.Infof("enabling volume access mode: %s", vcam.String())
-		vcams = append(vcams, newVolumeCapabilityAccessMode(vcam))
-	}
-	return vcams
-}
-
-func newVolumeCapabilityAccessMode(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability_AccessMode {
-	return &csi.VolumeCapability_AccessMode{Mode: mode}
+	return SupportedVolumeCapabilityAccessModes
 }
 
 // New returns a new driver instance
@@ -69,19 +89,66 @@ func New(config *config.Config, cli *client.Client) *CSIDriver {
 	driver := &CSIDriver{
 		config: config,
 		cap:    GetVolumeCapabilityAccessModes(),
+		client: cli,
 	}
 
+	recorder, err := cli.NewEventRecorder(config.DriverName)
+	if err != nil {
+		logrus.Warningf("Failed to create event recorder, events will not be recorded, err: {%v}", err)
+	} else {
+		driver.recorder = recorder
+	}
+
+	if config.EventWebhookURL != "" {
+		driver.webhook = newWebhookNotifier(config.EventWebhookURL, webhookQueueSize)
+	}
+
+	jivaTLS, err := jiva.NewTLSConfig(jiva.TLSOptions{
+		CAFile:             config.JivaAPICAFile,
+		CertFile:           config.JivaAPICertFile,
+		KeyFile:            config.JivaAPIKeyFile,
+		InsecureSkipVerify: config.JivaAPIInsecureSkipVerify,
+	})
+	if err != nil {
+		logrus.Fatalf("Failed to setup TLS of the jiva target REST API, err: {%v}", err)
+	}
+	if config.JivaAPIInsecureSkipVerify {
+		logrus.Warning("Certificate of the jiva target REST API is not verified")
+	}
+	driver.jivaTLS = jivaTLS
+
 	switch config.PluginType {
 	case "controller":
-		driver.cs = NewController(cli)
+		driver.cs = NewController(driver, cli)
+		if config.EnableVolumeGroupSnapshots {
+			driver.gcs = newGroupController(driver.cs.(*controller))
+		}
+		if config.EnableLeaderElection {
+			elector, err := newDriverLeaderElector(config, cli, driver.recorder)
+			if err != nil {
+				logrus.Fatalf("Failed to setup leader election, err: {%v}", err)
+			}
+			driver.elector = elector
+			driver.cs = newLeaderGatedController(driver.cs, elector)
+			if driver.gcs != nil {
+				driver.gcs = newLeaderGatedGroupController(driver.gcs, elector)
+			}
+		}
 
 	case "node":
 		ns := NewNode(driver, cli)
+		if config.CleanupOrphanedSessions {
+			if err := ns.cleanupOrphanedSessions(); err != nil {
+				logrus.Errorf("Failed to cleanup orphaned iscsi sessions, err: {%v}", err)
+			}
+		}
+		// mounts are not polled if the interval is 0
 		remount := os.Getenv("REMOUNT")
-		if remount == "true" || remount == "True" {
+		if (remount == "true" || remount == "True") && config.MountMonitorInterval > 0 {
 			nm := newNodeMounterWithOpts(
 				withClient(cli),
-				withNodeID(config.NodeID))
+				withNodeID(config.NodeID),
+				withMonitorInterval(config.MountMonitorInterval))
 			go nm.MonitorMounts()
 		}
 		driver.ns = ns
@@ -95,14 +162,119 @@ func New(config *config.Config, cli *client.Client) *CSIDriver {
 	return driver
 }
 
+// grpcServerOptions returns the grpc server options set
+// in the config, grpc defaults are used for the rest
+func (d *CSIDriver) grpcServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if d.config.GRPCMaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(d.config.GRPCMaxConcurrentStreams))
+	}
+	if d.config.GRPCKeepaliveTime > 0 || d.config.GRPCKeepaliveTimeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    d.config.GRPCKeepaliveTime,
+			Timeout: d.config.GRPCKeepaliveTimeout,
+		}))
+	}
+	return opts
+}
+
 // Run starts the CSI plugin by communicating
 // over the given endpoint
 func (d *CSIDriver) Run() error {
+	if addr := d.config.MetricsBindAddress; addr != "" && addr != "0" {
+		go serveMetrics(d.config.MetricsBindAddress, d.client, d.config.PluginType, d.config.NodeID)
+
+		if ns, ok := d.ns.(*node); ok && d.config.ISCSISessionMetricsInterval > 0 {
+			go newSessionCollector(ns.mounter.Exec, d.client, d.config.NodeID, d.config.ISCSISessionMetricsInterval).run()
+		}
+	}
+
+	// node-driver-registrar registers the plugin with the kubelet
+	// as soon as it reaches the grpc server, so the server is only
+	// started once the host dependencies are in place
+	if ns, ok := d.ns.(*node); ok && d.config.RegistrationReadinessTimeout > 0 {
+		check := func() []PreflightResult { return RunPreflight(ns.mounter.Exec) }
+		if err := waitForNodeReadiness(context.Background(), check, d.config.RegistrationReadinessTimeout); err != nil {
+			logrus.Fatalf("Registration: %v", err)
+		}
+	}
+
+	// iface record is created upfront so that a missing iscsiadm
+	// setup fails the node plugin instead of NodeStageVolume
+	if ns, ok := d.ns.(*node); ok && d.config.ISCSIInterface != "" {
+		if _, err := setupInitiatorIface(ns.mounter.Exec, d.config.ISCSIInitiatorName, d.config.ISCSIInterface); err != nil {
+			return err
+		}
+	}
+
+	if ns, ok := d.ns.(*node); ok && d.config.DebugBindAddress != "" {
+		addr, err := debugListenAddress(d.config.DebugBindAddress)
+		if err != nil {
+			return err
+		}
+		go serveDebug(addr, ns)
+	}
+
+	// lease is released once the grpc server is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if d.webhook != nil {
+		go d.webhook.run(ctx.Done())
+	}
+
+	electionDone := make(chan struct{})
+	if d.elector != nil {
+		go func() {
+			defer close(electionDone)
+			if err := d.elector.run(ctx); err != nil {
+				logrus.Errorf("LeaderElection: failed to run leader election, err: {%v}", err)
+			}
+		}()
+	} else {
+		close(electionDone)
+	}
+
 	// Initialize and start listening on grpc server
-	s := NewNonBlockingGRPCServer(d.config.Endpoint, d.ids, d.cs, d.ns)
+	s := NewNonBlockingGRPCServer(d.config.Endpoint, d.ids, d.cs, d.gcs, d.ns, d.grpcServerOptions()...)
 
 	s.Start()
-	s.Wait()
 
+	// in-flight requests are drained on SIGTERM, i.e when
+	// the pod is rolled, so that NodeStageVolume is not
+	// killed in the middle of the iSCSI login
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	done := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case sig := <-sigCh:
+		logrus.Infof("Received signal {%v}, shutting down", sig)
+		s.Shutdown(d.config.ShutdownTimeout)
+		<-done
+	}
+	cancel()
+	<-electionDone
 	return nil
 }
+
+// newDriverLeaderElector returns the leader elector of the controller
+// plugin, hostname i.e the pod name is the identity of the instance
+func newDriverLeaderElector(config *config.Config, cli *client.Client, recorder record.EventRecorder) (*leaderElector, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := cli.NewLeaseLock(config.LeaderElectionLeaseName, config.LeaderElectionNamespace, identity, recorder)
+	if err != nil {
+		return nil, err
+	}
+	return newLeaderElector(lock), nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// reasonProvisioningFailed is the event reason used
+	// when CreateVolume fails
+	reasonProvisioningFailed = "ProvisioningFailed"
+	// reasonAttachFailed is the event reason used when
+	// iSCSI login fails in NodeStageVolume
+	reasonAttachFailed = "AttachFailed"
+	// reasonResizeFailed is the event reason used when
+	// ControllerExpandVolume fails
+	reasonResizeFailed = "ResizeFailed"
+	// reasonVolumeNearlyFull is the event reason used when the
+	// usage of the volume is above the usage alert threshold
+	reasonVolumeNearlyFull = "VolumeNearlyFull"
+)
+
+// recordProvisioningEvent records a warning event on the PVC for
+// which the volume is being created. PVC details are available in
+// the parameters only if --extra-create-metadata is enabled. Failure
+// is also posted to the webhook if it is configured.
+func (d *CSIDriver) recordProvisioningEvent(req *csi.CreateVolumeRequest, reason, message string) {
+	d.notifyWebhook(webhookEventFailed, utils.VolumeID(req.GetName()), "", reason+": "+message)
+	if d.recorder == nil {
+		return
+	}
+
+	name := req.GetParameters()[client.PVCNameParam]
+	ns := req.GetParameters()[client.PVCNamespaceParam]
+	if name == "" || ns == "" {
+		logrus.Debugf("Skip recording event for volume {%v}, pvc details not found", req.GetName())
+		return
+	}
+
+	d.recorder.Event(&corev1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Name:       name,
+		Namespace:  ns,
+	}, corev1.EventTypeWarning, reason, message)
+}
+
+// recordVolumeEvent records a warning event on the PVC bound to the
+// PV of the given volume, volume ID is the name of the PV. Failure is
+// also posted to the webhook if it is configured.
+func (d *CSIDriver) recordVolumeEvent(cli *client.Client, volumeID, reason, message string) {
+	d.notifyWebhook(webhookEventFailed, volumeID, d.config.NodeID, reason+": "+message)
+	d.recordClaimEvent(cli, volumeID, reason, message)
+}
+
+// recordClaimEvent records a warning event on the PVC bound
+// to the PV of the given volume without notifying the webhook
+func (d *CSIDriver) recordClaimEvent(cli *client.Client, volumeID, reason, message string) {
+	if d.recorder == nil {
+		return
+	}
+
+	pv, err := cli.GetPersistentVolume(utils.VolumeName(volumeID))
+	if err != nil {
+		logrus.Debugf("Skip recording event for volume {%v}, failed to get pv, err: {%v}", volumeID, err)
+		return
+	}
+
+	if pv.Spec.ClaimRef == nil {
+		logrus.Debugf("Skip recording event for volume {%v}, pv is not bound", volumeID)
+		return
+	}
+
+	d.recorder.Event(pv.Spec.ClaimRef, corev1.EventTypeWarning, reason, message)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// outOfServiceTaint is set on a node by the cluster admin once it
+// is confirmed to be shut down, a NotReady node may still be running
+// i.e partitioned from the apiserver and writing to the target
+const outOfServiceTaint = "node.kubernetes.io/out-of-service"
+
+// nodeDeadReason returns why the node is confirmed to be dead, i.e it
+// is deleted or tainted out-of-service. Empty reason is returned if the
+// node may be alive, a NotReady node is assumed to be alive.
+func nodeDeadReason(node *corev1.Node) string {
+	if node == nil {
+		return "node is deleted"
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == outOfServiceTaint {
+			return fmt.Sprintf("node has the %v taint", outOfServiceTaint)
+		}
+	}
+	return ""
+}
+
+// forceDetach checks if the volume can be detached from the node it
+// is published to so that it can be published to the given node, it
+// is allowed only if force detach is enabled and the published node
+// is deleted or tainted out-of-service. The jiva target can't log out
+// or fence an initiator, so the dead node is relied upon to be fenced
+// already and NodeUnstageVolume never runs on it.
+func (cs *controller) forceDetach(instance *jv.JivaVolume, published, nodeID string) error {
+	volumeID := instance.Name
+	if !cs.driver.config.ForceDetach {
+		return status.Errorf(codes.FailedPrecondition,
+			"ControllerPublishVolume: volume {%v} is already published to node {%v}", volumeID, published)
+	}
+
+	var node *corev1.Node
+	n, err := cs.client.GetNode(published)
+	if err == nil {
+		node = n
+	} else if !errors.IsNotFound(err) {
+		return status.Errorf(codes.Internal, "ControllerPublishVolume: failed to get node {%v}, err: {%v}", published, err)
+	}
+
+	reason := nodeDeadReason(node)
+	if reason == "" {
+		return status.Errorf(codes.FailedPrecondition,
+			"ControllerPublishVolume: volume {%v} is already published to node {%v} which is neither deleted nor tainted %v", volumeID, published, outOfServiceTaint)
+	}
+
+	logrus.Warningf("ControllerPublishVolume: force detaching volume {%v} from node {%v} to publish it to node {%v}, %v",
+		volumeID, published, nodeID, reason)
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newTestNode returns a node whose Ready condition
+// has the given status since the given time
+func newTestNode(name string, ready corev1.ConditionStatus, since time.Time) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(since)},
+			},
+		},
+	}
+}
+
+func TestNodeDeadReason(t *testing.T) {
+	now := time.Now()
+	tainted := newTestNode("node-a", corev1.ConditionTrue, now)
+	tainted.Spec.Taints = []corev1.Taint{{Key: outOfServiceTaint, Effect: corev1.TaintEffectNoExecute}}
+
+	tests := map[string]struct {
+		node *corev1.Node
+		dead bool
+	}{
+		"deleted":                   {node: nil, dead: true},
+		"out-of-service":            {node: tainted, dead: true},
+		"ready":                     {node: newTestNode("node-a", corev1.ConditionTrue, now.Add(-time.Hour))},
+		"not ready":                 {node: newTestNode("node-a", corev1.ConditionFalse, now.Add(-time.Hour))},
+		"unknown":                   {node: newTestNode("node-a", corev1.ConditionUnknown, now.Add(-time.Hour))},
+		"ready condition not found": {node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			reason := nodeDeadReason(test.node)
+			if (reason != "") != test.dead {
+				t.Fatalf("expected dead %v, got reason: {%v}", test.dead, reason)
+			}
+		})
+	}
+}
+
+func TestControllerPublishVolumeForceDetach(t *testing.T) {
+	now := time.Now()
+	tainted := newTestNode("node-a", corev1.ConditionFalse, now)
+	tainted.Spec.Taints = []corev1.Taint{{Key: outOfServiceTaint, Effect: corev1.TaintEffectNoExecute}}
+
+	tests := map[string]struct {
+		forceDetach bool
+		node        *corev1.Node
+		fail        bool
+	}{
+		"disabled, node-a is dead": {
+			node: tainted,
+			fail: true,
+		},
+		"node-a is ready": {
+			forceDetach: true,
+			node:        newTestNode("node-a", corev1.ConditionTrue, now.Add(-10*time.Minute)),
+			fail:        true,
+		},
+		"node-a is not ready": {
+			forceDetach: true,
+			node:        newTestNode("node-a", corev1.ConditionFalse, now.Add(-time.Hour)),
+			fail:        true,
+		},
+		"node-a is out-of-service": {
+			forceDetach: true,
+			node:        tainted,
+		},
+		"node-a is deleted": {
+			forceDetach: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := newReadyJivaVolume("5Gi", "10.0.0.1")
+			vol.Annotations = map[string]string{publishedNodeAnnotation: "node-a"}
+			objs := []runtime.Object{vol}
+			if test.node != nil {
+				objs = append(objs, test.node)
+			}
+			cs, fakeClient := newFakeController(t, objs...)
+			cs.driver.config.ForceDetach = test.forceDetach
+
+			_, err := cs.ControllerPublishVolume(context.TODO(), newControllerPublishVolumeRequest("node-b"))
+			if test.fail {
+				if status.Code(err) != codes.FailedPrecondition {
+					t.Fatalf("expected FailedPrecondition, got err: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected volume to be force detached from node-a, got err: %v", err)
+			}
+
+			vol = &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+				t.Fatal(err)
+			}
+			expected := "node-b"
+			if test.fail {
+				expected = "node-a"
+			}
+			if published := vol.Annotations[publishedNodeAnnotation]; published != expected {
+				t.Fatalf("expected volume to be published to %v, got: %v", expected, published)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// groupController serves the group snapshots of the volumes,
+// it shares the client and the volume locks of the controller
+type groupController struct {
+	cs *controller
+}
+
+func newGroupController(cs *controller) csi.GroupControllerServer {
+	return &groupController{cs: cs}
+}
+
+// groupSnapshotMember is the snapshot of a volume
+// taken as part of a group snapshot
+type groupSnapshotMember struct {
+	volumeID string
+	targetIP string
+	snap     *client.JivaSnapshot
+	// created is set if the JivaSnapshot CR
+	// is created by the current request
+	created bool
+}
+
+// memberSnapshotID returns the ID of the snapshot of the volume taken
+// as part of the group snapshot, it is derived from both so that a
+// retried request takes the same snapshots. The ID is hashed as it is
+// also a label value of the JivaSnapshot CR.
+func memberSnapshotID(groupSnapshotID, volumeID string) string {
+	sum := sha256.Sum256([]byte(groupSnapshotID + "/" + volumeID))
+	return "snapshot-" + hex.EncodeToString(sum[:16])
+}
+
+// GroupControllerGetCapabilities fetches the group controller capabilities
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) GroupControllerGetCapabilities(
+	ctx context.Context,
+	req *csi.GroupControllerGetCapabilitiesRequest,
+) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.GroupControllerServiceCapability{
+			{
+				Type: &csi.GroupControllerServiceCapability_Rpc{
+					Rpc: &csi.GroupControllerServiceCapability_RPC{
+						Type: csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// CreateVolumeGroupSnapshot takes the snapshots of the given
+// volumes together
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) CreateVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.CreateVolumeGroupSnapshotRequest,
+) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+
+	groupID := strings.ToLower(req.GetName())
+	if len(groupID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot: group snapshot name not provided")
+	}
+
+	volumeIDs := req.GetSourceVolumeIds()
+	if len(volumeIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot: source volume IDs not provided")
+	}
+	seen := map[string]bool{}
+	for _, id := range volumeIDs {
+		if id == "" || seen[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolumeGroupSnapshot: invalid source volume IDs {%v}", volumeIDs)
+		}
+		seen[id] = true
+	}
+
+	cs := gc.cs
+	cs.volumeLocks.LockKey(groupID)
+	defer func() {
+		_ = cs.volumeLocks.UnlockKey(groupID)
+	}()
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	group, err := cs.client.GetJivaVolumeGroupSnapshot(groupID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to get JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+	}
+
+	if group != nil && !sameStrings(group.SourceVolumes, volumeIDs) {
+		return nil, status.Errorf(codes.AlreadyExists,
+			"CreateVolumeGroupSnapshot: group snapshot {%v} already exists for different source volumes {%v}",
+			groupID, group.SourceVolumes)
+	}
+
+	if group != nil && group.ReadyToUse {
+		logrus.Infof("CreateVolumeGroupSnapshot: group snapshot {%v} of volumes {%v} already exists", groupID, volumeIDs)
+		groupSnapshot, err := gc.newVolumeGroupSnapshot(group)
+		if err != nil {
+			return nil, err
+		}
+		return &csi.CreateVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+	}
+
+	members, err := gc.newGroupSnapshotMembers(groupID, volumeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// CRs are created before taking the snapshots on the
+	// jiva targets so that the snapshots are not leaked
+	// if the request fails midway
+	if group == nil {
+		group = &client.JivaVolumeGroupSnapshot{
+			Name:          groupID,
+			Namespace:     members[0].snap.Namespace,
+			SourceVolumes: volumeIDs,
+			CreationTime:  time.Now(),
+		}
+		for _, m := range members {
+			group.Snapshots = append(group.Snapshots, m.snap.Name)
+		}
+		if err := cs.client.CreateJivaVolumeGroupSnapshot(group); err != nil {
+			gc.deleteCreatedMembers(members)
+			return nil, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to create JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+		}
+	}
+
+	rolledBack, err := cs.snapshotGroup(ctx, members)
+	if err != nil {
+		if rolledBack {
+			gc.deleteGroupSnapshotCRs(group, members)
+		}
+		if ctxErr := contextStatus(ctx, "CreateVolumeGroupSnapshot"); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, jiva.Errorf(err, "CreateVolumeGroupSnapshot: failed to take group snapshot {%v} of volumes {%v}, err: {%v}", groupID, volumeIDs, err)
+	}
+
+	// all the members share the creation time of the group
+	creationTime := time.Now()
+	for _, m := range members {
+		m.snap.ReadyToUse = true
+		m.snap.CreationTime = creationTime
+		if err := cs.client.UpdateJivaSnapshot(m.snap); err != nil {
+			return nil, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to update JivaSnapshot {%v}, err: {%v}", m.snap.Name, err)
+		}
+	}
+	group.ReadyToUse = true
+	group.CreationTime = creationTime
+	if err := cs.client.UpdateJivaVolumeGroupSnapshot(group); err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to update JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+	}
+
+	logrus.Infof("CreateVolumeGroupSnapshot: group snapshot {%v} of volumes {%v} is created", groupID, volumeIDs)
+	groupSnapshot, err := gc.newVolumeGroupSnapshot(group)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.CreateVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+}
+
+// newGroupSnapshotMembers looks up the volumes of the group and the
+// JivaSnapshot CRs of their snapshots, the CRs are created if they
+// don't exist yet. The CRs created for the members are deleted if a
+// later member fails, they aren't tracked by a group CR yet.
+func (gc *groupController) newGroupSnapshotMembers(groupID string, volumeIDs []string) (members []groupSnapshotMember, err error) {
+	defer func() {
+		if err != nil {
+			gc.deleteCreatedMembers(members)
+		}
+	}()
+
+	cs := gc.cs
+	for _, volumeID := range volumeIDs {
+		instance, err := cs.client.GetJivaVolume(utils.StripName(volumeID))
+		if err != nil {
+			return members, err
+		}
+
+		snapshotID := memberSnapshotID(groupID, volumeID)
+		snap, err := cs.client.GetJivaSnapshot(snapshotID)
+		if err != nil {
+			return members, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to get JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+		}
+
+		created := false
+		if snap == nil {
+			size, err := getCapacityBytes(instance.Spec.Capacity)
+			if err != nil {
+				return members, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to parse capacity of volume {%v}, err: {%v}", volumeID, err)
+			}
+			snap = &client.JivaSnapshot{
+				Name:          snapshotID,
+				Namespace:     instance.Namespace,
+				SourceVolume:  volumeID,
+				SizeBytes:     size,
+				CreationTime:  time.Now(),
+				GroupSnapshot: groupID,
+			}
+			if err := cs.client.CreateJivaSnapshot(snap); err != nil {
+				return members, status.Errorf(codes.Internal, "CreateVolumeGroupSnapshot: failed to create JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+			}
+			created = true
+		}
+
+		members = append(members, groupSnapshotMember{
+			volumeID: volumeID,
+			targetIP: instance.Spec.ISCSISpec.TargetIP,
+			snap:     snap,
+			created:  created,
+		})
+	}
+	return members, nil
+}
+
+// deleteCreatedMembers deletes the JivaSnapshot CRs created for the
+// members by the current request before the group CR is created
+func (gc *groupController) deleteCreatedMembers(members []groupSnapshotMember) {
+	for _, m := range members {
+		if !m.created {
+			continue
+		}
+		if err := gc.cs.client.DeleteJivaSnapshot(m.snap); err != nil {
+			logrus.Errorf("CreateVolumeGroupSnapshot: failed to delete JivaSnapshot {%v}, err: {%v}", m.snap.Name, err)
+		}
+	}
+}
+
+// snapshotGroup takes the snapshots of the members on their jiva
+// targets. The volumes are looked up on all the targets first and the
+// snapshots are then posted concurrently, so that they are taken as
+// close in time as possible. If any of them fails, the snapshots which
+// are taken are deleted so that the group is taken as a whole or not
+// at all, rolledBack is false if any of them couldn't be deleted.
+func (cs *controller) snapshotGroup(ctx context.Context, members []groupSnapshotMember) (rolledBack bool, err error) {
+	clients := make([]*jiva.Client, len(members))
+	vols := make([]*jiva.Volume, len(members))
+	for i, m := range members {
+		cli, err := cs.newJivaClient(m.targetIP)
+		if err != nil {
+			return true, fmt.Errorf("failed to get jiva client of volume {%v}, err: %w", m.volumeID, err)
+		}
+		vol, err := cli.GetVolume(ctx)
+		if err != nil {
+			return true, fmt.Errorf("failed to get volume info of volume {%v} from jiva controller, err: %w", m.volumeID, err)
+		}
+		clients[i], vols[i] = cli, vol
+	}
+
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i := range members {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = clients[i].PostAction(ctx, vols[i], jiva.SnapshotAction, jiva.SnapshotInput{Name: members[i].snap.Name})
+		}(i)
+	}
+	wg.Wait()
+
+	for i, e := range errs {
+		if e != nil {
+			err = fmt.Errorf("failed to take snapshot {%v} of volume {%v}, err: %w", members[i].snap.Name, members[i].volumeID, e)
+			break
+		}
+	}
+	if err == nil {
+		return false, nil
+	}
+
+	// snapshots are deleted even if the request is cancelled
+	rolledBack = true
+	for i, m := range members {
+		if errs[i] != nil {
+			continue
+		}
+		logrus.Warningf("CreateVolumeGroupSnapshot: deleting snapshot {%v} of volume {%v} of the failed group", m.snap.Name, m.volumeID)
+		if e := clients[i].PostAction(context.Background(), vols[i], jiva.DeleteSnapshotAction, jiva.SnapshotInput{Name: m.snap.Name}); e != nil {
+			logrus.Errorf("CreateVolumeGroupSnapshot: failed to delete snapshot {%v} of volume {%v}, err: {%v}", m.snap.Name, m.volumeID, e)
+			rolledBack = false
+		}
+	}
+	return rolledBack, err
+}
+
+// deleteGroupSnapshotCRs deletes the CRs of a group snapshot which is
+// rolled back, so that a retry of the request starts clean
+func (gc *groupController) deleteGroupSnapshotCRs(group *client.JivaVolumeGroupSnapshot, members []groupSnapshotMember) {
+	for _, m := range members {
+		if err := gc.cs.client.DeleteJivaSnapshot(m.snap); err != nil {
+			logrus.Errorf("CreateVolumeGroupSnapshot: failed to delete JivaSnapshot {%v}, err: {%v}", m.snap.Name, err)
+		}
+	}
+	if err := gc.cs.client.DeleteJivaVolumeGroupSnapshot(group); err != nil {
+		logrus.Errorf("CreateVolumeGroupSnapshot: failed to delete JivaVolumeGroupSnapshot {%v}, err: {%v}", group.Name, err)
+	}
+}
+
+// DeleteVolumeGroupSnapshot deletes the given group snapshot
+// along with its snapshots
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) DeleteVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.DeleteVolumeGroupSnapshotRequest,
+) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+
+	groupID := req.GetGroupSnapshotId()
+	if len(groupID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolumeGroupSnapshot: group snapshot ID not provided")
+	}
+
+	cs := gc.cs
+	cs.volumeLocks.LockKey(groupID)
+	defer func() {
+		_ = cs.volumeLocks.UnlockKey(groupID)
+	}()
+
+	// set client each time to avoid caching issue
+	if err := cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteVolumeGroupSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	group, err := cs.client.GetJivaVolumeGroupSnapshot(groupID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteVolumeGroupSnapshot: failed to get JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+	}
+
+	if group == nil {
+		logrus.Warningf("DeleteVolumeGroupSnapshot: JivaVolumeGroupSnapshot {%v} not found, ignore deletion...", groupID)
+		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+	}
+
+	if ids := req.GetSnapshotIds(); len(ids) != 0 && !sameStrings(ids, group.Snapshots) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"DeleteVolumeGroupSnapshot: snapshots {%v} don't match the snapshots {%v} of group snapshot {%v}",
+			ids, group.Snapshots, groupID)
+	}
+
+	for _, snapshotID := range group.Snapshots {
+		if _, err := cs.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: snapshotID}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cs.client.DeleteJivaVolumeGroupSnapshot(group); err != nil {
+		return nil, status.Errorf(codes.Internal, "DeleteVolumeGroupSnapshot: failed to delete JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+	}
+
+	logrus.Infof("DeleteVolumeGroupSnapshot: group snapshot {%v} is deleted", groupID)
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+// GetVolumeGroupSnapshot returns the given group snapshot
+//
+// This implements csi.GroupControllerServer
+func (gc *groupController) GetVolumeGroupSnapshot(
+	ctx context.Context,
+	req *csi.GetVolumeGroupSnapshotRequest,
+) (*csi.GetVolumeGroupSnapshotResponse, error) {
+
+	groupID := req.GetGroupSnapshotId()
+	if len(groupID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "GetVolumeGroupSnapshot: group snapshot ID not provided")
+	}
+
+	// set client each time to avoid caching issue
+	if err := gc.cs.client.Set(); err != nil {
+		return nil, status.Errorf(codes.Internal, "GetVolumeGroupSnapshot: failed to set client, err: {%v}", err)
+	}
+
+	group, err := gc.cs.client.GetJivaVolumeGroupSnapshot(groupID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetVolumeGroupSnapshot: failed to get JivaVolumeGroupSnapshot {%v}, err: {%v}", groupID, err)
+	}
+
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "GetVolumeGroupSnapshot: group snapshot {%v} not found", groupID)
+	}
+
+	if ids := req.GetSnapshotIds(); len(ids) != 0 && !sameStrings(ids, group.Snapshots) {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"GetVolumeGroupSnapshot: snapshots {%v} don't match the snapshots {%v} of group snapshot {%v}",
+			ids, group.Snapshots, groupID)
+	}
+
+	groupSnapshot, err := gc.newVolumeGroupSnapshot(group)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.GetVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+}
+
+// newVolumeGroupSnapshot converts the JivaVolumeGroupSnapshot
+// along with its JivaSnapshots into csi group snapshot
+func (gc *groupController) newVolumeGroupSnapshot(group *client.JivaVolumeGroupSnapshot) (*csi.VolumeGroupSnapshot, error) {
+	creationTime, err := ptypes.TimestampProto(group.CreationTime)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid creation time of group snapshot {%v}, err: {%v}", group.Name, err)
+	}
+
+	groupSnapshot := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: group.Name,
+		CreationTime:    creationTime,
+		ReadyToUse:      group.ReadyToUse,
+	}
+	for _, snapshotID := range group.Snapshots {
+		snap, err := gc.cs.client.GetJivaSnapshot(snapshotID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get JivaSnapshot {%v}, err: {%v}", snapshotID, err)
+		}
+		if snap == nil {
+			return nil, status.Errorf(codes.Internal, "snapshot {%v} of group snapshot {%v} not found", snapshotID, group.Name)
+		}
+		snapshot, err := newCSISnapshot(snap)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "invalid creation time of snapshot {%v}, err: {%v}", snap.Name, err)
+		}
+		groupSnapshot.Snapshots = append(groupSnapshot.Snapshots, snapshot)
+	}
+	return groupSnapshot, nil
+}
+
+// sameStrings returns true if a and b have the
+// same strings irrespective of their order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		if count[s] == 0 {
+			return false
+		}
+		count[s]--
+	}
+	return true
+}
@@ -0,0 +1,235 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeSnapshotTarget is a fake jiva target which takes the first
+// succeed snapshots posted to it and fails the rest
+type fakeSnapshotTarget struct {
+	mu      sync.Mutex
+	succeed int
+	taken   []string
+	deleted []string
+}
+
+func newFakeSnapshotTarget(t *testing.T, target *fakeSnapshotTarget) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := r.URL.Query().Get("action")
+		if r.Method != http.MethodPost {
+			_ = json.NewEncoder(w).Encode(jiva.Volumes{
+				Data: []jiva.Volume{
+					{
+						Name: testVolumeID,
+						Actions: map[string]string{
+							jiva.SnapshotAction:       "http://" + r.Host + "/v1/volumes/" + testVolumeID + "?action=snapshot",
+							jiva.DeleteSnapshotAction: "http://" + r.Host + "/v1/volumes/" + testVolumeID + "?action=deleteSnapshot",
+						},
+					},
+				},
+			})
+			return
+		}
+
+		input := jiva.SnapshotInput{}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		switch action {
+		case jiva.SnapshotAction:
+			if len(target.taken) == target.succeed {
+				http.Error(w, "failed to take snapshot", http.StatusBadRequest)
+				return
+			}
+			target.taken = append(target.taken, input.Name)
+		case jiva.DeleteSnapshotAction:
+			target.deleted = append(target.deleted, input.Name)
+		}
+	}))
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jivaTargetPort = port
+	return server, host
+}
+
+func newGroupSnapshotMembers(groupID, targetIP string, volumeIDs ...string) []groupSnapshotMember {
+	members := []groupSnapshotMember{}
+	for _, volumeID := range volumeIDs {
+		members = append(members, groupSnapshotMember{
+			volumeID: volumeID,
+			targetIP: targetIP,
+			snap:     &client.JivaSnapshot{Name: memberSnapshotID(groupID, volumeID), SourceVolume: volumeID},
+		})
+	}
+	return members
+}
+
+func TestSnapshotGroup(t *testing.T) {
+	defer func(port string) { jivaTargetPort = port }(jivaTargetPort)
+
+	target := &fakeSnapshotTarget{succeed: 3}
+	server, targetIP := newFakeSnapshotTarget(t, target)
+	defer server.Close()
+
+	cs, _ := newFakeController(t)
+	members := newGroupSnapshotMembers("groupsnapshot-1", targetIP, "pvc-1", "pvc-2", "pvc-3")
+	rolledBack, err := cs.snapshotGroup(context.TODO(), members)
+	if err != nil || rolledBack {
+		t.Fatalf("expected group snapshot to be taken, got rolled back: %v, err: %v", rolledBack, err)
+	}
+
+	expected := []string{}
+	for _, m := range members {
+		expected = append(expected, m.snap.Name)
+	}
+	sort.Strings(expected)
+	sort.Strings(target.taken)
+	if !reflect.DeepEqual(target.taken, expected) || len(target.deleted) != 0 {
+		t.Fatalf("expected snapshots %v to be taken, got taken: %v, deleted: %v", expected, target.taken, target.deleted)
+	}
+}
+
+func TestSnapshotGroupPartialFailure(t *testing.T) {
+	defer func(port string) { jivaTargetPort = port }(jivaTargetPort)
+
+	// only one of the three snapshots is taken
+	target := &fakeSnapshotTarget{succeed: 1}
+	server, targetIP := newFakeSnapshotTarget(t, target)
+	defer server.Close()
+
+	cs, _ := newFakeController(t)
+	members := newGroupSnapshotMembers("groupsnapshot-1", targetIP, "pvc-1", "pvc-2", "pvc-3")
+	rolledBack, err := cs.snapshotGroup(context.TODO(), members)
+	if err == nil {
+		t.Fatal("expected group snapshot to fail")
+	}
+	if code := status.Code(jiva.Errorf(err, "%v", err)); code != codes.InvalidArgument {
+		t.Fatalf("expected error of the jiva target to be InvalidArgument, got: %v", code)
+	}
+
+	// snapshot already taken is deleted
+	if !rolledBack || len(target.taken) != 1 || !reflect.DeepEqual(target.deleted, target.taken) {
+		t.Fatalf("expected taken snapshots to be deleted, got rolled back: %v, taken: %v, deleted: %v",
+			rolledBack, target.taken, target.deleted)
+	}
+}
+
+func TestMemberSnapshotID(t *testing.T) {
+	id := memberSnapshotID("groupsnapshot-6a4e1e36-4d4b-4b1c-9f1e-0a4e7a0c2b11", "pvc-2d1b0a5c-8f6e-4d3a-9c2b-1e0f4a3b2c1d")
+	if id != memberSnapshotID("groupsnapshot-6a4e1e36-4d4b-4b1c-9f1e-0a4e7a0c2b11", "pvc-2d1b0a5c-8f6e-4d3a-9c2b-1e0f4a3b2c1d") {
+		t.Fatal("expected snapshot ID to be same across retries")
+	}
+	if id == memberSnapshotID("groupsnapshot-6a4e1e36-4d4b-4b1c-9f1e-0a4e7a0c2b11", "pvc-1") {
+		t.Fatal("expected snapshot IDs of the volumes of the group to differ")
+	}
+	// ID is also a label value
+	if len(id) > 63 {
+		t.Fatalf("expected snapshot ID of at most 63 characters, got: %v", id)
+	}
+}
+
+func TestGroupControllerCapability(t *testing.T) {
+	cs, _ := newFakeController(t)
+	for _, enabled := range []bool{false, true} {
+		d := &CSIDriver{}
+		if enabled {
+			d.gcs = newGroupController(cs)
+		}
+
+		resp, err := NewIdentity(d).GetPluginCapabilities(context.TODO(), &csi.GetPluginCapabilitiesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		advertised := false
+		for _, c := range resp.GetCapabilities() {
+			if c.GetService().GetType() == csi.PluginCapability_Service_GROUP_CONTROLLER_SERVICE {
+				advertised = true
+			}
+		}
+		if advertised != enabled {
+			t.Fatalf("expected group controller service advertised %v, got: %v", enabled, advertised)
+		}
+	}
+}
+
+func TestCreateVolumeGroupSnapshotInvalidArgs(t *testing.T) {
+	gc := newGroupController(nil)
+	tests := map[string]*csi.CreateVolumeGroupSnapshotRequest{
+		"no name":           {SourceVolumeIds: []string{"pvc-1"}},
+		"no source volumes": {Name: "groupsnapshot-1"},
+		"duplicate volumes": {Name: "groupsnapshot-1", SourceVolumeIds: []string{"pvc-1", "pvc-1"}},
+	}
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := gc.CreateVolumeGroupSnapshot(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument, got err: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewGroupSnapshotMembersCleanup(t *testing.T) {
+	vol := newReadyJivaVolume("5Gi", "10.0.0.1")
+	other := newReadyJivaVolume("5Gi", "10.0.0.2")
+	other.Name, other.Spec.PV = "pvc-5678", "pvc-5678"
+	cs, _ := newFakeController(t, vol, other)
+	gc := &groupController{cs: cs}
+
+	// CR of pvc-5678 is left by an earlier request
+	existing := &client.JivaSnapshot{Name: memberSnapshotID("group-1", "pvc-5678"), Namespace: "openebs", SourceVolume: "pvc-5678", GroupSnapshot: "group-1"}
+	if err := cs.client.CreateJivaSnapshot(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	// pvc-9012 doesn't exist, so the CR created
+	// for pvc-1234 before it must be deleted
+	if _, err := gc.newGroupSnapshotMembers("group-1", []string{testVolumeID, "pvc-5678", "pvc-9012"}); err == nil {
+		t.Fatal("expected members to fail for the volume which doesn't exist")
+	}
+
+	snaps, err := cs.client.ListJivaSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0].Name != existing.Name {
+		t.Fatalf("expected only JivaSnapshot {%v} to be left, got: %+v", existing.Name, snaps)
+	}
+}
@@ -20,11 +20,17 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
 	"github.com/sirupsen/logrus"
@@ -43,20 +49,98 @@ func parseEndpoint(ep string) (string, string, error) {
 	return "", "", fmt.Errorf("Invalid endpoint: %v", ep)
 }
 
+// ValidateEndpoint verifies that the endpoint is valid and for
+// a unix socket, its directory exists and is writable so that
+// the socket can be created
+func ValidateEndpoint(ep string) error {
+	proto, addr, err := parseEndpoint(ep)
+	if err != nil {
+		return err
+	}
+	if proto != "unix" {
+		return nil
+	}
+
+	dir := path.Dir("/" + addr)
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("socket directory {%s} is not accessible: %v", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("socket directory {%s} is not a directory", dir)
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return fmt.Errorf("socket directory {%s} is not writable: %v", dir, err)
+	}
+	return nil
+}
+
+// requestCount is used to generate the request_id
+// log field which correlates the lines of a grpc call
+var requestCount uint64
+
 // logGRPC logs all the grpc related errors, i.e the final errors
 // which are returned to the grpc clients
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	logrus.Debugf("GRPC call: %s", info.FullMethod)
-	logrus.Debugf("GRPC request: %s", protosanitizer.StripSecrets(req))
+	log := logrus.WithFields(grpcLogFields(req, info))
+	log.Debugf("GRPC call: %s", info.FullMethod)
+	log.Debugf("GRPC request: %s", protosanitizer.StripSecrets(req))
 	resp, err := handler(ctx, req)
 	if err != nil {
-		logrus.Errorf("GRPC error: %v", err)
+		log.Errorf("GRPC error: %v", err)
 	} else {
-		logrus.Debugf("GRPC response: %s", protosanitizer.StripSecrets(resp))
+		log.Debugf("GRPC response: %s", protosanitizer.StripSecrets(resp))
 	}
 	return resp, err
 }
 
+// grpcLogFields returns the method, the request ID and the
+// volume ID and node ID set in the request as log fields
+func grpcLogFields(req interface{}, info *grpc.UnaryServerInfo) logrus.Fields {
+	fields := logrus.Fields{
+		"method":     path.Base(info.FullMethod),
+		"request_id": atomic.AddUint64(&requestCount, 1),
+	}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		fields["volume_id"] = r.GetVolumeId()
+	}
+	// CreateVolume has the volume name instead of volume ID
+	if r, ok := req.(interface{ GetName() string }); ok && r.GetName() != "" {
+		fields["volume_id"] = r.GetName()
+	}
+	if r, ok := req.(interface{ GetNodeId() string }); ok && r.GetNodeId() != "" {
+		fields["node_id"] = r.GetNodeId()
+	}
+	return fields
+}
+
+// chainUnaryInterceptors chains the given interceptors into a
+// single one, the first interceptor is the outermost one
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// contextStatus returns the grpc status error for the request
+// context which is done, i.e Canceled if the sidecar gave up on
+// the request, nil is returned if the context is not done yet
+func contextStatus(ctx context.Context, op string) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.Canceled:
+		return status.Errorf(codes.Canceled, "%s: request is cancelled, err: {%v}", op, ctx.Err())
+	default:
+		return status.Errorf(codes.DeadlineExceeded, "%s: request deadline exceeded, err: {%v}", op, ctx.Err())
+	}
+}
+
 // NonBlockingGRPCServer defines Non blocking GRPC server interfaces
 type NonBlockingGRPCServer interface {
 	// Start services at the endpoint
@@ -70,15 +154,24 @@ type NonBlockingGRPCServer interface {
 
 	// Stops the service forcefully
 	ForceStop()
+
+	// Stops the service gracefully, in-flight requests
+	// are cancelled after the given timeout
+	Shutdown(timeout time.Duration)
 }
 
-// NewNonBlockingGRPCServer returns a new instance of NonBlockingGRPCServer
-func NewNonBlockingGRPCServer(ep string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) NonBlockingGRPCServer {
+// NewNonBlockingGRPCServer returns a new instance of NonBlockingGRPCServer,
+// the given options are applied on the grpc server along with the default
+// interceptors
+func NewNonBlockingGRPCServer(ep string, ids csi.IdentityServer, cs csi.ControllerServer, gcs csi.GroupControllerServer, ns csi.NodeServer, opts ...grpc.ServerOption) NonBlockingGRPCServer {
 	return &nonBlockingGRPCServer{
 		endpoint:       ep,
 		identityServer: ids,
 		ctrlServer:     cs,
-		agentServer:    ns}
+		groupServer:    gcs,
+		agentServer:    ns,
+		inflight:       newInflightTracker(),
+		opts:           opts}
 }
 
 // NonBlocking server
@@ -90,7 +183,10 @@ type nonBlockingGRPCServer struct {
 	endpoint       string
 	identityServer csi.IdentityServer
 	ctrlServer     csi.ControllerServer
+	groupServer    csi.GroupControllerServer
 	agentServer    csi.NodeServer
+	inflight       *inflightTracker
+	opts           []grpc.ServerOption
 }
 
 // Start grpc server for serving CSI endpoints
@@ -122,6 +218,7 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 // plugin. In this function all the csi related interfaces are provided by
 // container-storage-interface
 func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	defer s.wg.Done()
 
 	proto, addr, err := parseEndpoint(endpoint)
 	if err != nil {
@@ -145,8 +242,9 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	}
 
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.UnaryInterceptor(chainUnaryInterceptors(s.inflight.interceptor, metricsInterceptor, logGRPC)),
 	}
+	opts = append(opts, s.opts...)
 	// Create a new grpc server, all the request from csi client to
 	// create/delete/... will hit this server
 	server := grpc.NewServer(opts...)
@@ -158,6 +256,9 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if cs != nil {
 		csi.RegisterControllerServer(server, cs)
 	}
+	if s.groupServer != nil {
+		csi.RegisterGroupControllerServer(server, s.groupServer)
+	}
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	utilexec "k8s.io/utils/exec"
+)
+
+// healthCheck is one of the checks run
+// by the health-check subcommand
+type healthCheck struct {
+	name string
+	// check returns the details reported on
+	// success or the error reported on failure
+	check func() (string, error)
+}
+
+// RunHealthCheck checks that the kube-apiserver is reachable, the
+// JivaVolume CRs can be listed and on a node that iscsiadm is present,
+// without starting the grpc server. Report of the checks is written
+// to out, false is returned if any of the checks failed.
+func RunHealthCheck(out io.Writer, pluginType string, newClient func() (*client.Client, error), exec utilexec.Interface) bool {
+	var cli *client.Client
+	checks := []healthCheck{
+		{
+			name: "kube-apiserver",
+			check: func() (string, error) {
+				var err error
+				cli, err = newClient()
+				if err != nil {
+					return "", err
+				}
+				return "reachable", nil
+			},
+		},
+		{
+			name: "JivaVolumes",
+			check: func() (string, error) {
+				if cli == nil {
+					return "", fmt.Errorf("skipped, kube-apiserver is not reachable")
+				}
+				vols, err := cli.ListJivaVolumes()
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d found", len(vols.Items)), nil
+			},
+		},
+	}
+
+	if pluginType == "node" {
+		checks = append(checks, healthCheck{
+			name: "iscsiadm",
+			check: func() (string, error) {
+				return exec.LookPath("iscsiadm")
+			},
+		})
+	}
+
+	healthy := true
+	for _, c := range checks {
+		details, err := c.check()
+		if err != nil {
+			healthy = false
+			fmt.Fprintf(out, "[FAIL] %s: %v\n", c.name, err)
+			continue
+		}
+		fmt.Fprintf(out, "[PASS] %s: %s\n", c.name, details)
+	}
+
+	if healthy {
+		fmt.Fprintln(out, "health check passed")
+	} else {
+		fmt.Fprintln(out, "health check failed")
+	}
+	return healthy
+}
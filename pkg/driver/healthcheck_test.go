@@ -0,0 +1,103 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-operator/pkg/apis"
+	"k8s.io/apimachinery/pkg/runtime"
+	testingexec "k8s.io/utils/exec/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFakeLookPath(found bool) *testingexec.FakeExec {
+	return &testingexec.FakeExec{
+		LookPathFunc: func(cmd string) (string, error) {
+			if !found {
+				return "", errors.New("executable file not found in $PATH")
+			}
+			return "/usr/sbin/" + cmd, nil
+		},
+	}
+}
+
+func TestRunHealthCheck(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	vol := newTestJivaVolume()
+	newClient := func() (*client.Client, error) {
+		return client.NewWithClient(fake.NewFakeClientWithScheme(scheme, vol)), nil
+	}
+	unreachable := func() (*client.Client, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	tests := map[string]struct {
+		pluginType string
+		newClient  func() (*client.Client, error)
+		iscsiadm   bool
+		healthy    bool
+		report     []string
+	}{
+		"controller": {
+			pluginType: "controller",
+			newClient:  newClient,
+			healthy:    true,
+			report:     []string{"[PASS] kube-apiserver", "[PASS] JivaVolumes: 1 found"},
+		},
+		"node": {
+			pluginType: "node",
+			newClient:  newClient,
+			iscsiadm:   true,
+			healthy:    true,
+			report:     []string{"[PASS] JivaVolumes", "[PASS] iscsiadm: /usr/sbin/iscsiadm"},
+		},
+		"node without iscsiadm": {
+			pluginType: "node",
+			newClient:  newClient,
+			healthy:    false,
+			report:     []string{"[PASS] JivaVolumes", "[FAIL] iscsiadm", "health check failed"},
+		},
+		"kube-apiserver not reachable": {
+			pluginType: "controller",
+			newClient:  unreachable,
+			healthy:    false,
+			report:     []string{"[FAIL] kube-apiserver: connection refused", "[FAIL] JivaVolumes: skipped"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			if healthy := RunHealthCheck(out, test.pluginType, test.newClient, newFakeLookPath(test.iscsiadm)); healthy != test.healthy {
+				t.Fatalf("expected healthy %v, got report:\n%s", test.healthy, out.String())
+			}
+			for _, line := range test.report {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("expected report to contain {%v}, got:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+}
@@ -17,13 +17,21 @@ limitations under the License.
 package driver
 
 import (
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/openebs/jiva-csi/version"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// probeTimeout is the max time Probe waits for the
+// kube-apiserver so that the probe itself doesn't hang
+var probeTimeout = 5 * time.Second
+
 // identity is the server implementation
 // for CSI IdentityServer
 type identity struct {
@@ -64,10 +72,10 @@ func (id *identity) GetPluginInfo(
 	}, nil
 }
 
-// TODO
-// Need to implement this
-//
-// Probe checks if the plugin is running or not
+// Probe checks if the plugin is running or not, controller
+// plugin is reported as not ready if it can't list the
+// JivaVolume CRs i.e kube-apiserver is not reachable or the
+// jiva-operator CRDs are not installed
 //
 // This implements csi.IdentityServer
 func (id *identity) Probe(
@@ -75,7 +83,19 @@ func (id *identity) Probe(
 	req *csi.ProbeRequest,
 ) (*csi.ProbeResponse, error) {
 
-	return &csi.ProbeResponse{}, nil
+	if id.driver.config.PluginType != "controller" || id.driver.client == nil {
+		return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	if err := id.driver.client.Probe(ctx); err != nil {
+		logrus.Warningf("Probe: plugin is not ready, failed to list JivaVolumes, err: {%v}", err)
+		return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: false}}, nil
+	}
+
+	return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: true}}, nil
 }
 
 // GetPluginCapabilities returns supported capabilities
@@ -91,7 +111,7 @@ func (id *identity) GetPluginCapabilities(
 	req *csi.GetPluginCapabilitiesRequest,
 ) (*csi.GetPluginCapabilitiesResponse, error) {
 
-	return &csi.GetPluginCapabilitiesResponse{
+	resp := &csi.GetPluginCapabilitiesResponse{
 		Capabilities: []*csi.PluginCapability{
 			{
 				Type: &csi.PluginCapability_Service_{
@@ -100,13 +120,26 @@ func (id *identity) GetPluginCapabilities(
 					},
 				},
 			},
-			/*			{
-							Type: &csi.PluginCapability_Service_{
-								Service: &csi.PluginCapability_Service{
-									Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
-								},
-							},
-						},
-			*/},
-	}, nil
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
+		},
+	}
+
+	// group controller is only served if the
+	// group snapshots of the volumes are enabled
+	if id.driver.gcs != nil {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{
+					Type: csi.PluginCapability_Service_GROUP_CONTROLLER_SERVICE,
+				},
+			},
+		})
+	}
+	return resp, nil
 }
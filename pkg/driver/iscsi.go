@@ -0,0 +1,387 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-iscsi/iscsi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	utilexec "k8s.io/utils/exec"
+)
+
+const (
+	// iscsiLoginRetryInterval is the initial wait between two
+	// consecutive login attempts, it is doubled after each retry
+	iscsiLoginRetryInterval = 2 * time.Second
+
+	// deviceScanInterval is the interval at which the
+	// device path is polled for after iSCSI login
+	deviceScanInterval = time.Second
+
+	// initiatorIface is the iSCSI interface created with the
+	// initiator name and the network interface set via flags,
+	// sessions of the volumes are established through it
+	// instead of the default one
+	initiatorIface = "jiva-csi"
+
+	// targetServiceSuffix is the suffix of the name of the
+	// service created by jiva-operator for the jiva target
+	targetServiceSuffix = "-jiva-ctrl-svc"
+
+	// iscsiErrNoObjsFound is the exit status of iscsiadm
+	// if there is no matching session or node record
+	iscsiErrNoObjsFound = 21
+)
+
+// iqnRegex matches the iSCSI qualified name i.e
+// iqn.yyyy-mm.<reversed domain name>[:<unique name>]
+var iqnRegex = regexp.MustCompile(`^(?i)iqn\.[0-9]{4}-(0[1-9]|1[0-2])\.[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*(:[^\s]+)?$`)
+
+// lookupHost resolves the DNS portal of the target during
+// NodeStageVolume, it is replaced in tests
+var lookupHost = net.LookupHost
+
+var (
+	// iscsiLogoutRetries is the number of times logout is
+	// retried on transient errors during NodeUnstageVolume
+	iscsiLogoutRetries = 5
+	// iscsiLogoutRetryInterval is the initial wait between two
+	// consecutive logout attempts, it is doubled after each retry
+	iscsiLogoutRetryInterval = 2 * time.Second
+)
+
+// iscsiLogin logs in to the target using the given connector and
+// returns the path of the attached device. Login is attempted
+// retries+1 times with exponential backoff between the attempts.
+// If loginTimeout is set, the target is discovered upfront so that
+// the login timeout can be updated in the node record before login.
+// After each login the device path is polled for until it appears
+// or scanTimeout elapses. Retries are aborted once ctx is done.
+func iscsiLogin(
+	ctx context.Context,
+	exec utilexec.Interface,
+	connector iscsi.Connector,
+	loginTimeout time.Duration,
+	retries int,
+	scanTimeout time.Duration,
+) (string, error) {
+	if scanTimeout > 0 {
+		connector.CheckInterval = int32(deviceScanInterval.Seconds())
+		connector.RetryCount = int32((scanTimeout + deviceScanInterval - 1) / deviceScanInterval)
+	}
+
+	if loginTimeout > 0 {
+		for _, portal := range connector.TargetPortals {
+			if err := setISCSILoginTimeout(exec, connector.Interface, connector.TargetIqn, portal, loginTimeout); err != nil {
+				return "", err
+			}
+		}
+		// node record is already created by the
+		// discovery done above
+		connector.DoDiscovery = false
+	}
+
+	var (
+		devicePath string
+		err        error
+	)
+	interval := iscsiLoginRetryInterval
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logrus.Warningf(
+				"iscsi: login to target: {%s} portals: {%v} failed, retrying in %v (attempt %d/%d), err: {%v}",
+				connector.TargetIqn, connector.TargetPortals, interval, attempt, retries, err,
+			)
+			if err := sleepWithContext(ctx, interval); err != nil {
+				return "", err
+			}
+			interval *= 2
+		}
+
+		for _, portal := range connector.TargetPortals {
+			logrus.Infof(
+				"iscsi: scanning for device of target: {%s} portal: {%s} lun: {%d}, timeout: %v",
+				connector.TargetIqn, portal, connector.Lun, scanTimeout,
+			)
+		}
+		devicePath, err = iscsi.Connect(connector)
+		if err == nil {
+			return devicePath, nil
+		}
+	}
+
+	// login may have succeeded on the last attempt
+	// while the device never showed up
+	for _, portal := range connector.TargetPortals {
+		expected := iscsiDevicePath(portal, connector.TargetIqn, connector.Lun)
+		if _, statErr := os.Stat(expected); os.IsNotExist(statErr) {
+			return "", fmt.Errorf("iscsi: device {%s} did not appear within %v, err: {%v}", expected, scanTimeout, err)
+		}
+	}
+	return "", err
+}
+
+// iscsiDevicePath returns the by-path link of the device
+// created by udev for the given lun of the target
+func iscsiDevicePath(portal, iqn string, lun int32) string {
+	return fmt.Sprintf("/dev/disk/by-path/ip-%s-iscsi-%s-lun-%d", portal, iqn, lun)
+}
+
+// setISCSILoginTimeout discovers the target at the given portal and
+// updates the login timeout of the corresponding node record
+func setISCSILoginTimeout(exec utilexec.Interface, iface, iqn, portal string, timeout time.Duration) error {
+	logrus.Debugf("iscsi: discover target: {%s} at portal: {%s} via iface: {%s}", iqn, portal, iface)
+	out, err := exec.Command("iscsiadm", "-m", "discovery", "-t", "sendtargets", "-p", portal, "-I", iface).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iscsi: discovery failed for portal: {%s}, err: {%v}, output: {%s}", portal, err, string(out))
+	}
+
+	seconds := strconv.Itoa(int(timeout.Seconds()))
+	logrus.Debugf("iscsi: set login timeout: {%ss} for target: {%s} portal: {%s}", seconds, iqn, portal)
+	out, err = exec.Command("iscsiadm", "-m", "node", "-T", iqn, "-p", portal, "-I", iface,
+		"-o", "update", "-n", "node.conn[0].timeo.login_timeout", "-v", seconds).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iscsi: failed to update login timeout for target: {%s}, err: {%v}, output: {%s}", iqn, err, string(out))
+	}
+	return nil
+}
+
+// usesDNSPortal returns true if the iSCSI sessions of the volume
+// are established to the DNS name of the target service
+func usesDNSPortal(instance *jv.JivaVolume) bool {
+	use, _ := strconv.ParseBool(instance.Annotations[client.UseDNSPortalAnnotation])
+	return use
+}
+
+// targetPortal returns the portal of the jiva target of the volume, i.e
+// the DNS name of the target service if the volume uses DNS portal so
+// that the failover of the target is handled by iSCSI re-resolving the
+// name, and the IP of the target otherwise
+func targetPortal(instance *jv.JivaVolume, clusterDomain string) string {
+	if !usesDNSPortal(instance) {
+		return fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)
+	}
+
+	host := fmt.Sprintf("%s%s.%s.svc", instance.Name, targetServiceSuffix, instance.Namespace)
+	if clusterDomain != "" {
+		host += "." + clusterDomain
+	}
+	return net.JoinHostPort(host, fmt.Sprint(instance.Spec.ISCSISpec.TargetPort))
+}
+
+// resolvePortal verifies that the host of the given portal resolves,
+// the resolved address is not used so that iSCSI re-resolves the
+// name on reconnect
+func resolvePortal(portal string) error {
+	host, _, err := net.SplitHostPort(portal)
+	if err != nil {
+		return fmt.Errorf("invalid portal {%s}, err: {%v}", portal, err)
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if _, err := lookupHost(host); err != nil {
+		return fmt.Errorf("failed to resolve portal {%s}, err: {%v}", portal, err)
+	}
+	return nil
+}
+
+// ValidateInitiatorName verifies that the
+// initiator name is a valid iSCSI qualified name
+func ValidateInitiatorName(name string) error {
+	if !iqnRegex.MatchString(name) {
+		return fmt.Errorf("initiator name {%s} is not a valid IQN i.e iqn.yyyy-mm.<reversed domain name>[:<unique name>]", name)
+	}
+	return nil
+}
+
+// ResolveInitiatorName returns the initiator name set via the flag or
+// read from the given file, which is in the format of
+// /etc/iscsi/initiatorname.iscsi i.e InitiatorName=<iqn>. Empty name
+// is returned if neither is set, the default initiator name of the
+// node is used in that case.
+func ResolveInitiatorName(name, path string) (string, error) {
+	if name != "" && path != "" {
+		return "", fmt.Errorf("only one of the initiator name and the initiator name file can be set")
+	}
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read initiator name file {%s}, err: {%v}", path, err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			name = strings.TrimSpace(strings.TrimPrefix(line, "InitiatorName="))
+			break
+		}
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("failed to read initiator name file {%s}, err: {%v}", path, err)
+		}
+		if name == "" {
+			return "", fmt.Errorf("initiator name file {%s} doesn't have the initiator name", path)
+		}
+	}
+
+	if name == "" {
+		return "", nil
+	}
+	if err := ValidateInitiatorName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ValidateNetworkInterface verifies that the network interface
+// the iSCSI sessions are bound to exists on the node
+func ValidateNetworkInterface(name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return fmt.Errorf("network interface {%s} not found, err: {%v}", name, err)
+	}
+	return nil
+}
+
+// setupInitiatorIface creates the iSCSI interface used for the sessions
+// of the volumes if it doesn't exist and sets the initiator name and the
+// network interface on it, the ones which are not set are left as is.
+// These are set each time so that a changed initiator name is applied.
+func setupInitiatorIface(exec utilexec.Interface, initiatorName, netIface string) (string, error) {
+	if _, err := exec.Command("iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "show").CombinedOutput(); err != nil {
+		logrus.Infof("iscsi: creating iface: {%s}", initiatorIface)
+		if out, err := exec.Command("iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "new").CombinedOutput(); err != nil {
+			return "", fmt.Errorf("iscsi: failed to create iface: {%s}, err: {%v}, output: {%s}", initiatorIface, err, string(out))
+		}
+	}
+
+	if initiatorName != "" {
+		logrus.Debugf("iscsi: set initiator name: {%s} on iface: {%s}", initiatorName, initiatorIface)
+		out, err := exec.Command("iscsiadm", "-m", "iface", "-I", initiatorIface,
+			"-o", "update", "-n", "iface.initiatorname", "-v", initiatorName).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("iscsi: failed to set initiator name on iface: {%s}, err: {%v}, output: {%s}", initiatorIface, err, string(out))
+		}
+	}
+
+	// sessions are bound to the network interface instead
+	// of going through the default route of the node
+	if netIface != "" {
+		logrus.Debugf("iscsi: set network interface: {%s} on iface: {%s}", netIface, initiatorIface)
+		out, err := exec.Command("iscsiadm", "-m", "iface", "-I", initiatorIface,
+			"-o", "update", "-n", "iface.net_ifacename", "-v", netIface).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("iscsi: failed to set network interface on iface: {%s}, err: {%v}, output: {%s}", initiatorIface, err, string(out))
+		}
+	}
+	return initiatorIface, nil
+}
+
+// iscsiLogout flushes the device attached for the target, logs out
+// of the target at each portal and deletes the node records, so that
+// a stale session doesn't block staging the volume on another node.
+// Missing device, session or node record are treated as success.
+func iscsiLogout(exec utilexec.Interface, iqn string, portals []string, devicePath string) error {
+	if err := flushDevice(exec, devicePath); err != nil {
+		return err
+	}
+
+	for _, portal := range portals {
+		logrus.Infof("iscsi: logout from target: {%s} portal: {%s}", iqn, portal)
+		if err := retryISCSIAdm(exec, iqn, portal, "-u"); err != nil {
+			return fmt.Errorf("iscsi: logout failed for target: {%s} portal: {%s}, err: {%v}", iqn, portal, err)
+		}
+
+		logrus.Infof("iscsi: delete node record of target: {%s} portal: {%s}", iqn, portal)
+		if err := retryISCSIAdm(exec, iqn, portal, "-o", "delete"); err != nil {
+			return fmt.Errorf("iscsi: failed to delete node record of target: {%s} portal: {%s}, err: {%v}", iqn, portal, err)
+		}
+	}
+	return nil
+}
+
+// flushDevice flushes the buffers of the given device, multipath
+// devices are flushed using multipath so that the map is removed
+func flushDevice(exec utilexec.Interface, devicePath string) error {
+	if devicePath == "" {
+		return nil
+	}
+
+	device, err := filepath.EvalSymlinks(devicePath)
+	if os.IsNotExist(err) {
+		logrus.Infof("iscsi: device: {%s} is already removed, skip flushing", devicePath)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("iscsi: failed to resolve device: {%s}, err: {%v}", devicePath, err)
+	}
+
+	cmd, args := "blockdev", []string{"--flushbufs", device}
+	if strings.HasPrefix(filepath.Base(device), "dm-") {
+		cmd, args = "multipath", []string{"-f", device}
+	}
+
+	logrus.Debugf("iscsi: flush device: {%s} using %s", device, cmd)
+	if out, err := exec.Command(cmd, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("iscsi: failed to flush device: {%s}, err: {%v}, output: {%s}", device, err, string(out))
+	}
+	return nil
+}
+
+// retryISCSIAdm runs iscsiadm in node mode for the given target
+// and portal until it succeeds or iscsiLogoutRetries is exceeded
+func retryISCSIAdm(exec utilexec.Interface, iqn, portal string, args ...string) error {
+	args = append([]string{"-m", "node", "-T", iqn, "-p", portal}, args...)
+
+	var err error
+	interval := iscsiLogoutRetryInterval
+	for attempt := 0; attempt <= iscsiLogoutRetries; attempt++ {
+		if attempt > 0 {
+			logrus.Warningf("iscsi: iscsiadm %v failed, retrying in %v (attempt %d/%d), err: {%v}",
+				args, interval, attempt, iscsiLogoutRetries, err)
+			time.Sleep(interval)
+			interval *= 2
+		}
+
+		var out []byte
+		out, err = exec.Command("iscsiadm", args...).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.ExitStatus() == iscsiErrNoObjsFound {
+			logrus.Infof("iscsi: no session or node record found for target: {%s} portal: {%s}", iqn, portal)
+			return nil
+		}
+		err = fmt.Errorf("%v, output: {%s}", err, string(out))
+	}
+	return err
+}
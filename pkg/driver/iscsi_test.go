@@ -0,0 +1,320 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+const (
+	testIQN    = "iqn.2016-09.com.openebs.jiva:pvc-1234"
+	testPortal = "10.0.0.1:3260"
+)
+
+// newFakeExec returns a FakeExec which runs the given actions in
+// order and records the command line of each call in cmds
+func newFakeExec(cmds *[][]string, actions ...testingexec.FakeAction) *testingexec.FakeExec {
+	fakeExec := &testingexec.FakeExec{}
+	for _, action := range actions {
+		fakeCmd := &testingexec.FakeCmd{
+			CombinedOutputScript: []testingexec.FakeAction{action},
+		}
+		fakeExec.CommandScript = append(fakeExec.CommandScript,
+			func(cmd string, args ...string) utilexec.Cmd {
+				*cmds = append(*cmds, append([]string{cmd}, args...))
+				return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+			})
+	}
+	return fakeExec
+}
+
+func success() ([]byte, []byte, error) {
+	return []byte{}, nil, nil
+}
+
+func TestISCSILogoutStaleSession(t *testing.T) {
+	iscsiLogoutRetryInterval = 0
+	dir, err := ioutil.TempDir("", "iscsi-logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// device of the stale session is still present
+	device := filepath.Join(dir, "sdb")
+	if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds,
+		success,
+		// logout fails while the session is busy
+		func() ([]byte, []byte, error) {
+			return []byte("iscsiadm: Could not logout of all requested sessions"), nil, errors.New("exit status 8")
+		},
+		success,
+		success,
+	)
+
+	if err := iscsiLogout(fakeExec, testIQN, []string{testPortal}, device); err != nil {
+		t.Fatalf("expected logout to succeed, got err: %v", err)
+	}
+
+	expected := [][]string{
+		{"blockdev", "--flushbufs", device},
+		{"iscsiadm", "-m", "node", "-T", testIQN, "-p", testPortal, "-u"},
+		{"iscsiadm", "-m", "node", "-T", testIQN, "-p", testPortal, "-u"},
+		{"iscsiadm", "-m", "node", "-T", testIQN, "-p", testPortal, "-o", "delete"},
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("expected commands: %v, got: %v", expected, cmds)
+	}
+}
+
+func TestISCSILogoutAlreadyLoggedOut(t *testing.T) {
+	iscsiLogoutRetryInterval = 0
+	noObjsFound := func() ([]byte, []byte, error) {
+		return []byte("iscsiadm: No matching sessions found"), nil, &testingexec.FakeExitError{Status: iscsiErrNoObjsFound}
+	}
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds, noObjsFound, noObjsFound)
+
+	if err := iscsiLogout(fakeExec, testIQN, []string{testPortal}, "/dev/disk/by-path/missing"); err != nil {
+		t.Fatalf("expected logout to succeed, got err: %v", err)
+	}
+
+	// device is already removed, so it shouldn't be flushed
+	if len(cmds) != 2 {
+		t.Fatalf("expected logout and delete to be run, got: %v", cmds)
+	}
+}
+
+func TestISCSILogoutRetriesExceeded(t *testing.T) {
+	iscsiLogoutRetryInterval = 0
+	failure := func() ([]byte, []byte, error) {
+		return nil, nil, errors.New("exit status 8")
+	}
+
+	actions := []testingexec.FakeAction{}
+	for i := 0; i <= iscsiLogoutRetries; i++ {
+		actions = append(actions, failure)
+	}
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds, actions...)
+
+	if err := iscsiLogout(fakeExec, testIQN, []string{testPortal}, ""); err == nil {
+		t.Fatal("expected logout to fail")
+	}
+
+	if len(cmds) != iscsiLogoutRetries+1 {
+		t.Fatalf("expected %d logout attempts, got: %d", iscsiLogoutRetries+1, len(cmds))
+	}
+}
+
+func TestValidateInitiatorName(t *testing.T) {
+	tests := map[string]bool{
+		"iqn.1993-08.org.debian:01:4f2a9c3e":   true,
+		"iqn.2016-09.com.openebs.jiva:node-1":  true,
+		"IQN.2005-03.org.open-iscsi:b0e4c3d2f": true,
+		"iqn.2016-09.com.openebs":              true,
+		"":                                     false,
+		"eui.02004567A425678D":                 false,
+		"iqn.2016-13.com.openebs:node-1":       false,
+		"iqn.16-09.com.openebs:node-1":         false,
+		"iqn.2016-09.-openebs:node-1":          false,
+		"iqn.2016-09.com.openebs:node 1":       false,
+	}
+
+	for name, valid := range tests {
+		if err := ValidateInitiatorName(name); (err == nil) != valid {
+			t.Errorf("expected initiator name {%v} valid %v, got err: %v", name, valid, err)
+		}
+	}
+}
+
+func TestResolveInitiatorName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initiatorname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "initiatorname.iscsi")
+	content := "## DO NOT EDIT OR REMOVE THIS FILE!\n\nInitiatorName=iqn.1993-08.org.debian:01:4f2a9c3e\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid")
+	if err := ioutil.WriteFile(invalid, []byte("InitiatorName=node-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		name     string
+		path     string
+		expected string
+		ok       bool
+	}{
+		"unset":            {ok: true},
+		"flag":             {name: "iqn.2016-09.com.openebs.jiva:node-1", expected: "iqn.2016-09.com.openebs.jiva:node-1", ok: true},
+		"file":             {path: path, expected: "iqn.1993-08.org.debian:01:4f2a9c3e", ok: true},
+		"both":             {name: "iqn.2016-09.com.openebs.jiva:node-1", path: path},
+		"invalid flag":     {name: "node-1"},
+		"invalid file":     {path: invalid},
+		"file not present": {path: filepath.Join(dir, "missing")},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ResolveInitiatorName(test.name, test.path)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %v, got err: %v", test.ok, err)
+			}
+			if got != test.expected {
+				t.Fatalf("expected initiator name {%v}, got: {%v}", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestValidateNetworkInterface(t *testing.T) {
+	if err := ValidateNetworkInterface("lo"); err != nil {
+		t.Fatalf("expected loopback interface to exist, got err: %v", err)
+	}
+	if err := ValidateNetworkInterface("jiva-missing0"); err == nil {
+		t.Fatal("expected missing interface to fail validation")
+	}
+}
+
+func TestSetupInitiatorIface(t *testing.T) {
+	failure := func() ([]byte, []byte, error) {
+		return []byte("iface jiva-csi not found"), nil, errors.New("exit status 21")
+	}
+	name := "iqn.2016-09.com.openebs.jiva:node-1"
+	update := []string{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "update", "-n", "iface.initiatorname", "-v", name}
+	bind := []string{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "update", "-n", "iface.net_ifacename", "-v", "eth1"}
+
+	tests := map[string]struct {
+		initiatorName string
+		netIface      string
+		actions       []testingexec.FakeAction
+		expectedCmds  [][]string
+	}{
+		"iface exists": {
+			initiatorName: name,
+			actions:       []testingexec.FakeAction{success, success},
+			expectedCmds: [][]string{
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "show"},
+				update,
+			},
+		},
+		"iface is created": {
+			initiatorName: name,
+			actions:       []testingexec.FakeAction{failure, success, success},
+			expectedCmds: [][]string{
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "show"},
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "new"},
+				update,
+			},
+		},
+		"iface is bound to network interface": {
+			netIface: "eth1",
+			actions:  []testingexec.FakeAction{failure, success, success},
+			expectedCmds: [][]string{
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "show"},
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "new"},
+				bind,
+			},
+		},
+		"initiator name and network interface": {
+			initiatorName: name,
+			netIface:      "eth1",
+			actions:       []testingexec.FakeAction{success, success, success},
+			expectedCmds: [][]string{
+				{"iscsiadm", "-m", "iface", "-I", initiatorIface, "-o", "show"},
+				update,
+				bind,
+			},
+		},
+	}
+
+	for desc, test := range tests {
+		t.Run(desc, func(t *testing.T) {
+			var cmds [][]string
+			iface, err := setupInitiatorIface(newFakeExec(&cmds, test.actions...), test.initiatorName, test.netIface)
+			if err != nil {
+				t.Fatalf("expected iface to be set up, got err: %v", err)
+			}
+			if iface != initiatorIface {
+				t.Fatalf("expected iface %v, got: %v", initiatorIface, iface)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func TestTargetPortal(t *testing.T) {
+	vol := newTestJivaVolume()
+	vol.Spec.ISCSISpec.TargetIP = "10.0.0.1"
+	vol.Spec.ISCSISpec.TargetPort = 3260
+
+	if portal := targetPortal(vol, "cluster.local"); portal != "10.0.0.1:3260" {
+		t.Fatalf("expected IP portal, got: %v", portal)
+	}
+
+	vol.Annotations = map[string]string{client.UseDNSPortalAnnotation: "true"}
+	expected := "pvc-1234-jiva-ctrl-svc.openebs.svc.cluster.local:3260"
+	if portal := targetPortal(vol, "cluster.local"); portal != expected {
+		t.Fatalf("expected DNS portal %v, got: %v", expected, portal)
+	}
+}
+
+func TestResolvePortal(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupHost = f }(lookupHost)
+	lookupHost = func(host string) ([]string, error) {
+		if host != "pvc-1234-jiva-ctrl-svc.openebs.svc.cluster.local" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+
+	tests := map[string]bool{
+		"10.0.0.1:3260": true,
+		"pvc-1234-jiva-ctrl-svc.openebs.svc.cluster.local:3260": true,
+		"pvc-5678-jiva-ctrl-svc.openebs.svc.cluster.local:3260": false,
+		"pvc-1234-jiva-ctrl-svc":                                false,
+	}
+	for portal, ok := range tests {
+		if err := resolvePortal(portal); (err == nil) != ok {
+			t.Errorf("expected portal {%v} resolved %v, got err: %v", portal, ok, err)
+		}
+	}
+}
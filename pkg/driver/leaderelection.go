@@ -0,0 +1,215 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	// leaseDuration, renewDeadline and retryPeriod are the timings
+	// of the leader election, same as the defaults of the sidecars
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 5 * time.Second
+)
+
+// leaderElector tracks whether this instance of the
+// controller plugin holds the leader election lease
+type leaderElector struct {
+	lock   resourcelock.Interface
+	leader int32
+}
+
+func newLeaderElector(lock resourcelock.Interface) *leaderElector {
+	return &leaderElector{lock: lock}
+}
+
+// isLeader returns true if this instance holds the lease
+func (le *leaderElector) isLeader() bool {
+	return atomic.LoadInt32(&le.leader) == 1
+}
+
+// checkLeader returns Unavailable if this instance is not the leader
+func (le *leaderElector) checkLeader(op string) error {
+	if le.isLeader() {
+		return nil
+	}
+	return status.Errorf(codes.Unavailable, "%s: controller plugin is not the leader", op)
+}
+
+// run takes part in the leader election until the context is done,
+// the lease is released on cancel so that a standby instance can
+// take over without waiting for the lease to expire
+func (le *leaderElector) run(ctx context.Context) error {
+	identity := le.lock.Identity()
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            le.lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logrus.Infof("LeaderElection: {%v} became the leader of lease {%v}", identity, le.lock.Describe())
+				atomic.StoreInt32(&le.leader, 1)
+			},
+			OnStoppedLeading: func() {
+				// it is also called when the standby instance stops
+				if atomic.SwapInt32(&le.leader, 0) == 1 {
+					logrus.Warningf("LeaderElection: {%v} is no longer the leader of lease {%v}", identity, le.lock.Describe())
+				}
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					logrus.Infof("LeaderElection: {%v} is the leader of lease {%v}, {%v} is on standby",
+						current, le.lock.Describe(), identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("LeaderElection: {%v} is trying to acquire lease {%v}", identity, le.lock.Describe())
+	// Run returns once the leadership is lost, the instance
+	// then stands by until it acquires the lease again
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// leaderGatedController only lets the leader handle the requests
+// which modify the volumes and snapshots, the read-only requests
+// are served by all the instances
+type leaderGatedController struct {
+	csi.ControllerServer
+	elector *leaderElector
+}
+
+func newLeaderGatedController(cs csi.ControllerServer, elector *leaderElector) csi.ControllerServer {
+	return &leaderGatedController{ControllerServer: cs, elector: elector}
+}
+
+// checkLeader returns Unavailable if this instance is not the
+// leader, the sidecars retry the request which is then served
+// once the instance they are talking to is elected
+func (lc *leaderGatedController) checkLeader(op string) error {
+	return lc.elector.checkLeader(op)
+}
+
+// CreateVolume is only served by the leader
+func (lc *leaderGatedController) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if err := lc.checkLeader("CreateVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.CreateVolume(ctx, req)
+}
+
+// DeleteVolume is only served by the leader
+func (lc *leaderGatedController) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if err := lc.checkLeader("DeleteVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.DeleteVolume(ctx, req)
+}
+
+// ControllerPublishVolume is only served by the leader
+func (lc *leaderGatedController) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if err := lc.checkLeader("ControllerPublishVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.ControllerPublishVolume(ctx, req)
+}
+
+// ControllerUnpublishVolume is only served by the leader
+func (lc *leaderGatedController) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if err := lc.checkLeader("ControllerUnpublishVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.ControllerUnpublishVolume(ctx, req)
+}
+
+// ControllerExpandVolume is only served by the leader
+func (lc *leaderGatedController) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if err := lc.checkLeader("ControllerExpandVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.ControllerExpandVolume(ctx, req)
+}
+
+// ControllerModifyVolume is only served by the leader
+func (lc *leaderGatedController) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	if err := lc.checkLeader("ControllerModifyVolume"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.ControllerModifyVolume(ctx, req)
+}
+
+// CreateSnapshot is only served by the leader
+func (lc *leaderGatedController) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	if err := lc.checkLeader("CreateSnapshot"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.CreateSnapshot(ctx, req)
+}
+
+// DeleteSnapshot is only served by the leader
+func (lc *leaderGatedController) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if err := lc.checkLeader("DeleteSnapshot"); err != nil {
+		return nil, err
+	}
+	return lc.ControllerServer.DeleteSnapshot(ctx, req)
+}
+
+// leaderGatedGroupController only lets the leader create and delete
+// the group snapshots, they are fetched by all the instances
+type leaderGatedGroupController struct {
+	csi.GroupControllerServer
+	elector *leaderElector
+}
+
+func newLeaderGatedGroupController(gcs csi.GroupControllerServer, elector *leaderElector) csi.GroupControllerServer {
+	return &leaderGatedGroupController{GroupControllerServer: gcs, elector: elector}
+}
+
+// CreateVolumeGroupSnapshot is only served by the leader
+func (lc *leaderGatedGroupController) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	if err := lc.elector.checkLeader("CreateVolumeGroupSnapshot"); err != nil {
+		return nil, err
+	}
+	return lc.GroupControllerServer.CreateVolumeGroupSnapshot(ctx, req)
+}
+
+// DeleteVolumeGroupSnapshot is only served by the leader
+func (lc *leaderGatedGroupController) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	if err := lc.elector.checkLeader("DeleteVolumeGroupSnapshot"); err != nil {
+		return nil, err
+	}
+	return lc.GroupControllerServer.DeleteVolumeGroupSnapshot(ctx, req)
+}
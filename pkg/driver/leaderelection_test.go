@@ -0,0 +1,112 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/cloud-provider/volume/helpers"
+)
+
+func newFakeLeaderElector(kubeClient kubernetes.Interface, identity string) *leaderElector {
+	return newLeaderElector(&resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: "jiva-csi-controller", Namespace: "openebs"},
+		Client:     kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	})
+}
+
+// waitForLeader waits for the elector to become the leader
+func waitForLeader(t *testing.T, le *leaderElector) {
+	for i := 0; i < 100; i++ {
+		if le.isLeader() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("expected {%v} to become the leader", le.lock.Identity())
+}
+
+func TestLeaderElectorFailover(t *testing.T) {
+	defer func(lease, renew, retry time.Duration) {
+		leaseDuration, renewDeadline, retryPeriod = lease, renew, retry
+	}(leaseDuration, renewDeadline, retryPeriod)
+	leaseDuration, renewDeadline, retryPeriod = time.Second, 500*time.Millisecond, 100*time.Millisecond
+
+	kubeClient := k8sfake.NewSimpleClientset()
+	first := newFakeLeaderElector(kubeClient, "controller-0")
+	second := newFakeLeaderElector(kubeClient, "controller-1")
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		_ = first.run(firstCtx)
+	}()
+	waitForLeader(t, first)
+
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	go func() { _ = second.run(secondCtx) }()
+
+	time.Sleep(300 * time.Millisecond)
+	if second.isLeader() {
+		t.Fatal("expected controller-1 to stand by while controller-0 is the leader")
+	}
+
+	// lease is released on cancel and the standby takes over
+	cancelFirst()
+	<-firstDone
+	if first.isLeader() {
+		t.Fatal("expected controller-0 to not be the leader after it stopped")
+	}
+	waitForLeader(t, second)
+}
+
+func TestLeaderGatedController(t *testing.T) {
+	cs, _ := newFakeController(t)
+	elector := newFakeLeaderElector(k8sfake.NewSimpleClientset(), "controller-0")
+	gated := newLeaderGatedController(cs, elector)
+
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	if _, err := gated.CreateVolume(context.TODO(), req); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable on standby, got err: %v", err)
+	}
+	if _, err := gated.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable on standby, got err: %v", err)
+	}
+
+	// read-only requests are served on standby
+	if _, err := gated.ControllerGetCapabilities(context.TODO(), &csi.ControllerGetCapabilitiesRequest{}); err != nil {
+		t.Fatalf("expected capabilities on standby, got err: %v", err)
+	}
+
+	atomic.StoreInt32(&elector.leader, 1)
+	if _, err := gated.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created by the leader, got err: %v", err)
+	}
+}
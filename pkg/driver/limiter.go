@@ -0,0 +1,64 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// requestLimiter limits the number of the expensive node requests
+// i.e staging which logs into the target, served concurrently so that
+// a burst of them doesn't overwhelm iscsid. Excess requests wait for
+// a slot until their context is done.
+type requestLimiter struct {
+	slots chan struct{}
+}
+
+// newRequestLimiter returns a limiter which serves up to max requests
+// concurrently, requests are not limited if max is not positive
+func newRequestLimiter(max int) *requestLimiter {
+	if max <= 0 {
+		return &requestLimiter{}
+	}
+	return &requestLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits for a free slot, the returned func releases it. Error
+// is returned without taking a slot if ctx is done before it is free.
+func (rl *requestLimiter) acquire(ctx context.Context, op string) (func(), error) {
+	if rl.slots == nil {
+		return func() {}, nil
+	}
+
+	// request cancelled while queued is never served
+	if err := contextStatus(ctx, op); err != nil {
+		return nil, err
+	}
+
+	select {
+	case rl.slots <- struct{}{}:
+	default:
+		logrus.Infof("%s: %d requests in progress, waiting for a free slot", op, cap(rl.slots))
+		select {
+		case rl.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, contextStatus(ctx, op)
+		}
+	}
+	return func() { <-rl.slots }, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestLimiterConcurrency(t *testing.T) {
+	limiter := newRequestLimiter(3)
+
+	var inFlight, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.acquire(context.TODO(), "NodeStageVolume")
+			if err != nil {
+				t.Errorf("expected slot to be acquired, got err: %v", err)
+				return
+			}
+			defer release()
+
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+		}()
+	}
+	wg.Wait()
+
+	if max != 3 {
+		t.Fatalf("expected at most 3 requests in flight, got: %d", max)
+	}
+}
+
+func TestRequestLimiterCancelled(t *testing.T) {
+	limiter := newRequestLimiter(1)
+	release, err := limiter.acquire(context.TODO(), "NodeStageVolume")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queued request is cancelled before the slot is free
+	ctx, cancel := context.WithCancel(context.TODO())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := limiter.acquire(ctx, "NodeStageVolume"); status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got err: %v", err)
+	}
+
+	// cancelled request doesn't take the slot once it is free
+	release()
+	if _, err := limiter.acquire(ctx, "NodeStageVolume"); status.Code(err) != codes.Canceled {
+		t.Fatalf("expected Canceled, got err: %v", err)
+	}
+	if _, err := limiter.acquire(context.TODO(), "NodeStageVolume"); err != nil {
+		t.Fatalf("expected free slot to be acquired, got err: %v", err)
+	}
+}
+
+func TestNodeStageVolumeQueued(t *testing.T) {
+	ns, _, _ := newFakeNode(t, newFakeExec(nil))
+	ns.limiter = newRequestLimiter(1)
+	release, err := ns.limiter.acquire(context.TODO(), "NodeExpandVolume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// cheap requests are not limited
+	if _, err := ns.NodeGetCapabilities(context.TODO(), &csi.NodeGetCapabilitiesRequest{}); err != nil {
+		t.Fatalf("expected capabilities while the slot is taken, got err: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ns.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: "/var/lib/kubelet/plugins/staging/" + testVolumeID,
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected queued NodeUnstageVolume to time out, got err: %v", err)
+	}
+}
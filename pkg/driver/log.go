@@ -0,0 +1,73 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LogFormatText is the default logrus text format
+	LogFormatText = "text"
+	// LogFormatJSON logs each line as a json object
+	LogFormatJSON = "json"
+)
+
+// fieldsHook adds the given fields to all the log lines
+// which don't already have them set
+type fieldsHook struct {
+	fields logrus.Fields
+}
+
+func (h *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *fieldsHook) Fire(entry *logrus.Entry) error {
+	for k, v := range h.fields {
+		if _, ok := entry.Data[k]; !ok {
+			entry.Data[k] = v
+		}
+	}
+	return nil
+}
+
+// SetLogFormat sets the format of the logs and adds the plugin
+// type to all the log lines, node plugin adds its node ID as well
+// so that the lines can be correlated with the controller plugin
+// lines which have the node ID of the request
+func SetLogFormat(format, pluginType, nodeID string) error {
+	switch format {
+	case LogFormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case LogFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format {%s}, supported formats are: %s, %s", format, LogFormatText, LogFormatJSON)
+	}
+
+	fields := logrus.Fields{
+		"plugin": pluginType,
+	}
+	if pluginType == "node" {
+		fields["node_id"] = nodeID
+	}
+	logrus.AddHook(&fieldsHook{fields: fields})
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// luksSecretKey is the key in the encryption secret
+	// which holds the passphrase of the LUKS device
+	luksSecretKey = "key"
+
+	// fsTypeLUKS is the type reported by blkid
+	// for a LUKS formatted device
+	fsTypeLUKS = "crypto_LUKS"
+
+	cryptsetupCmd = "cryptsetup"
+)
+
+// luksMapperDir is the directory of the dm-crypt mappings
+var luksMapperDir = "/dev/mapper"
+
+// isEncrypted returns true if the volume
+// needs to be encrypted using LUKS
+func isEncrypted(instance *jv.JivaVolume) bool {
+	encrypt, _ := strconv.ParseBool(instance.Annotations[client.EncryptAnnotation])
+	return encrypt
+}
+
+// luksMapperPath returns the path of the
+// dm-crypt mapping of the given volume
+func luksMapperPath(instance *jv.JivaVolume) string {
+	return filepath.Join(luksMapperDir, instance.Name+"-luks")
+}
+
+// getLUKSKey returns the passphrase from the secret
+// referred in the annotations of the JivaVolume CR
+func (ns *node) getLUKSKey(instance *jv.JivaVolume) ([]byte, error) {
+	name := instance.Annotations[client.EncryptionSecretNameAnnotation]
+	namespace := instance.Annotations[client.EncryptionSecretNamespaceAnnotation]
+	if namespace == "" {
+		namespace = instance.Namespace
+	}
+
+	secret, err := ns.client.GetSecret(name, namespace)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "Failed to get encryption secret {%v/%v} of volume {%v}, err: {%v}", namespace, name, instance.Name, err)
+	}
+
+	key := secret.Data[luksSecretKey]
+	if len(key) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "Encryption secret {%v/%v} of volume {%v} doesn't have {%v}", namespace, name, instance.Name, luksSecretKey)
+	}
+	return key, nil
+}
+
+// openLUKS opens the LUKS mapping on the given device and returns
+// the path of the mapping, device without any filesystem is LUKS
+// formatted first. Device with some other filesystem is never
+// formatted to avoid losing the data on it.
+func (ns *node) openLUKS(instance *jv.JivaVolume, devicePath string) (string, error) {
+	if _, err := ns.mounter.Exec.LookPath(cryptsetupCmd); err != nil {
+		return "", status.Errorf(codes.FailedPrecondition, "Volume {%v} is encrypted but %s is not available on the node, err: {%v}", instance.Name, cryptsetupCmd, err)
+	}
+
+	mapperPath := luksMapperPath(instance)
+	if _, err := os.Stat(mapperPath); err == nil {
+		logrus.Infof("NodeStageVolume: LUKS mapping {%s} of volume {%v} is already open", mapperPath, instance.Name)
+		return mapperPath, nil
+	}
+
+	key, err := ns.getLUKSKey(instance)
+	if err != nil {
+		return "", err
+	}
+
+	fsType, err := getFsType(ns.mounter.Exec, devicePath)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to detect filesystem on device {%s}, err: {%v}", devicePath, err)
+	}
+
+	switch fsType {
+	case fsTypeLUKS:
+	case "":
+		logrus.Infof("NodeStageVolume: LUKS formatting device {%s} of volume {%v}", devicePath, instance.Name)
+		cmd := ns.mounter.Exec.Command(cryptsetupCmd, "luksFormat", "--batch-mode", "--type", "luks2", "--key-file", "-", devicePath)
+		cmd.SetStdin(bytes.NewReader(key))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", status.Errorf(codes.Internal, "Failed to LUKS format device {%s}, err: {%v}, output: {%s}", devicePath, err, string(out))
+		}
+	default:
+		return "", status.Errorf(codes.FailedPrecondition, "Device {%s} of encrypted volume {%v} has an unencrypted {%s} filesystem", devicePath, instance.Name, fsType)
+	}
+
+	logrus.Infof("NodeStageVolume: opening LUKS mapping {%s} on device {%s}", mapperPath, devicePath)
+	cmd := ns.mounter.Exec.Command(cryptsetupCmd, "luksOpen", "--key-file", "-", devicePath, filepath.Base(mapperPath))
+	cmd.SetStdin(bytes.NewReader(key))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to open LUKS device {%s}, err: {%v}, output: {%s}", devicePath, err, string(out))
+	}
+	return mapperPath, nil
+}
+
+// closeLUKS closes the LUKS mapping of the volume, it must be
+// done before logging out of the target. Missing mapping is
+// treated as success.
+func (ns *node) closeLUKS(instance *jv.JivaVolume) error {
+	mapperPath := luksMapperPath(instance)
+	if _, err := os.Stat(mapperPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	if _, err := ns.mounter.Exec.LookPath(cryptsetupCmd); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "Failed to close LUKS mapping {%s}, %s is not available on the node, err: {%v}", mapperPath, cryptsetupCmd, err)
+	}
+
+	logrus.Infof("Closing LUKS mapping {%s} of volume {%v}", mapperPath, instance.Name)
+	if out, err := ns.mounter.Exec.Command(cryptsetupCmd, "luksClose", filepath.Base(mapperPath)).CombinedOutput(); err != nil {
+		return status.Errorf(codes.Internal, "Failed to close LUKS mapping {%s}, err: {%v}, output: {%s}", mapperPath, err, string(out))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "jiva_csi"
+
+var (
+	operationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Total number of CSI operations by method and grpc status code",
+		},
+		[]string{"method", "grpc_code"},
+	)
+
+	operationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of CSI operations by method and grpc status code",
+			// volume operations wait for iSCSI login, mkfs
+			// and replicas to be ready, so the buckets go up
+			// to a few minutes
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"method", "grpc_code"},
+	)
+)
+
+// metricsInterceptor records the count and the duration
+// of every grpc call labeled by the method name and the
+// grpc status code
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	method := path.Base(info.FullMethod)
+	code := status.Code(err).String()
+	operationsTotal.WithLabelValues(method, code).Inc()
+	operationDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// newVolumesGauge returns a gauge which reports the number of
+// JivaVolume CRs managed by this driver instance, controller
+// plugin manages all the volumes whereas node plugin manages
+// the volumes staged on its node
+func newVolumesGauge(cli *client.Client, pluginType, nodeID string) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "volumes",
+			Help:      "Number of JivaVolumes managed by this driver instance",
+		},
+		func() float64 {
+			if err := cli.Set(); err != nil {
+				logrus.Errorf("Metrics: failed to set client, err: {%v}", err)
+				return 0
+			}
+
+			labels := map[string]string{}
+			if pluginType == "node" {
+				labels["nodeID"] = nodeID
+			}
+
+			volumes, err := cli.ListJivaVolumeWithOpts(labels)
+			if err != nil {
+				logrus.Errorf("Metrics: failed to list JivaVolumes, err: {%v}", err)
+				return 0
+			}
+			return float64(len(volumes.Items))
+		},
+	)
+}
+
+// serveMetrics registers the collectors and serves
+// the prometheus metrics at the given address
+func serveMetrics(addr string, cli *client.Client, pluginType, nodeID string) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		operationsTotal,
+		operationDuration,
+		newVolumesGauge(cli, pluginType, nodeID),
+	)
+	if pluginType == "node" {
+		registry.MustRegister(iscsiSessionUp)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	logrus.Infof("Serving metrics on address: %v", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logrus.Errorf("Failed to serve metrics, err: {%v}", err)
+	}
+}
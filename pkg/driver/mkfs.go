@@ -0,0 +1,122 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mkfsFlags are the flags of mkfs which can be set via mkfsOptionsKey
+// for each of the supported fsTypes along with whether they take a
+// value. Flags which change the behaviour of the format itself i.e
+// force or dry run are not allowed, the label of the filesystem is
+// set via fsLabelKey instead.
+var mkfsFlags = map[string]map[string]bool{
+	FSTypeExt2: extMkfsFlags,
+	FSTypeExt3: extMkfsFlags,
+	FSTypeExt4: extMkfsFlags,
+	FSTypeXfs: {
+		"-b": true, "-d": true, "-i": true, "-l": true,
+		"-m": true, "-n": true, "-r": true, "-s": true,
+		"-K": false,
+	},
+}
+
+// extMkfsFlags are the allowed flags of mkfs.ext2, mkfs.ext3 and mkfs.ext4
+var extMkfsFlags = map[string]bool{
+	"-b": true, "-C": true, "-E": true, "-g": true, "-G": true,
+	"-i": true, "-I": true, "-J": true, "-m": true, "-N": true,
+	"-O": true, "-T": true, "-j": false,
+}
+
+// parseMkfsOptions splits the mkfs options set in the StorageClass
+// i.e "-d su=64k,sw=4 -m reflink=1" into the arguments of the mkfs
+// command of the given fsType, an error is returned if a flag is not
+// supported by the fsType or a value is missing
+func parseMkfsOptions(fsType, options string) ([]string, error) {
+	flags, ok := mkfsFlags[fsType]
+	if !ok {
+		return nil, fmt.Errorf("mkfs options are not supported for fsType {%s}", fsType)
+	}
+
+	args := strings.Fields(options)
+	for i := 0; i < len(args); i++ {
+		hasValue, ok := flags[args[i]]
+		if !ok {
+			return nil, fmt.Errorf("mkfs option {%s} is not supported for fsType {%s}", args[i], fsType)
+		}
+		if !hasValue {
+			continue
+		}
+		if i+1 == len(args) || strings.HasPrefix(args[i+1], "-") {
+			return nil, fmt.Errorf("mkfs option {%s} of fsType {%s} requires a value", args[i], fsType)
+		}
+		i++
+	}
+	return args, nil
+}
+
+// isMkfsOptions validates the mkfs options set in the StorageClass,
+// fsType is only known on the node so the options must be supported
+// by at least one of the fsTypes
+func isMkfsOptions(val string) error {
+	for _, fsType := range ValidFSTypes {
+		if _, err := parseMkfsOptions(fsType, val); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be mkfs options supported by one of the fsTypes %v", ValidFSTypes)
+}
+
+// fsLabelMaxLength is the max length of the filesystem label which
+// can be set via fsLabelKey for each of the supported fsTypes
+var fsLabelMaxLength = map[string]int{
+	FSTypeExt2: 16,
+	FSTypeExt3: 16,
+	FSTypeExt4: 16,
+	FSTypeXfs:  12,
+}
+
+// fsLabelArgs returns the arguments of the mkfs command which set
+// the label of the filesystem, an error is returned if the label is
+// longer than the max length allowed by the fsType
+func fsLabelArgs(fsType, label string) ([]string, error) {
+	max, ok := fsLabelMaxLength[fsType]
+	if !ok {
+		return nil, fmt.Errorf("filesystem label is not supported for fsType {%s}", fsType)
+	}
+	if label == "" || strings.ContainsAny(label, " \t\n") {
+		return nil, fmt.Errorf("filesystem label must be non-empty and must not contain whitespace")
+	}
+	if len(label) > max {
+		return nil, fmt.Errorf("filesystem label must be at most %d characters for fsType {%s}", max, fsType)
+	}
+	return []string{"-L", label}, nil
+}
+
+// isFSLabel validates the filesystem label set in the StorageClass,
+// fsType is only known on the node so the label must be allowed by
+// at least one of the fsTypes, the length is checked again on format
+func isFSLabel(val string) error {
+	for _, fsType := range ValidFSTypes {
+		if _, err := fsLabelArgs(fsType, val); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be a filesystem label without whitespace of at most %d characters", fsLabelMaxLength[FSTypeExt4])
+}
@@ -21,6 +21,8 @@ import (
 	"net"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
 	"github.com/openebs/jiva-csi/pkg/request"
 	"github.com/openebs/jiva-csi/pkg/utils"
@@ -36,9 +38,9 @@ import (
 )
 
 const (
-	// MonitorMountRetryTimeout indicates the time gap between two consecutive
-	//monitoring attempts
-	MonitorMountRetryTimeout = 5
+	// DefaultMountMonitorInterval indicates the time gap between two
+	// consecutive monitoring attempts if it is not configured
+	DefaultMountMonitorInterval = 5 * time.Second
 )
 
 type Optfunc func(*NodeMounter)
@@ -48,6 +50,9 @@ type NodeMounter struct {
 	mount.SafeFormatAndMount
 	client *client.Client
 	nodeID string
+	// monitorInterval is the time gap between two
+	// consecutive monitoring attempts
+	monitorInterval time.Duration
 }
 
 func newNodeMounter() *NodeMounter {
@@ -69,6 +74,12 @@ func withNodeID(nodeID string) Optfunc {
 	}
 }
 
+func withMonitorInterval(interval time.Duration) Optfunc {
+	return func(n *NodeMounter) {
+		n.monitorInterval = interval
+	}
+}
+
 func newNodeMounterWithOpts(opts ...Optfunc) *NodeMounter {
 	nm := newNodeMounter()
 	for _, o := range opts {
@@ -119,11 +130,30 @@ func isVolumeReachable(targetPortal string) bool {
 	return false
 }
 
-func waitForVolumeToBeReady(volID string, cli *client.Client) (*jv.JivaVolume, error) {
+// sleepWithContext waits for the given duration, ctx.Err() is
+// returned if ctx is cancelled or times out before that
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func waitForVolumeToBeReady(ctx context.Context, volID string, cli *client.Client) (*jv.JivaVolume, error) {
 	var retry int
 	var sleepInterval time.Duration = 0
 	for {
-		time.Sleep(sleepInterval * time.Second)
+		if err := sleepWithContext(ctx, sleepInterval*time.Second); err != nil {
+			return nil, err
+		}
 		instance, err := doesVolumeExist(volID, cli)
 		if err != nil {
 			return nil, err
@@ -152,7 +182,7 @@ func waitForVolumeToBeReady(volID string, cli *client.Client) (*jv.JivaVolume, e
 	return nil, fmt.Errorf("Max retry count exceeded, volume: {%v} is not ready", volID)
 }
 
-func waitForVolumeToBeReachable(targetPortal string) error {
+func waitForVolumeToBeReachable(ctx context.Context, targetPortal string) error {
 	var (
 		retries int
 		err     error
@@ -169,7 +199,9 @@ func waitForVolumeToBeReachable(targetPortal string) error {
 		// wait until the iSCSI targetPortal is reachable
 		// There is no pointn of triggering iSCSIadm login commands
 		// until the portal is reachable
-		time.Sleep(2 * time.Second)
+		if err := sleepWithContext(ctx, 2*time.Second); err != nil {
+			return err
+		}
 		retries++
 		if retries >= MaxRetryCount {
 			// Let the caller function decide further if the volume is
@@ -199,7 +231,7 @@ func listContains(
 // with the driver are mounted with the original mount options
 // This function runs a never ending loop therefore should be run as a goroutine
 // Mounted list is fetched from the OS and the state of all the volumes is
-// reverified after every 5 seconds. If the mountpoint is not present in the
+// reverified after every monitor interval. If the mountpoint is not present in the
 // list or if it has been remounted with a different mount option by the OS, the
 // volume is added to the ReqMountList which is removed as soon as the remount
 // operation on the volume is complete
@@ -212,7 +244,11 @@ func (n *NodeMounter) MonitorMounts() {
 		csivolList *jv.JivaVolumeList
 		mountList  []mount.MountPoint
 	)
-	ticker := time.NewTicker(MonitorMountRetryTimeout * time.Second)
+	interval := n.monitorInterval
+	if interval == 0 {
+		interval = DefaultMountMonitorInterval
+	}
+	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
@@ -244,6 +280,11 @@ func (n *NodeMounter) MonitorMounts() {
 					vol.Spec.MountInfo.TargetPath == "" {
 					continue
 				}
+				// raw block volumes don't have any filesystem
+				// mounted on the staging path to be verified
+				if isBlockVolume(&vol) {
+					continue
+				}
 				// Search the volume in the list of mounted volumes at the node
 				// retrieved above
 				stagingMountPoint, stagingPathExists := listContains(
@@ -0,0 +1,88 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"k8s.io/utils/mount"
+)
+
+// mountRetryInterval is the initial wait between two consecutive
+// mount attempts on transient errors, it is doubled after each retry
+var mountRetryInterval = time.Second
+
+// transientMountErrors are the errors reported by mount(8) for EIO
+// and ENODEV, i.e while the jiva target fails over, the mount binary
+// only reports them in its output
+var transientMountErrors = []string{
+	"input/output error",
+	"no such device",
+}
+
+// isTransientMountError returns true if the mount failed with EIO or
+// ENODEV, which go away once the target is back. Other errors i.e
+// EINVAL for a bad fsType or mount option are permanent.
+func isTransientMountError(err error) bool {
+	if errors.Is(err, syscall.EIO) || errors.Is(err, syscall.ENODEV) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, e := range transientMountErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBusyUnmountError returns true if the unmount failed as the mount
+// is still in use, umount(8) only reports EBUSY in its output
+func isBusyUnmountError(err error) bool {
+	if errors.Is(err, syscall.EBUSY) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "target is busy") || strings.Contains(msg, "device is busy")
+}
+
+// mountWithRetry mounts the source at the target, mount is attempted
+// up to maxAttempts times with exponential backoff between the attempts
+// as long as it fails with a transient error. Retries are aborted once
+// ctx is done.
+func mountWithRetry(ctx context.Context, mounter mount.Interface, maxAttempts int, source, target, fsType string, options []string) error {
+	var err error
+	interval := mountRetryInterval
+	for attempt := 1; ; attempt++ {
+		err = mounter.Mount(source, target, fsType, options)
+		if err == nil || attempt >= maxAttempts || !isTransientMountError(err) {
+			return err
+		}
+
+		logrus.Warningf("mount of {%s} at {%s} failed, retrying in %v (attempt %d/%d), err: {%v}",
+			source, target, interval, attempt, maxAttempts, err)
+		if sleepErr := sleepWithContext(ctx, interval); sleepErr != nil {
+			return err
+		}
+		interval *= 2
+	}
+}
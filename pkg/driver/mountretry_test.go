@@ -0,0 +1,147 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/utils/mount"
+)
+
+// flakyMounter fails the first failures mounts with err
+type flakyMounter struct {
+	*mount.FakeMounter
+	err      error
+	failures int
+	calls    int
+}
+
+func (m *flakyMounter) Mount(source, target, fstype string, options []string) error {
+	m.calls++
+	if m.calls <= m.failures {
+		return m.err
+	}
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+func TestIsTransientMountError(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		transient bool
+	}{
+		"EIO":    {err: syscall.EIO, transient: true},
+		"ENODEV": {err: fmt.Errorf("mount failed: %w", syscall.ENODEV), transient: true},
+		"EIO reported by mount": {
+			err:       errors.New("mount failed: exit status 32\nOutput: mount: /mnt: mount(2) system call failed: Input/output error."),
+			transient: true,
+		},
+		"EINVAL": {err: syscall.EINVAL},
+		"bad fsType": {
+			err: errors.New("mount failed: exit status 32\nOutput: mount: /mnt: wrong fs type, bad option, bad superblock on /dev/sdb."),
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if transient := isTransientMountError(test.err); transient != test.transient {
+				t.Fatalf("expected transient %v, got: %v", test.transient, transient)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeMountRetry(t *testing.T) {
+	defer func(interval time.Duration) { mountRetryInterval = interval }(mountRetryInterval)
+	mountRetryInterval = time.Millisecond
+
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+
+	tests := map[string]struct {
+		err           error
+		failures      int
+		expectedCalls int
+		fail          bool
+	}{
+		"transient EIO is retried": {
+			err:           syscall.EIO,
+			failures:      2,
+			expectedCalls: 3,
+		},
+		"attempts are exhausted": {
+			err:           syscall.EIO,
+			failures:      3,
+			expectedCalls: 3,
+			fail:          true,
+		},
+		"invalid fsType fails immediately": {
+			err:           syscall.EINVAL,
+			failures:      1,
+			expectedCalls: 1,
+			fail:          true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "node-publish")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var cmds [][]string
+			ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds, success), newTestJivaVolume())
+			ns.driver.config.MountMaxAttempts = 3
+			mounter := &flakyMounter{FakeMounter: fakeMounter, err: test.err, failures: test.failures}
+			ns.mounter.Interface = mounter
+
+			target := filepath.Join(dir, "mount")
+			_, err = ns.NodePublishVolume(context.TODO(), newNodePublishVolumeRequest(target, volCap, false))
+			if test.fail {
+				if status.Code(err) != codes.Internal {
+					t.Fatalf("expected Internal, got err: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected volume to be published, got err: %v", err)
+			}
+			if mounter.calls != test.expectedCalls {
+				t.Fatalf("expected %d mount attempts, got: %d", test.expectedCalls, mounter.calls)
+			}
+			if mounted := len(fakeMounter.MountPoints) == 1; mounted == test.fail {
+				t.Fatalf("expected mounted %v, got mount points: %v", !test.fail, fakeMounter.MountPoints)
+			}
+		})
+	}
+}
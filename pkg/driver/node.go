@@ -18,7 +18,10 @@ package driver
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -31,6 +34,7 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -48,11 +52,92 @@ const (
 
 	defaultISCSILUN       = int32(0)
 	defaultISCSIInterface = "default"
+
+	// accessTypeAnnotation is set on the JivaVolume CR while staging
+	// the volume, it records whether the volume is consumed as a raw
+	// block device or as a mounted filesystem
+	accessTypeAnnotation = "openebs.io/access-type"
+	accessTypeBlock      = "block"
+	accessTypeMount      = "mount"
+
+	// mountPropagationKey is passed in the volume context from the
+	// StorageClass parameters, it sets the propagation of the bind
+	// mount done in NodePublishVolume
+	mountPropagationKey = "mountPropagation"
+	propagationPrivate  = "rprivate"
+	propagationSlave    = "rslave"
+	propagationShared   = "rshared"
+
+	// reservedBlocksKey is passed in the volume context from the
+	// StorageClass parameters, it sets the percentage of the blocks
+	// reserved for the super user i.e mkfs.ext4 -m, while formatting
+	// the ext filesystems
+	reservedBlocksKey           = "reservedBlocksPercentage"
+	maxReservedBlocksPercentage = 50
+
+	// skipFormatKey is passed in the volume context from the
+	// StorageClass parameters, if it is true the device is not
+	// formatted and it must already have a filesystem. It
+	// overrides the --skip-format flag of the node plugin.
+	skipFormatKey = "skipFormat"
+
+	// mkfsOptionsKey is passed in the volume context from the
+	// StorageClass parameters, the options are appended to the
+	// mkfs command while the volume is formatted for the first
+	// time i.e "-d su=64k,sw=4" for xfs or "-E stride=16" for ext4
+	mkfsOptionsKey = "mkfsOptions"
+
+	// fsLabelKey is passed in the volume context from the StorageClass
+	// parameters, it sets the label of the filesystem i.e mkfs -L while
+	// the volume is formatted for the first time
+	fsLabelKey = "fsLabel"
+
+	// ownerUIDKey and ownerGIDKey are passed in the volume context
+	// from the StorageClass parameters, the root directory of the
+	// filesystem is owned by them once it is mounted so that the
+	// pods running as a fixed non-root user can write to it
+	ownerUIDKey = "ownerUID"
+	ownerGIDKey = "ownerGID"
+
+	// seLinuxContextOption is the prefix of the mount option with
+	// the SELinux context of the pod, kubelet sets it in the mount
+	// flags if seLinuxMount is enabled in the CSIDriver object
+	seLinuxContextOption = "context="
+
+	// StagingPathLayoutDefault mounts the volume directly at the
+	// staging path passed by kubelet, StagingPathLayoutDriverVolume
+	// mounts it at <staging path>/<driver name>/<volume ID>
+	StagingPathLayoutDefault      = "default"
+	StagingPathLayoutDriverVolume = "driver-volume"
+
+	// DefaultMaxVolumesPerNode is the default number of volumes which
+	// can be attached to a node, every volume takes an iSCSI session
+	// and a SCSI disk on the node which are all logged in again by
+	// iscsid and probed by udev when the node restarts
+	DefaultMaxVolumesPerNode = 128
 )
 
 var (
 	// ValidFSTypes is the supported filesystem by the jiva-csi driver
 	ValidFSTypes = []string{FSTypeExt2, FSTypeExt3, FSTypeExt4, FSTypeXfs}
+	// ValidDefaultFSTypes is the list of filesystems which can be set
+	// as the default filesystem of the node plugin
+	ValidDefaultFSTypes = []string{FSTypeExt4, FSTypeXfs}
+	// ValidStagingPathLayouts is the list of the layouts
+	// of the path where the volumes are staged
+	ValidStagingPathLayouts = []string{StagingPathLayoutDefault, StagingPathLayoutDriverVolume}
+	// DefaultAllowedMountOptions is the list of the mount options which
+	// can be set in the StorageClass, options with a value i.e
+	// errors=remount-ro are matched by their name. Options which weaken
+	// the security of the mount i.e suid, dev or exec are not allowed
+	// unless they are added via --allowed-mount-options.
+	DefaultAllowedMountOptions = []string{
+		"defaults", "ro", "rw", "sync", "async", "dirsync",
+		"noatime", "nodiratime", "relatime", "strictatime", "lazytime",
+		"nosuid", "nodev", "noexec", "discard", "nodiscard", "_netdev",
+		"errors", "commit", "context",
+		"nfsvers", "hard", "soft", "timeo", "retrans",
+	}
 	// MaxRetryCount is the retry count to check if volume is ready during
 	// nodeStage RPC call
 	MaxRetryCount int
@@ -64,6 +149,8 @@ var (
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 )
 
@@ -71,6 +158,7 @@ type nodeStageRequest struct {
 	stagingPath string
 	fsType      string
 	volumeID    string
+	isBlock     bool
 }
 
 // node is the server implementation
@@ -79,30 +167,69 @@ type node struct {
 	client  *client.Client
 	driver  *CSIDriver
 	mounter *NodeMounter
+
+	// replicaStatus caches the replicas reported by
+	// the jiva targets for the volume condition
+	replicaStatus *replicaStatusCache
+
+	// delayedLogouts tracks the iSCSI logouts
+	// deferred by NodeUnstageVolume
+	delayedLogouts *delayedLogouts
+
+	// usageAlerts debounces the events recorded when
+	// the volumes are above the usage alert threshold
+	usageAlerts *usageAlerts
+
+	// limiter limits the concurrent stage, unstage
+	// and expand requests which talk to iscsid
+	limiter *requestLimiter
 }
 
 // NewNode returns a new instance
 // of CSI NodeServer
 func NewNode(d *CSIDriver, cli *client.Client) *node {
 	return &node{
-		client:  cli,
-		driver:  d,
-		mounter: newNodeMounter(),
+		client:         cli,
+		driver:         d,
+		mounter:        newNodeMounter(),
+		replicaStatus:  newReplicaStatusCache(d.jivaTLS),
+		delayedLogouts: newDelayedLogouts(),
+		usageAlerts:    newUsageAlerts(),
+		limiter:        newRequestLimiter(d.config.MaxConcurrentNodeOperations),
 	}
 }
 
-func (ns *node) attachDisk(instance *jv.JivaVolume) (string, error) {
+func (ns *node) attachDisk(ctx context.Context, instance *jv.JivaVolume, portal string) (string, error) {
 	connector := iscsi.Connector{
 		VolumeName:    instance.Name,
 		TargetIqn:     instance.Spec.ISCSISpec.Iqn,
 		Lun:           defaultISCSILUN,
 		Interface:     defaultISCSIInterface,
-		TargetPortals: []string{fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort)},
+		TargetPortals: []string{portal},
 		DoDiscovery:   true,
 	}
 
+	// sessions are established with the initiator name and over
+	// the network interface set via flags, the default iface of
+	// the node is used otherwise
+	name, netIface := ns.driver.config.ISCSIInitiatorName, ns.driver.config.ISCSIInterface
+	if name != "" || netIface != "" {
+		iface, err := setupInitiatorIface(ns.mounter.Exec, name, netIface)
+		if err != nil {
+			return "", err
+		}
+		connector.Interface = iface
+	}
+
 	logrus.Debugf("NodeStageVolume: attach disk with config: {%+v}", connector)
-	devicePath, err := iscsi.Connect(connector)
+	devicePath, err := iscsiLogin(
+		ctx,
+		ns.mounter.Exec,
+		connector,
+		ns.driver.config.ISCSILoginTimeout,
+		ns.driver.config.ISCSILoginRetries,
+		ns.driver.config.DeviceScanTimeout,
+	)
 	if err != nil {
 		return "", err
 	}
@@ -120,37 +247,122 @@ func (ns *node) validateStagingReq(req *csi.NodeStageVolumeRequest) (nodeStageRe
 	}
 
 	volID := utils.StripName(volumeID)
+	if err := validatePublishContext(volumeID, req.GetPublishContext()); err != nil {
+		return nodeStageRequest{}, err
+	}
+
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume capability not provided")
 	}
 
-	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}, ns.driver.config.EnableRWX) {
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
-	mount := volCap.GetMount()
-	if mount == nil {
+	var (
+		fsType  string
+		isBlock bool
+	)
+	switch volCap.GetAccessType().(type) {
+	case *csi.VolumeCapability_Block:
+		// raw block volumes are neither formatted
+		// nor mounted on the staging path
+		isBlock = true
+	case *csi.VolumeCapability_Mount:
+		fsType = volCap.GetMount().GetFsType()
+		if len(fsType) == 0 {
+			fsType = ns.defaultFSType()
+		}
+		if !isValidFSType(fsType) {
+			return nodeStageRequest{}, status.Errorf(codes.InvalidArgument, "NodeStageVolume: fsType {%s} not supported, supported fsTypes are: %v", fsType, ValidFSTypes)
+		}
+		if err := ns.validateMountOptions(volCap.GetMount().GetMountFlags()); err != nil {
+			return nodeStageRequest{}, err
+		}
+	default:
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "NodeStageVolume: mount is nil within volume capability")
 	}
 
-	fsType := mount.GetFsType()
-	if len(fsType) == 0 {
-		fsType = defaultFsType
-	}
-
-	stagingPath := req.GetStagingTargetPath()
-	if len(stagingPath) == 0 {
+	if len(req.GetStagingTargetPath()) == 0 {
 		return nodeStageRequest{}, status.Error(codes.InvalidArgument, "staging path is empty")
 	}
+	stagingPath := ns.stagingPath(req.GetStagingTargetPath(), volumeID, isBlock)
 
 	return nodeStageRequest{
 		volumeID:    volID,
 		fsType:      fsType,
 		stagingPath: stagingPath,
+		isBlock:     isBlock,
 	}, nil
 }
 
+// defaultFSType returns the filesystem used to format the volume
+// if fsType is not set in the volume capability
+func (ns *node) defaultFSType() string {
+	if ns.driver.config.DefaultFSType != "" {
+		return ns.driver.config.DefaultFSType
+	}
+	return defaultFsType
+}
+
+// IsValidStagingPathLayout returns true if the given
+// layout of the staging path is supported
+func IsValidStagingPathLayout(layout string) bool {
+	for _, l := range ValidStagingPathLayouts {
+		if l == layout {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingPath returns the path where the volume is staged for the
+// staging target path passed by kubelet, all the node RPCs derive the
+// staging path from it so that they agree on the layout
+func (ns *node) stagingPath(stagingTargetPath, volumeID string, isBlock bool) string {
+	return buildStagingPath(ns.driver.config.StagingPathLayout, ns.driver.config.DriverName,
+		stagingTargetPath, volumeID, isBlock)
+}
+
+// buildStagingPath returns the staging path of the volume as per the
+// layout. Raw block volumes are not mounted on the staging path, so
+// the staging target path is used as is for them.
+func buildStagingPath(layout, driverName, stagingTargetPath, volumeID string, isBlock bool) string {
+	if stagingTargetPath == "" || isBlock || layout != StagingPathLayoutDriverVolume {
+		return stagingTargetPath
+	}
+	return filepath.Join(stagingTargetPath, driverName, utils.StripName(volumeID))
+}
+
+// IsValidDefaultFSType returns true if the given filesystem
+// can be set as the default filesystem of the node plugin
+func IsValidDefaultFSType(fsType string) bool {
+	for _, t := range ValidDefaultFSTypes {
+		if t == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidFSType returns true if the given filesystem
+// is supported by the driver
+func isValidFSType(fsType string) bool {
+	for _, t := range ValidFSTypes {
+		if t == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlockVolume returns true if the volume has been
+// staged as a raw block device
+func isBlockVolume(instance *jv.JivaVolume) bool {
+	return instance.Annotations[accessTypeAnnotation] == accessTypeBlock
+}
+
 // NodeStageVolume mounts the volume on the staging
 // path
 //
@@ -160,6 +372,10 @@ func (ns *node) NodeStageVolume(
 	req *csi.NodeStageVolumeRequest,
 ) (*csi.NodeStageVolumeResponse, error) {
 
+	if req.GetVolumeContext()[nfsServerKey] != "" {
+		return ns.nodeStageRWX(req)
+	}
+
 	reqParam, err := ns.validateStagingReq(req)
 	if err != nil {
 		return nil, err
@@ -172,10 +388,25 @@ func (ns *node) NodeStageVolume(
 
 	defer request.RemoveVolumeFromTransitionList(reqParam.volumeID)
 
+	release, err := ns.limiter.acquire(ctx, "NodeStageVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// session kept by the delayed logout of NodeUnstageVolume
+	// is reused by the login below
+	if ns.delayedLogouts.cancel(reqParam.volumeID) {
+		logrus.Infof("NodeStageVolume: delayed logout of volume {%q} is cancelled, existing session is reused", reqParam.volumeID)
+	}
+
 	// Check if volume is ready to serve IOs,
 	// info is fetched from the JivaVolume CR
-	instance, err := waitForVolumeToBeReady(reqParam.volumeID, ns.client)
+	instance, err := waitForVolumeToBeReady(ctx, reqParam.volumeID, ns.client)
 	if err != nil {
+		if ctxErr := contextStatus(ctx, "NodeStageVolume"); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
 
@@ -184,28 +415,97 @@ func (ns *node) NodeStageVolume(
 		return nil, err
 	}
 
+	// DNS name of the target service is passed to iscsiadm as is,
+	// it is only verified that the name resolves
+	portal := targetPortal(instance, ns.driver.config.ClusterDomain)
+	if usesDNSPortal(instance) {
+		if p := req.GetPublishContext()[publishContextPortal]; p != "" {
+			portal = p
+		}
+		if err := resolvePortal(portal); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+
 	// A temporary TCP connection is made to the volume to check if its
 	// reachable
-	if err := waitForVolumeToBeReachable(
-		fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP,
-			instance.Spec.ISCSISpec.TargetPort),
-	); err != nil {
+	if err := waitForVolumeToBeReachable(ctx, portal); err != nil {
+		if ctxErr := contextStatus(ctx, "NodeStageVolume"); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil,
 			status.Error(codes.FailedPrecondition, err.Error())
 	}
 
-	devicePath, err := ns.attachDisk(instance)
+	// NodeStageVolume is invoked again for a staged volume i.e after
+	// the jiva target is rescheduled with a new portal, the session to
+	// the old portal is replaced by a login to the new one below. The
+	// DNS portal doesn't change on failover of the target.
+	if !usesDNSPortal(instance) {
+		if err := ns.logoutStalePortals(instance); err != nil {
+			logrus.Errorf("NodeStageVolume: failed to logout of stale sessions of volume: {%v}, err: {%v}", reqParam.volumeID, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	// session of a volume which is already staged on this
+	// node is in use, so it is not rolled back on cancellation
+	staged := instance.Labels["nodeID"] == ns.driver.config.NodeID && instance.Spec.MountInfo.StagingPath != ""
+	devicePath, err := ns.attachDisk(ctx, instance, portal)
 	if err != nil {
+		if ctxErr := contextStatus(ctx, "NodeStageVolume"); ctxErr != nil {
+			if !staged {
+				ns.rollbackAttach(instance, portal, "")
+			}
+			return nil, ctxErr
+		}
 		logrus.Errorf("NodeStageVolume: failed to attachDisk for volume: {%v}, err: {%v}", reqParam.volumeID, err)
+		ns.driver.recordVolumeEvent(ns.client, req.GetVolumeId(), reasonAttachFailed,
+			fmt.Sprintf("Failed to attach volume on node {%v}, err: {%v}", ns.driver.config.NodeID, err))
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// request may be cancelled during the login, i.e on shutdown
+	// of the plugin, kubelet retries the staging afterwards
+	if ctxErr := contextStatus(ctx, "NodeStageVolume"); ctxErr != nil {
+		if !staged {
+			ns.rollbackAttach(instance, portal, devicePath)
+		}
+		return nil, ctxErr
+	}
+	ns.driver.notifyWebhook(webhookEventAttached, req.GetVolumeId(), ns.driver.config.NodeID,
+		fmt.Sprintf("volume is attached as device {%v}", devicePath))
+
 	// JivaVolume CR may be updated by jiva-operator
 	instance, err = ns.client.GetJivaVolume(reqParam.volumeID)
 	if err != nil {
 		return nil, err
 	}
 
+	limits, err := getQoSLimits(instance)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := applyQoSLimits(devicePath, limits); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// filesystem is created on the LUKS mapping of an
+	// encrypted volume instead of the iSCSI device
+	if isEncrypted(instance) {
+		if devicePath, err = ns.openLUKS(instance, devicePath); err != nil {
+			return nil, err
+		}
+	}
+
+	accessType := accessTypeMount
+	if reqParam.isBlock {
+		accessType = accessTypeBlock
+	}
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+	instance.Annotations[accessTypeAnnotation] = accessType
 	instance.Spec.MountInfo.FSType = reqParam.fsType
 	instance.Spec.MountInfo.DevicePath = devicePath
 	instance.Spec.MountInfo.StagingPath = reqParam.stagingPath
@@ -214,19 +514,58 @@ func (ns *node) NodeStageVolume(
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// Device will be bind mounted directly at the
+	// target path in NodePublishVolume
+	if reqParam.isBlock {
+		if err := ns.resizeStagedVolume(instance, reqParam.stagingPath, portal, true); err != nil {
+			return nil, err
+		}
+		logrus.Infof("NodeStageVolume: volume: {%v} is staged as block device: {%v}", reqParam.volumeID, devicePath)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
 	if err := os.MkdirAll(reqParam.stagingPath, 0750); err != nil {
 		logrus.Errorf("Failed to mkdir %s, error: %v", reqParam.stagingPath, err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	if err := ns.resizeUnmountedVolume(instance, instance.Spec.MountInfo.DevicePath, reqParam.stagingPath, portal); err != nil {
+		return nil, err
+	}
+
 	logrus.Infof("NodeStageVolume: start format and mount operation on volume: {%v}", reqParam.volumeID)
-	if err := ns.formatAndMount(req, instance.Spec.MountInfo.DevicePath); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := ns.formatAndMount(req, instance.Spec.MountInfo.DevicePath, reqParam.fsType); err != nil {
+		return nil, err
+	}
+
+	if err := ns.resizeStagedVolume(instance, reqParam.stagingPath, portal, false); err != nil {
+		return nil, err
+	}
+
+	if err := setMountOwner(reqParam.stagingPath, req.GetVolumeContext()); err != nil {
+		return nil, err
+	}
+
+	// kubelet passes the fsGroup of the pod as volume mount
+	// group only if VOLUME_MOUNT_GROUP capability is advertised
+	if group := req.GetVolumeCapability().GetMount().GetVolumeMountGroup(); ns.driver.config.EnableVolumeMountGroup && group != "" {
+		if err := setVolumeOwnership(reqParam.stagingPath, group); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 	}
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// rollbackAttach logs out of the target of a volume whose staging is
+// cancelled, so that a half configured session is not left behind
+func (ns *node) rollbackAttach(instance *jv.JivaVolume, portal, devicePath string) {
+	logrus.Warningf("NodeStageVolume: staging of volume: {%v} is cancelled, logging out of target: {%v}", instance.Name, portal)
+	if err := iscsiLogout(ns.mounter.Exec, instance.Spec.ISCSISpec.Iqn, []string{portal}, devicePath); err != nil {
+		logrus.Errorf("NodeStageVolume: failed to logout of target of volume: {%v}, err: {%v}", instance.Name, err)
+	}
+}
+
 func (ns *node) doesVolumeExist(volID string) (*jv.JivaVolume, error) {
 	volID = utils.StripName(volID)
 	if err := ns.client.Set(); err != nil {
@@ -261,6 +600,9 @@ func (ns *node) NodeUnstageVolume(
 		return nil, status.Error(codes.InvalidArgument, "Staging target not provided")
 	}
 
+	// raw block volumes are never mounted on the staging path
+	target = ns.stagingPath(target, volID, false)
+
 	logrus.Infof("NodeUnstageVolume: start unstaging volume: {%q}", volID)
 	if err := request.AddVolumeToTransitionList(volID, "NodeUnStageVolume"); err != nil {
 		return nil, status.Error(codes.Aborted, err.Error())
@@ -268,6 +610,12 @@ func (ns *node) NodeUnstageVolume(
 
 	defer request.RemoveVolumeFromTransitionList(volID)
 
+	release, err := ns.limiter.acquire(ctx, "NodeUnstageVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	// Check if target directory is a mount point. GetDeviceNameFromMount
 	// given a mnt point, finds the device from /proc/mounts
 	// returns the device name, reference count, and error code
@@ -277,40 +625,78 @@ func (ns *node) NodeUnstageVolume(
 		return nil, status.Error(codes.Internal, msg)
 	}
 
-	// From the spec: If the volume corresponding to the volume_id
-	// is not staged to the staging_target_path, the Plugin MUST
-	// reply 0 OK.
-	if refCount == 0 {
-		logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
-		return &csi.NodeUnstageVolumeResponse{}, nil
-	}
-
 	if refCount > 1 {
 		logrus.Warningf("NodeUnstageVolume: found %d references to device %s mounted at target path %s", refCount, dev, target)
 	}
 
-	logrus.Debugf("NodeUnstageVolume: unmounting %s", target)
-	err = ns.mounter.Unmount(target)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not unmount target %q: %v", target, err)
+	if refCount > 0 {
+		logrus.Debugf("NodeUnstageVolume: unmounting %s", target)
+		err = ns.mounter.Unmount(target)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Could not unmount target %q: %v", target, err)
+		}
 	}
 
 	instance, err := doesVolumeExist(volID, ns.client)
 	if err != nil {
+		if refCount == 0 && status.Code(err) == codes.NotFound {
+			logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
+			return &csi.NodeUnstageVolumeResponse{}, nil
+		}
 		return nil, err
 	}
 
-	tgtIP := instance.Spec.ISCSISpec.TargetIP
-	logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}", tgtIP)
-	if err := iscsi.Disconnect(instance.Spec.ISCSISpec.Iqn, []string{fmt.Sprintf("%v:%v",
-		tgtIP, instance.Spec.ISCSISpec.TargetPort)}); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// From the spec: If the volume corresponding to the volume_id
+	// is not staged to the staging_target_path, the Plugin MUST
+	// reply 0 OK.
+	// Raw block volumes are never mounted on the staging path, so
+	// the iSCSI session needs to be cleaned up even though nothing
+	// is mounted there.
+	if refCount == 0 && (!isBlockVolume(instance) || instance.Spec.MountInfo.StagingPath == "") {
+		logrus.Infof("NodeUnstageVolume: %s target not mounted", target)
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
+
+	if isEncrypted(instance) {
+		if err := ns.closeLUKS(instance); err != nil {
+			return nil, err
+		}
+	}
+
+	portal := targetPortal(instance, ns.driver.config.ClusterDomain)
+	iqn, devicePath := instance.Spec.ISCSISpec.Iqn, instance.Spec.MountInfo.DevicePath
+
+	// limits are set on the iSCSI device even if the volume is
+	// encrypted, unstaging isn't failed as it is not retried once
+	// the staging path is unmounted
+	if err := clearQoSLimits(iscsiDevicePath(portal, iqn, defaultISCSILUN)); err != nil {
+		logrus.Warningf("NodeUnstageVolume: failed to clear io limits of volume {%v}, err: {%v}", volID, err)
+	}
+	if delay := ns.driver.config.UnstageLogoutDelay; delay > 0 {
+		// session is reused if the volume is staged
+		// again on this node within the delay
+		logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s} is delayed by %v", portal, delay)
+		ns.delayedLogouts.schedule(utils.StripName(volID), delay, func() error {
+			return iscsiLogout(ns.mounter.Exec, iqn, []string{portal}, devicePath)
+		})
+	} else {
+		logrus.Infof("NodeUnstageVolume: disconnect from iscsi target: {%s}", portal)
+		if err := iscsiLogout(ns.mounter.Exec, iqn, []string{portal}, devicePath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
 	}
 
 	if err := os.RemoveAll(instance.Spec.MountInfo.StagingPath); err != nil {
 		logrus.Errorf("Failed to remove mount path, err: {%v}", err)
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	// driver dir under the staging target path is removed once it
+	// is empty so that kubelet can remove the staging target path
+	if stagingTargetPath := req.GetStagingTargetPath(); target != stagingTargetPath {
+		if err := os.Remove(filepath.Dir(target)); err != nil && !os.IsNotExist(err) {
+			logrus.Warningf("NodeUnstageVolume: failed to remove dir {%v}, err: {%v}", filepath.Dir(target), err)
+		}
+	}
 
 	// Setting to empty
 	instance.Spec.MountInfo.StagingPath = ""
@@ -324,14 +710,14 @@ func (ns *node) NodeUnstageVolume(
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath string) error {
+func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath, fsType string) error {
 	// Mount device
-	mntPath := req.GetStagingTargetPath()
+	mntPath := ns.stagingPath(req.GetStagingTargetPath(), req.GetVolumeId(), false)
 	notMnt, err := ns.mounter.IsLikelyNotMountPoint(mntPath)
 	if err != nil && !os.IsNotExist(err) {
 		if err := os.MkdirAll(mntPath, 0750); err != nil {
 			logrus.Errorf("Failed to mkdir %s, err: {%v}", mntPath, err)
-			return err
+			return status.Error(codes.Internal, err.Error())
 		}
 	}
 
@@ -340,18 +726,171 @@ func (ns *node) formatAndMount(req *csi.NodeStageVolumeRequest, devicePath strin
 		return nil
 	}
 
-	fsType := req.GetVolumeCapability().GetMount().GetFsType()
 	options := []string{}
-	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
+	mountFlags := withSELinuxContext(req.GetVolumeCapability().GetMount().GetMountFlags())
 	options = append(options, mountFlags...)
 
-	err = ns.mounter.FormatAndMount(devicePath, mntPath, fsType, options)
+	// Device may already be formatted with a different
+	// filesystem, mounting it with the requested fsType
+	// would either fail or end up reformatting the data
+	existingFsType, err := ns.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to detect filesystem on device {%s}, err: {%v}", devicePath, err)
+	}
+
+	if existingFsType != "" && existingFsType != fsType {
+		return status.Errorf(codes.FailedPrecondition,
+			"Device {%s} of volume {%s} is already formatted with fsType {%s}, requested fsType is {%s}",
+			devicePath, req.GetVolumeId(), existingFsType, fsType,
+		)
+	}
+
+	skipFormat := ns.skipFormat(req.GetVolumeContext())
+	if existingFsType == "" {
+		// volume formatted out of band is expected to have a
+		// filesystem, a blank device is not formatted so that
+		// the data is not wiped if detection went wrong
+		if skipFormat {
+			return status.Errorf(codes.FailedPrecondition,
+				"Device {%s} of volume {%s} has no filesystem, formatting is disabled by {%v}",
+				devicePath, req.GetVolumeId(), skipFormatKey,
+			)
+		}
+		if err := ns.formatDevice(devicePath, fsType, req.GetVolumeContext()); err != nil {
+			return err
+		}
+	} else {
+		for _, key := range []string{mkfsOptionsKey, fsLabelKey} {
+			if _, ok := req.GetVolumeContext()[key]; ok {
+				logrus.Infof("NodeStageVolume: ignoring {%v} for device {%s}, it is already formatted", key, devicePath)
+			}
+		}
+	}
+
+	logrus.Infof("NodeStageVolume: mounting device: {%s} at: {%s} with fsType: {%s} and options: {%v}", devicePath, mntPath, fsType, options)
+	if skipFormat {
+		err = ns.mounter.Mount(devicePath, mntPath, fsType, options)
+	} else {
+		err = ns.mounter.FormatAndMount(devicePath, mntPath, fsType, options)
+	}
 	if err != nil {
 		logrus.Errorf(
 			"Failed to mount iscsi volume {%s [%s, %s]} to {%s}, error {%v}",
 			req.GetVolumeId(), devicePath, fsType, mntPath, err,
 		)
-		return err
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// withSELinuxContext returns the mount flags with only the last of the
+// SELinux context options, the filesystem can be mounted with a single
+// context and the one set by kubelet is appended after the mount
+// options of the StorageClass. Raw block volumes have no mount flags
+// so the context is never set on them.
+func withSELinuxContext(flags []string) []string {
+	last := -1
+	for i, f := range flags {
+		if strings.HasPrefix(f, seLinuxContextOption) {
+			last = i
+		}
+	}
+	if last == -1 {
+		return flags
+	}
+
+	logrus.Infof("Mounting with SELinux context option {%s}", flags[last])
+	filtered := make([]string, 0, len(flags))
+	for i, f := range flags {
+		if i != last && strings.HasPrefix(f, seLinuxContextOption) {
+			logrus.Warningf("Ignoring SELinux context option {%s}, it is overridden by {%s}", f, flags[last])
+			continue
+		}
+		filtered = append(filtered, f)
+	}
+	return filtered
+}
+
+// validateMountOptions verifies that all the mount flags are in the
+// default list of the allowed mount options or the ones added via flags
+func (ns *node) validateMountOptions(flags []string) error {
+	allowed := map[string]bool{}
+	for _, o := range DefaultAllowedMountOptions {
+		allowed[o] = true
+	}
+	for _, o := range ns.driver.config.AllowedMountOptions {
+		allowed[o] = true
+	}
+
+	for _, f := range flags {
+		name := strings.SplitN(f, "=", 2)[0]
+		if !allowed[name] {
+			return status.Errorf(codes.InvalidArgument,
+				"NodeStageVolume: mount option {%s} is not allowed, it can be allowed via --allowed-mount-options", f)
+		}
+	}
+	return nil
+}
+
+// skipFormat returns true if the device must not be formatted, the
+// value set in the volume context takes precedence over the flag
+func (ns *node) skipFormat(volumeContext map[string]string) bool {
+	if val, ok := volumeContext[skipFormatKey]; ok {
+		if skip, err := strconv.ParseBool(val); err == nil {
+			return skip
+		}
+		logrus.Warningf("NodeStageVolume: ignoring invalid {%v} {%v}", skipFormatKey, val)
+	}
+	return ns.driver.config.SkipFormat
+}
+
+// formatDevice formats the device with the reserved blocks percentage,
+// the mkfs options and the label set in the volume context while the
+// volume is formatted for the first time, the reserved blocks only apply
+// to the ext filesystems. Device is left to be formatted by
+// FormatAndMount if none of them is set.
+func (ns *node) formatDevice(devicePath, fsType string, volumeContext map[string]string) error {
+	var args []string
+	if reserved, ok := volumeContext[reservedBlocksKey]; ok {
+		if err := intInRange(0, maxReservedBlocksPercentage)(reserved); err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid {%v} {%v}, err: {%v}", reservedBlocksKey, reserved, err)
+		}
+
+		if fsType == FSTypeXfs {
+			logrus.Warningf("NodeStageVolume: ignoring {%v} for device {%s}, it is not supported by xfs", reservedBlocksKey, devicePath)
+		} else {
+			args = append(args, "-m", reserved)
+		}
+	}
+
+	if options, ok := volumeContext[mkfsOptionsKey]; ok {
+		mkfsArgs, err := parseMkfsOptions(fsType, options)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid {%v} {%v}, err: {%v}", mkfsOptionsKey, options, err)
+		}
+		args = append(args, mkfsArgs...)
+	}
+
+	if label, ok := volumeContext[fsLabelKey]; ok {
+		labelArgs, err := fsLabelArgs(fsType, label)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Invalid {%v} {%v}, err: {%v}", fsLabelKey, label, err)
+		}
+		args = append(args, labelArgs...)
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+	if fsType != FSTypeXfs {
+		args = append([]string{"-F"}, args...)
+	}
+	args = append(args, devicePath)
+
+	logrus.Infof("NodeStageVolume: formatting device: {%s} with fsType: {%s} and args: {%v}", devicePath, fsType, args)
+	out, err := ns.mounter.Exec.Command("mkfs."+fsType, args...).CombinedOutput()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to format device {%s} with fsType {%s}, err: {%v}, output: {%s}", devicePath, fsType, err, string(out))
 	}
 	return nil
 }
@@ -370,6 +909,14 @@ func (ns *node) NodePublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
 	}
 
+	if req.GetVolumeContext()[nfsServerKey] != "" {
+		return ns.nodePublishRWX(ctx, req)
+	}
+
+	if err := validatePublishContext(volumeID, req.GetPublishContext()); err != nil {
+		return nil, err
+	}
+
 	target := req.GetTargetPath()
 	if len(target) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
@@ -380,7 +927,7 @@ func (ns *node) NodePublishVolume(
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not provided")
 	}
 
-	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}) {
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}, ns.driver.config.EnableRWX) {
 		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
 	}
 
@@ -396,22 +943,43 @@ func (ns *node) NodePublishVolume(
 		return nil, err
 	}
 
+	instance, err := doesVolumeExist(volumeID, ns.client)
+	if err != nil {
+		return nil, err
+	}
+
+	if volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER {
+		if err := ns.isPublishedElsewhere(instance, target); err != nil {
+			return nil, err
+		}
+	}
+
+	propagation, err := getMountPropagation(req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+
 	mountOptions := []string{"bind"}
 	if req.GetReadonly() {
 		mountOptions = append(mountOptions, "ro")
 	}
 	switch mode := volCap.GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
-		return &csi.NodePublishVolumeResponse{}, status.Error(codes.Unimplemented, "doesn't support block device provisioning")
+		// there are no nested mounts on a device file
+		// which could be propagated
+		if propagation == propagationShared {
+			return nil, status.Errorf(codes.InvalidArgument, "Bidirectional mount propagation is not supported for block volume {%q}", volumeID)
+		}
+		if err := ns.nodePublishVolumeForBlock(ctx, req, mountOptions, instance.Spec.MountInfo.DevicePath); err != nil {
+			return nil, err
+		}
 	case *csi.VolumeCapability_Mount:
-		if err := ns.nodePublishVolumeForFileSystem(req, mountOptions, mode); err != nil {
+		if err := ns.nodePublishVolumeForFileSystem(ctx, req, mountOptions, mode); err != nil {
+			return nil, err
+		}
+		if err := ns.setMountPropagation(target, propagation); err != nil {
 			return nil, err
 		}
-	}
-
-	instance, err := doesVolumeExist(volumeID, ns.client)
-	if err != nil {
-		return nil, err
 	}
 
 	instance.Spec.MountInfo.TargetPath = target
@@ -422,9 +990,82 @@ func (ns *node) NodePublishVolume(
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest, mountOptions []string, mode *csi.VolumeCapability_Mount) error {
+// getMountPropagation returns the mount propagation requested in
+// the volume context, both the kubernetes names of propagation
+// modes and the mount flags are accepted. rprivate is returned
+// if the propagation is not set.
+func getMountPropagation(volumeContext map[string]string) (string, error) {
+	switch propagation := volumeContext[mountPropagationKey]; propagation {
+	case "", "None", propagationPrivate:
+		return propagationPrivate, nil
+	case "HostToContainer", propagationSlave:
+		return propagationSlave, nil
+	case "Bidirectional", propagationShared:
+		return propagationShared, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "Invalid mount propagation {%q}, supported values are: None, HostToContainer and Bidirectional", propagation)
+	}
+}
+
+// setMountPropagation sets the propagation type of the
+// mount at target i.e mount --make-rshared <target>
+func (ns *node) setMountPropagation(target, propagation string) error {
+	logrus.Infof("NodePublishVolume: setting mount propagation: {%s} on target: {%s}", propagation, target)
+	out, err := ns.mounter.Exec.Command("mount", "--make-"+propagation, target).CombinedOutput()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not set mount propagation {%s} on %q: %v, output: %s", propagation, target, err, string(out))
+	}
+	return nil
+}
+
+// validatePublishContext verifies that the publish context set by
+// ControllerPublishVolume has the well formed target details of the
+// given volume, so that a malformed context fails fast
+func validatePublishContext(volumeID string, publishContext map[string]string) error {
+	for _, key := range []string{publishContextVolumeName, publishContextIQN, publishContextPortal} {
+		if publishContext[key] == "" {
+			return status.Errorf(codes.InvalidArgument, "Publish context of volume {%v} is missing {%v}", volumeID, key)
+		}
+	}
+
+	if name := publishContext[publishContextVolumeName]; name != utils.StripName(volumeID) {
+		return status.Errorf(codes.InvalidArgument, "Publish context of volume {%v} has {%v} of another volume {%v}", volumeID, publishContextVolumeName, name)
+	}
+
+	if iqn := publishContext[publishContextIQN]; !strings.HasPrefix(iqn, "iqn.") || strings.ContainsAny(iqn, " \t") {
+		return status.Errorf(codes.InvalidArgument, "Publish context of volume {%v} has malformed {%v}: {%v}", volumeID, publishContextIQN, iqn)
+	}
+
+	portal := publishContext[publishContextPortal]
+	if host, port, err := net.SplitHostPort(portal); err != nil || host == "" || port == "" {
+		return status.Errorf(codes.InvalidArgument, "Publish context of volume {%v} has malformed {%v}: {%v}", volumeID, publishContextPortal, portal)
+	}
+	return nil
+}
+
+// isPublishedElsewhere returns FailedPrecondition if the volume
+// is still mounted at a target path other than the given one,
+// it ensures that a ReadWriteOncePod volume is published to a
+// single pod at a time
+func (ns *node) isPublishedElsewhere(instance *jv.JivaVolume, target string) error {
+	published := instance.Spec.MountInfo.TargetPath
+	if published == "" || published == target {
+		return nil
+	}
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(published)
+	if (err == nil && notMnt) || os.IsNotExist(err) {
+		return nil
+	}
+
+	return status.Errorf(codes.FailedPrecondition,
+		"Volume {%q} is already published at {%q}, it can't be published to more than one pod at a time",
+		instance.Spec.PV, published)
+}
+
+func (ns *node) nodePublishVolumeForFileSystem(ctx context.Context, req *csi.NodePublishVolumeRequest, mountOptions []string, mode *csi.VolumeCapability_Mount) error {
 	target := req.GetTargetPath()
-	source := req.GetStagingTargetPath()
+	source := ns.stagingPath(req.GetStagingTargetPath(), req.GetVolumeId(), false)
 	if m := mode.Mount; m != nil {
 		hasOption := func(options []string, opt string) bool {
 			for _, o := range options {
@@ -434,7 +1075,13 @@ func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest
 			}
 			return false
 		}
-		for _, f := range m.MountFlags {
+		readOnly := hasOption(mountOptions, "ro")
+		for _, f := range withSELinuxContext(m.MountFlags) {
+			// readonly publish takes precedence over
+			// the rw mount option set in StorageClass
+			if readOnly && f == "rw" {
+				continue
+			}
 			if !hasOption(mountOptions, f) {
 				mountOptions = append(mountOptions, f)
 			}
@@ -454,11 +1101,11 @@ func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest
 
 	fsType := mode.Mount.GetFsType()
 	if len(fsType) == 0 {
-		fsType = defaultFsType
+		fsType = ns.defaultFSType()
 	}
 
 	logrus.Infof("NodePublishVolume: start mounting: source: {%s} at target: {%s} with options: {%s} and fstype: {%s}", source, target, mountOptions, fsType)
-	if err := ns.mounter.Mount(source, target, fsType, mountOptions); err != nil {
+	if err := mountWithRetry(ctx, ns.mounter, ns.driver.config.MountMaxAttempts, source, target, fsType, mountOptions); err != nil {
 		if removeErr := os.Remove(target); removeErr != nil {
 			return status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, err)
 		}
@@ -468,6 +1115,45 @@ func (ns *node) nodePublishVolumeForFileSystem(req *csi.NodePublishVolumeRequest
 	return nil
 }
 
+// nodePublishVolumeForBlock bind mounts the iSCSI device
+// attached during NodeStageVolume on a file created at
+// the target path
+func (ns *node) nodePublishVolumeForBlock(ctx context.Context, req *csi.NodePublishVolumeRequest, mountOptions []string, devicePath string) error {
+	target := req.GetTargetPath()
+	if len(devicePath) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "Device path not found for volume {%q}, volume may not be staged", req.GetVolumeId())
+	}
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
+	if err == nil && !notMnt {
+		logrus.Infof("NodePublishVolume: block device: {%s} is already mounted at target: {%s}", devicePath, target)
+		return nil
+	}
+
+	targetDir := filepath.Dir(target)
+	logrus.Infof("NodePublishVolume: creating dir: {%s}", targetDir)
+	if err := os.MkdirAll(targetDir, 0750); err != nil {
+		return status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", targetDir, err)
+	}
+
+	logrus.Infof("NodePublishVolume: creating file: {%s}", target)
+	file, err := os.OpenFile(target, os.O_CREATE, 0660)
+	if err != nil {
+		return status.Errorf(codes.Internal, "Could not create file {%q}, err: %v", target, err)
+	}
+	file.Close()
+
+	logrus.Infof("NodePublishVolume: start mounting: source: {%s} at target: {%s} with options: {%s}", devicePath, target, mountOptions)
+	if err := mountWithRetry(ctx, ns.mounter, ns.driver.config.MountMaxAttempts, devicePath, target, "", mountOptions); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
+		}
+		return status.Errorf(codes.Internal, "Could not mount %q at %q: %v", devicePath, target, err)
+	}
+
+	return nil
+}
+
 // NodeUnpublishVolume unpublishes (unmounts) the volume
 // from the corresponding node from the given path
 //
@@ -502,6 +1188,12 @@ func (ns *node) NodeUnpublishVolume(
 		return nil, err
 	}
 
+	// ReadWriteMany volume is published on many nodes
+	// so the target path isn't tracked in the CR
+	if instance.Annotations[client.NFSServerAnnotation] != "" {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
 	instance.Spec.MountInfo.TargetPath = ""
 	if err := ns.client.UpdateJivaVolume(instance); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -537,17 +1229,42 @@ func (ns *node) isAlreadyMounted(volID, path string) error {
 	return nil
 }
 
+// unmount unmounts the target path of the volume, target which is
+// already removed i.e by kubelet is considered to be unmounted and
+// the target which is not a mount point is just removed, so that the
+// teardown of the pod isn't blocked. FailedPrecondition is returned
+// if the mount is still in use.
 func (ns *node) unmount(volumeID, target string) error {
 	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
-	if (err == nil && notMnt) || os.IsNotExist(err) {
-		logrus.Warningf("Volume: {%s} is not mounted, err: %v", target, err)
+	if os.IsNotExist(err) {
+		logrus.Warningf("Volume: {%s} target path {%s} does not exist, assuming it is unmounted", volumeID, target)
+		return nil
+	}
+
+	if err == nil && notMnt {
+		logrus.Warningf("Volume: {%s} target path {%s} is not mounted, removing it", volumeID, target)
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return status.Errorf(codes.Internal, "Could not remove %q: %v", target, err)
+		}
 		return nil
 	}
 
 	logrus.Infof("Unmounting: %s", target)
 	if err := ns.mounter.Unmount(target); err != nil {
+		if isBusyUnmountError(err) {
+			return status.Errorf(codes.FailedPrecondition, "Could not unmount %q, it is busy: %v", target, err)
+		}
 		return status.Errorf(codes.Internal, "Could not unmount %q: %v", target, err)
 	}
+
+	// raw block volumes are bind mounted on a file
+	// which needs to be removed after unmount
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		logrus.Infof("Removing block device target file: %s", target)
+		if err := os.Remove(target); err != nil {
+			return status.Errorf(codes.Internal, "Could not remove %q: %v", target, err)
+		}
+	}
 	return nil
 }
 
@@ -559,8 +1276,32 @@ func (ns *node) NodeGetInfo(
 	req *csi.NodeGetInfoRequest,
 ) (*csi.NodeGetInfoResponse, error) {
 
+	segments := map[string]string{
+		ns.driver.config.TopologyKey: ns.driver.config.NodeID,
+	}
+
+	// advertise the zone and region of the node as well, so
+	// that volumes can be pinned to a zone via allowedTopologies.
+	// Registration doesn't fail if the apiserver is unreachable,
+	// only the topology derived from the node ID is advertised.
+	node, err := ns.client.GetNode(ns.driver.config.NodeID)
+	if err != nil {
+		logrus.Warningf("NodeGetInfo: failed to get node {%v}, zone and region are not advertised, err: {%v}", ns.driver.config.NodeID, err)
+	} else {
+		for _, key := range []string{corev1.LabelZoneFailureDomainStable, corev1.LabelZoneRegionStable} {
+			if val, ok := node.Labels[key]; ok {
+				segments[key] = val
+			}
+		}
+	}
+
+	// 0 is not set in the response, i.e there is no limit
 	return &csi.NodeGetInfoResponse{
-		NodeId: ns.driver.config.NodeID,
+		NodeId:            ns.driver.config.NodeID,
+		MaxVolumesPerNode: ns.driver.config.MaxVolumesPerNode,
+		AccessibleTopology: &csi.Topology{
+			Segments: segments,
+		},
 	}, nil
 }
 
@@ -574,7 +1315,11 @@ func (ns *node) NodeGetCapabilities(
 ) (*csi.NodeGetCapabilitiesResponse, error) {
 
 	var caps []*csi.NodeServiceCapability
-	for _, cap := range nodeCaps {
+	capabilities := append([]csi.NodeServiceCapability_RPC_Type{}, nodeCaps...)
+	if ns.driver.config.EnableVolumeMountGroup {
+		capabilities = append(capabilities, csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP)
+	}
+	for _, cap := range capabilities {
 		c := &csi.NodeServiceCapability{
 			Type: &csi.NodeServiceCapability_Rpc{
 				Rpc: &csi.NodeServiceCapability_RPC{
@@ -607,9 +1352,12 @@ func (ns *node) NodeExpandVolume(
 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume ID must be provided")
 	}
 
-	volumePath := req.GetVolumePath()
-	if volumePath == "" {
-		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume Path must be provided")
+	// staging path is set by kubelet only on newer versions
+	// of kubernetes, older versions set only the volume path
+	// i.e the path where the volume is published
+	stagingTargetPath, volumePath := req.GetStagingTargetPath(), req.GetVolumePath()
+	if stagingTargetPath == "" && volumePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "NodeExpandVolume: staging target path or volume path must be provided")
 	}
 
 	if err := request.AddVolumeToTransitionList(volumeID, "NodeExpandVolume"); err != nil {
@@ -618,14 +1366,11 @@ func (ns *node) NodeExpandVolume(
 
 	defer request.RemoveVolumeFromTransitionList(volumeID)
 
-	mounted, err := ns.mounter.ExistsPath(volumePath)
+	release, err := ns.limiter.acquire(ctx, "NodeExpandVolume")
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to check if volume path %q is mounted: %s", volumePath, err)
-	}
-
-	if !mounted {
-		return nil, status.Errorf(codes.NotFound, "volume path %q is not mounted", volumePath)
+		return nil, err
 	}
+	defer release()
 
 	// JivaVolume CR may be updated by jiva-operator
 	instance, err := ns.doesVolumeExist(volumeID)
@@ -633,13 +1378,50 @@ func (ns *node) NodeExpandVolume(
 		return nil, err
 	}
 
+	if stagingTargetPath != "" {
+		volumePath = ns.stagingPath(stagingTargetPath, volumeID, isBlockVolume(instance))
+	}
+
+	mounted, err := ns.mounter.ExistsPath(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check if volume path %q is mounted: %s", volumePath, err)
+	}
+
+	if !mounted {
+		return nil, status.Errorf(codes.NotFound, "volume path %q is not mounted", volumePath)
+	}
+
 	resize := resizeInput{
 		volumePath:   volumePath,
 		fsType:       instance.Spec.MountInfo.FSType,
 		iqn:          instance.Spec.ISCSISpec.Iqn,
 		targetPortal: instance.Spec.ISCSISpec.TargetIP,
+		onlineOnly:   ns.driver.config.OnlineExpansionOnly,
 		exec:         ns.mounter.Exec,
 	}
+	if isEncrypted(instance) {
+		resize.luksMapping = filepath.Base(luksMapperPath(instance))
+	}
+
+	// There is no filesystem to be expanded on a raw block
+	// volume, rescan is enough to reflect the new size. Raw
+	// block volumes are not mounted on the staging path.
+	isBlock := isBlockVolume(instance)
+	if !isBlock {
+		isBlock, err = isBlockDevice(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to determine access type of volume path %q: %s", volumePath, err)
+		}
+	}
+
+	if isBlock {
+		if err := resize.reScan(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeExpandVolumeResponse{
+			CapacityBytes: client.RoundUpCapacity(req.GetCapacityRange().GetRequiredBytes()),
+		}, nil
+	}
 
 	list, err := ns.mounter.List()
 	if err != nil {
@@ -647,11 +1429,11 @@ func (ns *node) NodeExpandVolume(
 	}
 
 	if err := resize.volume(list); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Error(resizeErrorCode(err), err.Error())
 	}
 
 	return &csi.NodeExpandVolumeResponse{
-		CapacityBytes: req.GetCapacityRange().GetRequiredBytes(),
+		CapacityBytes: client.RoundUpCapacity(req.GetCapacityRange().GetRequiredBytes()),
 	}, nil
 }
 
@@ -674,6 +1456,16 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Error(codes.InvalidArgument, "Volume Path must be provided")
 	}
 
+	// volume is mounted under the staging target path
+	// as per the staging path layout
+	if stagingTargetPath := req.GetStagingTargetPath(); stagingTargetPath != "" && volumePath == stagingTargetPath {
+		instance, err := ns.doesVolumeExist(volumeID)
+		if err != nil {
+			return nil, err
+		}
+		volumePath = ns.stagingPath(stagingTargetPath, volumeID, isBlockVolume(instance))
+	}
+
 	mounted, err := ns.mounter.ExistsPath(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to check if volume path {%q} is mounted: %s", volumePath, err)
@@ -683,16 +1475,53 @@ func (ns *node) NodeGetVolumeStats(
 		return nil, status.Errorf(codes.NotFound, "Volume path {%q} is not mounted", volumePath)
 	}
 
+	isBlock, err := isBlockDevice(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to determine access type of volume path {%q}: {%s}", volumePath, err)
+	}
+
+	if isBlock {
+		stats, err := getBlockStatistics(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to retrieve capacity statistics for block volume path {%q}: {%s}", volumePath, err)
+		}
+		return &csi.NodeGetVolumeStatsResponse{
+			Usage:           stats,
+			VolumeCondition: ns.volumeCondition(ctx, volumeID),
+		}, nil
+	}
+
 	stats, err := getStatistics(volumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to retrieve capacity statistics for volume path {%q}: {%s}", volumePath, err)
 	}
 
+	// used bytes are not known for block volumes,
+	// so only the filesystem usage is alerted
 	return &csi.NodeGetVolumeStatsResponse{
-		Usage: stats,
+		Usage:           stats,
+		VolumeCondition: mergeConditions(ns.volumeCondition(ctx, volumeID), ns.usageCondition(volumeID, stats)),
 	}, nil
 }
 
+// volumeCondition returns the condition of the volume, nil is
+// returned if the JivaVolume CR can't be fetched so that the
+// usage is still reported
+func (ns *node) volumeCondition(ctx context.Context, volumeID string) *csi.VolumeCondition {
+	// set client each time to avoid caching issue
+	if err := ns.client.Set(); err != nil {
+		logrus.Warningf("NodeGetVolumeStats: failed to set client, err: {%v}", err)
+		return nil
+	}
+
+	instance, err := ns.client.GetJivaVolume(utils.StripName(volumeID))
+	if err != nil {
+		logrus.Warningf("NodeGetVolumeStats: failed to get volume {%v}, err: {%v}", volumeID, err)
+		return nil
+	}
+	return volumeCondition(ctx, ns.replicaStatus, instance)
+}
+
 func (ns *node) validateNodePublishReq(
 	req *csi.NodePublishVolumeRequest,
 ) error {
@@ -0,0 +1,1009 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/config"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-operator/pkg/apis"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/mount"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testVolumeID = "pvc-1234"
+
+func newTestJivaVolume() *jv.JivaVolume {
+	return &jv.JivaVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testVolumeID,
+			Namespace: "openebs",
+			Labels: map[string]string{
+				"openebs.io/persistent-volume": testVolumeID,
+				"openebs.io/component":         "jiva-volume",
+				"nodeID":                       "node-1",
+			},
+		},
+		Spec: jv.JivaVolumeSpec{
+			PV:       testVolumeID,
+			Capacity: "5Gi",
+			MountInfo: jv.MountInfo{
+				DevicePath: "/dev/sdb",
+			},
+		},
+	}
+}
+
+// newFakeNode returns a node backed by the fake client initialized
+// with the given objects, fake mounter and the given fake exec
+func newFakeNode(t *testing.T, exec utilexec.Interface, objs ...runtime.Object) (*node, *mount.FakeMounter, ctrlclient.Client) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeClient := fake.NewFakeClientWithScheme(scheme, objs...)
+	fakeMounter := mount.NewFakeMounter(nil)
+	ns := &node{
+		client: client.NewWithClient(fakeClient),
+		driver: &CSIDriver{config: &config.Config{NodeID: "node-1"}},
+		mounter: &NodeMounter{
+			SafeFormatAndMount: mount.SafeFormatAndMount{
+				Interface: fakeMounter,
+				Exec:      exec,
+			},
+		},
+		delayedLogouts: newDelayedLogouts(),
+		usageAlerts:    newUsageAlerts(),
+		limiter:        newRequestLimiter(0),
+	}
+	return ns, fakeMounter, fakeClient
+}
+
+// newTestPublishContext returns the publish context
+// set by ControllerPublishVolume for the test volume
+func newTestPublishContext() map[string]string {
+	return map[string]string{
+		publishContextVolumeName: testVolumeID,
+		publishContextIQN:        testIQN,
+		publishContextPortal:     testPortal,
+	}
+}
+
+func newNodePublishVolumeRequest(target string, volCap *csi.VolumeCapability, readOnly bool) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		PublishContext:    newTestPublishContext(),
+		StagingTargetPath: "/var/lib/kubelet/plugins/staging/" + testVolumeID,
+		TargetPath:        target,
+		VolumeCapability:  volCap,
+		Readonly:          readOnly,
+	}
+}
+
+func hasMountOption(mp mount.MountPoint, opt string) bool {
+	for _, o := range mp.Opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNodePublishVolumeReadOnlyFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cmds [][]string
+	ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds, success), newTestJivaVolume())
+
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{
+				MountFlags: []string{"rw", "noatime"},
+			},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+
+	target := filepath.Join(dir, "mount")
+	if _, err := ns.NodePublishVolume(context.TODO(), newNodePublishVolumeRequest(target, volCap, true)); err != nil {
+		t.Fatalf("expected volume to be published, got err: %v", err)
+	}
+
+	if len(fakeMounter.MountPoints) != 1 {
+		t.Fatalf("expected one mount, got: %v", fakeMounter.MountPoints)
+	}
+
+	mp := fakeMounter.MountPoints[0]
+	if !hasMountOption(mp, "ro") {
+		t.Fatalf("expected ro mount option, got: %v", mp.Opts)
+	}
+	if hasMountOption(mp, "rw") {
+		t.Fatalf("expected rw mount option to be dropped, got: %v", mp.Opts)
+	}
+	if !hasMountOption(mp, "noatime") {
+		t.Fatalf("expected noatime mount option, got: %v", mp.Opts)
+	}
+}
+
+func TestNodePublishVolumeReadOnlyBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ns, fakeMounter, _ := newFakeNode(t, &testingexec.FakeExec{}, newTestJivaVolume())
+
+	volCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}
+
+	target := filepath.Join(dir, "block", testVolumeID)
+	if _, err := ns.NodePublishVolume(context.TODO(), newNodePublishVolumeRequest(target, volCap, true)); err != nil {
+		t.Fatalf("expected volume to be published, got err: %v", err)
+	}
+
+	if len(fakeMounter.MountPoints) != 1 {
+		t.Fatalf("expected one mount, got: %v", fakeMounter.MountPoints)
+	}
+
+	mp := fakeMounter.MountPoints[0]
+	if mp.Device != "/dev/sdb" || !hasMountOption(mp, "bind") || !hasMountOption(mp, "ro") {
+		t.Fatalf("expected read-only bind mount of /dev/sdb, got: %+v", mp)
+	}
+}
+
+func TestLogoutStalePortalsAfterPortalChange(t *testing.T) {
+	iscsiLogoutRetryInterval = 0
+	dir, err := ioutil.TempDir("", "stale-portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// device attached from the old portal is still mounted
+	device := filepath.Join(dir, "sdb")
+	if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := newTestJivaVolume()
+	instance.Spec.ISCSISpec.Iqn = testIQN
+	instance.Spec.ISCSISpec.TargetIP = "10.0.0.2"
+	instance.Spec.ISCSISpec.TargetPort = 3260
+	instance.Spec.MountInfo.DevicePath = device
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds,
+		func() ([]byte, []byte, error) {
+			return []byte("tcp: [1] " + testPortal + ",1 " + testIQN + " (non-flash)\n"), nil, nil
+		},
+		success,
+		success,
+		success,
+	)
+	ns, fakeMounter, _ := newFakeNode(t, fakeExec, instance)
+	fakeMounter.MountPoints = []mount.MountPoint{{Device: device, Path: "/var/lib/kubelet/plugins/staging/" + testVolumeID}}
+
+	if err := ns.logoutStalePortals(instance); err != nil {
+		t.Fatalf("expected stale session to be logged out, got err: %v", err)
+	}
+
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Fatalf("expected device of stale session to be unmounted, got: %v", fakeMounter.MountPoints)
+	}
+
+	expected := [][]string{
+		{"iscsiadm", "-m", "session"},
+		{"blockdev", "--flushbufs", device},
+		{"iscsiadm", "-m", "node", "-T", testIQN, "-p", testPortal, "-u"},
+		{"iscsiadm", "-m", "node", "-T", testIQN, "-p", testPortal, "-o", "delete"},
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("expected commands %v, got: %v", expected, cmds)
+	}
+}
+
+func TestLogoutStalePortalsSamePortal(t *testing.T) {
+	instance := newTestJivaVolume()
+	instance.Spec.ISCSISpec.Iqn = testIQN
+	instance.Spec.ISCSISpec.TargetIP = "10.0.0.1"
+	instance.Spec.ISCSISpec.TargetPort = 3260
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds, func() ([]byte, []byte, error) {
+		return []byte("tcp: [1] " + testPortal + ",1 " + testIQN + " (non-flash)\n"), nil, nil
+	})
+	ns, fakeMounter, _ := newFakeNode(t, fakeExec, instance)
+	fakeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: "/var/lib/kubelet/plugins/staging/" + testVolumeID}}
+
+	if err := ns.logoutStalePortals(instance); err != nil {
+		t.Fatalf("expected no-op for healthy session, got err: %v", err)
+	}
+
+	if len(cmds) != 1 || len(fakeMounter.MountPoints) != 1 {
+		t.Fatalf("expected only sessions to be listed, got commands: %v, mounts: %v", cmds, fakeMounter.MountPoints)
+	}
+}
+
+// expandVolumeCmds returns the fake exec expecting the commands
+// run to expand ext4 filesystem on the given device
+func expandVolumeCmds(cmds *[][]string) *testingexec.FakeExec {
+	return newFakeExec(cmds,
+		success,
+		func() ([]byte, []byte, error) {
+			return []byte("ext4\n"), nil, nil
+		},
+		success,
+	)
+}
+
+func TestNodeExpandVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	staging := filepath.Join(dir, "staging")
+	target := filepath.Join(dir, "target")
+	for _, path := range []string{staging, target} {
+		if err := os.MkdirAll(path, 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]struct {
+		req     *csi.NodeExpandVolumeRequest
+		mounted string
+	}{
+		// newer kubernetes versions pass both the paths
+		"staging target path": {
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: staging,
+				VolumePath:        target,
+			},
+			mounted: staging,
+		},
+		// older kubernetes versions pass only the volume path
+		"volume path only": {
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   testVolumeID,
+				VolumePath: target,
+			},
+			mounted: target,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			ns, fakeMounter, _ := newFakeNode(t, expandVolumeCmds(&cmds), newTestJivaVolume())
+			fakeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: test.mounted, Type: "ext4"}}
+
+			if _, err := ns.NodeExpandVolume(context.TODO(), test.req); err != nil {
+				t.Fatalf("expected volume to be expanded, got err: %v", err)
+			}
+
+			if len(cmds) != 3 || !reflect.DeepEqual(cmds[2], []string{"resize2fs", "/dev/sdb"}) {
+				t.Fatalf("expected filesystem on /dev/sdb to be resized, got commands: %v", cmds)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolumeMissingPaths(t *testing.T) {
+	ns, _, _ := newFakeNode(t, &testingexec.FakeExec{}, newTestJivaVolume())
+
+	_, err := ns.NodeExpandVolume(context.TODO(), &csi.NodeExpandVolumeRequest{VolumeId: testVolumeID})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got err: %v", err)
+	}
+}
+
+func TestValidatePublishContext(t *testing.T) {
+	tests := map[string]struct {
+		modify func(map[string]string)
+		errMsg string
+	}{
+		"valid context": {
+			modify: func(map[string]string) {},
+		},
+		"missing iqn": {
+			modify: func(c map[string]string) { delete(c, publishContextIQN) },
+			errMsg: publishContextIQN,
+		},
+		"missing portal": {
+			modify: func(c map[string]string) { c[publishContextPortal] = "" },
+			errMsg: publishContextPortal,
+		},
+		"missing volume name": {
+			modify: func(c map[string]string) { delete(c, publishContextVolumeName) },
+			errMsg: publishContextVolumeName,
+		},
+		"volume name of another volume": {
+			modify: func(c map[string]string) { c[publishContextVolumeName] = "pvc-5678" },
+			errMsg: "pvc-5678",
+		},
+		"malformed iqn": {
+			modify: func(c map[string]string) { c[publishContextIQN] = "pvc-1234" },
+			errMsg: publishContextIQN,
+		},
+		"portal without port": {
+			modify: func(c map[string]string) { c[publishContextPortal] = "10.0.0.1" },
+			errMsg: publishContextPortal,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			publishContext := newTestPublishContext()
+			test.modify(publishContext)
+
+			err := validatePublishContext(testVolumeID, publishContext)
+			if test.errMsg == "" {
+				if err != nil {
+					t.Fatalf("expected valid context, got err: %v", err)
+				}
+				return
+			}
+
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("expected InvalidArgument, got err: %v", err)
+			}
+			if !strings.Contains(err.Error(), test.errMsg) {
+				t.Fatalf("expected err to name {%v}, got: %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeMissingPublishContext(t *testing.T) {
+	ns, fakeMounter, _ := newFakeNode(t, &testingexec.FakeExec{}, newTestJivaVolume())
+
+	req := newNodePublishVolumeRequest("/var/lib/kubelet/pods/pod-1/volumes/mount", &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		},
+	}, false)
+	delete(req.PublishContext, publishContextPortal)
+
+	if _, err := ns.NodePublishVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got err: %v", err)
+	}
+	if len(fakeMounter.MountPoints) != 0 {
+		t.Fatalf("expected nothing to be mounted, got: %v", fakeMounter.MountPoints)
+	}
+}
+
+func TestWaitForVolumeToBeReachableCancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connections to the closed listener are refused
+	portal := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if err := waitForVolumeToBeReachable(ctx, portal); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestFormatDeviceReservedBlocks(t *testing.T) {
+	tests := map[string]struct {
+		fsType        string
+		volumeContext map[string]string
+		code          codes.Code
+		expectedCmds  [][]string
+	}{
+		"reserved blocks not set": {
+			fsType: FSTypeExt4,
+			code:   codes.OK,
+		},
+		"ext4 with reserved blocks": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{reservedBlocksKey: "1"},
+			code:          codes.OK,
+			expectedCmds:  [][]string{{"mkfs.ext4", "-F", "-m", "1", "/dev/sdb"}},
+		},
+		"xfs is ignored": {
+			fsType:        FSTypeXfs,
+			volumeContext: map[string]string{reservedBlocksKey: "1"},
+			code:          codes.OK,
+		},
+		"out of range": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{reservedBlocksKey: "51"},
+			code:          codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			ns, _, _ := newFakeNode(t, newFakeExec(&cmds, success))
+
+			err := ns.formatDevice("/dev/sdb", test.fsType, test.volumeContext)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func TestFormatDeviceMkfsOptions(t *testing.T) {
+	tests := map[string]struct {
+		fsType        string
+		volumeContext map[string]string
+		code          codes.Code
+		expectedCmds  [][]string
+	}{
+		"xfs stripe and reflink": {
+			fsType:        FSTypeXfs,
+			volumeContext: map[string]string{mkfsOptionsKey: "-d su=64k,sw=4 -m reflink=1"},
+			code:          codes.OK,
+			expectedCmds:  [][]string{{"mkfs.xfs", "-d", "su=64k,sw=4", "-m", "reflink=1", "/dev/sdb"}},
+		},
+		"ext4 with reserved blocks": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{reservedBlocksKey: "1", mkfsOptionsKey: "-E stride=16,stripe-width=64 -j"},
+			code:          codes.OK,
+			expectedCmds:  [][]string{{"mkfs.ext4", "-F", "-m", "1", "-E", "stride=16,stripe-width=64", "-j", "/dev/sdb"}},
+		},
+		"ext4 flag on xfs": {
+			fsType:        FSTypeXfs,
+			volumeContext: map[string]string{mkfsOptionsKey: "-E stride=16"},
+			code:          codes.InvalidArgument,
+		},
+		"xfs flag on ext4": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{mkfsOptionsKey: "-d su=64k"},
+			code:          codes.InvalidArgument,
+		},
+		"missing value": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{mkfsOptionsKey: "-E -j"},
+			code:          codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			ns, _, _ := newFakeNode(t, newFakeExec(&cmds, success))
+
+			err := ns.formatDevice("/dev/sdb", test.fsType, test.volumeContext)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func TestFormatDeviceFSLabel(t *testing.T) {
+	tests := map[string]struct {
+		fsType        string
+		volumeContext map[string]string
+		code          codes.Code
+		expectedCmds  [][]string
+	}{
+		"ext4 label": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{fsLabelKey: "jiva-data-000001"},
+			code:          codes.OK,
+			expectedCmds:  [][]string{{"mkfs.ext4", "-F", "-L", "jiva-data-000001", "/dev/sdb"}},
+		},
+		"xfs label with mkfs options": {
+			fsType:        FSTypeXfs,
+			volumeContext: map[string]string{mkfsOptionsKey: "-m reflink=1", fsLabelKey: "jiva-data"},
+			code:          codes.OK,
+			expectedCmds:  [][]string{{"mkfs.xfs", "-m", "reflink=1", "-L", "jiva-data", "/dev/sdb"}},
+		},
+		"ext4 label too long": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{fsLabelKey: "jiva-data-0000001"},
+			code:          codes.InvalidArgument,
+		},
+		"xfs label too long": {
+			fsType:        FSTypeXfs,
+			volumeContext: map[string]string{fsLabelKey: "jiva-data-001"},
+			code:          codes.InvalidArgument,
+		},
+		"label with whitespace": {
+			fsType:        FSTypeExt4,
+			volumeContext: map[string]string{fsLabelKey: "jiva data"},
+			code:          codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			ns, _, _ := newFakeNode(t, newFakeExec(&cmds, success))
+
+			err := ns.formatDevice("/dev/sdb", test.fsType, test.volumeContext)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func blkidOutput(fsType string) testingexec.FakeAction {
+	return func() ([]byte, []byte, error) {
+		if fsType == "" {
+			// blkid exits with 2 if no filesystem is detected
+			return nil, nil, &testingexec.FakeExitError{Status: 2}
+		}
+		return []byte("DEVNAME=/dev/sdb\nTYPE=" + fsType + "\n"), nil, nil
+	}
+}
+
+func TestFormatAndMountSkipFormat(t *testing.T) {
+	tests := map[string]struct {
+		flag          bool
+		volumeContext map[string]string
+		existingFs    string
+		code          codes.Code
+		mounted       bool
+	}{
+		"flag set, device formatted": {
+			flag:       true,
+			existingFs: FSTypeExt4,
+			code:       codes.OK,
+			mounted:    true,
+		},
+		"flag set, blank device": {
+			flag: true,
+			code: codes.FailedPrecondition,
+		},
+		"parameter set, blank device": {
+			volumeContext: map[string]string{skipFormatKey: "true"},
+			code:          codes.FailedPrecondition,
+		},
+		"parameter set, different fsType": {
+			volumeContext: map[string]string{skipFormatKey: "true"},
+			existingFs:    FSTypeXfs,
+			code:          codes.FailedPrecondition,
+		},
+		"parameter overrides flag": {
+			flag:          true,
+			volumeContext: map[string]string{skipFormatKey: "false"},
+			existingFs:    FSTypeExt4,
+			code:          codes.OK,
+			mounted:       true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var cmds [][]string
+			// FormatAndMount detects the filesystem again and
+			// checks it if the device is not mounted as is
+			ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds, blkidOutput(test.existingFs), blkidOutput(test.existingFs), success))
+			ns.driver.config.SkipFormat = test.flag
+
+			req := &csi.NodeStageVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: dir,
+				VolumeContext:     test.volumeContext,
+				VolumeCapability:  newCreateVolumeRequest(testVolumeID, 0).GetVolumeCapabilities()[0],
+			}
+			err = ns.formatAndMount(req, "/dev/sdb", FSTypeExt4)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+
+			// the device is never formatted
+			for _, cmd := range cmds {
+				if strings.HasPrefix(cmd[0], "mkfs") {
+					t.Fatalf("expected device to not be formatted, got: %v", cmds)
+				}
+			}
+			if mounted := len(fakeMounter.MountPoints) == 1; mounted != test.mounted {
+				t.Fatalf("expected mounted %v, got mount points: %v", test.mounted, fakeMounter.MountPoints)
+			}
+		})
+	}
+}
+
+func TestMountSELinuxContext(t *testing.T) {
+	const seLinuxContext = `context="system_u:object_r:container_file_t:s0:c1,c2"`
+
+	tests := map[string]struct {
+		mountFlags []string
+		expected   string
+	}{
+		"context set by kubelet": {
+			mountFlags: []string{"noatime", seLinuxContext},
+			expected:   seLinuxContext,
+		},
+		"context set by kubelet overrides storageclass": {
+			mountFlags: []string{`context="system_u:object_r:nfs_t:s0"`, "noatime", seLinuxContext},
+			expected:   seLinuxContext,
+		},
+		"no context": {
+			mountFlags: []string{"noatime"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "selinux")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var cmds [][]string
+			ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds, blkidOutput(FSTypeExt4), blkidOutput(FSTypeExt4), success, success), newTestJivaVolume())
+			volCap := &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						FsType:     FSTypeExt4,
+						MountFlags: test.mountFlags,
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			}
+
+			stagingPath := filepath.Join(dir, "staging")
+			stageReq := &csi.NodeStageVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  volCap,
+			}
+			if err := ns.formatAndMount(stageReq, "/dev/sdb", FSTypeExt4); err != nil {
+				t.Fatalf("expected volume to be staged, got err: %v", err)
+			}
+
+			publishReq := newNodePublishVolumeRequest(filepath.Join(dir, "mount"), volCap, false)
+			publishReq.StagingTargetPath = stagingPath
+			if _, err := ns.NodePublishVolume(context.TODO(), publishReq); err != nil {
+				t.Fatalf("expected volume to be published, got err: %v", err)
+			}
+
+			if len(fakeMounter.MountPoints) != 2 {
+				t.Fatalf("expected staging and publish mounts, got: %v", fakeMounter.MountPoints)
+			}
+			for _, mp := range fakeMounter.MountPoints {
+				var contexts []string
+				for _, o := range mp.Opts {
+					if strings.HasPrefix(o, "context=") {
+						contexts = append(contexts, o)
+					}
+				}
+				if test.expected == "" && len(contexts) != 0 {
+					t.Fatalf("expected no context option on %v, got: %v", mp.Path, mp.Opts)
+				}
+				if test.expected != "" && (len(contexts) != 1 || contexts[0] != test.expected) {
+					t.Fatalf("expected context option {%v} on %v, got: %v", test.expected, mp.Path, mp.Opts)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateStagingReqMountOptions(t *testing.T) {
+	tests := map[string]struct {
+		mountFlags []string
+		allowed    []string
+		rejected   string
+	}{
+		"default options": {
+			mountFlags: []string{"noatime", "nosuid", "nodev", "errors=remount-ro"},
+		},
+		"selinux context": {
+			mountFlags: []string{`context="system_u:object_r:container_file_t:s0:c1,c2"`},
+		},
+		"insecure option": {
+			mountFlags: []string{"noatime", "suid"},
+			rejected:   "suid",
+		},
+		"option with value": {
+			mountFlags: []string{"uid=0"},
+			rejected:   "uid=0",
+		},
+		"option added via flags": {
+			mountFlags: []string{"noatime", "nouser_xattr"},
+			allowed:    []string{"nouser_xattr"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns, _, _ := newFakeNode(t, &testingexec.FakeExec{})
+			ns.driver.config.AllowedMountOptions = test.allowed
+			req := &csi.NodeStageVolumeRequest{
+				VolumeId:          testVolumeID,
+				PublishContext:    newTestPublishContext(),
+				StagingTargetPath: "/var/lib/kubelet/plugins/staging/" + testVolumeID,
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							FsType:     FSTypeExt4,
+							MountFlags: test.mountFlags,
+						},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			}
+
+			_, err := ns.validateStagingReq(req)
+			if test.rejected == "" {
+				if err != nil {
+					t.Fatalf("expected mount options to be allowed, got err: %v", err)
+				}
+				return
+			}
+			if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "{"+test.rejected+"}") {
+				t.Fatalf("expected InvalidArgument naming {%v}, got err: %v", test.rejected, err)
+			}
+		})
+	}
+}
+
+func TestBuildStagingPath(t *testing.T) {
+	const (
+		driverName        = "jiva.csi.openebs.io"
+		stagingTargetPath = "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1234/globalmount"
+	)
+
+	tests := map[string]struct {
+		layout   string
+		isBlock  bool
+		expected string
+	}{
+		"default layout, filesystem": {
+			layout:   StagingPathLayoutDefault,
+			expected: stagingTargetPath,
+		},
+		"default layout, block": {
+			layout:   StagingPathLayoutDefault,
+			isBlock:  true,
+			expected: stagingTargetPath,
+		},
+		"layout not set": {
+			expected: stagingTargetPath,
+		},
+		"driver-volume layout, filesystem": {
+			layout:   StagingPathLayoutDriverVolume,
+			expected: stagingTargetPath + "/jiva.csi.openebs.io/pvc-1234",
+		},
+		"driver-volume layout, block": {
+			layout:   StagingPathLayoutDriverVolume,
+			isBlock:  true,
+			expected: stagingTargetPath,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := buildStagingPath(test.layout, driverName, stagingTargetPath, testVolumeID, test.isBlock)
+			if path != test.expected {
+				t.Fatalf("expected staging path %v, got: %v", test.expected, path)
+			}
+		})
+	}
+
+	if path := buildStagingPath(StagingPathLayoutDriverVolume, driverName, "", testVolumeID, false); path != "" {
+		t.Fatalf("expected empty staging path, got: %v", path)
+	}
+}
+
+func TestNodeGetInfoMaxVolumesPerNode(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+
+	for _, max := range []int64{DefaultMaxVolumesPerNode, 16, 0} {
+		ns, _, _ := newFakeNode(t, &testingexec.FakeExec{}, node.DeepCopy())
+		ns.driver.config.MaxVolumesPerNode = max
+
+		resp, err := ns.NodeGetInfo(context.TODO(), &csi.NodeGetInfoRequest{})
+		if err != nil {
+			t.Fatalf("expected node info, got err: %v", err)
+		}
+		// 0 is omitted from the response i.e no limit
+		if resp.GetMaxVolumesPerNode() != max {
+			t.Fatalf("expected max volumes per node %v, got: %v", max, resp.GetMaxVolumesPerNode())
+		}
+	}
+}
+
+func TestNodeGetInfoTopology(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node-1",
+		Labels: map[string]string{
+			corev1.LabelZoneFailureDomainStable: "zone-a",
+			corev1.LabelZoneRegionStable:        "region-a",
+		},
+	}}
+
+	tests := map[string]struct {
+		objs     []runtime.Object
+		expected map[string]string
+	}{
+		"zone and region of the node": {
+			objs: []runtime.Object{node},
+			expected: map[string]string{
+				"topology.jiva.openebs.io/nodeName": "node-1",
+				corev1.LabelZoneFailureDomainStable: "zone-a",
+				corev1.LabelZoneRegionStable:        "region-a",
+			},
+		},
+		// node can't be fetched, i.e the apiserver is unreachable
+		"node not found": {
+			expected: map[string]string{"topology.jiva.openebs.io/nodeName": "node-1"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ns, _, _ := newFakeNode(t, &testingexec.FakeExec{}, test.objs...)
+			ns.driver.config.TopologyKey = "topology.jiva.openebs.io/nodeName"
+
+			resp, err := ns.NodeGetInfo(context.TODO(), &csi.NodeGetInfoRequest{})
+			if err != nil {
+				t.Fatalf("expected node info, got err: %v", err)
+			}
+			if segments := resp.GetAccessibleTopology().GetSegments(); !reflect.DeepEqual(segments, test.expected) {
+				t.Fatalf("expected topology %v, got: %v", test.expected, segments)
+			}
+		})
+	}
+}
+
+// busyMounter fails the unmount as the mount is still in use
+type busyMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *busyMounter) Unmount(target string) error {
+	return fmt.Errorf("unmount failed: exit status 32\nUnmounting arguments: %s\nOutput: umount: %s: target is busy.", target, target)
+}
+
+func TestNodeUnpublishVolumeStaleTarget(t *testing.T) {
+	tests := map[string]struct {
+		create  bool
+		mounted bool
+		busy    bool
+		code    codes.Code
+		removed bool
+	}{
+		"target path is missing": {removed: true},
+		"target is not a mount":  {create: true, removed: true},
+		"target is mounted":      {create: true, mounted: true},
+		"target mount is busy":   {create: true, mounted: true, busy: true, code: codes.FailedPrecondition},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "node-unpublish")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			vol := newStagedJivaVolume(testVolumeID, "10.0.0.1")
+			target := filepath.Join(dir, "mount")
+			vol.Spec.MountInfo.TargetPath = target
+			ns, fakeMounter, fakeClient := newFakeNode(t, newFakeExec(nil), vol)
+			if test.create {
+				if err := os.Mkdir(target, 0750); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.mounted {
+				fakeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: target, Type: "ext4"}}
+			}
+			if test.busy {
+				ns.mounter.Interface = &busyMounter{FakeMounter: fakeMounter}
+			}
+
+			_, err = ns.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   testVolumeID,
+				TargetPath: target,
+			})
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+
+			if _, err := os.Stat(target); os.IsNotExist(err) != test.removed {
+				t.Fatalf("expected target path removed %v, got err: %v", test.removed, err)
+			}
+			if test.busy {
+				if len(fakeMounter.MountPoints) != 1 {
+					t.Fatalf("expected busy mount to be left in place, got: %v", fakeMounter.MountPoints)
+				}
+				return
+			}
+			if len(fakeMounter.MountPoints) != 0 {
+				t.Fatalf("expected nothing to be mounted, got: %v", fakeMounter.MountPoints)
+			}
+
+			instance := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, instance); err != nil {
+				t.Fatal(err)
+			}
+			if instance.Spec.MountInfo.TargetPath != "" {
+				t.Fatalf("expected target path to be cleared, got: %v", instance.Spec.MountInfo.TargetPath)
+			}
+		})
+	}
+}
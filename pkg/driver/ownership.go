@@ -0,0 +1,99 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chown changes the owner of the mount root, it is
+// replaced in the tests
+var chown = os.Chown
+
+// setVolumeOwnership changes the group of all the files in the
+// volume mounted at path to the given gid and makes them group
+// read-writable, directories get the setgid bit so that new
+// files inherit the group. It is same as the fsGroup handling
+// done by kubelet.
+func setVolumeOwnership(path, group string) error {
+	gid, err := strconv.Atoi(group)
+	if err != nil || gid < 0 {
+		return fmt.Errorf("invalid volume mount group {%s}, must be a non-negative integer", group)
+	}
+
+	logrus.Infof("Setting group {%d} on volume mounted at {%s}", gid, path)
+	return filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := os.Lchown(file, -1, gid); err != nil {
+			return fmt.Errorf("failed to change group of {%s}, err: {%v}", file, err)
+		}
+
+		// mode of the symlinks are not used
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		mode := info.Mode() | 0060
+		if info.IsDir() {
+			mode |= 0010 | os.ModeSetgid
+		}
+		if err := os.Chmod(file, mode); err != nil {
+			return fmt.Errorf("failed to change mode of {%s}, err: {%v}", file, err)
+		}
+		return nil
+	})
+}
+
+// setMountOwner changes the owner of the root directory of the volume
+// mounted at path to the uid and gid set in the volume context, the
+// files in the volume are not changed to keep the staging fast. Owner
+// is not changed if neither of them are set.
+func setMountOwner(path string, volumeContext map[string]string) error {
+	ids := map[string]int{ownerUIDKey: -1, ownerGIDKey: -1}
+	for key := range ids {
+		val, ok := volumeContext[key]
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(val)
+		if err != nil || id < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid {%v} {%v}, must be a non-negative integer", key, val)
+		}
+		ids[key] = id
+	}
+
+	uid, gid := ids[ownerUIDKey], ids[ownerGIDKey]
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	logrus.Infof("NodeStageVolume: setting owner {%d:%d} on volume mounted at {%s}", uid, gid, path)
+	if err := chown(path, uid, gid); err != nil {
+		return status.Errorf(codes.Internal, "Failed to change owner of {%s}, err: {%v}", path, err)
+	}
+	return nil
+}
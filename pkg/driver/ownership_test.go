@@ -0,0 +1,151 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSetMountOwner(t *testing.T) {
+	defer func(f func(string, int, int) error) { chown = f }(chown)
+
+	tests := map[string]struct {
+		volumeContext map[string]string
+		code          codes.Code
+		expected      []int
+	}{
+		"uid and gid": {
+			volumeContext: map[string]string{ownerUIDKey: "1001", ownerGIDKey: "2002"},
+			expected:      []int{1001, 2002},
+		},
+		"only uid": {
+			volumeContext: map[string]string{ownerUIDKey: "1001"},
+			expected:      []int{1001, -1},
+		},
+		"root": {
+			volumeContext: map[string]string{ownerUIDKey: "0", ownerGIDKey: "0"},
+			expected:      []int{0, 0},
+		},
+		"not set": {
+			volumeContext: map[string]string{mkfsOptionsKey: "-j"},
+		},
+		"negative gid": {
+			volumeContext: map[string]string{ownerUIDKey: "1001", ownerGIDKey: "-1"},
+			code:          codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var calls [][]int
+			chown = func(path string, uid, gid int) error {
+				if path != "/staging/pvc-1234" {
+					t.Errorf("expected owner of the mount root to be changed, got path: %v", path)
+				}
+				calls = append(calls, []int{uid, gid})
+				return nil
+			}
+
+			err := setMountOwner("/staging/pvc-1234", test.volumeContext)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if test.expected == nil {
+				if len(calls) != 0 {
+					t.Fatalf("expected owner to not be changed, got: %v", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || calls[0][0] != test.expected[0] || calls[0][1] != test.expected[1] {
+				t.Fatalf("expected chown %v once, got: %v", test.expected, calls)
+			}
+		})
+	}
+}
+
+func TestSetVolumeOwnership(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ownership")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	subDir := filepath.Join(dir, "data")
+	file := filepath.Join(subDir, "file")
+	if err := os.Mkdir(subDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(file, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// group of the test process can be set without privileges
+	if err := setVolumeOwnership(dir, strconv.Itoa(os.Getgid())); err != nil {
+		t.Fatalf("expected ownership to be set, got err: %v", err)
+	}
+
+	for path, expected := range map[string]os.FileMode{
+		dir:    0070 | os.ModeDir | os.ModeSetgid,
+		subDir: 0070 | os.ModeDir | os.ModeSetgid,
+		file:   0060,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&expected != expected {
+			t.Errorf("expected mode of {%v} to include %v, got: %v", path, expected, info.Mode())
+		}
+	}
+
+	if err := setVolumeOwnership(dir, "-1"); err == nil {
+		t.Fatal("expected negative group to fail")
+	}
+}
+
+func TestNodeGetCapabilitiesVolumeMountGroup(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		ns, _, _ := newFakeNode(t, nil)
+		ns.driver.config.EnableVolumeMountGroup = enabled
+
+		resp, err := ns.NodeGetCapabilities(context.TODO(), &csi.NodeGetCapabilitiesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		advertised := false
+		for _, cap := range resp.GetCapabilities() {
+			if cap.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+				advertised = true
+			}
+		}
+		if advertised != enabled {
+			t.Fatalf("expected VOLUME_MOUNT_GROUP advertised %v with the flag %v", enabled, enabled)
+		}
+	}
+}
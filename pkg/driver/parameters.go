@@ -0,0 +1,210 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// casTypeParam is set on the StorageClass to identify
+// the volume type, it is not used by the driver
+const casTypeParam = "cas-type"
+
+// PolicyLookup returns true if the JivaVolumePolicy of
+// the given name exists in the given namespace
+type PolicyLookup func(name, namespace string) (bool, error)
+
+// mutableParams are the parameters which can be changed
+// on an existing volume via ControllerModifyVolume
+var mutableParams = map[string]bool{
+	client.ReplicaCountAnnotation: true,
+	client.IOPSLimitAnnotation:    true,
+	client.BPSLimitAnnotation:     true,
+}
+
+// paramValidator returns an error describing the problem
+// with the given value of a StorageClass parameter
+type paramValidator func(val string) error
+
+var paramValidators = map[string]paramValidator{
+	client.ReplicaCountAnnotation:              intInRange(client.MinReplicaCount, client.MaxReplicaCount),
+	client.IOPSLimitAnnotation:                 intInRange(1, 0),
+	client.BPSLimitAnnotation:                  intInRange(1, 0),
+	client.NamespaceParam:                      isNamespace,
+	client.PolicyParam:                         isNotEmpty,
+	client.EncryptAnnotation:                   isBool,
+	client.EncryptionSecretNameAnnotation:      isNotEmpty,
+	client.EncryptionSecretNamespaceAnnotation: isNamespace,
+	client.UseDNSPortalAnnotation:              isBool,
+	client.TargetCPURequestParam:               isQuantity,
+	client.TargetMemoryRequestParam:            isQuantity,
+	client.TargetCPULimitParam:                 isQuantity,
+	client.TargetMemoryLimitParam:              isQuantity,
+	client.ReplicaZoneAntiAffinityParam: func(val string) error {
+		if val != client.ZoneAntiAffinityStrict && val != client.ZoneAntiAffinityPreferred {
+			return fmt.Errorf("must be %v or %v", client.ZoneAntiAffinityStrict, client.ZoneAntiAffinityPreferred)
+		}
+		return nil
+	},
+	client.ReplicaPoolsParam: func(val string) error {
+		_, err := parseReplicaPools(val)
+		return err
+	},
+	client.TargetNodeSelectorParam: func(val string) error {
+		_, err := client.ParseTargetNodeSelector(val)
+		return err
+	},
+	reservedBlocksKey: intInRange(0, maxReservedBlocksPercentage),
+	skipFormatKey:     isBool,
+	mkfsOptionsKey:    isMkfsOptions,
+	fsLabelKey:        isFSLabel,
+	ownerUIDKey:       intInRange(0, 0),
+	ownerGIDKey:       intInRange(0, 0),
+	mountPropagationKey: func(val string) error {
+		if _, err := getMountPropagation(map[string]string{mountPropagationKey: val}); err != nil {
+			return fmt.Errorf("must be one of None, HostToContainer or Bidirectional")
+		}
+		return nil
+	},
+}
+
+// intInRange validates that the value is an integer between
+// min and max, max of 0 means there is no upper bound
+func intInRange(min, max int64) paramValidator {
+	return func(val string) error {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < min || (max != 0 && n > max) {
+			if max == 0 {
+				return fmt.Errorf("must be an integer greater than or equal to %d", min)
+			}
+			return fmt.Errorf("must be an integer between %d and %d", min, max)
+		}
+		return nil
+	}
+}
+
+func isNamespace(val string) error {
+	if errs := validation.IsDNS1123Label(val); len(errs) != 0 {
+		return fmt.Errorf("must be a valid namespace: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func isBool(val string) error {
+	if _, err := strconv.ParseBool(val); err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	return nil
+}
+
+func isQuantity(val string) error {
+	if _, err := resource.ParseQuantity(val); err != nil {
+		return fmt.Errorf("must be a resource quantity i.e 500m or 1Gi")
+	}
+	return nil
+}
+
+func isNotEmpty(val string) error {
+	if val == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// ValidateParameters validates all the recognized StorageClass
+// parameters of CreateVolume and returns a single InvalidArgument
+// error listing every problem found. The policy referred in the
+// parameters is looked up using policyExists, if it is not nil.
+func ValidateParameters(params map[string]string, policyExists PolicyLookup) error {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	var unknown []string
+	for _, key := range keys {
+		validate, ok := paramValidators[key]
+		if !ok {
+			if key != casTypeParam && !strings.HasPrefix(key, "csi.storage.k8s.io/") {
+				unknown = append(unknown, key)
+			}
+			continue
+		}
+		if err := validate(params[key]); err != nil {
+			problems = append(problems, fmt.Sprintf("parameter {%v} has invalid value {%v}: %v", key, params[key], err))
+		}
+	}
+
+	if encrypt, _ := strconv.ParseBool(params[client.EncryptAnnotation]); encrypt && params[client.EncryptionSecretNameAnnotation] == "" {
+		problems = append(problems, fmt.Sprintf("parameter {%v} is required if {%v} is true",
+			client.EncryptionSecretNameAnnotation, client.EncryptAnnotation))
+	}
+
+	if len(unknown) != 0 {
+		logrus.Warningf("CreateVolume: ignoring unknown parameters {%v}", strings.Join(unknown, ", "))
+	}
+
+	// policy can't be looked up in an invalid namespace,
+	// the namespace is already reported above
+	policy, ns := params[client.PolicyParam], client.Namespace(params)
+	if policy != "" && policyExists != nil && isNamespace(ns) == nil {
+		exists, err := policyExists(policy, ns)
+		if err != nil {
+			return status.Errorf(codes.Internal, "Failed to get JivaVolumePolicy {%v/%v}, err: {%v}", ns, policy, err)
+		}
+		if !exists {
+			problems = append(problems, fmt.Sprintf("parameter {%v} refers to JivaVolumePolicy {%v/%v} which does not exist", client.PolicyParam, ns, policy))
+		}
+	}
+
+	if len(problems) != 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid StorageClass parameters: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ValidateMutableParameters validates the mutable parameters
+// of ControllerModifyVolume, an InvalidArgument error naming
+// the key is returned if any of them can't be modified
+func ValidateMutableParameters(params map[string]string) error {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !mutableParams[key] {
+			return status.Errorf(codes.InvalidArgument,
+				"Parameter {%v} can't be modified, mutable parameters are: %v, %v and %v", key,
+				client.ReplicaCountAnnotation, client.IOPSLimitAnnotation, client.BPSLimitAnnotation)
+		}
+	}
+	return ValidateParameters(params, nil)
+}
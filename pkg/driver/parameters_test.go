@@ -0,0 +1,168 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func fakePolicyLookup(policies ...string) PolicyLookup {
+	return func(name, namespace string) (bool, error) {
+		for _, p := range policies {
+			if p == namespace+"/"+name {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	tests := map[string]struct {
+		params   map[string]string
+		lookup   PolicyLookup
+		code     codes.Code
+		problems []string
+	}{
+		"valid parameters": {
+			params: map[string]string{
+				"cas-type":                         "jiva",
+				"policy":                           "example-jivavolumepolicy",
+				"jiva.openebs.io/replica-count":    "3",
+				"jiva.openebs.io/iops-limit":       "500",
+				"mountPropagation":                 "HostToContainer",
+				"csi.storage.k8s.io/pvc/name":      "pvc",
+				"csi.storage.k8s.io/pvc/namespace": "default",
+			},
+			lookup: fakePolicyLookup("openebs/example-jivavolumepolicy"),
+			code:   codes.OK,
+		},
+		"no parameters": {
+			params: nil,
+			lookup: fakePolicyLookup(),
+			code:   codes.OK,
+		},
+		"policy not looked up without lookup func": {
+			params: map[string]string{"policy": "example-jivavolumepolicy"},
+			code:   codes.OK,
+		},
+		"all problems are reported": {
+			params: map[string]string{
+				"jiva.openebs.io/replica-count": "7",
+				"jiva.openebs.io/bps-limit":     "-1",
+				"mountPropagation":              "Shared",
+				"policy":                        "missing-policy",
+			},
+			lookup: fakePolicyLookup("openebs/example-jivavolumepolicy"),
+			code:   codes.InvalidArgument,
+			problems: []string{
+				"jiva.openebs.io/replica-count",
+				"jiva.openebs.io/bps-limit",
+				"mountPropagation",
+				"openebs/missing-policy",
+			},
+		},
+		"non integer replica count": {
+			params:   map[string]string{"jiva.openebs.io/replica-count": "three"},
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva.openebs.io/replica-count"},
+		},
+		"non boolean encrypt": {
+			params:   map[string]string{"jiva.openebs.io/encrypt": "yes"},
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva.openebs.io/encrypt"},
+		},
+		"encryption secret is required": {
+			params:   map[string]string{"jiva.openebs.io/encrypt": "true"},
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva.openebs.io/encryption-secret-name"},
+		},
+		"malformed target node selector": {
+			params:   map[string]string{"jiva.openebs.io/target-node-selector": "node-group in fast"},
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva.openebs.io/target-node-selector"},
+		},
+		"unparseable target resources": {
+			params: map[string]string{
+				"jiva.openebs.io/target-cpu-request":  "500m",
+				"jiva.openebs.io/target-memory-limit": "1 GiB",
+			},
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva.openebs.io/target-memory-limit"},
+		},
+		"negative owner uid": {
+			params:   map[string]string{"ownerUID": "-1000", "ownerGID": "1000"},
+			code:     codes.InvalidArgument,
+			problems: []string{"ownerUID"},
+		},
+		"unsupported mkfs option": {
+			params:   map[string]string{"mkfsOptions": "-F -d su=64k"},
+			code:     codes.InvalidArgument,
+			problems: []string{"mkfsOptions"},
+		},
+		"filesystem label too long": {
+			params:   map[string]string{"fsLabel": "jiva-data-volume-1"},
+			code:     codes.InvalidArgument,
+			problems: []string{"fsLabel"},
+		},
+		"policy in a different namespace": {
+			params: map[string]string{
+				"policy":    "example-jivavolumepolicy",
+				"namespace": "jiva-ns",
+			},
+			lookup:   fakePolicyLookup("openebs/example-jivavolumepolicy"),
+			code:     codes.InvalidArgument,
+			problems: []string{"jiva-ns/example-jivavolumepolicy"},
+		},
+		"invalid namespace": {
+			params: map[string]string{
+				"policy":    "example-jivavolumepolicy",
+				"namespace": "Jiva_NS",
+			},
+			lookup:   fakePolicyLookup("openebs/example-jivavolumepolicy"),
+			code:     codes.InvalidArgument,
+			problems: []string{"namespace"},
+		},
+		"policy lookup failure": {
+			params: map[string]string{"policy": "example-jivavolumepolicy"},
+			lookup: func(name, namespace string) (bool, error) {
+				return false, errors.New("connection refused")
+			},
+			code: codes.Internal,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateParameters(test.params, test.lookup)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("expected err to report {%v}, got: %v", problem, err)
+				}
+			}
+		})
+	}
+}
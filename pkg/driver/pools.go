@@ -0,0 +1,332 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultReplicaCount is the replication factor of jiva-operator
+// assumed for a volume if neither the pvc, the StorageClass nor
+// its policy sets the replication factor
+const defaultReplicaCount = 3
+
+// unlimitedCapacity is reported by GetCapacity for the pools whose
+// capacity is not set, CreateVolume doesn't limit them either
+const unlimitedCapacity = math.MaxInt64
+
+// randIntn returns a random number in [0, n), it is
+// used for the weighted selection of the replica pool
+var randIntn = rand.Intn
+
+// replicaPool is a StorageClass in which the
+// replicas of a volume can be created
+type replicaPool struct {
+	name   string
+	weight int
+	// capacity is the total capacity of the
+	// pool in bytes, 0 means it is unlimited
+	capacity int64
+	// used is the capacity in bytes used by
+	// the replicas of the existing volumes
+	used int64
+}
+
+// parseReplicaPools parses the weighted list of the
+// replica pools i.e "ssd:3,hdd:1", weight is 1 if it
+// is not set for a pool
+func parseReplicaPools(val string) ([]replicaPool, error) {
+	var pools []replicaPool
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(val, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		pool := replicaPool{name: parts[0], weight: 1}
+		if pool.name == "" {
+			return nil, fmt.Errorf("pool name must not be empty")
+		}
+		if seen[pool.name] {
+			return nil, fmt.Errorf("pool {%v} is listed more than once", pool.name)
+		}
+		if len(parts) == 2 {
+			weight, err := strconv.Atoi(parts[1])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("weight {%v} of pool {%v} must be a positive integer", parts[1], pool.name)
+			}
+			pool.weight = weight
+		}
+		seen[pool.name] = true
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// pickReplicaPool chooses one of the pools which can fit the given
+// bytes with the probability proportional to its weight, false is
+// returned if none of the pools can fit it
+func pickReplicaPool(pools []replicaPool, requiredBytes int64) (string, bool) {
+	var candidates []replicaPool
+	total := 0
+	for _, pool := range pools {
+		if pool.capacity != 0 && pool.capacity-pool.used < requiredBytes {
+			logrus.Debugf("CreateVolume: pool {%v} can't fit {%v}, used {%v} of {%v}",
+				pool.name, utils.FormatCapacity(requiredBytes), utils.FormatCapacity(pool.used), utils.FormatCapacity(pool.capacity))
+			continue
+		}
+		candidates = append(candidates, pool)
+		total += pool.weight
+	}
+
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	n := randIntn(total)
+	for _, pool := range candidates {
+		if n < pool.weight {
+			return pool.name, true
+		}
+		n -= pool.weight
+	}
+	return candidates[len(candidates)-1].name, true
+}
+
+// effectiveReplicaCount returns the replication factor the volume is
+// created with, the given replica count set on the pvc or in the
+// StorageClass takes precedence over the one of the policy
+func (cs *controller) effectiveReplicaCount(req *csi.CreateVolumeRequest, replicaCount int) (int, error) {
+	if replicaCount != 0 {
+		return replicaCount, nil
+	}
+
+	count, err := cs.client.PolicyReplicaCount(req.GetParameters())
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		count = defaultReplicaCount
+	}
+	return count, nil
+}
+
+// selectReplicaPool chooses the replica pool for the volume from the
+// pools listed in the StorageClass parameters, honoring their weights
+// and the available capacity. Empty pool is returned if the pools are
+// not listed, ResourceExhausted if none of the pools can fit the volume.
+func (cs *controller) selectReplicaPool(req *csi.CreateVolumeRequest, replicaCount int) (string, error) {
+	val, ok := req.GetParameters()[client.ReplicaPoolsParam]
+	if !ok {
+		return "", nil
+	}
+
+	pools, err := parseReplicaPools(val)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "Invalid parameter {%v}, err: {%v}", client.ReplicaPoolsParam, err)
+	}
+
+	name := utils.StripName(req.GetName())
+	for i := range pools {
+		if _, err := cs.getPoolUsage(&pools[i], name); err != nil {
+			return "", err
+		}
+	}
+
+	requiredBytes := client.CapacityBytes(req) * int64(replicaCount)
+	pool, ok := pickReplicaPool(pools, requiredBytes)
+	if !ok {
+		return "", status.Errorf(codes.ResourceExhausted,
+			"CreateVolume: none of the replica pools {%v} has {%v} bytes available for {%v} replicas of volume {%v}",
+			val, requiredBytes, replicaCount, name)
+	}
+	return pool, nil
+}
+
+// checkReplicaPoolCapacity checks if the replica pool of the volume can
+// fit the given number of its replicas, a volume which is not created in
+// a pool or a pool whose capacity is not set always fits. ResourceExhausted
+// is returned if the pool can't fit them.
+func (cs *controller) checkReplicaPoolCapacity(instance *jv.JivaVolume, replicaCount int) error {
+	pool := replicaPool{name: instance.Labels[client.ReplicaPoolLabel]}
+	if pool.name == "" {
+		return nil
+	}
+
+	if _, err := cs.getPoolUsage(&pool, instance.Name); err != nil {
+		return err
+	}
+	if pool.capacity == 0 {
+		return nil
+	}
+
+	size, err := getCapacityBytes(instance.Spec.Capacity)
+	if err != nil {
+		return status.Errorf(codes.Internal, "ControllerModifyVolume: invalid capacity {%v} of volume {%v}, err: {%v}", instance.Spec.Capacity, instance.Name, err)
+	}
+	if requiredBytes := size * int64(replicaCount); pool.capacity-pool.used < requiredBytes {
+		return status.Errorf(codes.ResourceExhausted,
+			"ControllerModifyVolume: replica pool {%v} doesn't have {%v} bytes available for {%v} replicas of volume {%v}",
+			pool.name, requiredBytes, replicaCount, instance.Name)
+	}
+	return nil
+}
+
+// getPoolUsage sets the total capacity of the pool from its
+// StorageClass and the capacity used by the replicas of the
+// volumes in it, excluding the given volume so that a retried
+// CreateVolume isn't counted twice. StorageClass of the pool
+// is returned.
+func (cs *controller) getPoolUsage(pool *replicaPool, volumeName string) (*storagev1.StorageClass, error) {
+	sc, err := cs.client.GetStorageClass(pool.name)
+	if err != nil && errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.InvalidArgument, "StorageClass of replica pool {%v} does not exist", pool.name)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to get StorageClass of replica pool {%v}, err: {%v}", pool.name, err)
+	}
+
+	val, ok := sc.Annotations[client.PoolCapacityAnnotation]
+	if !ok {
+		return sc, nil
+	}
+	capacity, err := resource.ParseQuantity(val)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Invalid {%v} {%v} of replica pool {%v}, err: {%v}",
+			client.PoolCapacityAnnotation, val, pool.name, err)
+	}
+	pool.capacity = capacity.Value()
+
+	volumes, err := cs.client.ListJivaVolumeWithOpts(map[string]string{
+		client.ReplicaPoolLabel: pool.name,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to list volumes of replica pool {%v}, err: {%v}", pool.name, err)
+	}
+
+	for _, vol := range volumes.Items {
+		if vol.Name == volumeName {
+			continue
+		}
+		size, err := getCapacityBytes(vol.Spec.Capacity)
+		if err != nil {
+			logrus.Warningf("Skip volume {%v} in usage of pool {%v}, invalid capacity {%v}", vol.Name, pool.name, vol.Spec.Capacity)
+			continue
+		}
+		rf := vol.Spec.Policy.Target.ReplicationFactor
+		if rf == 0 {
+			rf = defaultReplicaCount
+		}
+		pool.used += size * int64(rf)
+	}
+	return sc, nil
+}
+
+// getPoolsCapacity returns the capacity available for the volumes of
+// the StorageClass with the given parameters in the topology segment,
+// and the size of the largest volume which can be created, all the
+// replicas of a volume are created in one pool. Pools whose capacity
+// is not set or a StorageClass without pools is unlimited.
+func (cs *controller) getPoolsCapacity(params map[string]string, segments map[string]string) (int64, int64, error) {
+	val, ok := params[client.ReplicaPoolsParam]
+	if !ok {
+		return unlimitedCapacity, unlimitedCapacity, nil
+	}
+
+	pools, err := parseReplicaPools(val)
+	if err != nil {
+		return 0, 0, status.Errorf(codes.InvalidArgument, "Invalid parameter {%v}, err: {%v}", client.ReplicaPoolsParam, err)
+	}
+
+	// pvc is not known, so the replica count
+	// of the StorageClass or its policy is used
+	req := &csi.CreateVolumeRequest{Parameters: params}
+	replicaCount, err := cs.client.ReplicaCount(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	if replicaCount, err = cs.effectiveReplicaCount(req, replicaCount); err != nil {
+		return 0, 0, err
+	}
+
+	var available, largest int64
+	for i := range pools {
+		sc, err := cs.getPoolUsage(&pools[i], "")
+		if err != nil {
+			return 0, 0, err
+		}
+		if !poolMatchesTopology(sc, segments) {
+			continue
+		}
+		if pools[i].capacity == 0 {
+			return unlimitedCapacity, unlimitedCapacity, nil
+		}
+
+		free := pools[i].capacity - pools[i].used
+		if free < 0 {
+			free = 0
+		}
+		available += free
+		if free > largest {
+			largest = free
+		}
+	}
+	return available / int64(replicaCount), largest / int64(replicaCount), nil
+}
+
+// poolMatchesTopology returns true if the replicas of the pool can be
+// created in the given topology segment i.e the allowed topologies
+// of its StorageClass match it, keys missing in the segment are ignored
+func poolMatchesTopology(sc *storagev1.StorageClass, segments map[string]string) bool {
+	if len(segments) == 0 || len(sc.AllowedTopologies) == 0 {
+		return true
+	}
+
+	for _, term := range sc.AllowedTopologies {
+		matches := true
+		for _, expr := range term.MatchLabelExpressions {
+			if val, ok := segments[expr.Key]; ok && !hasValue(expr.Values, val) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func hasValue(values []string, val string) bool {
+	for _, v := range values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider/volume/helpers"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newPoolStorageClass(name, capacity string) *storagev1.StorageClass {
+	sc := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: name},
+		Provisioner: "openebs.io/local",
+	}
+	if capacity != "" {
+		sc.Annotations = map[string]string{client.PoolCapacityAnnotation: capacity}
+	}
+	return sc
+}
+
+func TestParseReplicaPools(t *testing.T) {
+	pools, err := parseReplicaPools("ssd:3, hdd")
+	if err != nil {
+		t.Fatalf("expected pools to be parsed, got err: %v", err)
+	}
+
+	expected := []replicaPool{{name: "ssd", weight: 3}, {name: "hdd", weight: 1}}
+	if !reflect.DeepEqual(pools, expected) {
+		t.Fatalf("expected pools %+v, got: %+v", expected, pools)
+	}
+
+	for _, val := range []string{"", "ssd:0", "ssd:fast", "ssd,ssd:2", ":2"} {
+		if _, err := parseReplicaPools(val); err == nil {
+			t.Errorf("expected pools {%v} to be invalid", val)
+		}
+	}
+}
+
+func TestPickReplicaPool(t *testing.T) {
+	defer func(f func(int) int) { randIntn = f }(randIntn)
+
+	pools := []replicaPool{
+		{name: "ssd", weight: 3, capacity: 10 * helpers.GiB, used: 8 * helpers.GiB},
+		{name: "nvme", weight: 1},
+		{name: "hdd", weight: 2, capacity: 100 * helpers.GiB},
+	}
+
+	// ssd can't fit the volume, so the weights of nvme and
+	// hdd i.e [0, 1) and [1, 3) are used for the selection
+	for n, expected := range map[int]string{0: "nvme", 1: "hdd", 2: "hdd"} {
+		randIntn = func(total int) int {
+			if total != 3 {
+				t.Fatalf("expected total weight 3, got: %v", total)
+			}
+			return n
+		}
+		if pool, ok := pickReplicaPool(pools, 5*helpers.GiB); !ok || pool != expected {
+			t.Errorf("expected pool %v for %v, got: %v", expected, n, pool)
+		}
+	}
+
+	if _, ok := pickReplicaPool(pools[:1], 5*helpers.GiB); ok {
+		t.Fatal("expected no pool to fit the volume")
+	}
+}
+
+func TestEffectiveReplicaCount(t *testing.T) {
+	policy := &jv.JivaVolumePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "single-replica", Namespace: "openebs"},
+		Spec:       jv.JivaVolumePolicySpec{Target: jv.TargetSpec{ReplicationFactor: 1}},
+	}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:        "data-0",
+		Namespace:   "default",
+		Annotations: map[string]string{client.ReplicaCountAnnotation: "2"},
+	}}
+
+	tests := map[string]struct {
+		params   map[string]string
+		count    int
+		expected int
+		code     codes.Code
+	}{
+		"pvc overrides storageclass and policy": {
+			params: map[string]string{
+				client.PVCNameParam:           "data-0",
+				client.PVCNamespaceParam:      "default",
+				client.ReplicaCountAnnotation: "3",
+				client.PolicyParam:            "single-replica",
+			},
+			count:    2,
+			expected: 2,
+		},
+		"storageclass overrides policy": {
+			params:   map[string]string{client.ReplicaCountAnnotation: "3", client.PolicyParam: "single-replica"},
+			count:    3,
+			expected: 3,
+		},
+		"policy": {
+			params:   map[string]string{client.PolicyParam: "single-replica"},
+			expected: 1,
+		},
+		"jiva default": {
+			params:   map[string]string{},
+			expected: defaultReplicaCount,
+		},
+		"invalid storageclass count": {
+			params: map[string]string{client.ReplicaCountAnnotation: "three"},
+			code:   codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs, _ := newFakeController(t, policy, pvc)
+			req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+			req.Parameters = test.params
+
+			count, err := cs.client.ReplicaCount(req)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+			if count != test.count {
+				t.Fatalf("expected replica count %v, got: %v", test.count, count)
+			}
+
+			effective, err := cs.effectiveReplicaCount(req, count)
+			if err != nil || effective != test.expected {
+				t.Fatalf("expected effective replica count %v, got: %v, err: %v", test.expected, effective, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeReplicaPools(t *testing.T) {
+	used := newTestJivaVolume()
+	used.Name = "pvc-5678"
+	used.Labels = map[string]string{client.ReplicaPoolLabel: "ssd"}
+	used.Spec.Capacity = "10Gi"
+	used.Spec.Policy.Target.ReplicationFactor = 1
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-0", Namespace: "default"}}
+	cs, fakeClient := newFakeController(t,
+		newPoolStorageClass("ssd", "12Gi"),
+		newPoolStorageClass("hdd", "12Gi"),
+		used,
+		pvc,
+	)
+
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	req.Parameters = map[string]string{
+		client.ReplicaPoolsParam:      "ssd:3,hdd:1",
+		client.ReplicaCountAnnotation: "3",
+		client.PVCNameParam:           "data-0",
+		client.PVCNamespaceParam:      "default",
+	}
+
+	// 15Gi required for 3 replicas doesn't fit any of the pools
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got err: %v", err)
+	}
+
+	// replica count set on the pvc overrides the StorageClass
+	pvc.Annotations = map[string]string{client.ReplicaCountAnnotation: "1"}
+	if err := fakeClient.Update(context.TODO(), pvc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	// ssd has only 2Gi available
+	if vol.Spec.Policy.ReplicaSC != "hdd" || vol.Labels[client.ReplicaPoolLabel] != "hdd" {
+		t.Fatalf("expected replicas in pool hdd, got: %v", vol.Spec.Policy.ReplicaSC)
+	}
+	if rf := vol.Spec.Policy.Target.ReplicationFactor; rf != 1 {
+		t.Fatalf("expected replication factor 1, got: %v", rf)
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	used := newTestJivaVolume()
+	used.Name = "pvc-5678"
+	used.Labels = map[string]string{client.ReplicaPoolLabel: "ssd"}
+	used.Spec.Capacity = "10Gi"
+	used.Spec.Policy.Target.ReplicationFactor = 1
+
+	// replicas of hdd can only be created in zone-b
+	hdd := newPoolStorageClass("hdd", "12Gi")
+	hdd.AllowedTopologies = []corev1.TopologySelectorTerm{{
+		MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{
+			{Key: client.ZoneTopologyKey, Values: []string{"zone-b"}},
+		},
+	}}
+	cs, _ := newFakeController(t,
+		newPoolStorageClass("ssd", "20Gi"),
+		hdd,
+		newPoolStorageClass("nvme", ""),
+		used,
+	)
+
+	tests := map[string]struct {
+		params    map[string]string
+		segments  map[string]string
+		available int64
+		maxVolume int64
+	}{
+		"all segments": {
+			params:    map[string]string{client.ReplicaPoolsParam: "ssd,hdd", client.ReplicaCountAnnotation: "2"},
+			available: 11 * helpers.GiB,
+			maxVolume: 6 * helpers.GiB,
+		},
+		"pool not in the segment": {
+			params:    map[string]string{client.ReplicaPoolsParam: "ssd,hdd", client.ReplicaCountAnnotation: "2"},
+			segments:  map[string]string{client.ZoneTopologyKey: "zone-a"},
+			available: 5 * helpers.GiB,
+			maxVolume: 5 * helpers.GiB,
+		},
+		"default replica count": {
+			params:    map[string]string{client.ReplicaPoolsParam: "hdd"},
+			available: 4 * helpers.GiB,
+			maxVolume: 4 * helpers.GiB,
+		},
+		"pool without capacity": {
+			params:    map[string]string{client.ReplicaPoolsParam: "ssd,nvme"},
+			available: unlimitedCapacity,
+			maxVolume: unlimitedCapacity,
+		},
+		"no pools": {
+			available: unlimitedCapacity,
+			maxVolume: unlimitedCapacity,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := &csi.GetCapacityRequest{Parameters: test.params}
+			if test.segments != nil {
+				req.AccessibleTopology = &csi.Topology{Segments: test.segments}
+			}
+			resp, err := cs.GetCapacity(context.TODO(), req)
+			if err != nil {
+				t.Fatalf("expected capacity, got err: %v", err)
+			}
+			if resp.GetAvailableCapacity() != test.available || resp.GetMaximumVolumeSize().GetValue() != test.maxVolume {
+				t.Fatalf("expected available capacity %v and max volume size %v, got: %v and %v",
+					test.available, test.maxVolume, resp.GetAvailableCapacity(), resp.GetMaximumVolumeSize().GetValue())
+			}
+		})
+	}
+
+	// usage of a pool above its capacity is reported as full
+	full := newTestJivaVolume()
+	full.Name = "pvc-9999"
+	full.Labels = map[string]string{client.ReplicaPoolLabel: "full"}
+	full.Spec.Capacity = "10Gi"
+	cs, _ = newFakeController(t, newPoolStorageClass("full", "5Gi"), full)
+	resp, err := cs.GetCapacity(context.TODO(), &csi.GetCapacityRequest{Parameters: map[string]string{client.ReplicaPoolsParam: "full"}})
+	if err != nil || resp.GetAvailableCapacity() != 0 {
+		t.Fatalf("expected no capacity available, got: %v, err: %v", resp.GetAvailableCapacity(), err)
+	}
+}
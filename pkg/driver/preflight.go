@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	utilexec "k8s.io/utils/exec"
+)
+
+// iscsiErrISCSIDNotConn is the exit status
+// of iscsiadm if iscsid is not reachable
+const iscsiErrISCSIDNotConn = 20
+
+var (
+	// preflightModules are the kernel modules which
+	// must be loaded on the node to login to the targets
+	preflightModules = []string{"iscsi_tcp"}
+
+	// preflightUtilities are the executables used by the node
+	// plugin to attach, format, mount and resize the volumes
+	preflightUtilities = []string{
+		"iscsiadm", "mount", "umount", "blkid",
+		"mkfs.ext4", "mkfs.xfs", "resize2fs", "xfs_growfs",
+	}
+
+	// sysModuleDir has a directory for each of the loaded
+	// kernel modules, including the built-in ones
+	sysModuleDir = "/sys/module"
+)
+
+// PreflightResult is the result of one of the
+// checks run by the preflight subcommand
+type PreflightResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Details string `json:"details"`
+}
+
+// RunPreflight checks that the host dependencies of the node plugin
+// are in place, i.e iscsid is reachable, the iSCSI kernel modules are
+// loaded and the mount utilities are present. It doesn't need the
+// kube-apiserver or the grpc server and is run by the init container
+// of the node plugin before it registers with the kubelet.
+func RunPreflight(exec utilexec.Interface) []PreflightResult {
+	results := []PreflightResult{checkISCSID(exec)}
+	for _, m := range preflightModules {
+		results = append(results, checkKernelModule(m))
+	}
+	for _, u := range preflightUtilities {
+		results = append(results, checkUtility(exec, u))
+	}
+	return results
+}
+
+// checkISCSID lists the sessions which requires iscsiadm to
+// connect to iscsid, having no sessions is not a failure
+func checkISCSID(exec utilexec.Interface) PreflightResult {
+	result := PreflightResult{Name: "iscsid"}
+	out, err := exec.Command("iscsiadm", "-m", "session").CombinedOutput()
+	if err == nil {
+		result.Passed, result.Details = true, "reachable"
+		return result
+	}
+
+	exitErr, ok := err.(utilexec.ExitError)
+	switch {
+	case ok && exitErr.ExitStatus() == iscsiErrNoObjsFound:
+		result.Passed, result.Details = true, "reachable, no active sessions"
+	case ok && exitErr.ExitStatus() == iscsiErrISCSIDNotConn:
+		result.Details = fmt.Sprintf("not reachable, output: {%s}", strings.TrimSpace(string(out)))
+	default:
+		result.Details = fmt.Sprintf("failed to list sessions, err: {%v}, output: {%s}", err, strings.TrimSpace(string(out)))
+	}
+	return result
+}
+
+// checkKernelModule checks that the kernel module is loaded
+func checkKernelModule(module string) PreflightResult {
+	result := PreflightResult{Name: "kernel module " + module}
+	if _, err := os.Stat(filepath.Join(sysModuleDir, module)); err != nil {
+		result.Details = fmt.Sprintf("not loaded, err: {%v}", err)
+		return result
+	}
+	result.Passed, result.Details = true, "loaded"
+	return result
+}
+
+// checkUtility checks that the executable is in the PATH
+func checkUtility(exec utilexec.Interface, name string) PreflightResult {
+	result := PreflightResult{Name: name}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		result.Details = err.Error()
+		return result
+	}
+	result.Passed, result.Details = true, path
+	return result
+}
+
+// WritePreflightReport writes the results of the preflight checks to
+// out either as a pass/fail line for each check or as JSON, false is
+// returned if any of the checks failed
+func WritePreflightReport(out io.Writer, results []PreflightResult, asJSON bool) bool {
+	passed := true
+	for _, r := range results {
+		passed = passed && r.Passed
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			Passed bool              `json:"passed"`
+			Checks []PreflightResult `json:"checks"`
+		}{Passed: passed, Checks: results})
+		return passed
+	}
+
+	for _, r := range results {
+		if r.Passed {
+			fmt.Fprintf(out, "[PASS] %s: %s\n", r.Name, r.Details)
+		} else {
+			fmt.Fprintf(out, "[FAIL] %s: %s\n", r.Name, r.Details)
+		}
+	}
+	if passed {
+		fmt.Fprintln(out, "preflight check passed")
+	} else {
+		fmt.Fprintln(out, "preflight check failed")
+	}
+	return passed
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestRunPreflight(t *testing.T) {
+	defer func(dir string) { sysModuleDir = dir }(sysModuleDir)
+	dir, err := ioutil.TempDir("", "sys-module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sysModuleDir = dir
+
+	iscsidNotConn := func() ([]byte, []byte, error) {
+		return []byte("iscsiadm: can not connect to iSCSI daemon (111)!"), nil, &testingexec.FakeExitError{Status: iscsiErrISCSIDNotConn}
+	}
+	noSessions := func() ([]byte, []byte, error) {
+		return []byte("iscsiadm: No active sessions."), nil, &testingexec.FakeExitError{Status: iscsiErrNoObjsFound}
+	}
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds, iscsidNotConn, noSessions)
+	fakeExec.LookPathFunc = func(cmd string) (string, error) {
+		if cmd == "mkfs.xfs" {
+			return "", errors.New("executable file not found in $PATH")
+		}
+		return "/usr/sbin/" + cmd, nil
+	}
+
+	// iscsid is down, iscsi_tcp is not loaded and mkfs.xfs is missing
+	failed := map[string]bool{}
+	for _, r := range RunPreflight(fakeExec) {
+		if !r.Passed {
+			failed[r.Name] = true
+		}
+	}
+	expected := map[string]bool{"iscsid": true, "kernel module iscsi_tcp": true, "mkfs.xfs": true}
+	if len(failed) != len(expected) {
+		t.Fatalf("expected checks %v to fail, got: %v", expected, failed)
+	}
+	for name := range expected {
+		if !failed[name] {
+			t.Fatalf("expected check {%v} to fail, got: %v", name, failed)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "iscsi_tcp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fakeExec.LookPathFunc = func(cmd string) (string, error) { return "/usr/sbin/" + cmd, nil }
+
+	out := &bytes.Buffer{}
+	if !WritePreflightReport(out, RunPreflight(fakeExec), true) {
+		t.Fatalf("expected preflight to pass, got: %s", out.String())
+	}
+	report := struct {
+		Passed bool              `json:"passed"`
+		Checks []PreflightResult `json:"checks"`
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Passed || len(report.Checks) != 2+len(preflightUtilities) {
+		t.Fatalf("expected all the checks to pass, got: %+v", report)
+	}
+
+	out.Reset()
+	WritePreflightReport(out, []PreflightResult{{Name: "iscsid", Details: "not reachable"}}, false)
+	if !strings.Contains(out.String(), "[FAIL] iscsid: not reachable") || !strings.Contains(out.String(), "preflight check failed") {
+		t.Fatalf("expected failed report, got: %s", out.String())
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRoot is the mount point of the cgroup
+// hierarchy on the node
+var cgroupRoot = "/sys/fs/cgroup"
+
+// qosLimits are the io limits set on the
+// device of the volume, 0 means no limit
+type qosLimits struct {
+	iops int64
+	bps  int64
+}
+
+// getQoSLimits returns the io limits set on the JivaVolume CR
+func getQoSLimits(instance *jv.JivaVolume) (qosLimits, error) {
+	limits := qosLimits{}
+	for annotation, limit := range map[string]*int64{
+		client.IOPSLimitAnnotation: &limits.iops,
+		client.BPSLimitAnnotation:  &limits.bps,
+	} {
+		val, ok := instance.Annotations[annotation]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n <= 0 {
+			return qosLimits{}, fmt.Errorf("invalid value {%v} of %v, must be a positive integer", val, annotation)
+		}
+		*limit = n
+	}
+	return limits, nil
+}
+
+// applyQoSLimits throttles the io on the device using the blkio
+// controller on cgroup v1 or the io controller on cgroup v2, the
+// limits which are not set are cleared so that the limits of a
+// volume staged earlier on the device don't persist. If the
+// controller isn't available on the node, limits are skipped.
+func applyQoSLimits(devicePath string, limits qosLimits) error {
+	device, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve device {%s}, err: {%v}", devicePath, err)
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(device, &stat); err != nil {
+		return fmt.Errorf("failed to stat device {%s}, err: {%v}", device, err)
+	}
+	majMin := fmt.Sprintf("%d:%d", unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)))
+
+	// cgroup v2 exposes the controllers of the
+	// unified hierarchy in cgroup.controllers
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return applyIOMax(majMin, limits)
+	}
+	return applyBlkioThrottle(majMin, limits)
+}
+
+// clearQoSLimits removes the io limits set on the device, it
+// is skipped if the device is already removed from the node
+func clearQoSLimits(devicePath string) error {
+	if _, err := os.Stat(devicePath); os.IsNotExist(err) {
+		return nil
+	}
+	return applyQoSLimits(devicePath, qosLimits{})
+}
+
+// applyBlkioThrottle sets the limits in the blkio throttle files
+// of the root cgroup (cgroup v1), 0 removes the limit of a device
+func applyBlkioThrottle(majMin string, limits qosLimits) error {
+	blkio := filepath.Join(cgroupRoot, "blkio")
+	if _, err := os.Stat(blkio); err != nil {
+		if limits != (qosLimits{}) {
+			logrus.Warningf("QoS: blkio controller is not available, skip setting io limits on device {%s}, err: {%v}", majMin, err)
+		}
+		return nil
+	}
+
+	files := map[string]int64{
+		"blkio.throttle.read_iops_device":  limits.iops,
+		"blkio.throttle.write_iops_device": limits.iops,
+		"blkio.throttle.read_bps_device":   limits.bps,
+		"blkio.throttle.write_bps_device":  limits.bps,
+	}
+
+	for file, limit := range files {
+		path := filepath.Join(blkio, file)
+		logrus.Infof("QoS: setting {%s %d} in %s", majMin, limit, path)
+		if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("%s %d", majMin, limit)), 0644); err != nil {
+			return fmt.Errorf("failed to set io limit in %s, err: {%v}", path, err)
+		}
+	}
+	return nil
+}
+
+// applyIOMax sets the limits in io.max of the kubepods
+// cgroup (cgroup v2), io.max is not available on the
+// root cgroup. max removes the limit of a device.
+func applyIOMax(majMin string, limits qosLimits) error {
+	var ioMax string
+	for _, dir := range []string{"kubepods.slice", "kubepods"} {
+		path := filepath.Join(cgroupRoot, dir, "io.max")
+		if _, err := os.Stat(path); err == nil {
+			ioMax = path
+			break
+		}
+	}
+
+	if ioMax == "" {
+		if limits != (qosLimits{}) {
+			logrus.Warningf("QoS: io controller is not available for kubepods cgroup, skip setting io limits on device {%s}", majMin)
+		}
+		return nil
+	}
+
+	iops, bps := ioMaxLimit(limits.iops), ioMaxLimit(limits.bps)
+	value := fmt.Sprintf("%s riops=%s wiops=%s rbps=%s wbps=%s", majMin, iops, iops, bps, bps)
+
+	logrus.Infof("QoS: setting {%s} in %s", value, ioMax)
+	if err := ioutil.WriteFile(ioMax, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set io limit in %s, err: {%v}", ioMax, err)
+	}
+	return nil
+}
+
+// ioMaxLimit returns the value of the limit in io.max
+func ioMaxLimit(limit int64) string {
+	if limit == 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+)
+
+// qosTestDevice is a device which exists on every node, its
+// major:minor is 1:3
+const qosTestDevice = "/dev/null"
+
+// newTestCgroupRoot creates the given files under a temp
+// cgroup root and returns the root
+func newTestCgroupRoot(t *testing.T, files ...string) string {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root := cgroupRoot
+	cgroupRoot = dir
+	t.Cleanup(func() { cgroupRoot = root })
+	return dir
+}
+
+func readCgroupFile(t *testing.T, root, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join(root, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGetQoSLimits(t *testing.T) {
+	tests := map[string]struct {
+		annotations map[string]string
+		expected    qosLimits
+		fail        bool
+	}{
+		"no limits": {},
+		"iops and bps": {
+			annotations: map[string]string{client.IOPSLimitAnnotation: "100", client.BPSLimitAnnotation: "1048576"},
+			expected:    qosLimits{iops: 100, bps: 1048576},
+		},
+		"only bps": {
+			annotations: map[string]string{client.BPSLimitAnnotation: "1048576"},
+			expected:    qosLimits{bps: 1048576},
+		},
+		"zero iops": {
+			annotations: map[string]string{client.IOPSLimitAnnotation: "0"},
+			fail:        true,
+		},
+		"invalid bps": {
+			annotations: map[string]string{client.BPSLimitAnnotation: "1Mi"},
+			fail:        true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			vol := newTestJivaVolume()
+			vol.Annotations = test.annotations
+			limits, err := getQoSLimits(vol)
+			if (err != nil) != test.fail {
+				t.Fatalf("expected fail %v, got err: %v", test.fail, err)
+			}
+			if limits != test.expected {
+				t.Fatalf("expected limits %+v, got: %+v", test.expected, limits)
+			}
+		})
+	}
+}
+
+func TestApplyQoSLimitsBlkio(t *testing.T) {
+	root := newTestCgroupRoot(t,
+		"blkio/blkio.throttle.read_iops_device", "blkio/blkio.throttle.write_iops_device",
+		"blkio/blkio.throttle.read_bps_device", "blkio/blkio.throttle.write_bps_device",
+	)
+
+	if err := applyQoSLimits(qosTestDevice, qosLimits{iops: 100}); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]string{
+		"blkio/blkio.throttle.read_iops_device":  "1:3 100",
+		"blkio/blkio.throttle.write_iops_device": "1:3 100",
+		"blkio/blkio.throttle.read_bps_device":   "1:3 0",
+		"blkio/blkio.throttle.write_bps_device":  "1:3 0",
+	} {
+		if got := readCgroupFile(t, root, file); got != expected {
+			t.Fatalf("expected {%v} in %v, got: {%v}", expected, file, got)
+		}
+	}
+
+	if err := clearQoSLimits(qosTestDevice); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		"blkio/blkio.throttle.read_iops_device", "blkio/blkio.throttle.write_iops_device",
+	} {
+		if got := readCgroupFile(t, root, file); got != "1:3 0" {
+			t.Fatalf("expected limit in %v to be cleared, got: {%v}", file, got)
+		}
+	}
+}
+
+func TestApplyQoSLimitsIOMax(t *testing.T) {
+	root := newTestCgroupRoot(t, "cgroup.controllers", "kubepods.slice/io.max")
+
+	if err := applyQoSLimits(qosTestDevice, qosLimits{bps: 1048576}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1:3 riops=max wiops=max rbps=1048576 wbps=1048576"
+	if got := readCgroupFile(t, root, "kubepods.slice/io.max"); got != expected {
+		t.Fatalf("expected {%v} in io.max, got: {%v}", expected, got)
+	}
+
+	if err := clearQoSLimits(qosTestDevice); err != nil {
+		t.Fatal(err)
+	}
+	expected = "1:3 riops=max wiops=max rbps=max wbps=max"
+	if got := readCgroupFile(t, root, "kubepods.slice/io.max"); got != expected {
+		t.Fatalf("expected {%v} in io.max, got: {%v}", expected, got)
+	}
+}
+
+func TestApplyQoSLimitsNoController(t *testing.T) {
+	newTestCgroupRoot(t)
+	if err := applyQoSLimits(qosTestDevice, qosLimits{iops: 100}); err != nil {
+		t.Fatalf("expected limits to be skipped, got err: %v", err)
+	}
+}
+
+func TestClearQoSLimitsRemovedDevice(t *testing.T) {
+	newTestCgroupRoot(t, "blkio/blkio.throttle.read_iops_device")
+	if err := clearQoSLimits("/dev/disk/by-path/ip-10.0.0.1:3260-iscsi-iqn.2016-09.com.openebs.jiva:pvc-1-lun-0"); err != nil {
+		t.Fatalf("expected clearing to be skipped for a removed device, got err: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// registrationCheckInterval is the wait between the self-checks
+// of the node plugin while it waits to be ready to register
+var registrationCheckInterval = 5 * time.Second
+
+// waitForNodeReadiness runs the checks until all of them pass. The grpc
+// server of the node plugin is started only afterwards, so that the
+// node-driver-registrar which connects to its socket doesn't register
+// the plugin with the kubelet before iscsid is reachable. Error with
+// the failed checks is returned if they don't pass within the timeout.
+func waitForNodeReadiness(ctx context.Context, check func() []PreflightResult, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		failed := failedChecks(check())
+		if len(failed) == 0 {
+			logrus.Info("Registration: self-check passed, node plugin is ready to register")
+			return nil
+		}
+
+		logrus.Warningf("Registration: node plugin is not ready to register, failed checks: %v, retrying in %v",
+			failed, registrationCheckInterval)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("node plugin is not ready to register after %v, failed checks: %v", timeout, failed)
+		case <-time.After(registrationCheckInterval):
+		}
+	}
+}
+
+// failedChecks returns the names and details of the failed checks
+func failedChecks(results []PreflightResult) []string {
+	failed := []string{}
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, r.Details))
+		}
+	}
+	return failed
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestWaitForNodeReadiness(t *testing.T) {
+	defer func(interval time.Duration) { registrationCheckInterval = interval }(registrationCheckInterval)
+	registrationCheckInterval = 10 * time.Millisecond
+
+	// iscsid becomes reachable after a few checks
+	var checks int
+	check := func() []PreflightResult {
+		checks++
+		return []PreflightResult{
+			{Name: "iscsid", Passed: checks > 3, Details: "not reachable"},
+			{Name: "iscsiadm", Passed: true, Details: "/sbin/iscsiadm"},
+		}
+	}
+	if err := waitForNodeReadiness(context.TODO(), check, time.Minute); err != nil {
+		t.Fatalf("expected node plugin to be ready, got err: %v", err)
+	}
+	if checks != 4 {
+		t.Fatalf("expected readiness to wait for 4 checks, got: %d", checks)
+	}
+}
+
+func TestWaitForNodeReadinessTimeout(t *testing.T) {
+	defer func(interval time.Duration) { registrationCheckInterval = interval }(registrationCheckInterval)
+	registrationCheckInterval = 10 * time.Millisecond
+
+	check := func() []PreflightResult {
+		return []PreflightResult{
+			{Name: "iscsid", Passed: false, Details: "not reachable"},
+			{Name: "mkfs.xfs", Passed: true, Details: "/sbin/mkfs.xfs"},
+		}
+	}
+	start := time.Now()
+	err := waitForNodeReadiness(context.TODO(), check, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "iscsid: not reachable") || strings.Contains(err.Error(), "mkfs.xfs") {
+		t.Fatalf("expected node plugin to not be ready due to iscsid, got err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected readiness to give up after the timeout, took: %v", elapsed)
+	}
+}
@@ -17,17 +17,44 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	utilexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 )
 
+// exit codes of e2fsck, the codes are or'ed
+// together if multiple conditions are hit
+const (
+	fsckErrorsCorrected       = 1
+	fsckErrorsCorrectedReboot = 2
+)
+
+// errOfflineResizeRequired is returned if the filesystem can only be
+// resized while it is unmounted and offline resize is disabled
+var errOfflineResizeRequired = errors.New("filesystem requires offline resize, it is disabled by --online-expansion-only")
+
 type resizeInput struct {
 	volumePath   string
 	fsType       string
 	iqn          string
 	targetPortal string
-	exec         utilexec.Interface
+	// luksMapping is the name of the dm-crypt
+	// mapping of an encrypted volume
+	luksMapping string
+	// onlineOnly disables the resize of the
+	// filesystems which are not mounted
+	onlineOnly bool
+	exec       utilexec.Interface
 }
 
 func (r resizeInput) volume(list []mount.MountPoint) error {
@@ -37,22 +64,161 @@ func (r resizeInput) volume(list []mount.MountPoint) error {
 			if err != nil {
 				return err
 			}
-			switch r.fsType {
-			case "ext4":
-				err = r.resizeExt4(mpt.Device)
-			case "xfs":
-				err = r.resizeXFS(r.volumePath)
-			}
+
+			// filesystem present on the device is preferred
+			// over the one recorded while staging the volume
+			fsType, err := getFsType(r.exec, mpt.Device)
 			if err != nil {
 				return err
 			}
-			break
+			if fsType == "" {
+				fsType = r.fsType
+			}
+
+			logrus.Infof("Resize filesystem: {%s} on device: {%s} mounted at: {%s}", fsType, mpt.Device, r.volumePath)
+			switch fsType {
+			case FSTypeExt2, FSTypeExt3, FSTypeExt4:
+				err = r.resizeExt4(mpt.Device, true)
+			case FSTypeXfs:
+				err = r.resizeXFS(r.volumePath)
+			default:
+				err = fmt.Errorf("filesystem {%s} on device {%s} can't be expanded, no supported resize tool", fsType, mpt.Device)
+			}
+			return err
 		}
 	}
+	if r.onlineOnly {
+		return fmt.Errorf("volume path {%s} is not mounted: %w", r.volumePath, errOfflineResizeRequired)
+	}
+	return fmt.Errorf("volume path {%s} is not mounted", r.volumePath)
+}
+
+// resizeErrorCode returns the code of a failed resize, the resize
+// which requires offline handling is a FailedPrecondition so that
+// it is told apart from a failure of the resize tools
+func resizeErrorCode(err error) codes.Code {
+	if errors.Is(err, errOfflineResizeRequired) {
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// resizeUnmountedVolume grows the ext filesystem of a volume which was
+// expanded while it was not published to any node, it is called by
+// NodeStageVolume before the device is mounted on the staging path.
+// resize2fs refuses to resize an unmounted filesystem which is not
+// checked, so e2fsck is run first. Volume with --online-expansion-only,
+// any other filesystem or the one already mounted is left to
+// resizeStagedVolume which resizes it online once it is mounted.
+func (ns *node) resizeUnmountedVolume(instance *jv.JivaVolume, devicePath, stagingPath, portal string) error {
+	size := instance.Annotations[client.ResizePendingAnnotation]
+	if size == "" || ns.driver.config.OnlineExpansionOnly {
+		return nil
+	}
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to check if staging path {%s} is mounted, err: {%v}", stagingPath, err)
+	}
+	if err == nil && !notMnt {
+		return nil
+	}
+
+	fsType, err := getFsType(ns.mounter.Exec, devicePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to detect filesystem on device {%s}, err: {%v}", devicePath, err)
+	}
+	if fsType != FSTypeExt2 && fsType != FSTypeExt3 && fsType != FSTypeExt4 {
+		return nil
+	}
+
+	logrus.Infof("NodeStageVolume: volume {%v} was expanded to {%v} bytes while offline, resizing it before mount", instance.Name, size)
+	resize := resizeInput{
+		iqn:          instance.Spec.ISCSISpec.Iqn,
+		targetPortal: portal,
+		exec:         ns.mounter.Exec,
+	}
+	if isEncrypted(instance) {
+		resize.luksMapping = filepath.Base(luksMapperPath(instance))
+	}
+	if err := resize.reScan(); err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to rescan volume {%v}, err: {%v}", instance.Name, err)
+	}
+	if err := resize.resizeExt4(devicePath, false); err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to resize volume {%v}, err: {%v}", instance.Name, err)
+	}
+
+	delete(instance.Annotations, client.ResizePendingAnnotation)
+	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to clear pending resize of volume {%v}, err: {%v}", instance.Name, err)
+	}
 	return nil
 }
 
-// ReScan rescans all the iSCSI sessions on the host
+// resizeStagedVolume grows the filesystem of a volume which was
+// expanded while it was not published to any node, it is called by
+// NodeStageVolume once the volume is mounted on the staging path.
+// Pending resize is cleared from the JivaVolume CR only once the
+// filesystem is resized, so a failed resize is retried on next stage.
+func (ns *node) resizeStagedVolume(instance *jv.JivaVolume, stagingPath, portal string, isBlock bool) error {
+	size := instance.Annotations[client.ResizePendingAnnotation]
+	if size == "" {
+		return nil
+	}
+
+	logrus.Infof("NodeStageVolume: volume {%v} was expanded to {%v} bytes while offline, resizing it", instance.Name, size)
+	resize := resizeInput{
+		volumePath:   stagingPath,
+		fsType:       instance.Spec.MountInfo.FSType,
+		iqn:          instance.Spec.ISCSISpec.Iqn,
+		targetPortal: portal,
+		onlineOnly:   ns.driver.config.OnlineExpansionOnly,
+		exec:         ns.mounter.Exec,
+	}
+	if isEncrypted(instance) {
+		resize.luksMapping = filepath.Base(luksMapperPath(instance))
+	}
+
+	// device of a raw block volume only needs to be rescanned
+	var err error
+	if isBlock {
+		err = resize.reScan()
+	} else {
+		var list []mount.MountPoint
+		if list, err = ns.mounter.List(); err == nil {
+			err = resize.volume(list)
+		}
+	}
+	if err != nil {
+		return status.Errorf(resizeErrorCode(err), "NodeStageVolume: failed to resize volume {%v}, err: {%v}", instance.Name, err)
+	}
+
+	delete(instance.Annotations, client.ResizePendingAnnotation)
+	if err := ns.client.UpdateJivaVolume(instance); err != nil {
+		return status.Errorf(codes.Internal, "NodeStageVolume: failed to clear pending resize of volume {%v}, err: {%v}", instance.Name, err)
+	}
+	return nil
+}
+
+// getFsType detects the filesystem present on the device,
+// empty string is returned if no filesystem is found
+func getFsType(exec utilexec.Interface, device string) (string, error) {
+	out, err := exec.Command("blkid", "-p", "-s", "TYPE", "-o", "value", device).CombinedOutput()
+	if err != nil {
+		// blkid exits with status 2 if the
+		// device doesn't have any filesystem
+		if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.ExitStatus() == 2 {
+			return "", nil
+		}
+		logrus.Errorf("blkid failed for device: {%s}, error: %s", device, string(out))
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ReScan rescans all the iSCSI sessions on the host, LUKS
+// mapping of an encrypted volume is resized to the new size
+// of the device
 func (r resizeInput) reScan() error {
 	logrus.Info("Rescan ISCSI session")
 	out, err := r.exec.Command("iscsiadm", "-m", "node", "-T", r.iqn, "-P", r.targetPortal, "--rescan").CombinedOutput()
@@ -60,12 +226,30 @@ func (r resizeInput) reScan() error {
 		logrus.Errorf("iscsi: rescan failed error: %s", string(out))
 		return err
 	}
+
+	if r.luksMapping != "" {
+		logrus.Infof("Resize LUKS mapping: {%s}", r.luksMapping)
+		out, err := r.exec.Command(cryptsetupCmd, "resize", r.luksMapping).CombinedOutput()
+		if err != nil {
+			logrus.Errorf("cryptsetup: resize failed error: %s", string(out))
+			return err
+		}
+	}
 	return nil
 }
 
 // ResizeExt4 can be used to run a resize command on the ext4 filesystem
-// to expand the filesystem to the actual size of the device
-func (r resizeInput) resizeExt4(path string) error {
+// to expand the filesystem to the actual size of the device. resize2fs
+// refuses to resize an unmounted filesystem which is not checked, so
+// e2fsck is run before it. Mounted filesystem is resized online which
+// doesn't need the check, e2fsck can't be run on it anyway.
+func (r resizeInput) resizeExt4(path string, mounted bool) error {
+	if !mounted {
+		if err := checkExtFilesystem(r.exec, path); err != nil {
+			return err
+		}
+	}
+
 	out, err := r.exec.Command("resize2fs", path).CombinedOutput()
 	if err != nil {
 		logrus.Errorf("iscsi: resize failed error: %s", string(out))
@@ -74,6 +258,30 @@ func (r resizeInput) resizeExt4(path string) error {
 	return nil
 }
 
+// checkExtFilesystem runs a non-interactive e2fsck on the device, the
+// errors which are corrected by e2fsck are not treated as a failure
+func checkExtFilesystem(exec utilexec.Interface, device string) error {
+	logrus.Infof("Check filesystem on device: {%s} before resize", device)
+	out, err := exec.Command("e2fsck", "-f", "-p", device).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	exitErr, ok := err.(utilexec.ExitError)
+	if !ok {
+		return fmt.Errorf("e2fsck failed on device {%s}, err: {%v}, output: {%s}", device, err, string(out))
+	}
+
+	switch exitErr.ExitStatus() {
+	case fsckErrorsCorrected, fsckErrorsCorrectedReboot, fsckErrorsCorrected | fsckErrorsCorrectedReboot:
+		logrus.Warningf("e2fsck corrected errors on device: {%s}, output: %s", device, string(out))
+		return nil
+	default:
+		return fmt.Errorf("e2fsck found uncorrected errors on device {%s}, exit status: {%d}, output: {%s}",
+			device, exitErr.ExitStatus(), string(out))
+	}
+}
+
 // ResizeXFS can be used to run a resize command on the xfs filesystem
 // to expand the filesystem to the actual size of the device
 func (r resizeInput) resizeXFS(path string) error {
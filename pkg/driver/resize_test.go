@@ -0,0 +1,344 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"k8s.io/cloud-provider/volume/helpers"
+	testingexec "k8s.io/utils/exec/testing"
+	"k8s.io/utils/mount"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func fsckExitStatus(status int) testingexec.FakeAction {
+	return func() ([]byte, []byte, error) {
+		return []byte("e2fsck output"), nil, &testingexec.FakeExitError{Status: status}
+	}
+}
+
+func TestCheckExtFilesystem(t *testing.T) {
+	tests := map[string]struct {
+		action testingexec.FakeAction
+		ok     bool
+	}{
+		"no errors":                    {action: success, ok: true},
+		"errors corrected":             {action: fsckExitStatus(1), ok: true},
+		"errors corrected, reboot":     {action: fsckExitStatus(2), ok: true},
+		"errors corrected, both flags": {action: fsckExitStatus(3), ok: true},
+		"errors left uncorrected":      {action: fsckExitStatus(4), ok: false},
+		"corrected and uncorrected":    {action: fsckExitStatus(5), ok: false},
+		"operational error":            {action: fsckExitStatus(8), ok: false},
+		"usage error":                  {action: fsckExitStatus(16), ok: false},
+		"cancelled by user":            {action: fsckExitStatus(32), ok: false},
+		"e2fsck not found": {
+			action: func() ([]byte, []byte, error) {
+				return nil, nil, errors.New("executable file not found in $PATH")
+			},
+			ok: false,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			err := checkExtFilesystem(newFakeExec(&cmds, test.action), "/dev/sdb")
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %v, got err: %v", test.ok, err)
+			}
+
+			expected := [][]string{{"e2fsck", "-f", "-p", "/dev/sdb"}}
+			if !reflect.DeepEqual(cmds, expected) {
+				t.Fatalf("expected commands %v, got: %v", expected, cmds)
+			}
+		})
+	}
+}
+
+func TestResizeExt4(t *testing.T) {
+	tests := map[string]struct {
+		mounted      bool
+		onlineOnly   bool
+		actions      []testingexec.FakeAction
+		ok           bool
+		expectedCmds [][]string
+	}{
+		"mounted filesystem is not checked": {
+			mounted:      true,
+			actions:      []testingexec.FakeAction{success},
+			ok:           true,
+			expectedCmds: [][]string{{"resize2fs", "/dev/sdb"}},
+		},
+		"unmounted filesystem is checked": {
+			actions: []testingexec.FakeAction{fsckExitStatus(1), success},
+			ok:      true,
+			expectedCmds: [][]string{
+				{"e2fsck", "-f", "-p", "/dev/sdb"},
+				{"resize2fs", "/dev/sdb"},
+			},
+		},
+		"uncorrected errors skip resize": {
+			actions:      []testingexec.FakeAction{fsckExitStatus(4)},
+			ok:           false,
+			expectedCmds: [][]string{{"e2fsck", "-f", "-p", "/dev/sdb"}},
+		},
+		"mounted filesystem is resized if online only": {
+			mounted:      true,
+			onlineOnly:   true,
+			actions:      []testingexec.FakeAction{success},
+			ok:           true,
+			expectedCmds: [][]string{{"resize2fs", "/dev/sdb"}},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			r := resizeInput{onlineOnly: test.onlineOnly, exec: newFakeExec(&cmds, test.actions...)}
+			err := r.resizeExt4("/dev/sdb", test.mounted)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %v, got err: %v", test.ok, err)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func TestResizeVolumeOnlineOnly(t *testing.T) {
+	mounts := []mount.MountPoint{{Device: "/dev/sdb", Path: "/var/lib/kubelet/plugins/staging/pvc-1234", Type: "xfs"}}
+	tests := map[string]struct {
+		volumePath   string
+		onlineOnly   bool
+		code         codes.Code
+		expectedCmds [][]string
+	}{
+		"xfs is resized online": {
+			volumePath: "/var/lib/kubelet/plugins/staging/pvc-1234",
+			onlineOnly: true,
+			code:       codes.OK,
+			expectedCmds: [][]string{
+				{"iscsiadm", "-m", "node", "-T", "iqn", "-P", "10.0.0.1:3260", "--rescan"},
+				{"blkid", "-p", "-s", "TYPE", "-o", "value", "/dev/sdb"},
+				{"xfs_growfs", "/var/lib/kubelet/plugins/staging/pvc-1234"},
+			},
+		},
+		"unmounted volume requires offline resize": {
+			volumePath: "/var/lib/kubelet/plugins/staging/pvc-5678",
+			onlineOnly: true,
+			code:       codes.FailedPrecondition,
+		},
+		"unmounted volume without online only": {
+			volumePath: "/var/lib/kubelet/plugins/staging/pvc-5678",
+			code:       codes.Internal,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var cmds [][]string
+			r := resizeInput{
+				volumePath:   test.volumePath,
+				iqn:          "iqn",
+				targetPortal: "10.0.0.1:3260",
+				onlineOnly:   test.onlineOnly,
+				exec: newFakeExec(&cmds, success, func() ([]byte, []byte, error) {
+					return []byte("xfs\n"), nil, nil
+				}, success),
+			}
+
+			code := codes.OK
+			if err := r.volume(mounts); err != nil {
+				code = resizeErrorCode(err)
+			}
+			if code != test.code {
+				t.Fatalf("expected code %v, got: %v", test.code, code)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+		})
+	}
+}
+
+func TestOfflineExpandThenStage(t *testing.T) {
+	defer func(retries int, port string) {
+		MaxRetryCount, jivaTargetPort = retries, port
+	}(MaxRetryCount, jivaTargetPort)
+	MaxRetryCount = 1
+
+	var resized bool
+	server, targetIP := newFakeJivaTarget(t, &resized)
+	defer server.Close()
+
+	getVolume := func(c ctrlclient.Client) *jv.JivaVolume {
+		vol := &jv.JivaVolume{}
+		if err := c.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+			t.Fatal(err)
+		}
+		return vol
+	}
+
+	// resize of a published volume is done by NodeExpandVolume
+	published := newReadyJivaVolume("5Gi", targetIP)
+	published.Annotations = map[string]string{publishedNodeAnnotation: "node-1"}
+	cs, fakeClient := newFakeController(t, published)
+	if _, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(10*helpers.GiB)); err != nil {
+		t.Fatalf("expected success, got err: %v", err)
+	}
+	if size, ok := getVolume(fakeClient).Annotations[client.ResizePendingAnnotation]; ok {
+		t.Fatalf("expected no pending resize of published volume, got: %v", size)
+	}
+
+	cs, fakeClient = newFakeController(t, newReadyJivaVolume("5Gi", targetIP))
+	resp, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(10*helpers.GiB))
+	if err != nil {
+		t.Fatalf("expected offline volume to be expanded, got err: %v", err)
+	}
+	if !resized || !resp.GetNodeExpansionRequired() {
+		t.Fatalf("expected target to be resized and node expansion to be required, got: %+v", resp)
+	}
+	vol := getVolume(fakeClient)
+	if size := vol.Annotations[client.ResizePendingAnnotation]; size != "10737418240" {
+		t.Fatalf("expected pending resize to 10737418240 bytes, got: %v", size)
+	}
+
+	// volume is staged again once the pod is started
+	staging := "/var/lib/kubelet/plugins/staging/" + testVolumeID
+	vol.ResourceVersion = ""
+	vol.Spec.MountInfo.FSType = "ext4"
+	vol.Spec.MountInfo.DevicePath = "/dev/sdb"
+	vol.Spec.MountInfo.StagingPath = staging
+	var cmds [][]string
+	ns, fakeMounter, nodeClient := newFakeNode(t, expandVolumeCmds(&cmds), vol)
+	fakeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: staging, Type: "ext4"}}
+
+	vol = getVolume(nodeClient)
+	if err := ns.resizeStagedVolume(vol, staging, targetIP+":3260", false); err != nil {
+		t.Fatalf("expected filesystem to be resized, got err: %v", err)
+	}
+	expected := [][]string{
+		{"iscsiadm", "-m", "node", "-T", vol.Spec.ISCSISpec.Iqn, "-P", targetIP + ":3260", "--rescan"},
+		{"blkid", "-p", "-s", "TYPE", "-o", "value", "/dev/sdb"},
+		{"resize2fs", "/dev/sdb"},
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("expected commands %v, got: %v", expected, cmds)
+	}
+	if size, ok := getVolume(nodeClient).Annotations[client.ResizePendingAnnotation]; ok {
+		t.Fatalf("expected pending resize to be cleared, got: %v", size)
+	}
+
+	// filesystem isn't resized again on next stage
+	if err := ns.resizeStagedVolume(getVolume(nodeClient), staging, targetIP+":3260", false); err != nil || len(cmds) != 3 {
+		t.Fatalf("expected no resize without pending resize, got err: %v, commands: %v", err, cmds)
+	}
+}
+
+func TestResizeUnmountedVolume(t *testing.T) {
+	blkid := func(fsType string) testingexec.FakeAction {
+		return func() ([]byte, []byte, error) {
+			return []byte(fsType + "\n"), nil, nil
+		}
+	}
+	portal := "10.0.0.1:3260"
+	rescan := []string{"iscsiadm", "-m", "node", "-T", "iqn", "-P", portal, "--rescan"}
+	probe := []string{"blkid", "-p", "-s", "TYPE", "-o", "value", "/dev/sdb"}
+
+	tests := map[string]struct {
+		onlineOnly   bool
+		mounted      bool
+		actions      []testingexec.FakeAction
+		fail         bool
+		pending      bool
+		expectedCmds [][]string
+	}{
+		"ext4 is checked and resized": {
+			actions:      []testingexec.FakeAction{blkid("ext4"), success, fsckExitStatus(1), success},
+			expectedCmds: [][]string{probe, rescan, {"e2fsck", "-f", "-p", "/dev/sdb"}, {"resize2fs", "/dev/sdb"}},
+		},
+		"uncorrected errors keep the resize pending": {
+			actions:      []testingexec.FakeAction{blkid("ext4"), success, fsckExitStatus(4)},
+			fail:         true,
+			pending:      true,
+			expectedCmds: [][]string{probe, rescan, {"e2fsck", "-f", "-p", "/dev/sdb"}},
+		},
+		"xfs is resized after mount": {
+			actions:      []testingexec.FakeAction{blkid("xfs")},
+			pending:      true,
+			expectedCmds: [][]string{probe},
+		},
+		"online only is resized after mount": {
+			onlineOnly: true,
+			pending:    true,
+		},
+		"mounted volume is resized online": {
+			mounted: true,
+			pending: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			staging, err := ioutil.TempDir("", "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(staging)
+
+			vol := newTestJivaVolume()
+			vol.Annotations = map[string]string{client.ResizePendingAnnotation: "10737418240"}
+			vol.Spec.ISCSISpec.Iqn = "iqn"
+
+			var cmds [][]string
+			ns, fakeMounter, fakeClient := newFakeNode(t, newFakeExec(&cmds, test.actions...), vol)
+			ns.driver.config.OnlineExpansionOnly = test.onlineOnly
+			if test.mounted {
+				fakeMounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: staging, Type: "ext4"}}
+			}
+
+			instance := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, instance); err != nil {
+				t.Fatal(err)
+			}
+			err = ns.resizeUnmountedVolume(instance, "/dev/sdb", staging, portal)
+			if (err != nil) != test.fail {
+				t.Fatalf("expected fail %v, got err: %v", test.fail, err)
+			}
+			if !reflect.DeepEqual(cmds, test.expectedCmds) {
+				t.Fatalf("expected commands %v, got: %v", test.expectedCmds, cmds)
+			}
+
+			got := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, got); err != nil {
+				t.Fatal(err)
+			}
+			if _, pending := got.Annotations[client.ResizePendingAnnotation]; pending != test.pending {
+				t.Fatalf("expected resize pending %v, got annotations: %v", test.pending, got.Annotations)
+			}
+		})
+	}
+}
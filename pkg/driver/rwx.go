@@ -0,0 +1,209 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/request"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// nfsServerKey is the key of the volume context with the cluster
+	// IP of the NFS server of a ReadWriteMany volume, node plugin
+	// mounts the NFS share instead of logging in to the jiva target
+	// if it is set
+	nfsServerKey = "nfsServer"
+
+	// nfsExport is the path of the share exported by the NFS
+	// server, it is the NFSv4 root of the server
+	nfsExport = "/"
+
+	// DefaultNFSServerImage is the image of the NFS server
+	// which exports the ReadWriteMany volumes
+	DefaultNFSServerImage = "openebs/nfs-server-alpine:0.9.0"
+)
+
+// isRWXAccessMode returns true if the capability is ReadWriteMany
+func isRWXAccessMode(volCap *csi.VolumeCapability) bool {
+	return volCap.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+}
+
+// isRWXRequest returns true if ReadWriteMany is one
+// of the requested capabilities of the volume
+func isRWXRequest(volCaps []*csi.VolumeCapability) bool {
+	for _, c := range volCaps {
+		if isRWXAccessMode(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionNFSServer creates the NFS server which exports the jiva
+// volume to the nodes and returns the volume context keys used by the
+// node plugin to mount it. Unavailable is returned until the target
+// of the volume is ready, the provisioner retries CreateVolume.
+func (cs *controller) provisionNFSServer(req *csi.CreateVolumeRequest) (map[string]string, error) {
+	volumeID := utils.StripName(req.GetName())
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTargetReady(instance) {
+		return nil, status.Errorf(codes.Unavailable,
+			"CreateVolume: target of ReadWriteMany volume {%v} is not ready, nfs server is created once it is ready", volumeID)
+	}
+
+	fsType := cs.driver.config.DefaultFSType
+	for _, c := range req.GetVolumeCapabilities() {
+		if t := c.GetMount().GetFsType(); t != "" {
+			fsType = t
+		}
+	}
+	if fsType == "" {
+		fsType = FSTypeExt4
+	}
+
+	svc, err := cs.client.CreateNFSServer(instance, cs.nfsServerImage(), fsType)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "CreateVolume: failed to create nfs server of volume {%v}, err: {%v}", volumeID, err)
+	}
+	if svc.Spec.ClusterIP == "" {
+		return nil, status.Errorf(codes.Unavailable, "CreateVolume: cluster IP of nfs server of volume {%v} is not allocated yet", volumeID)
+	}
+
+	name := client.NFSServerName(instance.Name)
+	if instance.Annotations[client.NFSServerAnnotation] != name {
+		if instance.Annotations == nil {
+			instance.Annotations = map[string]string{}
+		}
+		instance.Annotations[client.NFSServerAnnotation] = name
+		if err := cs.client.UpdateJivaVolume(instance); err != nil {
+			return nil, status.Errorf(codes.Internal, "CreateVolume: failed to update volume {%v}, err: {%v}", volumeID, err)
+		}
+	}
+
+	logrus.Infof("CreateVolume: volume {%v} is exported by nfs server {%v} at {%v}", volumeID, name, svc.Spec.ClusterIP)
+	return map[string]string{nfsServerKey: svc.Spec.ClusterIP}, nil
+}
+
+// nfsServerImage returns the image of the NFS server set via
+// flags, the default image is used if it is not set
+func (cs *controller) nfsServerImage() string {
+	if image := cs.driver.config.NFSServerImage; image != "" {
+		return image
+	}
+	return DefaultNFSServerImage
+}
+
+// deleteNFSServer deletes the NFS server of a ReadWriteMany
+// volume, it is a no-op for the other volumes
+func (cs *controller) deleteNFSServer(volumeID string) error {
+	instance, err := cs.client.GetJivaVolume(volumeID)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name := instance.Annotations[client.NFSServerAnnotation]
+	if name == "" {
+		return nil
+	}
+	return cs.client.DeleteNFSServer(name, instance.Namespace)
+}
+
+// nodeStageRWX validates the staging request of a ReadWriteMany
+// volume, the NFS share is mounted directly at the target path
+// by NodePublishVolume so there is nothing to stage
+func (ns *node) nodeStageRWX(req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not provided")
+	}
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}, ns.driver.config.EnableRWX) {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
+	}
+	if err := ns.validateMountOptions(volCap.GetMount().GetMountFlags()); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("NodeStageVolume: volume {%q} is exported by nfs server {%v}, nothing to stage", req.GetVolumeId(), req.GetVolumeContext()[nfsServerKey])
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// nodePublishRWX mounts the NFS share of a ReadWriteMany
+// volume at the target path
+func (ns *node) nodePublishRWX(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	target := req.GetTargetPath()
+	if len(target) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Target path not provided")
+	}
+
+	volCap := req.GetVolumeCapability()
+	if volCap == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not provided")
+	}
+	if !isValidVolumeCapabilities([]*csi.VolumeCapability{volCap}, ns.driver.config.EnableRWX) {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability not supported")
+	}
+
+	if err := request.AddVolumeToTransitionList(volumeID, "NodePublishVolume"); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	defer request.RemoveVolumeFromTransitionList(volumeID)
+
+	notMnt, err := ns.mounter.IsLikelyNotMountPoint(target)
+	if err == nil && !notMnt {
+		logrus.Infof("NodePublishVolume: nfs share of volume {%q} is already mounted at target: {%s}", volumeID, target)
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	logrus.Infof("NodePublishVolume: creating dir: {%s}", target)
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not create dir {%q}, err: %v", target, err)
+	}
+
+	options := withSELinuxContext(volCap.GetMount().GetMountFlags())
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+	source := fmt.Sprintf("%s:%s", req.GetVolumeContext()[nfsServerKey], nfsExport)
+	logrus.Infof("NodePublishVolume: start mounting: nfs share: {%s} at target: {%s} with options: {%s}", source, target, options)
+	if err := mountWithRetry(ctx, ns.mounter, ns.driver.config.MountMaxAttempts, source, target, "nfs", options); err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return nil, status.Errorf(codes.Internal, "Could not remove mount target %q: %v", target, removeErr)
+		}
+		return nil, status.Errorf(codes.Internal, "Could not mount %q at %q: %v", source, target, err)
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cloud-provider/volume/helpers"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newRWXCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+}
+
+func TestCreateVolumeRWX(t *testing.T) {
+	nfsKey := ctrlclient.ObjectKey{Name: client.NFSServerName(testVolumeID), Namespace: "openebs"}
+	// fake client doesn't allocate the cluster IP
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: nfsKey.Name, Namespace: nfsKey.Namespace},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+	cs, fakeClient := newFakeController(t, newReadyJivaVolume("5Gi", "10.0.0.1"), svc)
+
+	req := newCreateVolumeRequest(testVolumeID, 5*helpers.GiB)
+	req.VolumeCapabilities = []*csi.VolumeCapability{newRWXCapability()}
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument if rwx is not enabled, got err: %v", err)
+	}
+
+	cs.driver.config.EnableRWX = true
+	resp, err := cs.CreateVolume(context.TODO(), req)
+	if err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+	if server := resp.GetVolume().GetVolumeContext()[nfsServerKey]; server != "10.96.0.10" {
+		t.Fatalf("expected nfs server 10.96.0.10 in volume context, got: {%v}", server)
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := fakeClient.Get(context.TODO(), nfsKey, deploy); err != nil {
+		t.Fatalf("expected nfs server deployment to be created, got err: %v", err)
+	}
+	if image := deploy.Spec.Template.Spec.Containers[0].Image; image != DefaultNFSServerImage {
+		t.Fatalf("expected image %v, got: %v", DefaultNFSServerImage, image)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: testVolumeID, Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if name := vol.Annotations[client.NFSServerAnnotation]; name != nfsKey.Name {
+		t.Fatalf("expected nfs server {%v} to be set on the CR, got: {%v}", nfsKey.Name, name)
+	}
+
+	if _, err := cs.ControllerExpandVolume(context.TODO(), newControllerExpandVolumeRequest(10*helpers.GiB)); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition on expansion, got err: %v", err)
+	}
+
+	if _, err := cs.DeleteVolume(context.TODO(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+		t.Fatalf("expected volume to be deleted, got err: %v", err)
+	}
+	if err := fakeClient.Get(context.TODO(), nfsKey, &appsv1.Deployment{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("expected nfs server deployment to be deleted, got err: %v", err)
+	}
+	if err := fakeClient.Get(context.TODO(), nfsKey, &corev1.Service{}); !k8serrors.IsNotFound(err) {
+		t.Fatalf("expected nfs server service to be deleted, got err: %v", err)
+	}
+}
+
+func TestNodePublishRWX(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-publish-rwx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cmds [][]string
+	ns, fakeMounter, _ := newFakeNode(t, newFakeExec(&cmds))
+	ns.driver.config.EnableRWX = true
+
+	target := filepath.Join(dir, "mount")
+	volumeContext := map[string]string{nfsServerKey: "10.96.0.10"}
+	if _, err := ns.NodeStageVolume(context.TODO(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: filepath.Join(dir, "staging"),
+		VolumeCapability:  newRWXCapability(),
+		VolumeContext:     volumeContext,
+	}); err != nil {
+		t.Fatalf("expected staging to be a no-op, got err: %v", err)
+	}
+
+	if _, err := ns.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+		VolumeId:         testVolumeID,
+		TargetPath:       target,
+		VolumeCapability: newRWXCapability(),
+		VolumeContext:    volumeContext,
+	}); err != nil {
+		t.Fatalf("expected nfs share to be mounted, got err: %v", err)
+	}
+
+	if len(cmds) != 0 {
+		t.Fatalf("expected no iscsi commands, got: %v", cmds)
+	}
+	if len(fakeMounter.MountPoints) != 1 {
+		t.Fatalf("expected 1 mount, got: %+v", fakeMounter.MountPoints)
+	}
+	if mp := fakeMounter.MountPoints[0]; mp.Device != "10.96.0.10:/" || mp.Path != target || mp.Type != "nfs" {
+		t.Fatalf("expected nfs share to be mounted at %v, got: %+v", target, mp)
+	}
+}
+
+func TestValidateVolumeCapabilitiesRWX(t *testing.T) {
+	cs, _ := newFakeController(t, newTestJivaVolume())
+	cs.driver.config.EnableRWX = true
+
+	resp, err := cs.ValidateVolumeCapabilities(context.TODO(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           testVolumeID,
+		VolumeCapabilities: []*csi.VolumeCapability{newRWXCapability()},
+	})
+	if err != nil || resp.GetConfirmed() == nil {
+		t.Fatalf("expected ReadWriteMany to be confirmed, got: %v, err: %v", resp, err)
+	}
+
+	// nfs share can't be consumed as a raw block device
+	block := newRWXCapability()
+	block.AccessType = &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}
+	resp, err = cs.ValidateVolumeCapabilities(context.TODO(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           testVolumeID,
+		VolumeCapabilities: []*csi.VolumeCapability{block},
+	})
+	if err != nil || resp.GetConfirmed() != nil {
+		t.Fatalf("expected ReadWriteMany block volume to not be confirmed, got: %v, err: %v", resp, err)
+	}
+}
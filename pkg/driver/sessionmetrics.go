@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	utilexec "k8s.io/utils/exec"
+)
+
+var iscsiSessionUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "iscsi_session_up",
+		Help:      "Whether the iSCSI session to the target of a volume staged on this node is up",
+	},
+	[]string{"volume_id"},
+)
+
+// sessionCollector periodically sets iscsiSessionUp for the
+// volumes staged on the node from the active iSCSI sessions
+type sessionCollector struct {
+	exec     utilexec.Interface
+	client   *client.Client
+	nodeID   string
+	interval time.Duration
+
+	// volumes are the volume IDs for which the gauge is
+	// set, gauge is removed once the volume is unstaged
+	volumes map[string]bool
+}
+
+func newSessionCollector(exec utilexec.Interface, cli *client.Client, nodeID string, interval time.Duration) *sessionCollector {
+	return &sessionCollector{
+		exec:     exec,
+		client:   cli,
+		nodeID:   nodeID,
+		interval: interval,
+		volumes:  map[string]bool{},
+	}
+}
+
+// run collects the session state at every interval, it only lists
+// the sessions and the JivaVolumes, so it doesn't take the volume
+// locks and doesn't interfere with the staging of the volumes
+func (c *sessionCollector) run() {
+	logrus.Infof("Collecting iscsi session metrics every %v", c.interval)
+	for {
+		if err := c.collect(); err != nil {
+			logrus.Errorf("Metrics: failed to collect iscsi session state, err: {%v}", err)
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+// collect sets the gauge to 1 for the volumes staged on the node
+// with a session to their target portal and 0 for the rest
+func (c *sessionCollector) collect() error {
+	// set client each time to avoid caching issue
+	if err := c.client.Set(); err != nil {
+		return err
+	}
+
+	volumes, err := c.client.ListJivaVolumeWithOpts(map[string]string{"nodeID": c.nodeID})
+	if err != nil {
+		return err
+	}
+
+	sessions, err := listJivaSessions(c.exec)
+	if err != nil {
+		return err
+	}
+
+	up := map[iscsiSession]bool{}
+	for _, s := range sessions {
+		up[s] = true
+	}
+
+	staged := map[string]bool{}
+	for _, vol := range volumes.Items {
+		volumeID := utils.VolumeID(vol.Name)
+		session := iscsiSession{
+			portal: fmt.Sprintf("%v:%v", vol.Spec.ISCSISpec.TargetIP, vol.Spec.ISCSISpec.TargetPort),
+			iqn:    vol.Spec.ISCSISpec.Iqn,
+		}
+
+		val := 0.0
+		if up[session] {
+			val = 1
+		}
+		iscsiSessionUp.WithLabelValues(volumeID).Set(val)
+		staged[volumeID] = true
+	}
+
+	for volumeID := range c.volumes {
+		if !staged[volumeID] {
+			iscsiSessionUp.DeleteLabelValues(volumeID)
+		}
+	}
+	c.volumes = staged
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	testingexec "k8s.io/utils/exec/testing"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newStagedJivaVolume(name, targetIP string) *jv.JivaVolume {
+	vol := newTestJivaVolume()
+	vol.Name = name
+	vol.Spec.ISCSISpec.TargetIP = targetIP
+	vol.Spec.ISCSISpec.TargetPort = 3260
+	vol.Spec.ISCSISpec.Iqn = "iqn.2016-09.com.openebs.jiva:" + name
+	return vol
+}
+
+func sessionsOutput(out string) testingexec.FakeAction {
+	return func() ([]byte, []byte, error) {
+		return []byte(out), nil, nil
+	}
+}
+
+func TestSessionCollector(t *testing.T) {
+	defer iscsiSessionUp.Reset()
+
+	up := newStagedJivaVolume("pvc-1234", "10.0.0.1")
+	down := newStagedJivaVolume("pvc-5678", "10.0.0.2")
+	sessions := "tcp: [1] 10.0.0.1:3260,1 iqn.2016-09.com.openebs.jiva:pvc-1234 (non-flash)\n" +
+		"tcp: [2] 10.0.0.9:3260,1 iqn.2016-09.com.openebs.jiva:pvc-5678 (non-flash)\n"
+
+	var cmds [][]string
+	fakeExec := newFakeExec(&cmds, sessionsOutput(sessions), sessionsOutput(sessions))
+	ns, _, fakeClient := newFakeNode(t, fakeExec, up, down)
+	c := newSessionCollector(fakeExec, ns.client, "node-1", time.Minute)
+
+	if err := c.collect(); err != nil {
+		t.Fatalf("expected session state to be collected, got err: %v", err)
+	}
+	if val := testutil.ToFloat64(iscsiSessionUp.WithLabelValues("pvc-1234")); val != 1 {
+		t.Errorf("expected session of pvc-1234 to be up, got: %v", val)
+	}
+	// session to the old portal of a rescheduled target is not healthy
+	if val := testutil.ToFloat64(iscsiSessionUp.WithLabelValues("pvc-5678")); val != 0 {
+		t.Errorf("expected session of pvc-5678 to be down, got: %v", val)
+	}
+
+	// unstaged volume is removed from the metric
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-5678", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	vol.Labels["nodeID"] = ""
+	if err := fakeClient.Update(context.TODO(), vol); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.collect(); err != nil {
+		t.Fatalf("expected session state to be collected, got err: %v", err)
+	}
+	if c.volumes["pvc-5678"] || !c.volumes["pvc-1234"] {
+		t.Fatalf("expected only pvc-1234 to be tracked, got: %v", c.volumes)
+	}
+	if iscsiSessionUp.DeleteLabelValues("pvc-5678") {
+		t.Fatal("expected metric of unstaged volume pvc-5678 to be removed")
+	}
+}
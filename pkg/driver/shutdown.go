@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// shutdownRollbackTimeout is the time given to the requests cancelled
+// on shutdown to roll back, i.e logout of the iSCSI session created by
+// a cancelled NodeStageVolume, before the grpc server is stopped
+var shutdownRollbackTimeout = 10 * time.Second
+
+// inflightOp is a grpc call which is being served
+type inflightOp struct {
+	method string
+	cancel context.CancelFunc
+}
+
+// inflightTracker tracks the grpc calls being served so that they
+// can be drained or cancelled when the plugin is shutting down
+type inflightTracker struct {
+	mu   sync.Mutex
+	next uint64
+	ops  map[uint64]inflightOp
+}
+
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{ops: map[uint64]inflightOp{}}
+}
+
+// interceptor registers the grpc call for the time it is served, the
+// handler gets a context which is cancelled by cancelAll
+func (t *inflightTracker) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.mu.Lock()
+	id := t.next
+	t.next++
+	t.ops[id] = inflightOp{method: path.Base(info.FullMethod), cancel: cancel}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.ops, id)
+		t.mu.Unlock()
+	}()
+	return handler(ctx, req)
+}
+
+// count returns the number of grpc calls being served
+func (t *inflightTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.ops)
+}
+
+// cancelAll cancels the context of all the grpc calls
+// being served and returns the number of calls cancelled
+func (t *inflightTracker) cancelAll() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, op := range t.ops {
+		logrus.Warningf("Shutdown: cancelling in-flight %s", op.method)
+		op.cancel()
+	}
+	return len(t.ops)
+}
+
+// Shutdown stops the grpc server gracefully, i.e new requests are
+// rejected and the in-flight ones are given the timeout to complete.
+// Requests which are still in-flight after the timeout are cancelled
+// so that they can roll back before the server is stopped forcefully.
+func (s *nonBlockingGRPCServer) Shutdown(timeout time.Duration) {
+	if s.server == nil {
+		return
+	}
+
+	pending := s.inflight.count()
+	logrus.Infof("Shutdown: draining %d in-flight operations, timeout: %v", pending, timeout)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		logrus.Infof("Shutdown: drained %d in-flight operations, cancelled 0", pending)
+		return
+	case <-time.After(timeout):
+	}
+
+	cancelled := s.inflight.cancelAll()
+	logrus.Warningf("Shutdown: drained %d in-flight operations, cancelled %d", pending-cancelled, cancelled)
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownRollbackTimeout):
+		logrus.Warningf("Shutdown: cancelled operations did not complete within %v, stopping forcefully", shutdownRollbackTimeout)
+		s.server.Stop()
+	}
+}
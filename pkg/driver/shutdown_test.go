@@ -0,0 +1,143 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// blockingIdentity serves Probe until its context is
+// cancelled or release is closed
+type blockingIdentity struct {
+	csi.IdentityServer
+	started   chan struct{}
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func (b *blockingIdentity) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(b.started)
+	select {
+	case <-b.release:
+		return &csi.ProbeResponse{}, nil
+	case <-ctx.Done():
+		close(b.cancelled)
+		return nil, ctx.Err()
+	}
+}
+
+// startProbe starts a grpc server with the given identity server and
+// calls Probe on it, the error of the call is sent on the returned chan
+func startProbe(t *testing.T, ids *blockingIdentity) (*nonBlockingGRPCServer, <-chan error, func()) {
+	dir, err := ioutil.TempDir("", "shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(dir, "csi.sock")
+
+	s := NewNonBlockingGRPCServer("unix://"+sock, ids, nil, nil, nil).(*nonBlockingGRPCServer)
+	s.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, sock, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+		errCh <- err
+	}()
+	<-ids.started
+
+	return s, errCh, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newBlockingIdentity() *blockingIdentity {
+	return &blockingIdentity{
+		started:   make(chan struct{}),
+		release:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+}
+
+func TestShutdownDrainsInflightRequests(t *testing.T) {
+	ids := newBlockingIdentity()
+	s, errCh, cleanup := startProbe(t, ids)
+	defer cleanup()
+
+	if n := s.inflight.count(); n != 1 {
+		t.Fatalf("expected 1 in-flight request, got: %d", n)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(ids.release)
+	}()
+	s.Shutdown(10 * time.Second)
+	s.Wait()
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("expected in-flight request to complete, got err: %v", err)
+	}
+	select {
+	case <-ids.cancelled:
+		t.Fatal("expected in-flight request not to be cancelled")
+	default:
+	}
+}
+
+func TestShutdownCancelsInflightRequests(t *testing.T) {
+	defer func(timeout time.Duration) { shutdownRollbackTimeout = timeout }(shutdownRollbackTimeout)
+	shutdownRollbackTimeout = 10 * time.Second
+
+	ids := newBlockingIdentity()
+	s, errCh, cleanup := startProbe(t, ids)
+	defer cleanup()
+
+	s.Shutdown(100 * time.Millisecond)
+	s.Wait()
+
+	select {
+	case <-ids.cancelled:
+	default:
+		t.Fatal("expected in-flight request to be cancelled")
+	}
+	if err := <-errCh; err == nil {
+		t.Fatal("expected cancelled request to fail")
+	}
+	if n := s.inflight.count(); n != 0 {
+		t.Fatalf("expected no in-flight requests, got: %d", n)
+	}
+}
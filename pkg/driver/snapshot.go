@@ -0,0 +1,95 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/openebs/jiva-csi/pkg/jiva"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	"golang.org/x/net/context"
+)
+
+// jivaTargetPort is the port of the REST API of the jiva target
+var jivaTargetPort = "9501"
+
+// newJivaClient returns the REST client of the jiva target at
+// targetIP, requests are retried as per the configured policy
+func (cs *controller) newJivaClient(targetIP string) (*jiva.Client, error) {
+	if len(targetIP) == 0 {
+		return nil, fmt.Errorf("target IP is nil")
+	}
+
+	cli := jiva.NewControllerClient(targetIP+":"+jivaTargetPort, jiva.RetryPolicy{
+		MaxAttempts: cs.driver.config.JivaAPIMaxAttempts,
+		BaseDelay:   cs.driver.config.JivaAPIRetryDelay,
+	})
+	cli.SetTimeout(30 * time.Second)
+	cli.SetTLSConfig(cs.driver.jivaTLS)
+	return cli, nil
+}
+
+// postVolumeAction posts the given action on the
+// volume exposed by the jiva target at targetIP
+func (cs *controller) postVolumeAction(ctx context.Context, targetIP, action string, input interface{}) error {
+	cli, err := cs.newJivaClient(targetIP)
+	if err != nil {
+		return err
+	}
+
+	vol, err := cli.GetVolume(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get volume info from jiva controller, err: %w", err)
+	}
+
+	if err := cli.PostAction(ctx, vol, action, input); err != nil {
+		return fmt.Errorf("failed to post %v request to jiva controller, err: %w", action, err)
+	}
+	return nil
+}
+
+// takeSnapshot takes a snapshot with the given
+// name on the jiva target at targetIP
+func (cs *controller) takeSnapshot(ctx context.Context, targetIP, name string) error {
+	return cs.postVolumeAction(ctx, targetIP, jiva.SnapshotAction, jiva.SnapshotInput{Name: name})
+}
+
+// deleteSnapshot deletes the snapshot with the given
+// name from the jiva target at targetIP
+func (cs *controller) deleteSnapshot(ctx context.Context, targetIP, name string) error {
+	return cs.postVolumeAction(ctx, targetIP, jiva.DeleteSnapshotAction, jiva.SnapshotInput{Name: name})
+}
+
+// newCSISnapshot converts the JivaSnapshot into csi snapshot
+func newCSISnapshot(snap *client.JivaSnapshot) (*csi.Snapshot, error) {
+	creationTime, err := ptypes.TimestampProto(snap.CreationTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csi.Snapshot{
+		SnapshotId:      snap.Name,
+		SourceVolumeId:  snap.SourceVolume,
+		SizeBytes:       snap.SizeBytes,
+		CreationTime:    creationTime,
+		ReadyToUse:      snap.ReadyToUse,
+		GroupSnapshotId: snap.GroupSnapshot,
+	}, nil
+}
@@ -17,10 +17,46 @@ limitations under the License.
 package driver
 
 import (
+	"io"
+	"os"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/sys/unix"
 )
 
+// isBlockDevice returns true if the given path is a
+// device file i.e raw block volume published on a pod
+func isBlockDevice(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return info.Mode()&os.ModeDevice != 0, nil
+}
+
+// getBlockStatistics returns the size of the block device,
+// there is no filesystem on the device so used/available
+// bytes and inodes can't be reported
+func getBlockStatistics(devicePath string) ([]*csi.VolumeUsage, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*csi.VolumeUsage{
+		&csi.VolumeUsage{
+			Total: size,
+			Unit:  csi.VolumeUsage_BYTES,
+		},
+	}, nil
+}
+
 func getStatistics(volumePath string) ([]*csi.VolumeUsage, error) {
 	var statfs unix.Statfs_t
 	// See http://man7.org/linux/man-pages/man2/statfs.2.html for details.
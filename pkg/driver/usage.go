@@ -0,0 +1,112 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultUsageAlertInterval is the min time between
+// the usage alert events of a volume
+const DefaultUsageAlertInterval = time.Hour
+
+// usageAlerts tracks when the usage alert event was last
+// recorded for each volume, so that NodeGetVolumeStats
+// called by kubelet every minute doesn't flood the events
+type usageAlerts struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newUsageAlerts() *usageAlerts {
+	return &usageAlerts{last: map[string]time.Time{}}
+}
+
+// shouldAlert returns true if the alert of the volume is not
+// recorded within the interval, the alert is then tracked
+func (ua *usageAlerts) shouldAlert(volumeID string, now time.Time, interval time.Duration) bool {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	if last, ok := ua.last[volumeID]; ok && now.Sub(last) < interval {
+		return false
+	}
+	ua.last[volumeID] = now
+	return true
+}
+
+// usedFraction returns the highest fraction of the bytes or the
+// inodes used in the volume, usage without the total is skipped
+func usedFraction(usage []*csi.VolumeUsage) (float64, *csi.VolumeUsage) {
+	var max float64
+	var highest *csi.VolumeUsage
+	for _, u := range usage {
+		if u.GetTotal() <= 0 {
+			continue
+		}
+		if fraction := float64(u.GetUsed()) / float64(u.GetTotal()); highest == nil || fraction > max {
+			max, highest = fraction, u
+		}
+	}
+	return max, highest
+}
+
+// usageCondition returns an abnormal condition if the usage of the
+// volume is above the usage alert threshold, nil is returned if it
+// is below or the threshold is not set. A warning event is recorded
+// on the PVC of the volume at most once per usage alert interval.
+func (ns *node) usageCondition(volumeID string, usage []*csi.VolumeUsage) *csi.VolumeCondition {
+	threshold := ns.driver.config.UsageAlertThreshold
+	if threshold == 0 {
+		return nil
+	}
+
+	fraction, u := usedFraction(usage)
+	if u == nil || fraction < threshold {
+		return nil
+	}
+
+	used := fmt.Sprintf("%v of %v bytes", utils.FormatCapacity(u.GetUsed()), utils.FormatCapacity(u.GetTotal()))
+	if u.GetUnit() == csi.VolumeUsage_INODES {
+		used = fmt.Sprintf("%d of %d inodes", u.GetUsed(), u.GetTotal())
+	}
+	msg := fmt.Sprintf("volume is %.0f%% full, %s used", fraction*100, used)
+
+	if ns.usageAlerts.shouldAlert(volumeID, time.Now(), ns.driver.config.UsageAlertInterval) {
+		logrus.Warningf("NodeGetVolumeStats: volume {%v} is above the usage alert threshold {%v}, %s", volumeID, threshold, msg)
+		ns.driver.recordClaimEvent(ns.client, volumeID, reasonVolumeNearlyFull, msg)
+	}
+	return &csi.VolumeCondition{Abnormal: true, Message: msg}
+}
+
+// mergeConditions returns the abnormal one of the conditions of
+// the volume, messages are joined if both of them are abnormal
+func mergeConditions(cond, usage *csi.VolumeCondition) *csi.VolumeCondition {
+	if usage == nil {
+		return cond
+	}
+	if !cond.GetAbnormal() {
+		return usage
+	}
+	return &csi.VolumeCondition{Abnormal: true, Message: cond.GetMessage() + "; " + usage.GetMessage()}
+}
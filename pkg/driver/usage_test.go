@@ -0,0 +1,115 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestUsageConditionThresholdCrossing(t *testing.T) {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: testVolumeID},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "data", Namespace: "default"},
+		},
+	}
+	ns, _, _ := newFakeNode(t, newFakeExec(nil), pv)
+	recorder := record.NewFakeRecorder(10)
+	ns.driver.recorder = recorder
+	ns.driver.config.UsageAlertThreshold = 0.9
+	ns.driver.config.UsageAlertInterval = time.Hour
+
+	newUsage := func(usedBytes, usedInodes int64) []*csi.VolumeUsage {
+		return []*csi.VolumeUsage{
+			{Unit: csi.VolumeUsage_BYTES, Total: 100, Used: usedBytes, Available: 100 - usedBytes},
+			{Unit: csi.VolumeUsage_INODES, Total: 1000, Used: usedInodes, Available: 1000 - usedInodes},
+		}
+	}
+	events := func() int {
+		n := len(recorder.Events)
+		for i := 0; i < n; i++ {
+			if event := <-recorder.Events; !strings.Contains(event, reasonVolumeNearlyFull) {
+				t.Errorf("expected %v event, got: %v", reasonVolumeNearlyFull, event)
+			}
+		}
+		return n
+	}
+
+	if cond := ns.usageCondition(testVolumeID, newUsage(80, 100)); cond != nil || events() != 0 {
+		t.Fatalf("expected no alert below the threshold, got: %+v", cond)
+	}
+
+	// bytes cross the threshold
+	cond := ns.usageCondition(testVolumeID, newUsage(95, 100))
+	if !cond.GetAbnormal() || !strings.Contains(cond.GetMessage(), "95% full") {
+		t.Fatalf("expected abnormal condition of 95%% usage, got: %+v", cond)
+	}
+	if n := events(); n != 1 {
+		t.Fatalf("expected 1 event, got: %d", n)
+	}
+
+	// inodes cross the threshold within the interval, condition
+	// is still reported but the event is debounced
+	cond = ns.usageCondition(testVolumeID, newUsage(50, 990))
+	if !cond.GetAbnormal() || !strings.Contains(cond.GetMessage(), "990 of 1000 inodes") {
+		t.Fatalf("expected abnormal condition of inode usage, got: %+v", cond)
+	}
+	if n := events(); n != 0 {
+		t.Fatalf("expected event to be debounced, got: %d", n)
+	}
+
+	// event is recorded again once the interval elapses
+	ns.usageAlerts.last[testVolumeID] = time.Now().Add(-2 * time.Hour)
+	if cond := ns.usageCondition(testVolumeID, newUsage(95, 100)); !cond.GetAbnormal() {
+		t.Fatalf("expected abnormal condition, got: %+v", cond)
+	}
+	if n := events(); n != 1 {
+		t.Fatalf("expected 1 event after the interval, got: %d", n)
+	}
+
+	// usage is not alerted without the threshold
+	ns.driver.config.UsageAlertThreshold = 0
+	if cond := ns.usageCondition(testVolumeID, newUsage(100, 1000)); cond != nil {
+		t.Fatalf("expected no alert without the threshold, got: %+v", cond)
+	}
+}
+
+func TestMergeConditions(t *testing.T) {
+	healthy := &csi.VolumeCondition{Message: "volume is healthy"}
+	degraded := &csi.VolumeCondition{Abnormal: true, Message: "volume is degraded"}
+	full := &csi.VolumeCondition{Abnormal: true, Message: "volume is 95% full"}
+
+	if cond := mergeConditions(healthy, nil); cond != healthy {
+		t.Fatalf("expected replica condition, got: %+v", cond)
+	}
+	if cond := mergeConditions(nil, full); cond != full {
+		t.Fatalf("expected usage condition, got: %+v", cond)
+	}
+	if cond := mergeConditions(healthy, full); cond != full {
+		t.Fatalf("expected usage condition, got: %+v", cond)
+	}
+	if cond := mergeConditions(degraded, full); !cond.GetAbnormal() || cond.GetMessage() != "volume is degraded; volume is 95% full" {
+		t.Fatalf("expected both conditions, got: %+v", cond)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// webhook event types of the volume lifecycle
+	webhookEventProvisioned = "provisioned"
+	webhookEventAttached    = "attached"
+	webhookEventResized     = "resized"
+	webhookEventFailed      = "failed"
+	webhookEventDeleted     = "deleted"
+
+	// webhookQueueSize is the max number of events waiting to be
+	// posted, oldest event is dropped once the queue is full
+	webhookQueueSize = 100
+)
+
+// webhookTimeout is the max time taken by a single post to the webhook
+var webhookTimeout = 5 * time.Second
+
+// webhookEvent is the JSON notification posted to the webhook
+type webhookEvent struct {
+	Type      string    `json:"type"`
+	VolumeID  string    `json:"volumeID"`
+	NodeID    string    `json:"nodeID,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// webhookNotifier posts the volume events to the webhook in the
+// background, so that a slow webhook doesn't stall the requests.
+// Delivery is best-effort, failed posts are logged and not retried.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []webhookEvent
+	size    int
+	pending chan struct{}
+}
+
+func newWebhookNotifier(webhookURL string, size int) *webhookNotifier {
+	return &webhookNotifier{
+		url:     webhookURL,
+		client:  &http.Client{Timeout: webhookTimeout},
+		size:    size,
+		pending: make(chan struct{}, 1),
+	}
+}
+
+// ValidateWebhookURL verifies that the webhook URL
+// is an absolute http or https URL
+func ValidateWebhookURL(webhookURL string) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url {%s}, err: {%v}", webhookURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url {%s}, it must be an http or https url", webhookURL)
+	}
+	return nil
+}
+
+// notify queues the event without blocking the caller,
+// oldest queued event is dropped if the queue is full
+func (wn *webhookNotifier) notify(event webhookEvent) {
+	wn.mu.Lock()
+	if len(wn.queue) >= wn.size {
+		dropped := wn.queue[0]
+		wn.queue = wn.queue[1:]
+		logrus.Warningf("Webhook: queue is full, dropped event {%v} of volume {%v} at %v",
+			dropped.Type, dropped.VolumeID, dropped.Timestamp.Format(time.RFC3339))
+	}
+	wn.queue = append(wn.queue, event)
+	wn.mu.Unlock()
+
+	select {
+	case wn.pending <- struct{}{}:
+	default:
+	}
+}
+
+// next removes and returns the oldest queued event
+func (wn *webhookNotifier) next() (webhookEvent, bool) {
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+	if len(wn.queue) == 0 {
+		return webhookEvent{}, false
+	}
+	event := wn.queue[0]
+	wn.queue = wn.queue[1:]
+	return event, true
+}
+
+// run posts the queued events until stop is closed
+func (wn *webhookNotifier) run(stop <-chan struct{}) {
+	logrus.Infof("Webhook: posting volume events to {%v}", wn.url)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wn.pending:
+		}
+
+		for {
+			event, ok := wn.next()
+			if !ok {
+				break
+			}
+			if err := wn.post(event); err != nil {
+				logrus.Warningf("Webhook: failed to post event {%v} of volume {%v}, err: {%v}", event.Type, event.VolumeID, err)
+			}
+		}
+	}
+}
+
+func (wn *webhookNotifier) post(event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := wn.client.Post(wn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyWebhook queues the event of the given volume if
+// the webhook is configured, nodeID may be empty
+func (d *CSIDriver) notifyWebhook(eventType, volumeID, nodeID, message string) {
+	if d.webhook == nil {
+		return
+	}
+
+	d.webhook.notify(webhookEvent{
+		Type:      eventType,
+		VolumeID:  volumeID,
+		NodeID:    nodeID,
+		Timestamp: time.Now().UTC(),
+		Message:   message,
+	})
+}
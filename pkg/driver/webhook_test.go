@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"k8s.io/cloud-provider/volume/helpers"
+)
+
+func TestWebhookNotifierDropsOldest(t *testing.T) {
+	wn := newWebhookNotifier("http://127.0.0.1:1", 2)
+	for _, id := range []string{"pvc-1", "pvc-2", "pvc-3"} {
+		wn.notify(webhookEvent{Type: webhookEventProvisioned, VolumeID: id})
+	}
+
+	var got []string
+	for {
+		event, ok := wn.next()
+		if !ok {
+			break
+		}
+		got = append(got, event.VolumeID)
+	}
+	if len(got) != 2 || got[0] != "pvc-2" || got[1] != "pvc-3" {
+		t.Fatalf("expected oldest event to be dropped, got: %v", got)
+	}
+}
+
+func TestCreateVolumeNotifiesWebhook(t *testing.T) {
+	events := make(chan webhookEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := webhookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	cs, _ := newFakeController(t)
+	cs.driver.webhook = newWebhookNotifier(server.URL, webhookQueueSize)
+	stop := make(chan struct{})
+	defer close(stop)
+	go cs.driver.webhook.run(stop)
+
+	if _, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != webhookEventProvisioned || event.VolumeID != "pvc-1234" || event.Timestamp.IsZero() {
+			t.Fatalf("expected provisioned event of pvc-1234, got: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected provisioned event to be posted to the webhook")
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := map[string]struct {
+		url  string
+		fail bool
+	}{
+		"http":           {url: "http://collector.monitoring:8080/events"},
+		"https":          {url: "https://collector.example.com/events"},
+		"missing scheme": {url: "collector.monitoring:8080", fail: true},
+		"unsupported":    {url: "ftp://collector.monitoring/events", fail: true},
+		"missing host":   {url: "http:///events", fail: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateWebhookURL(test.url); (err != nil) != test.fail {
+				t.Fatalf("expected fail %v, got err: %v", test.fail, err)
+			}
+		})
+	}
+}
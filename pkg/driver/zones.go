@@ -0,0 +1,100 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countZones returns the number of distinct zones of the
+// schedulable nodes, replicas can only be placed on them
+func (cs *controller) countZones() (int, error) {
+	nodes, err := cs.client.ListNodes(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	zones := map[string]bool{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if zone := node.Labels[client.ZoneTopologyKey]; zone != "" && isNodeSchedulable(node) {
+			zones[zone] = true
+		}
+	}
+	return len(zones), nil
+}
+
+// checkReplicaZones checks if the replicas of the volume can be
+// placed in distinct zones when the zone anti-affinity is set,
+// ResourceExhausted is returned if there are fewer zones than the
+// replicas and the anti-affinity is strict.
+func (cs *controller) checkReplicaZones(req *csi.CreateVolumeRequest, replicaCount int) error {
+	mode, ok := req.GetParameters()[client.ReplicaZoneAntiAffinityParam]
+	if !ok {
+		return nil
+	}
+
+	return cs.checkZonesForReplicas("CreateVolume", req.GetName(), mode, replicaCount)
+}
+
+// checkZonesForReplicas checks if there are as many zones as the
+// replicas of the volume with the given zone anti-affinity mode,
+// rpc is the name of the calling RPC used in the messages
+func (cs *controller) checkZonesForReplicas(rpc, volumeID, mode string, replicaCount int) error {
+	zones, err := cs.countZones()
+	if err != nil {
+		return status.Errorf(codes.Internal, "%s: failed to list nodes, err: {%v}", rpc, err)
+	}
+	if zones >= replicaCount {
+		return nil
+	}
+
+	if mode == client.ZoneAntiAffinityStrict {
+		return status.Errorf(codes.ResourceExhausted,
+			"%s: %d replicas of volume {%v} can't be placed in distinct zones, only %d zones are available",
+			rpc, replicaCount, volumeID, zones)
+	}
+	logrus.Warningf("%s: only %d zones are available for %d replicas of volume {%v}, some replicas will share a zone",
+		rpc, zones, replicaCount, volumeID)
+	return nil
+}
+
+// replicaZoneAntiAffinityMode returns the zone anti-affinity mode of
+// the replicas from the affinity set on the JivaVolume CR by
+// CreateVolume, empty string is returned if it is not set
+func replicaZoneAntiAffinityMode(instance *jv.JivaVolume) string {
+	affinity := instance.Spec.Policy.Replica.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		return ""
+	}
+	for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if term.TopologyKey == client.ZoneTopologyKey {
+			return client.ZoneAntiAffinityStrict
+		}
+	}
+	for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if term.PodAffinityTerm.TopologyKey == client.ZoneTopologyKey {
+			return client.ZoneAntiAffinityPreferred
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openebs/jiva-csi/pkg/kubernetes/client"
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cloud-provider/volume/helpers"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newZonedNodes returns a ready node in each of the zones
+// along with a not ready node in an other zone
+func newZonedNodes(zones ...string) []runtime.Object {
+	objs := []runtime.Object{}
+	for _, zone := range append(zones, "zone-down") {
+		ready := corev1.ConditionTrue
+		if zone == "zone-down" {
+			ready = corev1.ConditionFalse
+		}
+		node := newTestNode("node-"+zone, ready, time.Now())
+		node.Labels = map[string]string{client.ZoneTopologyKey: zone}
+		objs = append(objs, node)
+	}
+	return objs
+}
+
+func TestCreateVolumeReplicaZoneAntiAffinity(t *testing.T) {
+	tests := map[string]struct {
+		mode  string
+		zones []string
+		code  codes.Code
+	}{
+		"strict, a zone for each replica": {
+			mode:  client.ZoneAntiAffinityStrict,
+			zones: []string{"zone-a", "zone-b", "zone-c"},
+		},
+		"strict, fewer zones than replicas": {
+			mode:  client.ZoneAntiAffinityStrict,
+			zones: []string{"zone-a", "zone-b"},
+			code:  codes.ResourceExhausted,
+		},
+		"preferred, fewer zones than replicas": {
+			mode:  client.ZoneAntiAffinityPreferred,
+			zones: []string{"zone-a", "zone-b"},
+		},
+		"invalid mode": {
+			mode:  "required",
+			zones: []string{"zone-a", "zone-b", "zone-c"},
+			code:  codes.InvalidArgument,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cs, fakeClient := newFakeController(t, newZonedNodes(test.zones...)...)
+			req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+			req.Parameters = map[string]string{
+				client.ReplicaCountAnnotation:       "3",
+				client.ReplicaZoneAntiAffinityParam: test.mode,
+			}
+
+			_, err := cs.CreateVolume(context.TODO(), req)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %v, got err: %v", test.code, err)
+			}
+			if test.code != codes.OK {
+				return
+			}
+
+			vol := &jv.JivaVolume{}
+			if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+				t.Fatal(err)
+			}
+			affinity := vol.Spec.Policy.Replica.Affinity
+			if affinity == nil || affinity.PodAntiAffinity == nil {
+				t.Fatalf("expected replica anti-affinity to be set, got: %+v", affinity)
+			}
+
+			antiAffinity := affinity.PodAntiAffinity
+			var term corev1.PodAffinityTerm
+			switch test.mode {
+			case client.ZoneAntiAffinityStrict:
+				if len(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 || len(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 0 {
+					t.Fatalf("expected required anti-affinity, got: %+v", antiAffinity)
+				}
+				term = antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+			case client.ZoneAntiAffinityPreferred:
+				if len(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 || len(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 0 {
+					t.Fatalf("expected preferred anti-affinity, got: %+v", antiAffinity)
+				}
+				term = antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm
+			}
+			if term.TopologyKey != client.ZoneTopologyKey || term.LabelSelector.MatchLabels["openebs.io/persistent-volume"] != "pvc-1234" {
+				t.Fatalf("expected replicas of pvc-1234 to be spread across zones, got: %+v", term)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeWithoutZoneAntiAffinity(t *testing.T) {
+	cs, fakeClient := newFakeController(t, newZonedNodes("zone-a")...)
+	if _, err := cs.CreateVolume(context.TODO(), newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+
+	vol := &jv.JivaVolume{}
+	if err := fakeClient.Get(context.TODO(), ctrlclient.ObjectKey{Name: "pvc-1234", Namespace: "openebs"}, vol); err != nil {
+		t.Fatal(err)
+	}
+	if vol.Spec.Policy.Replica.Affinity != nil {
+		t.Fatalf("expected replica placement to be left to the operator, got: %+v", vol.Spec.Policy.Replica.Affinity)
+	}
+}
+
+func TestCreateVolumeReplicaZonesPVCOverride(t *testing.T) {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:        "data-0",
+		Namespace:   "default",
+		Annotations: map[string]string{client.ReplicaCountAnnotation: "1"},
+	}}
+	cs, _ := newFakeController(t, append(newZonedNodes("zone-a", "zone-b"), pvc)...)
+
+	// single replica set on the pvc fits in the zones
+	// even if the StorageClass asks for more of them
+	req := newCreateVolumeRequest("pvc-1234", 5*helpers.GiB)
+	req.Parameters = map[string]string{
+		client.ReplicaCountAnnotation:       "3",
+		client.ReplicaZoneAntiAffinityParam: client.ZoneAntiAffinityStrict,
+		client.PVCNameParam:                 "data-0",
+		client.PVCNamespaceParam:            "default",
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); err != nil {
+		t.Fatalf("expected volume to be created, got err: %v", err)
+	}
+}
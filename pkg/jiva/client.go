@@ -0,0 +1,285 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ResizeAction resizes the volume
+	ResizeAction = "resize"
+	// SnapshotAction takes a snapshot of the volume
+	SnapshotAction = "snapshot"
+	// DeleteSnapshotAction deletes a snapshot of the volume
+	DeleteSnapshotAction = "deleteSnapshot"
+)
+
+// RetryPolicy defines how the requests to the
+// jiva controller are retried on transient errors
+type RetryPolicy struct {
+	// MaxAttempts is the max number of times
+	// a request is sent, including the first one
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry,
+	// it is doubled after each retry
+	BaseDelay time.Duration
+}
+
+// DefaultRetryPolicy is used if the retry policy is not set
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   2 * time.Second,
+}
+
+// Volume is the volume exposed by the jiva controller
+type Volume struct {
+	Name         string            `json:"name"`
+	ReplicaCount int               `json:"replicaCount"`
+	Actions      map[string]string `json:"actions"`
+}
+
+// Volumes is the response of the volumes API
+type Volumes struct {
+	Data []Volume `json:"data"`
+}
+
+// Replica is a replica of the volume as reported by the jiva
+// controller, its mode is RW if it is healthy, WO while it is
+// being rebuilt and ERR if it has failed
+type Replica struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+}
+
+// Replicas is the response of the replicas API
+type Replicas struct {
+	Data []Replica `json:"data"`
+}
+
+// ResizeInput is the request body of the resize action
+type ResizeInput struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+// SnapshotInput is the request body of the snapshot
+// and deleteSnapshot actions
+type SnapshotInput struct {
+	Name string `json:"name"`
+}
+
+// HTTPError is returned if the jiva controller
+// responds with a non 2xx status code
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s: status code: %d, body: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// transientError wraps the errors on which the
+// request to the jiva controller can be retried
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// Client is the REST client of the jiva controller
+type Client struct {
+	address    string
+	httpClient *http.Client
+	retry      RetryPolicy
+	// tls is set if the jiva controller is reached over HTTPS
+	tls bool
+}
+
+// NewControllerClient returns the client of the jiva controller
+// listening at the given address i.e <ip>:9501
+func NewControllerClient(address string, retry RetryPolicy) *Client {
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if retry.BaseDelay < 0 {
+		retry.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+
+	if !strings.HasPrefix(address, "http") {
+		address = "http://" + address
+	}
+	return &Client{
+		address:    address + "/v1",
+		httpClient: &http.Client{},
+		retry:      retry,
+	}
+}
+
+// SetTimeout sets the timeout of each request
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// GetVolume returns the volume exposed by the jiva controller
+func (c *Client) GetVolume(ctx context.Context) (*Volume, error) {
+	vols := Volumes{}
+	if err := c.Get(ctx, "/volumes", &vols); err != nil {
+		return nil, err
+	}
+
+	if len(vols.Data) == 0 {
+		return nil, fmt.Errorf("no volume found")
+	}
+	return &vols.Data[0], nil
+}
+
+// ListReplicas returns the replicas of the volume
+// exposed by the jiva controller
+func (c *Client) ListReplicas(ctx context.Context) ([]Replica, error) {
+	reps := Replicas{}
+	if err := c.Get(ctx, "/replicas", &reps); err != nil {
+		return nil, err
+	}
+	return reps.Data, nil
+}
+
+// PostAction posts the given action on the volume
+func (c *Client) PostAction(ctx context.Context, vol *Volume, action string, input interface{}) error {
+	url, ok := vol.Actions[action]
+	if !ok {
+		return fmt.Errorf("action {%v} is not supported by jiva controller", action)
+	}
+	return c.Post(ctx, url, input, nil)
+}
+
+// Get sends a GET request at the given path and
+// decodes the response body into resp
+func (c *Client) Get(ctx context.Context, path string, resp interface{}) error {
+	return c.do(ctx, http.MethodGet, path, nil, resp)
+}
+
+// Post sends a POST request with req as the body at the
+// given path and decodes the response body into resp
+func (c *Client) Post(ctx context.Context, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, path, body, resp)
+}
+
+// do sends the request and retries it with exponential backoff
+// on transient errors i.e connection errors and 5xx responses.
+// The request and the wait between the retries are aborted as
+// soon as ctx is cancelled, ctx.Err() is returned in that case.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, resp interface{}) error {
+	url := path
+	if !strings.HasPrefix(url, "http") {
+		url = c.address + path
+	} else if c.tls {
+		// action urls are reported by the jiva
+		// controller which may not know the scheme
+		url = httpsURL(url)
+	}
+
+	var err error
+	delay := c.retry.BaseDelay
+	for attempt := 1; attempt <= c.retry.MaxAttempts; attempt++ {
+		err = c.send(ctx, method, url, body, resp)
+		if err == nil {
+			return nil
+		}
+
+		transient, ok := err.(*transientError)
+		if !ok {
+			return err
+		}
+		err = transient.err
+
+		if attempt < c.retry.MaxAttempts {
+			logrus.Warningf("jiva: %s %s failed, retrying in %v (attempt %d/%d), err: {%v}",
+				method, url, delay, attempt, c.retry.MaxAttempts, err)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte, resp interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil && ctx.Err() != nil {
+		// request is aborted by the caller
+		return ctx.Err()
+	} else if err != nil {
+		// connection refused, reset or timed out
+		return &transientError{err: err}
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &transientError{err: err}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		httpErr := &HTTPError{
+			Method:     method,
+			URL:        url,
+			StatusCode: res.StatusCode,
+			Body:       string(data),
+		}
+		if res.StatusCode >= 500 {
+			return &transientError{err: httpErr}
+		}
+		return httpErr
+	}
+
+	if resp == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
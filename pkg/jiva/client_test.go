@@ -0,0 +1,161 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Millisecond,
+}
+
+// newFakeServer returns a server which responds with failureCode
+// for the first failures requests and then serves the volume
+func newFakeServer(failures int32, failureCode int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(failureCode)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Volumes{
+			Data: []Volume{
+				{
+					Name:    "pvc-1234",
+					Actions: map[string]string{ResizeAction: "http://" + r.Host + "/v1/volumes/pvc-1234?action=resize"},
+				},
+			},
+		})
+	}))
+	return server, &requests
+}
+
+func TestGetVolumeRetriesOnServerError(t *testing.T) {
+	server, requests := newFakeServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	vol, err := NewControllerClient(server.URL, testRetryPolicy).GetVolume(context.TODO())
+	if err != nil {
+		t.Fatalf("expected request to succeed after retries, got err: %v", err)
+	}
+
+	if vol.Name != "pvc-1234" {
+		t.Fatalf("expected volume pvc-1234, got: %v", vol.Name)
+	}
+
+	if *requests != 3 {
+		t.Fatalf("expected 3 requests, got: %d", *requests)
+	}
+}
+
+func TestGetVolumeNoRetryOnClientError(t *testing.T) {
+	server, requests := newFakeServer(1, http.StatusBadRequest)
+	defer server.Close()
+
+	_, err := NewControllerClient(server.URL, testRetryPolicy).GetVolume(context.TODO())
+	httpErr, ok := err.(*HTTPError)
+	if !ok || httpErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected bad request error, got: %v", err)
+	}
+
+	if *requests != 1 {
+		t.Fatalf("expected 1 request, got: %d", *requests)
+	}
+}
+
+func TestGetVolumeMaxAttemptsExceeded(t *testing.T) {
+	server, requests := newFakeServer(10, http.StatusInternalServerError)
+	defer server.Close()
+
+	_, err := NewControllerClient(server.URL, testRetryPolicy).GetVolume(context.TODO())
+	httpErr, ok := err.(*HTTPError)
+	if !ok || httpErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected internal server error, got: %v", err)
+	}
+
+	if int(*requests) != testRetryPolicy.MaxAttempts {
+		t.Fatalf("expected %d requests, got: %d", testRetryPolicy.MaxAttempts, *requests)
+	}
+}
+
+func TestGetVolumeRetriesOnConnectionError(t *testing.T) {
+	server, _ := newFakeServer(0, http.StatusOK)
+	// requests to the closed server fail with connection refused
+	server.Close()
+
+	cli := NewControllerClient(server.URL, testRetryPolicy)
+	start := time.Now()
+	if _, err := cli.GetVolume(context.TODO()); err == nil {
+		t.Fatal("expected request to fail")
+	}
+
+	// backoff of 1ms, 2ms and 4ms between the attempts
+	if elapsed := time.Since(start); elapsed < 7*time.Millisecond {
+		t.Fatalf("expected request to be retried, took: %v", elapsed)
+	}
+}
+
+func TestGetVolumeCancelled(t *testing.T) {
+	server, requests := newFakeServer(10, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// cancel while waiting before the first retry
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	cli := NewControllerClient(server.URL, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute})
+	start := time.Now()
+	_, err := cli.GetVolume(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected request to be aborted promptly, took: %v", elapsed)
+	}
+
+	if *requests != 1 {
+		t.Fatalf("expected 1 request, got: %d", *requests)
+	}
+}
+
+func TestGetVolumeCancelledInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// block till the test is done
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := NewControllerClient(server.URL, testRetryPolicy).GetVolume(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CodeFromHTTPStatus returns the grpc code of the error
+// response of the jiva controller with the given status code
+func CodeFromHTTPStatus(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// newStatus returns the grpc status with the given message, the
+// response body of the jiva controller is set in the details
+func (e *HTTPError) newStatus(msg string) *status.Status {
+	st := status.New(CodeFromHTTPStatus(e.StatusCode), msg)
+	if detailed, err := st.WithDetails(&errdetails.DebugInfo{Detail: e.Body}); err == nil {
+		return detailed
+	}
+	return st
+}
+
+// GRPCStatus returns the grpc status of the error, it lets
+// the HTTPError be passed to status.Code and status.FromError
+func (e *HTTPError) GRPCStatus() *status.Status {
+	return e.newStatus(e.Error())
+}
+
+// Errorf returns a grpc status error with the given message, the code
+// is mapped from the status code of the jiva controller response if err
+// is or wraps an HTTPError and it is Internal otherwise
+func Errorf(err error, format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return status.Error(codes.Internal, msg)
+	}
+	return httpErr.newStatus(msg).Err()
+}
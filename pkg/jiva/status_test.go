@@ -0,0 +1,80 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCodeFromHTTPStatus(t *testing.T) {
+	tests := map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusConflict:            codes.AlreadyExists,
+		http.StatusTooManyRequests:     codes.Unavailable,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusUnauthorized:        codes.Internal,
+		http.StatusInternalServerError: codes.Internal,
+		http.StatusBadGateway:          codes.Internal,
+	}
+
+	for statusCode, expected := range tests {
+		if code := CodeFromHTTPStatus(statusCode); code != expected {
+			t.Errorf("expected code %v for status %d, got: %v", expected, statusCode, code)
+		}
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte("snapshot snap-1 already exists"))
+	}))
+	defer server.Close()
+
+	cli := NewControllerClient(server.URL, testRetryPolicy)
+	err := cli.Post(context.TODO(), "/volumes/pvc-1234?action=snapshot", SnapshotInput{Name: "snap-1"}, nil)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected HTTPError with code AlreadyExists, got: %v", err)
+	}
+
+	// code and details are kept if the error is wrapped
+	wrapped := fmt.Errorf("failed to post snapshot request, err: %w", err)
+	st := status.Convert(Errorf(wrapped, "CreateSnapshot: failed to take snapshot, err: {%v}", wrapped))
+	if st.Code() != codes.AlreadyExists {
+		t.Fatalf("expected AlreadyExists, got: %v", st)
+	}
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("expected response body in the details, got: %v", details)
+	}
+	if info, ok := details[0].(*errdetails.DebugInfo); !ok || info.Detail != "snapshot snap-1 already exists" {
+		t.Fatalf("expected response body in the details, got: %v", details[0])
+	}
+
+	if code := status.Code(Errorf(fmt.Errorf("no volume found"), "failed")); code != codes.Internal {
+		t.Fatalf("expected Internal for errors other than HTTPError, got: %v", code)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// TLSOptions configures HTTPS to the REST API of the jiva
+// controller, plain HTTP is used if none of them are set
+type TLSOptions struct {
+	// CAFile is the PEM bundle of the CAs which sign the
+	// serving certificate of the jiva controller, the
+	// system roots are used if it is not set
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate
+	// and key presented to the jiva controller
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify skips the verification of the
+	// serving certificate, it is meant for test clusters
+	InsecureSkipVerify bool
+}
+
+// Enabled returns true if the jiva controller is to be reached over HTTPS
+func (o TLSOptions) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.InsecureSkipVerify
+}
+
+// NewTLSConfig returns the TLS config of the client as per the
+// options, nil is returned if TLS is not enabled
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if !opts.Enabled() {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle {%s}, err: {%v}", opts.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle {%s}", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, fmt.Errorf("both client certificate and key must be set")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate {%s}, err: {%v}", opts.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// SetTLSConfig makes the client reach the jiva controller over HTTPS
+// with the given config, it is a no-op if the config is nil
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	if cfg == nil {
+		return
+	}
+	c.httpClient.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: cfg,
+	}
+	c.address = httpsURL(c.address)
+	c.tls = true
+}
+
+// httpsURL replaces the http scheme of the url with https
+func httpsURL(url string) string {
+	if strings.HasPrefix(url, "http://") {
+		return "https://" + strings.TrimPrefix(url, "http://")
+	}
+	return url
+}
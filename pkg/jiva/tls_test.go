@@ -0,0 +1,241 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jiva
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA is a CA which signs the certificates of the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "jiva-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "jiva-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// newFakeTLSServer starts a jiva controller served over TLS with a
+// certificate of the CA, it requires a client certificate of the CA
+func newFakeTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	certPEM, keyPEM := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			return
+		}
+		// action urls are reported without the scheme of the request
+		_ = json.NewEncoder(w).Encode(Volumes{
+			Data: []Volume{
+				{
+					Name:    "pvc-1234",
+					Actions: map[string]string{ResizeAction: "http://" + r.Host + "/v1/volumes/pvc-1234?action=resize"},
+				},
+			},
+		})
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	return server
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jiva-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	server := newFakeTLSServer(t, ca)
+	defer server.Close()
+
+	clientCert, clientKey := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	certFile := writeFile(t, dir, "client.crt", clientCert)
+	keyFile := writeFile(t, dir, "client.key", clientKey)
+	caFile := writeFile(t, dir, "ca.crt", ca.pem)
+	otherCAFile := writeFile(t, dir, "other-ca.crt", newTestCA(t).pem)
+
+	tests := map[string]struct {
+		opts TLSOptions
+		ok   bool
+	}{
+		"custom CA and client certificate": {
+			opts: TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			ok:   true,
+		},
+		"certificate of other CA": {
+			opts: TLSOptions{CAFile: otherCAFile, CertFile: certFile, KeyFile: keyFile},
+		},
+		"system roots": {
+			opts: TLSOptions{CertFile: certFile, KeyFile: keyFile},
+		},
+		"no client certificate": {
+			opts: TLSOptions{CAFile: caFile},
+		},
+		"verification skipped": {
+			opts: TLSOptions{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true},
+			ok:   true,
+		},
+	}
+
+	address := strings.TrimPrefix(server.URL, "https://")
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := NewTLSConfig(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cli := NewControllerClient(address, RetryPolicy{MaxAttempts: 1})
+			cli.SetTLSConfig(cfg)
+
+			vol, err := cli.GetVolume(context.TODO())
+			if (err == nil) != test.ok {
+				t.Fatalf("expected success %v, got err: %v", test.ok, err)
+			}
+			if !test.ok {
+				return
+			}
+			if err := cli.PostAction(context.TODO(), vol, ResizeAction, ResizeInput{Name: vol.Name, Size: "10Gi"}); err != nil {
+				t.Fatalf("expected action to be posted over https, got err: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jiva-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certPEM, keyPEM := newTestCA(t).issue(t, 2, x509.ExtKeyUsageClientAuth)
+	certFile := writeFile(t, dir, "client.crt", certPEM)
+	keyFile := writeFile(t, dir, "client.key", keyPEM)
+	invalidFile := writeFile(t, dir, "invalid.crt", []byte("not a certificate"))
+
+	tests := map[string]struct {
+		opts TLSOptions
+		fail bool
+	}{
+		"plain http":         {opts: TLSOptions{}},
+		"missing CA bundle":  {opts: TLSOptions{CAFile: filepath.Join(dir, "missing.crt")}, fail: true},
+		"invalid CA bundle":  {opts: TLSOptions{CAFile: invalidFile}, fail: true},
+		"certificate only":   {opts: TLSOptions{CertFile: certFile}, fail: true},
+		"key of other cert":  {opts: TLSOptions{CertFile: invalidFile, KeyFile: keyFile}, fail: true},
+		"client certificate": {opts: TLSOptions{CertFile: certFile, KeyFile: keyFile}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := NewTLSConfig(test.opts)
+			if (err != nil) != test.fail {
+				t.Fatalf("expected fail %v, got err: %v", test.fail, err)
+			}
+			if !test.fail && (cfg == nil) == test.opts.Enabled() {
+				t.Fatalf("expected TLS config to be set only if enabled, got: %v", cfg)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // Jiva wraps the JivaVolume structure
@@ -87,6 +88,12 @@ func (j *Jiva) WithLabels(labels map[string]string) *Jiva {
 	return j
 }
 
+// WithFinalizers is used to set the finalizers in JivaVolume CR
+func (j *Jiva) WithFinalizers(finalizers ...string) *Jiva {
+	j.jvObj.Finalizers = append(j.jvObj.Finalizers, finalizers...)
+	return j
+}
+
 // WithAnnotations is used to set the annotations in JivaVolume CR
 func (j *Jiva) WithAnnotations(annotations map[string]string) *Jiva {
 	if annotations != nil {
@@ -124,3 +131,38 @@ func (j *Jiva) WithCapacity(capacity string) *Jiva {
 	j.jvObj.Spec.Capacity = capacity
 	return j
 }
+
+// WithReplicationFactor defines the ReplicationFactor field of the
+// target policy in JivaVolumeSpec
+func (j *Jiva) WithReplicationFactor(rf int) *Jiva {
+	j.jvObj.Spec.Policy.Target.ReplicationFactor = rf
+	return j
+}
+
+// WithTargetNodeSelector defines the NodeSelector field of the
+// target policy in JivaVolumeSpec
+func (j *Jiva) WithTargetNodeSelector(selector map[string]string) *Jiva {
+	j.jvObj.Spec.Policy.Target.NodeSelector = selector
+	return j
+}
+
+// WithTargetResources defines the Resources field of the
+// target policy in JivaVolumeSpec
+func (j *Jiva) WithTargetResources(resources *corev1.ResourceRequirements) *Jiva {
+	j.jvObj.Spec.Policy.Target.Resources = resources
+	return j
+}
+
+// WithReplicaAffinity defines the Affinity field of the
+// replica policy in JivaVolumeSpec
+func (j *Jiva) WithReplicaAffinity(affinity *corev1.Affinity) *Jiva {
+	j.jvObj.Spec.Policy.Replica.Affinity = affinity
+	return j
+}
+
+// WithReplicaSC defines the ReplicaSC field of the policy in
+// JivaVolumeSpec i.e the StorageClass of the replicas
+func (j *Jiva) WithReplicaSC(sc string) *Jiva {
+	j.jvObj.Spec.Policy.ReplicaSC = sc
+	return j
+}
@@ -19,6 +19,7 @@ package client
 import (
 	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/openebs/jiva-csi/pkg/jivavolume"
@@ -28,10 +29,23 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/cloud-provider/volume/helpers"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -41,8 +55,155 @@ const (
 	defaultReplicaSC = "openebs-hostpath"
 	defaultNS        = "openebs"
 	defaultSizeBytes = 5 * helpers.GiB
+
+	// MinVolumeSizeBytes is the smallest volume jiva can provision,
+	// capacity is allocated in GiB so a smaller size would end up as
+	// a volume of 0Gi
+	MinVolumeSizeBytes = helpers.GiB
+
+	componentLabel      = "openebs.io/component"
+	jivaVolumeComponent = "jiva-volume"
+	// jivaReplicaComponent is the component label set on
+	// the replica pods of a volume by jiva-operator
+	jivaReplicaComponent = "jiva-replica"
+
+	// PVCNameParam, PVCNamespaceParam and PVNameParam are passed
+	// by the external-provisioner in CreateVolume parameters when
+	// --extra-create-metadata is enabled
+	PVCNameParam      = "csi.storage.k8s.io/pvc/name"
+	PVCNamespaceParam = "csi.storage.k8s.io/pvc/namespace"
+	PVNameParam       = "csi.storage.k8s.io/pv/name"
+
+	// PVCNameAnnotation, PVCNamespaceAnnotation and PVNameAnnotation
+	// are set on the JivaVolume CR from the parameters above, so that
+	// the CR can be correlated with its PVC. PVCNameLabel and
+	// PVCNamespaceLabel are also set if the values are valid labels.
+	PVCNameAnnotation      = "jiva.openebs.io/pvc-name"
+	PVCNamespaceAnnotation = "jiva.openebs.io/pvc-namespace"
+	PVNameAnnotation       = "jiva.openebs.io/pv-name"
+	PVCNameLabel           = "openebs.io/pvc-name"
+	PVCNamespaceLabel      = "openebs.io/pvc-namespace"
+
+	// ReplicaCountAnnotation can be set on the PVC to override
+	// the replication factor of the volume set via policy, the
+	// StorageClass parameter with the same name sets it for all
+	// the volumes of the class, the PVC annotation takes precedence
+	ReplicaCountAnnotation = "jiva.openebs.io/replica-count"
+
+	// PolicyParam is the StorageClass parameter which refers to
+	// the JivaVolumePolicy used for the volume
+	PolicyParam = "policy"
+	// NamespaceParam is the StorageClass parameter which sets the
+	// namespace of the JivaVolume CR and its policy
+	NamespaceParam = "namespace"
+
+	// IOPSLimitAnnotation and BPSLimitAnnotation are set on the
+	// JivaVolume CR from the StorageClass parameters with the same
+	// name, node plugin throttles the io on the device accordingly
+	IOPSLimitAnnotation = "jiva.openebs.io/iops-limit"
+	BPSLimitAnnotation  = "jiva.openebs.io/bps-limit"
+
+	// ReplicaPoolsParam is the StorageClass parameter listing the
+	// candidate StorageClasses of the replicas with their weights,
+	// i.e "ssd:3,hdd:1", CreateVolume chooses one of them
+	ReplicaPoolsParam = "jiva.openebs.io/replica-pools"
+	// PoolCapacityAnnotation can be set on the StorageClass of a
+	// replica pool with the total capacity of the pool, pool without
+	// it is considered to have unlimited capacity
+	PoolCapacityAnnotation = "jiva.openebs.io/pool-capacity"
+	// ReplicaPoolLabel is set on the JivaVolume CR with the replica
+	// pool chosen for the volume, it is used to find the capacity
+	// used in the pool
+	ReplicaPoolLabel = "openebs.io/replica-pool"
+
+	// EncryptAnnotation, EncryptionSecretNameAnnotation and
+	// EncryptionSecretNamespaceAnnotation are set on the JivaVolume CR
+	// from the StorageClass parameters with the same name, node plugin
+	// sets up a LUKS mapping on the device using the key in the secret.
+	// Only the reference to the secret is stored in the CR.
+	EncryptAnnotation                   = "jiva.openebs.io/encrypt"
+	EncryptionSecretNameAnnotation      = "jiva.openebs.io/encryption-secret-name"
+	EncryptionSecretNamespaceAnnotation = "jiva.openebs.io/encryption-secret-namespace"
+
+	// UseDNSPortalAnnotation is set on the JivaVolume CR from the
+	// StorageClass parameter with the same name, if it is true the
+	// iSCSI sessions are established to the DNS name of the target
+	// service instead of its IP
+	UseDNSPortalAnnotation = "jiva.openebs.io/use-dns-portal"
+
+	// TargetNodeSelectorParam is the StorageClass parameter with the
+	// label selector of the nodes where the jiva target of the volume
+	// should be scheduled, i.e "node-group=fast"
+	TargetNodeSelectorParam = "jiva.openebs.io/target-node-selector"
+
+	// TargetCPURequestParam, TargetMemoryRequestParam, TargetCPULimitParam
+	// and TargetMemoryLimitParam are the StorageClass parameters with the
+	// resources of the jiva target pod of the volume, the operator
+	// defaults are used for the ones which are not set
+	TargetCPURequestParam    = "jiva.openebs.io/target-cpu-request"
+	TargetMemoryRequestParam = "jiva.openebs.io/target-memory-request"
+	TargetCPULimitParam      = "jiva.openebs.io/target-cpu-limit"
+	TargetMemoryLimitParam   = "jiva.openebs.io/target-memory-limit"
+
+	// ReplicaZoneAntiAffinityParam is the StorageClass parameter which
+	// spreads the replicas of the volume across the zones, the replicas
+	// must be in distinct zones if it is strict and they are spread on
+	// a best-effort basis if it is preferred
+	ReplicaZoneAntiAffinityParam = "jiva.openebs.io/replica-zone-anti-affinity"
+	ZoneAntiAffinityStrict       = "strict"
+	ZoneAntiAffinityPreferred    = "preferred"
+	// ZoneTopologyKey is the node label with the zone of the node
+	ZoneTopologyKey = "topology.kubernetes.io/zone"
+
+	// ProvisioningPhaseAnnotation is set on the JivaVolume CR by
+	// CreateVolume, it is Pending once the CR is created, Bound once
+	// the target is ready and Failed if the target isn't ready within
+	// the provisioning timeout. CreateVolume retried for a volume which
+	// is not Bound, i.e after a restart of the controller plugin,
+	// resumes the provisioning of the existing CR. The status of the CR
+	// is owned by jiva-operator, so the phase is kept in an annotation.
+	ProvisioningPhaseAnnotation = "jiva.openebs.io/provisioning-phase"
+	ProvisioningPhasePending    = "Pending"
+	ProvisioningPhaseBound      = "Bound"
+	ProvisioningPhaseFailed     = "Failed"
+
+	// CapacityBytesAnnotation is set on the JivaVolume CR with the
+	// capacity of the volume in bytes by CreateVolume and updated by
+	// ControllerExpandVolume, so that the capacity recorded by the
+	// driver is unambiguous. The capacity in the spec is in GiB.
+	CapacityBytesAnnotation = "jiva.openebs.io/capacity-bytes"
+
+	// ResizePendingAnnotation is set on the JivaVolume CR with the
+	// capacity in bytes by ControllerExpandVolume of a volume which
+	// is not published to any node, its filesystem is then resized by
+	// the next NodeStageVolume which removes the annotation. The status
+	// of the CR is owned by jiva-operator, so it is kept in an annotation.
+	ResizePendingAnnotation = "jiva.openebs.io/resize-pending"
+
+	// VolumeProtectionFinalizer is set on the JivaVolume CR by
+	// CreateVolume and removed only by DeleteVolume, so that the
+	// CR deleted directly isn't removed along with its target
+	// while the PV still exists
+	VolumeProtectionFinalizer = "jiva.csi.openebs.io/volume-protection"
+
+	// MinReplicaCount and MaxReplicaCount are the bounds of the
+	// replication factor of a volume
+	MinReplicaCount = 1
+	MaxReplicaCount = 5
+
+	// eventBurstSize and eventQPS rate limit the events
+	// recorded for the same object, so that a volume which
+	// fails repeatedly doesn't flood the event stream
+	eventBurstSize = 10
+	eventQPS       = 1.0 / 60
 )
 
+var jivaVolumePolicyGVK = schema.GroupVersionKind{
+	Group:   "openebs.io",
+	Version: "v1alpha1",
+	Kind:    "JivaVolumePolicy",
+}
+
 // Client is the wrapper over the k8s client that will be used by
 // jiva-csi to interface with etcd
 type Client struct {
@@ -62,8 +223,20 @@ func New(config *rest.Config) (*Client, error) {
 	return c, nil
 }
 
+// NewWithClient returns a Client which uses the given k8s
+// client, it is not refreshed by Set. It is used in tests
+// with the fake client.
+func NewWithClient(c client.Client) *Client {
+	return &Client{
+		client: c,
+	}
+}
+
 // Set sets the client using the config
 func (cl *Client) Set() error {
+	if cl.cfg == nil {
+		return nil
+	}
 	c, err := client.New(cl.cfg, client.Options{})
 	if err != nil {
 		return err
@@ -87,6 +260,41 @@ func (cl *Client) RegisterAPI(opts manager.Options) error {
 	return nil
 }
 
+// NewEventRecorder returns a rate limited recorder which records
+// kubernetes events with the given component as the source
+func (cl *Client) NewEventRecorder(component string) (record.EventRecorder, error) {
+	kubeClient, err := kubernetes.NewForConfig(cl.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: eventBurstSize,
+		QPS:       eventQPS,
+	})
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kubeClient.CoreV1().Events(""),
+	})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component}), nil
+}
+
+// NewLeaseLock returns the lease lock with the given name and
+// namespace used for the leader election of the controller plugin
+func (cl *Client) NewLeaseLock(name, namespace, identity string, recorder record.EventRecorder) (resourcelock.Interface, error) {
+	kubeClient, err := kubernetes.NewForConfig(cl.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rlc := resourcelock.ResourceLockConfig{Identity: identity}
+	if recorder != nil {
+		rlc.EventRecorder = recorder
+	}
+	return resourcelock.New(resourcelock.LeasesResourceLock, namespace, name,
+		kubeClient.CoreV1(), kubeClient.CoordinationV1(), rlc)
+}
+
 // GetJivaVolume get the instance of JivaVolume CR.
 func (cl *Client) GetJivaVolume(name string) (*jv.JivaVolume, error) {
 	instance, err := cl.ListJivaVolume(name)
@@ -115,7 +323,32 @@ func (cl *Client) UpdateJivaVolume(cr *jv.JivaVolume) error {
 func getDefaultLabels(pv string) map[string]string {
 	return map[string]string{
 		"openebs.io/persistent-volume": pv,
-		"openebs.io/component":         "jiva-volume",
+		componentLabel:                 jivaVolumeComponent,
+	}
+}
+
+// setClaimMetadata sets the details of the PVC of the volume passed
+// in the parameters on the annotations and labels of the JivaVolume,
+// they are skipped if the provisioner doesn't pass them
+func setClaimMetadata(req *csi.CreateVolumeRequest, annotations, labels map[string]string) {
+	for param, annotation := range map[string]string{
+		PVCNameParam:      PVCNameAnnotation,
+		PVCNamespaceParam: PVCNamespaceAnnotation,
+		PVNameParam:       PVNameAnnotation,
+	} {
+		if val := req.GetParameters()[param]; val != "" {
+			annotations[annotation] = val
+		}
+	}
+
+	// pvc name can be longer than a label value
+	for param, label := range map[string]string{
+		PVCNameParam:      PVCNameLabel,
+		PVCNamespaceParam: PVCNamespaceLabel,
+	} {
+		if val := req.GetParameters()[param]; val != "" && len(validation.IsValidLabelValue(val)) == 0 {
+			labels[label] = val
+		}
 	}
 }
 
@@ -127,32 +360,274 @@ func getdefaultAnnotations(policy string) map[string]string {
 	return annotations
 }
 
+// RequiredBytes returns the size requested in the CreateVolume
+// request, if capacity range is not set default size is returned.
+// If only the limit is set, the default size is capped to the
+// limit rounded down to GiB so that the capacity doesn't exceed it.
+func RequiredBytes(req *csi.CreateVolumeRequest) int64 {
+	if req.GetCapacityRange() == nil {
+		logrus.Warningf("CreateVolume: capacity range is nil, provisioning with default size: {%v}", utils.FormatCapacity(defaultSizeBytes))
+		return defaultSizeBytes
+	}
+
+	if required := req.GetCapacityRange().GetRequiredBytes(); required != 0 {
+		return required
+	}
+	size := int64(defaultSizeBytes)
+	if limit := req.GetCapacityRange().GetLimitBytes(); limit != 0 && limit < size {
+		size = limit / helpers.GiB * helpers.GiB
+	}
+	return size
+}
+
+// RoundUpCapacity rounds up the given size to GiB which
+// is the granularity in which jiva allocates the capacity
+func RoundUpCapacity(sizeBytes int64) int64 {
+	return helpers.RoundUpToGiB(*resource.NewQuantity(sizeBytes, resource.BinarySI)) * helpers.GiB
+}
+
+// CapacityBytes returns the capacity of the volume provisioned
+// for the CreateVolume request i.e the requested size rounded
+// up to the allocation granularity
+func CapacityBytes(req *csi.CreateVolumeRequest) int64 {
+	return RoundUpCapacity(RequiredBytes(req))
+}
+
+// AccessibleTopology returns the topology segment where the volume
+// should be provisioned, the first preferred topology is selected
+// and if none is preferred the first requisite topology is used
+func AccessibleTopology(req *csi.CreateVolumeRequest) map[string]string {
+	requirement := req.GetAccessibilityRequirements()
+	if preferred := requirement.GetPreferred(); len(preferred) != 0 {
+		return preferred[0].GetSegments()
+	}
+	if requisite := requirement.GetRequisite(); len(requisite) != 0 {
+		return requisite[0].GetSegments()
+	}
+	return nil
+}
+
+// ParseTargetNodeSelector parses the value of TargetNodeSelectorParam,
+// target is scheduled using the node selector of its pod so only the
+// equality based requirements are supported
+func ParseTargetNodeSelector(val string) (map[string]string, error) {
+	selector, err := labels.Parse(val)
+	if err != nil {
+		return nil, err
+	}
+
+	requirements, _ := selector.Requirements()
+	nodeSelector := map[string]string{}
+	for _, r := range requirements {
+		switch r.Operator() {
+		case selection.Equals, selection.DoubleEquals:
+		case selection.In:
+			if r.Values().Len() == 1 {
+				break
+			}
+			fallthrough
+		default:
+			return nil, fmt.Errorf("only equality based requirements are supported, got {%v}", r.String())
+		}
+		nodeSelector[r.Key()] = r.Values().List()[0]
+	}
+	return nodeSelector, nil
+}
+
+// targetNodeSelector returns the node selector of the target of
+// the volume, it is the topology where the volume should be
+// provisioned along with the selector set in the StorageClass
+func targetNodeSelector(req *csi.CreateVolumeRequest) (map[string]string, error) {
+	nodeSelector := map[string]string{}
+	if val, ok := req.GetParameters()[TargetNodeSelectorParam]; ok {
+		selector, err := ParseTargetNodeSelector(val)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid value {%v} of parameter {%v}, err: {%v}",
+				val, TargetNodeSelectorParam, err)
+		}
+		nodeSelector = selector
+	}
+
+	for key, val := range AccessibleTopology(req) {
+		if existing, ok := nodeSelector[key]; ok && existing != val {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"Parameter {%v} requires {%v=%v} which conflicts with the accessible topology {%v=%v}",
+				TargetNodeSelectorParam, key, existing, key, val)
+		}
+		nodeSelector[key] = val
+	}
+	return nodeSelector, nil
+}
+
+// replicaZoneAntiAffinity returns the affinity of the replica pods of
+// the volume which spreads them across the zones as per the mode, nil
+// is returned if the mode is not set
+func replicaZoneAntiAffinity(pv, mode string) (*corev1.Affinity, error) {
+	term := corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"openebs.io/persistent-volume": pv,
+				componentLabel:                 jivaReplicaComponent,
+			},
+		},
+		TopologyKey: ZoneTopologyKey,
+	}
+
+	switch mode {
+	case "":
+		return nil, nil
+	case ZoneAntiAffinityStrict:
+		return &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+			},
+		}, nil
+	case ZoneAntiAffinityPreferred:
+		return &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{Weight: 100, PodAffinityTerm: term},
+				},
+			},
+		}, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid value {%v} of parameter {%v}, must be %v or %v",
+			mode, ReplicaZoneAntiAffinityParam, ZoneAntiAffinityStrict, ZoneAntiAffinityPreferred)
+	}
+}
+
+// ParseTargetResources parses the resources of the target pod set in
+// the StorageClass parameters, nil is returned if none of them are set
+func ParseTargetResources(params map[string]string) (*corev1.ResourceRequirements, error) {
+	var res *corev1.ResourceRequirements
+	for _, r := range []struct {
+		param string
+		name  corev1.ResourceName
+		limit bool
+	}{
+		{TargetCPURequestParam, corev1.ResourceCPU, false},
+		{TargetMemoryRequestParam, corev1.ResourceMemory, false},
+		{TargetCPULimitParam, corev1.ResourceCPU, true},
+		{TargetMemoryLimitParam, corev1.ResourceMemory, true},
+	} {
+		val, ok := params[r.param]
+		if !ok {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value {%v} of parameter {%v}, err: {%v}", val, r.param, err)
+		}
+		if res == nil {
+			res = &corev1.ResourceRequirements{}
+		}
+		if r.limit {
+			if res.Limits == nil {
+				res.Limits = corev1.ResourceList{}
+			}
+			res.Limits[r.name] = quantity
+		} else {
+			if res.Requests == nil {
+				res.Requests = corev1.ResourceList{}
+			}
+			res.Requests[r.name] = quantity
+		}
+	}
+
+	if res == nil {
+		return nil, nil
+	}
+
+	// target pod is rejected if a request exceeds its limit
+	for name, request := range res.Requests {
+		if limit, ok := res.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("%v request {%v} of the target is greater than its limit {%v}", name, request.String(), limit.String())
+		}
+	}
+	return res, nil
+}
+
 // CreateJivaVolume check whether JivaVolume CR already exists and creates one
-// if it doesn't exist.
-func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
-	var sizeBytes int64
+// if it doesn't exist. Replicas are created in the given replica pool if it
+// is not empty, the replication factor of the policy is used if the given
+// replica count is 0.
+func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest, replicaPool string, replicaCount int) error {
 	name := utils.StripName(req.GetName())
-	policyName := req.GetParameters()["policy"]
-	ns, ok := req.GetParameters()["namespace"]
-	if !ok {
-		ns = defaultNS
+	policyName := req.GetParameters()[PolicyParam]
+	ns := Namespace(req.GetParameters())
+	sizeBytes := CapacityBytes(req)
+
+	annotations := getdefaultAnnotations(policyName)
+
+	// parameters are validated by the driver before
+	// creating the volume
+	for _, param := range []string{
+		IOPSLimitAnnotation,
+		BPSLimitAnnotation,
+		EncryptAnnotation,
+		EncryptionSecretNameAnnotation,
+		EncryptionSecretNamespaceAnnotation,
+		UseDNSPortalAnnotation,
+	} {
+		if val, ok := req.GetParameters()[param]; ok {
+			annotations[param] = val
+		}
 	}
-	if req.GetCapacityRange() == nil {
-		logrus.Warningf("CreateVolume: capacity range is nil, provisioning with default size: {%v (bytes)}", defaultSizeBytes)
-		sizeBytes = defaultSizeBytes
-	} else {
-		sizeBytes = req.GetCapacityRange().RequiredBytes
+
+	annotations[ProvisioningPhaseAnnotation] = ProvisioningPhasePending
+	annotations[CapacityBytesAnnotation] = strconv.FormatInt(sizeBytes, 10)
+
+	capacity := fmt.Sprintf("%dGi", sizeBytes/helpers.GiB)
+	labels := getDefaultLabels(name)
+	if replicaPool != "" {
+		labels[ReplicaPoolLabel] = replicaPool
 	}
+	setClaimMetadata(req, annotations, labels)
 
-	size := resource.NewQuantity(sizeBytes, resource.BinarySI)
-	volSizeGiB := helpers.RoundUpToGiB(*size)
-	capacity := fmt.Sprintf("%dGi", volSizeGiB)
 	jiva := jivavolume.New().WithKindAndAPIVersion("JivaVolume", "openebs.io/v1alpha1").
 		WithNameAndNamespace(name, ns).
-		WithAnnotations(getdefaultAnnotations(policyName)).
-		WithLabels(getDefaultLabels(name)).
+		WithAnnotations(annotations).
+		WithLabels(labels).
 		WithPV(name).
-		WithCapacity(capacity)
+		WithCapacity(capacity).
+		WithFinalizers(VolumeProtectionFinalizer)
+
+	if replicaPool != "" {
+		logrus.Infof("CreateVolume: creating replicas of volume {%v} in pool {%v}", name, replicaPool)
+		jiva.WithReplicaSC(replicaPool)
+	}
+
+	if replicaCount != 0 {
+		logrus.Infof("CreateVolume: using replica count {%v} for volume {%v}", replicaCount, name)
+		jiva.WithReplicationFactor(replicaCount)
+	}
+
+	nodeSelector, err := targetNodeSelector(req)
+	if err != nil {
+		return err
+	}
+	if len(nodeSelector) != 0 {
+		logrus.Infof("CreateVolume: scheduling target of volume {%v} on nodes {%v}", name, nodeSelector)
+		jiva.WithTargetNodeSelector(nodeSelector)
+	}
+
+	affinity, err := replicaZoneAntiAffinity(name, req.GetParameters()[ReplicaZoneAntiAffinityParam])
+	if err != nil {
+		return err
+	}
+	if affinity != nil {
+		logrus.Infof("CreateVolume: spreading replicas of volume {%v} across zones, mode: {%v}", name, req.GetParameters()[ReplicaZoneAntiAffinityParam])
+		jiva.WithReplicaAffinity(affinity)
+	}
+
+	resources, err := ParseTargetResources(req.GetParameters())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid target resources, err: {%v}", err)
+	}
+	if resources != nil {
+		logrus.Infof("CreateVolume: using target resources {requests: %v, limits: %v} for volume {%v}", resources.Requests, resources.Limits, name)
+		jiva.WithTargetResources(resources)
+	}
 
 	if jiva.Errs != nil {
 		return status.Errorf(codes.Internal, "Failed to build JivaVolume CR, err: {%v}", jiva.Errs)
@@ -160,9 +635,9 @@ func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
 
 	obj := jiva.Instance()
 	objExists := &jv.JivaVolume{}
-	err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, objExists)
+	err = cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, objExists)
 	if err != nil && errors.IsNotFound(err) {
-		logrus.Infof("Creating a new JivaVolume CR {name: %v, namespace: %v}", name, ns)
+		logrus.Infof("Creating a new JivaVolume CR {name: %v, namespace: %v} with capacity {%v}", name, ns, utils.FormatCapacity(sizeBytes))
 		err = cl.client.Create(context.TODO(), obj)
 		if err != nil {
 			return status.Errorf(codes.Internal, "Failed to create JivaVolume CR, err: {%v}", err)
@@ -176,9 +651,166 @@ func (cl *Client) CreateJivaVolume(req *csi.CreateVolumeRequest) error {
 		return status.Errorf(codes.AlreadyExists, "Failed to create JivaVolume CR, volume with different size already exists")
 	}
 
+	if phase := objExists.Annotations[ProvisioningPhaseAnnotation]; phase == ProvisioningPhasePending || phase == ProvisioningPhaseFailed {
+		logrus.Infof("CreateVolume: resuming provisioning of JivaVolume CR {name: %v, namespace: %v} in phase {%v}", name, ns, phase)
+		return cl.ensureVolumeFinalizer(objExists)
+	}
+
 	return nil
 }
 
+// Namespace returns the namespace of the JivaVolume CR
+// set in the given StorageClass parameters
+func Namespace(params map[string]string) string {
+	if ns, ok := params[NamespaceParam]; ok {
+		return ns
+	}
+	return defaultNS
+}
+
+// JivaVolumePolicyExists returns true if the JivaVolumePolicy
+// of the given name exists in the given namespace
+func (cl *Client) JivaVolumePolicyExists(name, ns string) (bool, error) {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(jivaVolumePolicyGVK)
+	err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, obj)
+	if err != nil && errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReplicaCount returns the replica count of the volume set on the PVC
+// via ReplicaCountAnnotation or else the one set in the StorageClass
+// parameters, 0 is returned if neither of them is set
+func (cl *Client) ReplicaCount(req *csi.CreateVolumeRequest) (int, error) {
+	count, err := cl.getReplicaCountOverride(req)
+	if err != nil || count != 0 {
+		return count, err
+	}
+
+	val, ok := req.GetParameters()[ReplicaCountAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	count, err = strconv.Atoi(val)
+	if err != nil || count < MinReplicaCount || count > MaxReplicaCount {
+		return 0, status.Errorf(codes.InvalidArgument,
+			"Invalid value {%v} of parameter {%v}, replica count must be an integer between %d and %d",
+			val, ReplicaCountAnnotation, MinReplicaCount, MaxReplicaCount)
+	}
+	return count, nil
+}
+
+// PolicyReplicaCount returns the replication factor set in the
+// JivaVolumePolicy referred by the StorageClass parameters, 0 is
+// returned if the policy is not set or it doesn't set the factor
+func (cl *Client) PolicyReplicaCount(params map[string]string) (int, error) {
+	name, ok := params[PolicyParam]
+	if !ok {
+		return 0, nil
+	}
+
+	policy := &jv.JivaVolumePolicy{}
+	err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: Namespace(params)}, policy)
+	if err != nil && errors.IsNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, status.Errorf(codes.Internal, "Failed to get JivaVolumePolicy {%v/%v}, err: {%v}", Namespace(params), name, err)
+	}
+	return policy.Spec.Target.ReplicationFactor, nil
+}
+
+// getReplicaCountOverride returns the replica count set on the PVC
+// via ReplicaCountAnnotation, 0 is returned if it is not set
+func (cl *Client) getReplicaCountOverride(req *csi.CreateVolumeRequest) (int, error) {
+	pvcName := req.GetParameters()[PVCNameParam]
+	pvcNamespace := req.GetParameters()[PVCNamespaceParam]
+	if pvcName == "" || pvcNamespace == "" {
+		return 0, nil
+	}
+
+	pvc, err := cl.GetPersistentVolumeClaim(pvcName, pvcNamespace)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Failed to get pvc {%v/%v}, err: {%v}", pvcNamespace, pvcName, err)
+	}
+
+	val, ok := pvc.Annotations[ReplicaCountAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(val)
+	if err != nil || count < MinReplicaCount || count > MaxReplicaCount {
+		return 0, status.Errorf(codes.InvalidArgument,
+			"Invalid value {%v} of annotation {%v} on pvc {%v/%v}, replica count must be an integer between %d and %d",
+			val, ReplicaCountAnnotation, pvcNamespace, pvcName, MinReplicaCount, MaxReplicaCount)
+	}
+	return count, nil
+}
+
+// GetPersistentVolume fetches the PV with the given name
+func (cl *Client) GetPersistentVolume(name string) (*corev1.PersistentVolume, error) {
+	pv := &corev1.PersistentVolume{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, pv); err != nil {
+		return nil, err
+	}
+	return pv, nil
+}
+
+// GetPersistentVolumeClaim fetches the PVC with the given
+// name and namespace
+func (cl *Client) GetPersistentVolumeClaim(name, ns string) (*corev1.PersistentVolumeClaim, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, pvc); err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+// GetNode returns the node with the given name
+func (cl *Client) GetNode(name string) (*corev1.Node, error) {
+	node := &corev1.Node{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// GetStorageClass returns the StorageClass with the given name
+func (cl *Client) GetStorageClass(name string) (*storagev1.StorageClass, error) {
+	sc := &storagev1.StorageClass{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name}, sc); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// GetSecret returns the secret with the given name and namespace
+func (cl *Client) GetSecret(name, ns string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ListNodes returns the list of nodes matching the given labels
+func (cl *Client) ListNodes(labels map[string]string) (*corev1.NodeList, error) {
+	obj := &corev1.NodeList{}
+	opts := []client.ListOption{
+		client.MatchingLabels(labels),
+	}
+
+	if err := cl.client.List(context.TODO(), obj, opts...); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
 // ListJivaVolume returns the list of JivaVolume resources
 func (cl *Client) ListJivaVolume(volumeID string) (*jv.JivaVolumeList, error) {
 	volumeID = utils.StripName(volumeID)
@@ -208,7 +840,26 @@ func (cl *Client) ListJivaVolumeWithOpts(opts map[string]string) (*jv.JivaVolume
 	return obj, nil
 }
 
-// DeleteJivaVolume delete the JivaVolume CR
+// ListJivaVolumes returns the list of all the JivaVolume
+// resources provisioned by jiva-csi
+func (cl *Client) ListJivaVolumes() (*jv.JivaVolumeList, error) {
+	return cl.ListJivaVolumeWithOpts(map[string]string{
+		componentLabel: jivaVolumeComponent,
+	})
+}
+
+// Probe verifies that the JivaVolume CRs can be listed
+// using the client within the deadline of ctx
+func (cl *Client) Probe(ctx context.Context) error {
+	return cl.client.List(ctx, &jv.JivaVolumeList{}, client.MatchingLabels{
+		componentLabel: jivaVolumeComponent,
+	})
+}
+
+// DeleteJivaVolume delete the JivaVolume CR and removes the volume
+// protection finalizer once the deletion is requested. It can be
+// retried after a partial cleanup, i.e the CR which is already
+// marked for deletion only has its finalizer removed.
 func (cl *Client) DeleteJivaVolume(volumeID string) error {
 	obj, err := cl.ListJivaVolume(volumeID)
 	if err != nil {
@@ -222,7 +873,74 @@ func (cl *Client) DeleteJivaVolume(volumeID string) error {
 
 	logrus.Debugf("DeleteVolume: object: {%+v}", obj)
 	instance := obj.Items[0].DeepCopy()
-	if err := cl.client.Delete(context.TODO(), instance); err != nil {
+	if instance.DeletionTimestamp == nil {
+		if err := cl.client.Delete(context.TODO(), instance); err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	} else {
+		logrus.Infof("DeleteVolume: JivaVolume: {%v} is already marked for deletion", volumeID)
+	}
+
+	return cl.removeVolumeFinalizer(instance.Name, instance.Namespace)
+}
+
+// ensureVolumeFinalizer sets the volume protection finalizer
+// on the JivaVolume CR if it is missing
+func (cl *Client) ensureVolumeFinalizer(instance *jv.JivaVolume) error {
+	for _, f := range instance.Finalizers {
+		if f == VolumeProtectionFinalizer {
+			return nil
+		}
+	}
+
+	instance.Finalizers = append(instance.Finalizers, VolumeProtectionFinalizer)
+	if err := cl.client.Update(context.TODO(), instance); err != nil {
+		return status.Errorf(codes.Internal, "Failed to set finalizer on JivaVolume CR: {%v}, err: {%v}", instance.Name, err)
+	}
+	return nil
+}
+
+// SetProvisioningPhase records the given provisioning
+// phase in the annotation of the JivaVolume CR
+func (cl *Client) SetProvisioningPhase(instance *jv.JivaVolume, phase string) error {
+	if instance.Annotations[ProvisioningPhaseAnnotation] == phase {
+		return nil
+	}
+	if instance.Annotations == nil {
+		instance.Annotations = map[string]string{}
+	}
+	instance.Annotations[ProvisioningPhaseAnnotation] = phase
+	return cl.UpdateJivaVolume(instance)
+}
+
+// removeVolumeFinalizer removes the volume protection finalizer
+// from the latest version of the JivaVolume CR, CR which is
+// already removed is treated as success
+func (cl *Client) removeVolumeFinalizer(name, ns string) error {
+	instance := &jv.JivaVolume{}
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	finalizers := []string{}
+	for _, f := range instance.Finalizers {
+		if f != VolumeProtectionFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(instance.Finalizers) {
+		return nil
+	}
+
+	logrus.Infof("DeleteVolume: removing finalizer {%v} from JivaVolume: {%v}", VolumeProtectionFinalizer, name)
+	instance.Finalizers = finalizers
+	if err := cl.client.Update(context.TODO(), instance); err != nil && !errors.IsNotFound(err) {
 		return err
 	}
 	return nil
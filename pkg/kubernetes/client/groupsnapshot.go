@@ -0,0 +1,155 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	jivaGroupSnapshotComponent = "jiva-volume-group-snapshot"
+
+	// groupSnapshotLabel is set on the JivaVolumeGroupSnapshot
+	// CR with the group snapshot ID, it is used to look up the
+	// CR without knowing its namespace
+	groupSnapshotLabel = "openebs.io/jiva-volume-group-snapshot"
+)
+
+var jivaGroupSnapshotGVK = schema.GroupVersionKind{
+	Group:   "openebs.io",
+	Version: "v1alpha1",
+	Kind:    "JivaVolumeGroupSnapshot",
+}
+
+// JivaVolumeGroupSnapshot is the representation of the
+// JivaVolumeGroupSnapshot CR which tracks the snapshots
+// taken together on the jiva targets of the source volumes
+type JivaVolumeGroupSnapshot struct {
+	// Name of the CR, it is same as the group snapshot ID
+	Name      string
+	Namespace string
+	// SourceVolumes are the names of the JivaVolumes of the
+	// group, Snapshots are the IDs of their snapshots in the
+	// same order
+	SourceVolumes []string
+	Snapshots     []string
+	CreationTime  time.Time
+	ReadyToUse    bool
+}
+
+func (g *JivaVolumeGroupSnapshot) toUnstructured() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(jivaGroupSnapshotGVK)
+	obj.SetName(g.Name)
+	obj.SetNamespace(g.Namespace)
+	obj.SetLabels(map[string]string{
+		componentLabel:     jivaGroupSnapshotComponent,
+		groupSnapshotLabel: g.Name,
+	})
+	obj.Object["spec"] = map[string]interface{}{
+		"sourceVolumes": toInterfaceSlice(g.SourceVolumes),
+		"snapshots":     toInterfaceSlice(g.Snapshots),
+	}
+	obj.Object["status"] = map[string]interface{}{
+		"creationTime": g.CreationTime.UTC().Format(time.RFC3339),
+		"readyToUse":   g.ReadyToUse,
+	}
+	return obj
+}
+
+// toInterfaceSlice converts the strings into the
+// slice type of the unstructured objects
+func toInterfaceSlice(s []string) []interface{} {
+	out := make([]interface{}, 0, len(s))
+	for _, v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+func jivaGroupSnapshotFromUnstructured(obj *unstructured.Unstructured) *JivaVolumeGroupSnapshot {
+	group := &JivaVolumeGroupSnapshot{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}
+	group.SourceVolumes, _, _ = unstructured.NestedStringSlice(obj.Object, "spec", "sourceVolumes")
+	group.Snapshots, _, _ = unstructured.NestedStringSlice(obj.Object, "spec", "snapshots")
+	group.ReadyToUse, _, _ = unstructured.NestedBool(obj.Object, "status", "readyToUse")
+	if ts, found, _ := unstructured.NestedString(obj.Object, "status", "creationTime"); found {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			group.CreationTime = t
+		}
+	}
+	return group
+}
+
+// GetJivaVolumeGroupSnapshot returns the JivaVolumeGroupSnapshot CR with
+// the given group snapshot ID, nil is returned if the CR doesn't exist
+func (cl *Client) GetJivaVolumeGroupSnapshot(groupSnapshotID string) (*JivaVolumeGroupSnapshot, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   jivaGroupSnapshotGVK.Group,
+		Version: jivaGroupSnapshotGVK.Version,
+		Kind:    jivaGroupSnapshotGVK.Kind + "List",
+	})
+
+	if err := cl.client.List(context.TODO(), list, client.MatchingLabels(map[string]string{
+		componentLabel:     jivaGroupSnapshotComponent,
+		groupSnapshotLabel: groupSnapshotID,
+	})); err != nil {
+		return nil, err
+	}
+
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	return jivaGroupSnapshotFromUnstructured(&list.Items[0]), nil
+}
+
+// CreateJivaVolumeGroupSnapshot creates the JivaVolumeGroupSnapshot CR
+func (cl *Client) CreateJivaVolumeGroupSnapshot(group *JivaVolumeGroupSnapshot) error {
+	logrus.Infof("Creating a new JivaVolumeGroupSnapshot CR {name: %v, namespace: %v}", group.Name, group.Namespace)
+	return cl.client.Create(context.TODO(), group.toUnstructured())
+}
+
+// UpdateJivaVolumeGroupSnapshot updates the status of the JivaVolumeGroupSnapshot CR
+func (cl *Client) UpdateJivaVolumeGroupSnapshot(group *JivaVolumeGroupSnapshot) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(jivaGroupSnapshotGVK)
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: group.Name, Namespace: group.Namespace}, obj); err != nil {
+		return err
+	}
+
+	obj.Object["status"] = group.toUnstructured().Object["status"]
+	if err := cl.client.Update(context.TODO(), obj); err != nil {
+		logrus.Errorf("Failed to update JivaVolumeGroupSnapshot CR: {%v}, err: {%v}", group.Name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteJivaVolumeGroupSnapshot deletes the JivaVolumeGroupSnapshot CR
+func (cl *Client) DeleteJivaVolumeGroupSnapshot(group *JivaVolumeGroupSnapshot) error {
+	return client.IgnoreNotFound(cl.client.Delete(context.TODO(), group.toUnstructured()))
+}
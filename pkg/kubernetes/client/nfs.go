@@ -0,0 +1,164 @@
+/*
+Copyright 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	jv "github.com/openebs/jiva-operator/pkg/apis/openebs/v1alpha1"
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// NFSServerAnnotation is set on the JivaVolume CR of a
+	// ReadWriteMany volume with the name of the NFS server
+	// which exports the filesystem of the volume
+	NFSServerAnnotation = "jiva.openebs.io/nfs-server"
+
+	// nfsServerLabel is set on the deployment and the
+	// service of the NFS server with its name
+	nfsServerLabel = "openebs.io/nfs-server"
+
+	// nfsServerSuffix is the suffix of the name of the deployment
+	// and the service of the NFS server of the volume
+	nfsServerSuffix = "-nfs"
+
+	// NFSPort is the port the NFS server listens on
+	NFSPort = 2049
+
+	// nfsExportPath is the path where the filesystem
+	// of the volume is mounted in the NFS server
+	nfsExportPath = "/nfsshare"
+)
+
+// NFSServerName returns the name of the NFS server of the volume
+func NFSServerName(volumeName string) string {
+	return volumeName + nfsServerSuffix
+}
+
+// CreateNFSServer creates the deployment and the service of the NFS
+// server which mounts the jiva volume with the given fsType over iSCSI
+// and exports it, the ones which already exist are left as is. Target
+// of the volume must be ready before the NFS server is created.
+func (cl *Client) CreateNFSServer(instance *jv.JivaVolume, image, fsType string) (*corev1.Service, error) {
+	name := NFSServerName(instance.Name)
+	labels := map[string]string{
+		nfsServerLabel:                 name,
+		"openebs.io/persistent-volume": instance.Spec.PV,
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{nfsServerLabel: name},
+			Ports: []corev1.ServicePort{{
+				Name:       "nfs",
+				Port:       NFSPort,
+				TargetPort: intstr.FromInt(NFSPort),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+	if err := cl.client.Create(context.TODO(), svc); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create service of nfs server {%v}, err: {%v}", name, err)
+	}
+
+	replicas := int32(1)
+	privileged := true
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{nfsServerLabel: name},
+			},
+			// volume is logged in from a single node at a time
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "nfs-server",
+						Image: image,
+						Env: []corev1.EnvVar{
+							{Name: "SHARED_DIRECTORY", Value: nfsExportPath},
+						},
+						Ports: []corev1.ContainerPort{
+							{Name: "nfs", ContainerPort: NFSPort, Protocol: corev1.ProtocolTCP},
+						},
+						SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "export", MountPath: nfsExportPath},
+						},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "export",
+						VolumeSource: corev1.VolumeSource{
+							ISCSI: &corev1.ISCSIVolumeSource{
+								TargetPortal: fmt.Sprintf("%v:%v", instance.Spec.ISCSISpec.TargetIP, instance.Spec.ISCSISpec.TargetPort),
+								IQN:          instance.Spec.ISCSISpec.Iqn,
+								Lun:          0,
+								FSType:       fsType,
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if err := cl.client.Create(context.TODO(), deploy); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create deployment of nfs server {%v}, err: {%v}", name, err)
+	}
+
+	// cluster IP is allocated once the service is created
+	if err := cl.client.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: instance.Namespace}, svc); err != nil {
+		return nil, fmt.Errorf("failed to get service of nfs server {%v}, err: {%v}", name, err)
+	}
+	return svc, nil
+}
+
+// DeleteNFSServer deletes the deployment and the service of the
+// NFS server, the ones which are already removed are ignored
+func (cl *Client) DeleteNFSServer(name, ns string) error {
+	meta := metav1.ObjectMeta{Name: name, Namespace: ns}
+	for _, obj := range []runtime.Object{
+		&appsv1.Deployment{ObjectMeta: meta},
+		&corev1.Service{ObjectMeta: meta},
+	} {
+		if err := cl.client.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete nfs server {%v}, err: {%v}", name, err)
+		}
+	}
+	logrus.Infof("DeleteVolume: nfs server {%v} is deleted", name)
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	jivaSnapshotComponent = "jiva-snapshot"
+
+	// snapshotLabel is set on the JivaSnapshot CR with
+	// the snapshot ID, it is used to look up the CR
+	// without knowing its namespace
+	snapshotLabel = "openebs.io/jiva-snapshot"
+	// sourceVolumeLabel is set on the JivaSnapshot CR
+	// with the name of the source volume
+	sourceVolumeLabel = "openebs.io/persistent-volume"
+)
+
+var jivaSnapshotGVK = schema.GroupVersionKind{
+	Group:   "openebs.io",
+	Version: "v1alpha1",
+	Kind:    "JivaSnapshot",
+}
+
+// JivaSnapshot is the representation of the JivaSnapshot
+// CR which tracks a snapshot taken on the jiva target of
+// the source volume
+type JivaSnapshot struct {
+	// Name of the CR, it is same as the snapshot ID
+	Name      string
+	Namespace string
+	// SourceVolume is the name of the JivaVolume
+	// on which snapshot has been taken
+	SourceVolume string
+	// SizeBytes is the size of the source volume
+	// at the time of taking the snapshot
+	SizeBytes    int64
+	CreationTime time.Time
+	ReadyToUse   bool
+	// GroupSnapshot is the ID of the group snapshot
+	// the snapshot is taken as part of, if any
+	GroupSnapshot string
+}
+
+func (s *JivaSnapshot) toUnstructured() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(jivaSnapshotGVK)
+	obj.SetName(s.Name)
+	obj.SetNamespace(s.Namespace)
+	obj.SetLabels(map[string]string{
+		componentLabel:    jivaSnapshotComponent,
+		snapshotLabel:     s.Name,
+		sourceVolumeLabel: s.SourceVolume,
+	})
+	obj.Object["spec"] = map[string]interface{}{
+		"sourceVolume": s.SourceVolume,
+	}
+	if s.GroupSnapshot != "" {
+		obj.Object["spec"].(map[string]interface{})["groupSnapshot"] = s.GroupSnapshot
+	}
+	obj.Object["status"] = map[string]interface{}{
+		"sizeBytes":    s.SizeBytes,
+		"creationTime": s.CreationTime.UTC().Format(time.RFC3339),
+		"readyToUse":   s.ReadyToUse,
+	}
+	return obj
+}
+
+func jivaSnapshotFromUnstructured(obj *unstructured.Unstructured) *JivaSnapshot {
+	snap := &JivaSnapshot{
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+	}
+	snap.SourceVolume, _, _ = unstructured.NestedString(obj.Object, "spec", "sourceVolume")
+	snap.GroupSnapshot, _, _ = unstructured.NestedString(obj.Object, "spec", "groupSnapshot")
+	snap.SizeBytes, _, _ = unstructured.NestedInt64(obj.Object, "status", "sizeBytes")
+	snap.ReadyToUse, _, _ = unstructured.NestedBool(obj.Object, "status", "readyToUse")
+	if ts, found, _ := unstructured.NestedString(obj.Object, "status", "creationTime"); found {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			snap.CreationTime = t
+		}
+	}
+	return snap
+}
+
+// GetJivaSnapshot returns the JivaSnapshot CR with the given snapshot
+// ID, nil is returned if the CR doesn't exist
+func (cl *Client) GetJivaSnapshot(snapshotID string) (*JivaSnapshot, error) {
+	snaps, err := cl.listJivaSnapshots(map[string]string{
+		componentLabel: jivaSnapshotComponent,
+		snapshotLabel:  snapshotID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snaps) == 0 {
+		return nil, nil
+	}
+	return snaps[0], nil
+}
+
+// ListJivaSnapshots returns all the JivaSnapshot CRs
+func (cl *Client) ListJivaSnapshots() ([]*JivaSnapshot, error) {
+	return cl.listJivaSnapshots(map[string]string{
+		componentLabel: jivaSnapshotComponent,
+	})
+}
+
+func (cl *Client) listJivaSnapshots(labels map[string]string) ([]*JivaSnapshot, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   jivaSnapshotGVK.Group,
+		Version: jivaSnapshotGVK.Version,
+		Kind:    jivaSnapshotGVK.Kind + "List",
+	})
+
+	if err := cl.client.List(context.TODO(), list, client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+
+	snaps := []*JivaSnapshot{}
+	for i := range list.Items {
+		snaps = append(snaps, jivaSnapshotFromUnstructured(&list.Items[i]))
+	}
+	return snaps, nil
+}
+
+// CreateJivaSnapshot creates the JivaSnapshot CR
+func (cl *Client) CreateJivaSnapshot(snap *JivaSnapshot) error {
+	logrus.Infof("Creating a new JivaSnapshot CR {name: %v, namespace: %v}", snap.Name, snap.Namespace)
+	return cl.client.Create(context.TODO(), snap.toUnstructured())
+}
+
+// UpdateJivaSnapshot updates the status of the JivaSnapshot CR
+func (cl *Client) UpdateJivaSnapshot(snap *JivaSnapshot) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(jivaSnapshotGVK)
+	if err := cl.client.Get(context.TODO(), types.NamespacedName{Name: snap.Name, Namespace: snap.Namespace}, obj); err != nil {
+		return err
+	}
+
+	obj.Object["status"] = snap.toUnstructured().Object["status"]
+	if err := cl.client.Update(context.TODO(), obj); err != nil {
+		logrus.Errorf("Failed to update JivaSnapshot CR: {%v}, err: {%v}", snap.Name, err)
+		return err
+	}
+	return nil
+}
+
+// DeleteJivaSnapshot deletes the JivaSnapshot CR
+func (cl *Client) DeleteJivaSnapshot(snap *JivaSnapshot) error {
+	return client.IgnoreNotFound(cl.client.Delete(context.TODO(), snap.toUnstructured()))
+}
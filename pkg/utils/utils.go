@@ -16,17 +16,39 @@ limitations under the License.
 
 package utils
 
-import "strings"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 const maxNameLen = 43
 
+// VolumeIDPrefix is prepended to the name of the volume to generate
+// its volume ID, so that the volume IDs of multiple drivers in the
+// same cluster don't collide. It is empty by default.
+var VolumeIDPrefix string
+
+// VolumeID returns the volume ID of the
+// volume with the given name i.e pv name
+func VolumeID(name string) string {
+	return VolumeIDPrefix + name
+}
+
+// VolumeName returns the name of the volume i.e
+// pv name by stripping the prefix of the volume ID
+func VolumeName(volumeID string) string {
+	return strings.TrimPrefix(volumeID, VolumeIDPrefix)
+}
+
 // StripName strips the extra characters from the name
 // Since Custom Resources only support names upto 63 chars
 // so this trims the rest of the trailing chars and it generates
 // the controller-revision hash of by appending more 10 chars
-// after appending `-jiva-rep-` so total 20 chars must be stripped
+// after appending `-jiva-rep-` so total 20 chars must be stripped.
+// VolumeIDPrefix is stripped first if name is a volume ID.
 func StripName(name string) string {
-	name = strings.ToLower(name)
+	name = strings.ToLower(VolumeName(name))
 	if len(name) > maxNameLen {
 		name = name[:maxNameLen]
 	}
@@ -36,3 +58,38 @@ func StripName(name string) string {
 	}
 	return name
 }
+
+// capacityUnits are the binary units used by FormatCapacity
+var capacityUnits = []string{"Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+
+// FormatCapacity returns the capacity in bytes along with its human
+// readable form in binary units i.e "5368709120 (5Gi)", it is only
+// meant for the logs and the events, capacity is always stored in
+// bytes or as a quantity of whole units
+func FormatCapacity(bytes int64) string {
+	return fmt.Sprintf("%d (%s)", bytes, humanCapacity(bytes))
+}
+
+// humanCapacity returns the capacity in the largest binary unit which
+// is not bigger than it, i.e 1.5Gi, with at most two decimals
+func humanCapacity(bytes int64) string {
+	sign, abs := "", uint64(bytes)
+	if bytes < 0 {
+		sign, abs = "-", uint64(-bytes)
+	}
+	if abs < 1024 {
+		return fmt.Sprintf("%s%dB", sign, abs)
+	}
+
+	unit, div := 0, uint64(1024)
+	for unit < len(capacityUnits)-1 && abs/div >= 1024 {
+		unit++
+		div *= 1024
+	}
+	if abs%div == 0 {
+		return fmt.Sprintf("%s%d%s", sign, abs/div, capacityUnits[unit])
+	}
+	val := strconv.FormatFloat(float64(abs)/float64(div), 'f', 2, 64)
+	val = strings.TrimRight(strings.TrimRight(val, "0"), ".")
+	return sign + val + capacityUnits[unit]
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2020 The OpenEBS Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import "testing"
+
+func TestFormatCapacity(t *testing.T) {
+	tests := map[string]struct {
+		bytes    int64
+		expected string
+	}{
+		"zero":              {bytes: 0, expected: "0 (0B)"},
+		"below one Ki":      {bytes: 1023, expected: "1023 (1023B)"},
+		"one Ki":            {bytes: 1024, expected: "1024 (1Ki)"},
+		"fractional Mi":     {bytes: 1536 * 1024, expected: "1572864 (1.5Mi)"},
+		"whole Gi":          {bytes: 5 << 30, expected: "5368709120 (5Gi)"},
+		"just below Gi":     {bytes: 1<<30 - 1, expected: "1073741823 (1024Mi)"},
+		"just above Gi":     {bytes: 1<<30 + 1, expected: "1073741825 (1Gi)"},
+		"two decimals":      {bytes: 4990 << 20, expected: "5232394240 (4.87Gi)"},
+		"whole Ti":          {bytes: 2 << 40, expected: "2199023255552 (2Ti)"},
+		"smallest":          {bytes: -1 << 63, expected: "-9223372036854775808 (-8Ei)"},
+		"largest":           {bytes: 1<<63 - 1, expected: "9223372036854775807 (8Ei)"},
+		"negative":          {bytes: -2048, expected: "-2048 (-2Ki)"},
+		"more than 1024 Gi": {bytes: 1536 << 30, expected: "1649267441664 (1.5Ti)"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := FormatCapacity(test.bytes); got != test.expected {
+				t.Fatalf("expected %v, got: %v", test.expected, got)
+			}
+		})
+	}
+}